@@ -0,0 +1,66 @@
+// Command alert-engine evaluates the clinical alert rules stored on
+// PatientCareChaincode over readings forwarded by telemetry-ingest and
+// records a signed alert on the ledger whenever a rule fires.
+package main
+
+import (
+    "context"
+    "errors"
+    "log"
+    "net/http"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
+
+    "github.com/tyuvic777/tyuabc777/alerts"
+    "github.com/tyuvic777/tyuabc777/gateway"
+)
+
+const chaincodeName = "patientcare"
+
+func main() {
+    refresh := time.Minute
+    if value := os.Getenv("ALERT_RULES_REFRESH"); value != "" {
+        d, err := time.ParseDuration(value)
+        if err != nil || d <= 0 {
+            log.Fatalf("ALERT_RULES_REFRESH must be a positive duration")
+        }
+        refresh = d
+    }
+    conn, err := gateway.ConnectFromEnv()
+    if err != nil {
+        log.Fatalf("Failed to connect to Fabric: %v", err)
+    }
+    defer conn.Close()
+    contract := conn.Network().GetContract(chaincodeName)
+
+    service := alerts.NewService(alerts.NewEngine(),
+        &alerts.GatewayRules{Contract: contract},
+        &alerts.GatewayRecorder{Contract: contract, Key: conn.Key},
+        refresh, nil)
+    token := os.Getenv("ALERT_ENGINE_TOKEN")
+    if token == "" {
+        log.Printf("ALERT_ENGINE_TOKEN is not set; /readings accepts unauthenticated requests")
+    }
+    addr := os.Getenv("ALERT_ENGINE_ADDR")
+    if addr == "" {
+        addr = ":8091"
+    }
+    server := &http.Server{Addr: addr, Handler: alerts.Handler(service, token), ReadHeaderTimeout: 10 * time.Second}
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    go service.Run(ctx)
+    go func() {
+        if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+            log.Fatalf("HTTP server: %v", err)
+        }
+    }()
+    log.Printf("Alert engine listening on %s", addr)
+
+    <-ctx.Done()
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    server.Shutdown(shutdownCtx)
+}
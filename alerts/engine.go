@@ -0,0 +1,141 @@
+package alerts
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "sort"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/tyuvic777/tyuabc777/telemetry"
+)
+
+// Reading is a device-signed wearable reading
+type Reading = telemetry.Reading
+
+// Firing is a rule becoming true for a patient device
+type Firing struct {
+    Rule      *Rule
+    PatientID string
+    DeviceID  string
+    FiredAt   time.Time
+    Summary   string
+    Evidence  []Reading
+}
+
+// AlertID is deterministic, so a firing recorded twice after a retry is
+// refused by the chaincode rather than duplicated
+func (f Firing) AlertID() string {
+    sum := sha256.Sum256([]byte(f.Rule.ID + "\x00" + strconv.Itoa(f.Rule.Version) + "\x00" + f.PatientID + "\x00" + f.DeviceID + "\x00" + strconv.FormatInt(f.FiredAt.UnixNano(), 10)))
+    return "al-" + hex.EncodeToString(sum[:16])
+}
+
+// EvidenceDigest is the SHA-256 of the evidence readings' JSON. They are
+// the same signed readings that the telemetry batches anchor.
+func (f Firing) EvidenceDigest() (string, error) {
+    data, err := json.Marshal(f.Evidence)
+    if err != nil {
+        return "", err
+    }
+    sum := sha256.Sum256(data)
+    return hex.EncodeToString(sum[:]), nil
+}
+
+type deviceKey struct {
+    patientID string
+    deviceID  string
+}
+
+type activeKey struct {
+    rule    string
+    version int
+    device  deviceKey
+}
+
+// Engine keeps a window of readings per patient device and evaluates every
+// rule as readings arrive. Alerts are edge-triggered: a rule fires when its
+// condition becomes true and fires again only after it has cleared.
+type Engine struct {
+    mu      sync.Mutex
+    rules   []*Rule
+    span    time.Duration
+    history map[deviceKey][]Reading
+    active  map[activeKey]bool
+}
+
+// NewEngine creates an engine with no rules
+func NewEngine() *Engine {
+    return &Engine{history: map[deviceKey][]Reading{}, active: map[activeKey]bool{}}
+}
+
+// SetRules replaces the rule set, e.g. after a refresh from the ledger
+func (e *Engine) SetRules(rules []*Rule) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    e.rules = rules
+    e.span = 0
+    live := map[string]int{}
+    for _, rule := range rules {
+        live[rule.ID] = rule.Version
+        if s := rule.Condition.Span(); s > e.span {
+            e.span = s
+        }
+    }
+    for key := range e.active {
+        if live[key.rule] != key.version {
+            delete(e.active, key)
+        }
+    }
+}
+
+// Observe adds a reading and returns the rules it made fire
+func (e *Engine) Observe(reading Reading) []Firing {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    key := deviceKey{reading.PatientID, reading.DeviceID}
+    history := append(e.history[key], reading)
+    sort.SliceStable(history, func(i, j int) bool { return history[i].RecordedAt.Before(history[j].RecordedAt) })
+    latest := history[len(history)-1].RecordedAt
+    keep := e.span + maxSampleGap
+    trim := 0
+    for trim < len(history)-1 && latest.Sub(history[trim].RecordedAt) > keep {
+        trim++
+    }
+    history = append([]Reading(nil), history[trim:]...)
+    e.history[key] = history
+
+    var firings []Firing
+    for _, rule := range e.rules {
+        ak := activeKey{rule.ID, rule.Version, key}
+        ok, summary := rule.Condition.Evaluate(history)
+        if !ok {
+            delete(e.active, ak)
+            continue
+        }
+        if e.active[ak] {
+            continue
+        }
+        e.active[ak] = true
+        firings = append(firings, Firing{
+            Rule:      rule,
+            PatientID: reading.PatientID,
+            DeviceID:  reading.DeviceID,
+            FiredAt:   latest,
+            Summary:   rule.Name + ": " + summary,
+            Evidence:  evidence(history, rule.Condition.Span()),
+        })
+    }
+    return firings
+}
+
+// The readings within span of the latest one
+func evidence(history []Reading, span time.Duration) []Reading {
+    latest := history[len(history)-1].RecordedAt
+    first := len(history) - 1
+    for first > 0 && latest.Sub(history[first-1].RecordedAt) <= span {
+        first--
+    }
+    return append([]Reading(nil), history[first:]...)
+}
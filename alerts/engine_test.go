@@ -0,0 +1,102 @@
+package alerts
+
+import (
+    "testing"
+    "time"
+)
+
+func testRule(version int, condition Condition) *Rule {
+    return &Rule{ID: "r1", Version: version, Name: "Tachycardia", Severity: "critical", Notify: []string{"d1"}, Condition: condition}
+}
+
+// observe feeds readings to the engine and returns how many firings each
+// one produced
+func observe(engine *Engine, readings []Reading) []int {
+    counts := make([]int, len(readings))
+    for i, reading := range readings {
+        counts[i] = len(engine.Observe(reading))
+    }
+    return counts
+}
+
+func TestEngineFiresOnRisingEdge(t *testing.T) {
+    tests := []struct {
+        name   string
+        values []float64
+        want   []int
+    }{
+        {"fires once while the condition holds", []float64{120, 135, 140, 138}, []int{0, 1, 0, 0}},
+        {"fires again after clearing", []float64{135, 140, 120, 136, 125}, []int{1, 0, 0, 1, 0}},
+        {"never fires below threshold", []float64{100, 110, 120}, []int{0, 0, 0}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            engine := NewEngine()
+            engine.SetRules([]*Rule{testRule(1, threshold(">", 130, 0))})
+            got := observe(engine, series(test.values...))
+            for i := range got {
+                if got[i] != test.want[i] {
+                    t.Fatalf("firings per reading %v, want %v", got, test.want)
+                }
+            }
+        })
+    }
+}
+
+func TestEngineKeepsDevicesApart(t *testing.T) {
+    engine := NewEngine()
+    engine.SetRules([]*Rule{testRule(1, threshold(">", 130, 0))})
+    watch := series(140, 140)
+    other := series(140)
+    other[0].DeviceID = "watch-2"
+
+    if n := len(engine.Observe(watch[0])); n != 1 {
+        t.Fatalf("%d firings for watch-1, want 1", n)
+    }
+    firings := engine.Observe(other[0])
+    if len(firings) != 1 || firings[0].DeviceID != "watch-2" {
+        t.Fatalf("firings %+v, want one for watch-2", firings)
+    }
+    if n := len(engine.Observe(watch[1])); n != 0 {
+        t.Fatalf("watch-1 fired again while its condition held")
+    }
+}
+
+func TestEngineFiringCarriesEvidence(t *testing.T) {
+    engine := NewEngine()
+    engine.SetRules([]*Rule{testRule(1, threshold(">", 130, 2*time.Minute))})
+    var firings []Firing
+    for _, reading := range series(100, 100, 135, 140, 145) {
+        firings = append(firings, engine.Observe(reading)...)
+    }
+    if len(firings) != 1 {
+        t.Fatalf("%d firings, want 1", len(firings))
+    }
+    firing := firings[0]
+    if !firing.FiredAt.Equal(testStart.Add(4*time.Minute)) || len(firing.Evidence) != 3 {
+        t.Fatalf("fired at %s with %d evidence readings, want the last 3 readings", firing.FiredAt, len(firing.Evidence))
+    }
+    // The ID is deterministic so a retried firing is refused, not duplicated
+    if firing.AlertID() != (Firing{Rule: firing.Rule, PatientID: "p1", DeviceID: "watch-1", FiredAt: firing.FiredAt}).AlertID() {
+        t.Fatal("alert ID depends on more than rule, device and time")
+    }
+}
+
+func TestEngineNewRuleVersionCanFireAgain(t *testing.T) {
+    engine := NewEngine()
+    engine.SetRules([]*Rule{testRule(1, threshold(">", 130, 0))})
+    readings := series(140, 140, 140)
+    if n := len(engine.Observe(readings[0])); n != 1 {
+        t.Fatalf("%d firings for v1, want 1", n)
+    }
+
+    // Keeping v1 keeps its active state; a revision starts afresh
+    engine.SetRules([]*Rule{testRule(1, threshold(">", 130, 0))})
+    if n := len(engine.Observe(readings[1])); n != 0 {
+        t.Fatalf("v1 fired again after an unchanged refresh")
+    }
+    engine.SetRules([]*Rule{testRule(2, threshold(">", 130, 0))})
+    if n := len(engine.Observe(readings[2])); n != 1 {
+        t.Fatalf("%d firings for v2, want 1", n)
+    }
+}
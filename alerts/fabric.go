@@ -0,0 +1,85 @@
+package alerts
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "strings"
+
+    "github.com/hyperledger/fabric-gateway/pkg/client"
+    "github.com/tyuvic777/tyuabc777/nonce"
+)
+
+// GatewayRules reads active rules with getActiveAlertRules
+type GatewayRules struct {
+    Contract *client.Contract
+}
+
+// Rules returns the latest version of every active rule. A rule the engine
+// cannot parse is an error, so a bad definition is noticed rather than
+// silently never firing.
+func (g *GatewayRules) Rules(ctx context.Context) ([]*Rule, error) {
+    result, err := g.Contract.EvaluateWithContext(ctx, "getActiveAlertRules")
+    if err != nil {
+        return nil, err
+    }
+    var stored []json.RawMessage
+    if err := json.Unmarshal(result, &stored); err != nil {
+        return nil, err
+    }
+    rules := make([]*Rule, 0, len(stored))
+    for _, ruleJSON := range stored {
+        rule, err := ParseRule(ruleJSON)
+        if err != nil {
+            return nil, err
+        }
+        rules = append(rules, rule)
+    }
+    return rules, nil
+}
+
+// GatewayRecorder submits recordAlert as the alert engine identity, whose
+// enrollment key signs the nonce that makes the alert auditable
+type GatewayRecorder struct {
+    Contract *client.Contract
+    Key      *ecdsa.PrivateKey
+}
+
+// Record submits the alert and waits for the commit. The summary quotes
+// vitals and is not sent; the ledger gets the evidence digest. An alert
+// already on the ledger counts as recorded.
+func (g *GatewayRecorder) Record(ctx context.Context, firing Firing) error {
+    digest, err := firing.EvidenceDigest()
+    if err != nil {
+        return err
+    }
+    args, err := nonce.Args(g.Key, "recordAlert",
+        firing.AlertID(),
+        firing.Rule.ID,
+        strconv.Itoa(firing.Rule.Version),
+        firing.PatientID,
+        firing.DeviceID,
+        strconv.FormatInt(firing.FiredAt.Unix(), 10),
+        digest,
+    )
+    if err != nil {
+        return err
+    }
+    _, commit, err := g.Contract.SubmitAsync("recordAlert", client.WithArguments(args...))
+    if err != nil {
+        if strings.Contains(err.Error(), "already recorded") {
+            return nil
+        }
+        return err
+    }
+    status, err := commit.StatusWithContext(ctx)
+    if err != nil {
+        return err
+    }
+    if !status.Successful {
+        return fmt.Errorf("alerts: transaction %s failed to commit with status %d", status.TransactionID, int32(status.Code))
+    }
+    return nil
+}
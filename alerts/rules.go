@@ -0,0 +1,236 @@
+// Package alerts evaluates clinical alert rules over the wearable
+// telemetry stream. Rules are versioned on PatientCareChaincode; when one
+// fires the engine records a signed alert there with recordAlert.
+package alerts
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "time"
+)
+
+// Condition types
+const (
+    Threshold = "threshold"
+    Trend     = "trend"
+    All       = "all"
+    Any       = "any"
+)
+
+// Readings further apart than this break a sustained threshold, so a
+// device that drops out does not keep an alert condition alive
+const maxSampleGap = 2 * time.Minute
+
+// Duration is a time.Duration written as a string such as "10m" in rule JSON
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+    var s string
+    if err := json.Unmarshal(data, &s); err != nil {
+        return errors.New("alerts: durations are strings such as \"10m\"")
+    }
+    parsed, err := time.ParseDuration(s)
+    if err != nil || parsed < 0 {
+        return fmt.Errorf("alerts: invalid duration %q", s)
+    }
+    *d = Duration(parsed)
+    return nil
+}
+
+// MarshalJSON writes the duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+    return json.Marshal(time.Duration(d).String())
+}
+
+// Condition is a node of a rule:
+//
+//   threshold: Metric Op Value holds on every reading for at least For,
+//              e.g. heart_rate > 130 for 10m; with no For, the latest
+//              reading alone decides
+//   trend:     Metric moved by at least Change over Window, e.g. a
+//              Change of -5 fires on a drop of 5 or more
+//   all, any:  composite of Conditions
+type Condition struct {
+    Type       string      `json:"type"`
+    Metric     string      `json:"metric,omitempty"`
+    Op         string      `json:"op,omitempty"`
+    Value      float64     `json:"value,omitempty"`
+    For        Duration    `json:"for,omitempty"`
+    Window     Duration    `json:"window,omitempty"`
+    Change     float64     `json:"change,omitempty"`
+    Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// Rule is one version of a rule as stored by putAlertRule
+type Rule struct {
+    ID        string    `json:"id"`
+    Version   int       `json:"version"`
+    Name      string    `json:"name"`
+    Severity  string    `json:"severity"`
+    Notify    []string  `json:"notify"`
+    Condition Condition `json:"condition"`
+}
+
+// ParseRule reads a ledger rule, whose definition holds the condition
+func ParseRule(ledgerJSON []byte) (*Rule, error) {
+    var stored struct {
+        ID         string          `json:"id"`
+        Version    int             `json:"version"`
+        Definition json.RawMessage `json:"definition"`
+    }
+    if err := json.Unmarshal(ledgerJSON, &stored); err != nil {
+        return nil, err
+    }
+    var rule Rule
+    if err := json.Unmarshal(stored.Definition, &rule); err != nil {
+        return nil, fmt.Errorf("alerts: rule %s: %w", stored.ID, err)
+    }
+    rule.ID, rule.Version = stored.ID, stored.Version
+    if err := rule.Condition.Validate(); err != nil {
+        return nil, fmt.Errorf("alerts: rule %s v%d: %w", rule.ID, rule.Version, err)
+    }
+    return &rule, nil
+}
+
+// Validate checks a condition tree
+func (c Condition) Validate() error {
+    switch c.Type {
+    case Threshold:
+        if c.Metric == "" {
+            return errors.New("threshold needs a metric")
+        }
+        if _, ok := comparisons[c.Op]; !ok {
+            return fmt.Errorf("threshold op %q must be one of >, >=, <, <=", c.Op)
+        }
+    case Trend:
+        if c.Metric == "" || c.Window <= 0 || c.Change == 0 {
+            return errors.New("trend needs a metric, a window and a non-zero change")
+        }
+    case All, Any:
+        if len(c.Conditions) == 0 {
+            return fmt.Errorf("%s needs at least one condition", c.Type)
+        }
+        for _, child := range c.Conditions {
+            if err := child.Validate(); err != nil {
+                return err
+            }
+        }
+    default:
+        return fmt.Errorf("unknown condition type %q", c.Type)
+    }
+    return nil
+}
+
+// Span is how much history the condition looks at
+func (c Condition) Span() time.Duration {
+    span := time.Duration(c.For)
+    if time.Duration(c.Window) > span {
+        span = time.Duration(c.Window)
+    }
+    for _, child := range c.Conditions {
+        if s := child.Span(); s > span {
+            span = s
+        }
+    }
+    return span
+}
+
+var comparisons = map[string]func(a, b float64) bool{
+    ">":  func(a, b float64) bool { return a > b },
+    ">=": func(a, b float64) bool { return a >= b },
+    "<":  func(a, b float64) bool { return a < b },
+    "<=": func(a, b float64) bool { return a <= b },
+}
+
+// sample is one metric value from a reading
+type sample struct {
+    at    time.Time
+    value float64
+}
+
+// Values of a metric in history order
+func samples(history []Reading, metric string) []sample {
+    out := []sample{}
+    for _, r := range history {
+        if v, ok := r.Metrics[metric]; ok {
+            out = append(out, sample{r.RecordedAt, v})
+        }
+    }
+    return out
+}
+
+// Evaluate reports whether the condition holds over history, oldest
+// first, with a human-readable reason when it does
+func (c Condition) Evaluate(history []Reading) (bool, string) {
+    switch c.Type {
+    case Threshold:
+        values := samples(history, c.Metric)
+        if len(values) == 0 {
+            return false, ""
+        }
+        compare := comparisons[c.Op]
+        latest := values[len(values)-1]
+        if !compare(latest.value, c.Value) {
+            return false, ""
+        }
+        since := latest.at
+        for i := len(values) - 2; i >= 0; i-- {
+            if !compare(values[i].value, c.Value) || values[i+1].at.Sub(values[i].at) > maxSampleGap {
+                break
+            }
+            since = values[i].at
+        }
+        if latest.at.Sub(since) < time.Duration(c.For) {
+            return false, ""
+        }
+        if c.For == 0 {
+            return true, fmt.Sprintf("%s %g %s %g", c.Metric, latest.value, c.Op, c.Value)
+        }
+        return true, fmt.Sprintf("%s %s %g for %s (now %g)", c.Metric, c.Op, c.Value, latest.at.Sub(since).Round(time.Second), latest.value)
+    case Trend:
+        values := samples(history, c.Metric)
+        if len(values) < 2 {
+            return false, ""
+        }
+        latest := values[len(values)-1]
+        start := latest.at.Add(-time.Duration(c.Window))
+        first := -1
+        for i, v := range values {
+            if !v.at.Before(start) {
+                first = i
+                break
+            }
+        }
+        // Need readings across at least half the window to call a trend
+        if first < 0 || first == len(values)-1 || latest.at.Sub(values[first].at) < time.Duration(c.Window)/2 {
+            return false, ""
+        }
+        change := latest.value - values[first].value
+        if (c.Change < 0 && change <= c.Change) || (c.Change > 0 && change >= c.Change) {
+            return true, fmt.Sprintf("%s changed by %+g over %s", c.Metric, change, latest.at.Sub(values[first].at).Round(time.Second))
+        }
+        return false, ""
+    case All:
+        reasons := ""
+        for _, child := range c.Conditions {
+            ok, reason := child.Evaluate(history)
+            if !ok {
+                return false, ""
+            }
+            if reasons != "" {
+                reasons += " and "
+            }
+            reasons += reason
+        }
+        return true, reasons
+    case Any:
+        for _, child := range c.Conditions {
+            if ok, reason := child.Evaluate(history); ok {
+                return true, reason
+            }
+        }
+    }
+    return false, ""
+}
@@ -0,0 +1,97 @@
+package alerts
+
+import (
+    "testing"
+    "time"
+)
+
+var testStart = time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+// series returns one heart_rate reading a minute from testStart per value
+func series(values ...float64) []Reading {
+    readings := make([]Reading, len(values))
+    for i, v := range values {
+        readings[i] = Reading{
+            PatientID:  "p1",
+            DeviceID:   "watch-1",
+            RecordedAt: testStart.Add(time.Duration(i) * time.Minute),
+            Metrics:    map[string]float64{"heart_rate": v},
+        }
+    }
+    return readings
+}
+
+func threshold(op string, value float64, forDuration time.Duration) Condition {
+    return Condition{Type: Threshold, Metric: "heart_rate", Op: op, Value: value, For: Duration(forDuration)}
+}
+
+func trend(change float64, window time.Duration) Condition {
+    return Condition{Type: Trend, Metric: "heart_rate", Change: change, Window: Duration(window)}
+}
+
+func TestConditionEvaluate(t *testing.T) {
+    gap := series(140, 140, 140, 140)
+    gap[2].RecordedAt = gap[1].RecordedAt.Add(maxSampleGap + time.Second)
+    gap[3].RecordedAt = gap[2].RecordedAt.Add(time.Minute)
+
+    tests := []struct {
+        name      string
+        condition Condition
+        history   []Reading
+        want      bool
+    }{
+        {"threshold latest reading", threshold(">", 130, 0), series(120, 135), true},
+        {"threshold latest reading below", threshold(">", 130, 0), series(135, 120), false},
+        {"threshold no samples", threshold(">", 130, 0), nil, false},
+        {"threshold other metric", Condition{Type: Threshold, Metric: "spo2", Op: "<", Value: 90}, series(80), false},
+        {"threshold held for long enough", threshold(">", 130, 3*time.Minute), series(120, 135, 140, 138, 132), true},
+        {"threshold not held long enough", threshold(">", 130, 3*time.Minute), series(120, 120, 135, 140, 132), false},
+        {"threshold broken by a dip", threshold(">", 130, 3*time.Minute), series(135, 140, 125, 140, 138), false},
+        {"threshold broken by a gap", threshold(">=", 140, 2*time.Minute), gap, false},
+        {"threshold less or equal", threshold("<=", 50, time.Minute), series(60, 50, 48), true},
+        {"trend rise", trend(20, 4*time.Minute), series(80, 85, 95, 100, 105), true},
+        {"trend rise too small", trend(20, 4*time.Minute), series(80, 85, 90, 95, 99), false},
+        {"trend drop", trend(-10, 4*time.Minute), series(100, 98, 95, 92, 90), true},
+        {"trend drop is not a rise", trend(10, 4*time.Minute), series(100, 98, 95, 92, 90), false},
+        {"trend too little history", trend(5, 10*time.Minute), series(80, 90, 100), false},
+        {"trend one reading", trend(5, time.Minute), series(80), false},
+        {"all holds", Condition{Type: All, Conditions: []Condition{threshold(">", 100, 0), trend(20, 2*time.Minute)}}, series(80, 95, 110), true},
+        {"all one fails", Condition{Type: All, Conditions: []Condition{threshold(">", 120, 0), trend(20, 2*time.Minute)}}, series(80, 95, 110), false},
+        {"any one holds", Condition{Type: Any, Conditions: []Condition{threshold(">", 120, 0), trend(20, 2*time.Minute)}}, series(80, 95, 110), true},
+        {"any none hold", Condition{Type: Any, Conditions: []Condition{threshold(">", 120, 0), trend(50, 2*time.Minute)}}, series(80, 95, 110), false},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            if err := test.condition.Validate(); err != nil {
+                t.Fatalf("Validate: %v", err)
+            }
+            got, reason := test.condition.Evaluate(test.history)
+            if got != test.want {
+                t.Fatalf("Evaluate = %v (%q), want %v", got, reason, test.want)
+            }
+            if got && reason == "" {
+                t.Fatal("a condition that holds needs a reason")
+            }
+        })
+    }
+}
+
+func TestConditionValidate(t *testing.T) {
+    tests := []struct {
+        name      string
+        condition Condition
+    }{
+        {"unknown type", Condition{Type: "median"}},
+        {"threshold without metric", Condition{Type: Threshold, Op: ">"}},
+        {"threshold bad op", Condition{Type: Threshold, Metric: "heart_rate", Op: "=="}},
+        {"trend without window", Condition{Type: Trend, Metric: "heart_rate", Change: 5}},
+        {"trend without change", trend(0, time.Minute)},
+        {"empty all", Condition{Type: All}},
+        {"bad child", Condition{Type: Any, Conditions: []Condition{{Type: Threshold}}}},
+    }
+    for _, test := range tests {
+        if err := test.condition.Validate(); err == nil {
+            t.Errorf("%s: Validate succeeded", test.name)
+        }
+    }
+}
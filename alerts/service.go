@@ -0,0 +1,159 @@
+package alerts
+
+import (
+    "context"
+    "crypto/subtle"
+    "encoding/json"
+    "log"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// RuleSource loads the current rule set
+type RuleSource interface {
+    Rules(ctx context.Context) ([]*Rule, error)
+}
+
+// Recorder records a firing as an alert
+type Recorder interface {
+    Record(ctx context.Context, firing Firing) error
+}
+
+// Service feeds readings to the engine, keeps its rules in step with the
+// ledger and records firings, retrying those the ledger did not take
+type Service struct {
+    engine   *Engine
+    rules    RuleSource
+    recorder Recorder
+    refresh  time.Duration
+    logger   *log.Logger
+
+    mu      sync.Mutex
+    pending []Firing
+    wake    chan struct{}
+}
+
+// NewService creates a service that reloads rules every refresh
+func NewService(engine *Engine, rules RuleSource, recorder Recorder, refresh time.Duration, logger *log.Logger) *Service {
+    if logger == nil {
+        logger = log.Default()
+    }
+    return &Service{
+        engine:   engine,
+        rules:    rules,
+        recorder: recorder,
+        refresh:  refresh,
+        logger:   logger,
+        wake:     make(chan struct{}, 1),
+    }
+}
+
+// Observe evaluates a reading and queues any firings for recording
+func (s *Service) Observe(reading Reading) {
+    firings := s.engine.Observe(reading)
+    if len(firings) == 0 {
+        return
+    }
+    s.mu.Lock()
+    s.pending = append(s.pending, firings...)
+    s.mu.Unlock()
+    select {
+    case s.wake <- struct{}{}:
+    default:
+    }
+}
+
+func (s *Service) loadRules(ctx context.Context) {
+    rules, err := s.rules.Rules(ctx)
+    if err != nil {
+        s.logger.Printf("alerts: loading rules: %v", err)
+        return
+    }
+    s.engine.SetRules(rules)
+}
+
+// Record pending firings; failures stay queued for the next attempt
+func (s *Service) recordPending(ctx context.Context) {
+    s.mu.Lock()
+    firings := s.pending
+    s.pending = nil
+    s.mu.Unlock()
+
+    var failed []Firing
+    for _, firing := range firings {
+        if err := s.recorder.Record(ctx, firing); err != nil {
+            s.logger.Printf("alerts: recording %s for patient %s: %v", firing.Rule.ID, firing.PatientID, err)
+            failed = append(failed, firing)
+            continue
+        }
+        s.logger.Printf("alerts: %s alert %s recorded for patient %s", firing.Rule.Severity, firing.AlertID(), firing.PatientID)
+    }
+    if len(failed) > 0 {
+        s.mu.Lock()
+        s.pending = append(failed, s.pending...)
+        s.mu.Unlock()
+    }
+}
+
+// Run loads rules, then records firings as they come and refreshes the
+// rules on a timer until ctx is done
+func (s *Service) Run(ctx context.Context) {
+    s.loadRules(ctx)
+    refresh := time.NewTicker(s.refresh)
+    defer refresh.Stop()
+    retry := time.NewTicker(10 * time.Second)
+    defer retry.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-refresh.C:
+            s.loadRules(ctx)
+        case <-s.wake:
+            s.recordPending(ctx)
+        case <-retry.C:
+            s.recordPending(ctx)
+        }
+    }
+}
+
+// Handler accepts POST /readings with an array of readings, as forwarded by
+// the telemetry ingestion service after it has verified them
+func Handler(s *Service, token string) http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/readings", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            w.Header().Set("Allow", http.MethodPost)
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        if token != "" {
+            given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+            if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+                http.Error(w, "unauthorized", http.StatusUnauthorized)
+                return
+            }
+        }
+        var readings []Reading
+        if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<20)).Decode(&readings); err != nil {
+            http.Error(w, "invalid readings", http.StatusBadRequest)
+            return
+        }
+        for _, reading := range readings {
+            if err := reading.Validate(); err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+        }
+        for _, reading := range readings {
+            s.Observe(reading)
+        }
+        w.WriteHeader(http.StatusAccepted)
+    })
+    mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusOK)
+    })
+    return mux
+}
@@ -0,0 +1,119 @@
+// Package attachments checks IPFS objects against the attachment manifest
+// that PatientCareChaincode keeps for each patient record.
+package attachments
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "time"
+)
+
+// Attachment mirrors one entry of the chaincode's attachment manifest
+type Attachment struct {
+    ID             string    `json:"id"`
+    CID            string    `json:"cid"`
+    MIMEType       string    `json:"mime_type"`
+    Size           int64     `json:"size"`
+    Digest         string    `json:"digest"`
+    KeyRef         string    `json:"key_ref"`
+    UploaderDID    string    `json:"uploader_did"`
+    AddedAt        time.Time `json:"added_at"`
+}
+
+// Manifest mirrors the manifest returned by getAttachments
+type Manifest struct {
+    RecordID       string       `json:"record_id"`
+    Attachments    []Attachment `json:"attachments"`
+    NextID         int          `json:"next_id"`
+    UpdatedAt      time.Time    `json:"updated_at"`
+}
+
+// Store fetches object bytes by CID
+type Store interface {
+    Get(ctx context.Context, cid string) (io.ReadCloser, error)
+}
+
+// HTTPStore reads from an IPFS node's HTTP RPC API (kubo /api/v0/cat)
+type HTTPStore struct {
+    BaseURL string
+    Client  *http.Client
+}
+
+// NewHTTPStore uses IPFS_URL, falling back to the local node
+func NewHTTPStore() *HTTPStore {
+    baseURL := os.Getenv("IPFS_URL")
+    if baseURL == "" {
+        baseURL = "http://localhost:5001"
+    }
+    return &HTTPStore{BaseURL: baseURL, Client: &http.Client{Timeout: 60 * time.Second}}
+}
+
+// Get streams the object stored under cid
+func (s *HTTPStore) Get(ctx context.Context, cid string) (io.ReadCloser, error) {
+    endpoint := strings.TrimRight(s.BaseURL, "/") + "/api/v0/cat?arg=" + url.QueryEscape(cid)
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, nil)
+    if err != nil {
+        return nil, err
+    }
+    client := s.Client
+    if client == nil {
+        client = http.DefaultClient
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode != http.StatusOK {
+        resp.Body.Close()
+        return nil, fmt.Errorf("attachments: ipfs cat %s returned %s", cid, resp.Status)
+    }
+    return resp.Body, nil
+}
+
+// ErrMismatch is returned when a fetched object does not match its manifest entry
+var ErrMismatch = errors.New("attachments: object does not match manifest")
+
+// Verify fetches an attachment from the store and confirms its size and
+// SHA-256 digest against the manifest entry
+func Verify(ctx context.Context, store Store, attachment Attachment) error {
+    body, err := store.Get(ctx, attachment.CID)
+    if err != nil {
+        return err
+    }
+    defer body.Close()
+
+    // Read one byte past the expected size so oversized objects are caught
+    hash := sha256.New()
+    n, err := io.Copy(hash, io.LimitReader(body, attachment.Size+1))
+    if err != nil {
+        return err
+    }
+    if n != attachment.Size {
+        return fmt.Errorf("%w: %s is %d bytes, manifest says %d", ErrMismatch, attachment.CID, n, attachment.Size)
+    }
+    digest := hex.EncodeToString(hash.Sum(nil))
+    if digest != attachment.Digest {
+        return fmt.Errorf("%w: %s has digest %s, manifest says %s", ErrMismatch, attachment.CID, digest, attachment.Digest)
+    }
+    return nil
+}
+
+// VerifyManifest checks every attachment on a record and returns the
+// failures keyed by attachment ID
+func VerifyManifest(ctx context.Context, store Store, manifest Manifest) map[string]error {
+    failures := make(map[string]error)
+    for _, attachment := range manifest.Attachments {
+        if err := Verify(ctx, store, attachment); err != nil {
+            failures[attachment.ID] = err
+        }
+    }
+    return failures
+}
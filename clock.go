@@ -0,0 +1,42 @@
+package main
+
+import (
+    "errors"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Clock supplies the time recorded in chaincode state. Every endorsing peer
+// must compute the same write set, so chaincode never reads the wall clock.
+type Clock interface {
+    Now(stub shim.ChaincodeStubInterface) (time.Time, error)
+}
+
+// TxClock reports the transaction timestamp set by the submitting client,
+// which is identical on every endorser
+type TxClock struct{}
+
+func (TxClock) Now(stub shim.ChaincodeStubInterface) (time.Time, error) {
+    ts, err := stub.GetTxTimestamp()
+    if err != nil || ts == nil {
+        return time.Time{}, errors.New("Failed to read transaction timestamp")
+    }
+    return ts.AsTime().UTC(), nil
+}
+
+// FixedClock always reports the same time; tests swap it in for TxClock
+type FixedClock struct {
+    Time time.Time
+}
+
+func (c FixedClock) Now(stub shim.ChaincodeStubInterface) (time.Time, error) {
+    return c.Time.UTC(), nil
+}
+
+// Clock used by all chaincodes in this package
+var chaincodeClock Clock = TxClock{}
+
+// Current ledger time for a transaction
+func ledgerNow(stub shim.ChaincodeStubInterface) (time.Time, error) {
+    return chaincodeClock.Now(stub)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Ledger time and events as PatientCareChaincode records them; see clock.go
+// and events.go at the repository root, which these must stay in step with
+
+// Current ledger time: the transaction timestamp set by the submitting
+// client, which is identical on every endorser
+func ledgerNow(stub shim.ChaincodeStubInterface) (time.Time, error) {
+    ts, err := stub.GetTxTimestamp()
+    if err != nil || ts == nil {
+        return time.Time{}, errors.New("Failed to read transaction timestamp")
+    }
+    return ts.AsTime().UTC(), nil
+}
+
+// LedgerEvent is the identifier-only payload of every chaincode event
+type LedgerEvent struct {
+    Type           string    `json:"type"`
+    ObjectID       string    `json:"object_id"`
+    PatientID      string    `json:"patient_id,omitempty"`
+    ActorID        string    `json:"actor_id,omitempty"`
+    Status         string    `json:"status,omitempty"`
+    CounterpartyID string    `json:"counterparty_id,omitempty"`
+    Amount         float64   `json:"amount,omitempty"`
+    TxID           string    `json:"tx_id"`
+    At             time.Time `json:"at"`
+}
+
+// Set event as the transaction's chaincode event, stamping it with the
+// transaction ID and ledger time
+func emitLedgerEvent(stub shim.ChaincodeStubInterface, event LedgerEvent) error {
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return err
+    }
+    event.TxID = stub.GetTxID()
+    event.At = now
+    eventJSON, err := json.Marshal(event)
+    if err != nil {
+        return errors.New("Failed to marshal event JSON")
+    }
+    if err := stub.SetEvent(event.Type, eventJSON); err != nil {
+        return errors.New("Failed to emit " + event.Type + " event")
+    }
+    return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/asn1"
+    "encoding/json"
+    "encoding/pem"
+    "fmt"
+    "math/big"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/golang/protobuf/proto"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-chaincode-go/shimtest"
+    "github.com/hyperledger/fabric-protos-go/msp"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// testCreator returns a serialized Org1MSP identity with the user_id and
+// role attributes Fabric CA puts in the enrollment certificate
+func testCreator(t *testing.T, userID, role string) []byte {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    attrsJSON, _ := json.Marshal(map[string]interface{}{"attrs": map[string]string{"user_id": userID, "role": role}})
+    template := &x509.Certificate{
+        SerialNumber:    big.NewInt(1),
+        Subject:         pkix.Name{CommonName: userID},
+        NotBefore:       time.Unix(0, 0),
+        NotAfter:        time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+        ExtraExtensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}, Value: attrsJSON}},
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: "Org1MSP", IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return creator
+}
+
+// testPatientCare answers getEncounter from a fixed set of encounters
+type testPatientCare struct {
+    encounters map[string]EncounterSummary
+}
+
+func (c *testPatientCare) Init(stub shim.ChaincodeStubInterface) pb.Response {
+    return shim.Success(nil)
+}
+
+func (c *testPatientCare) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+    function, args := stub.GetFunctionAndParameters()
+    encounter, ok := c.encounters[strings.Join(args, "")]
+    if function != "getEncounter" || !ok {
+        return shim.Error("Encounter not found")
+    }
+    encounterJSON, _ := json.Marshal(encounter)
+    return shim.Success(encounterJSON)
+}
+
+// testPayments drives PaymentChaincode through a MockStub next to a stub
+// patient care chaincode, with the Ethereum sync pointed at a test server
+type testPayments struct {
+    t       *testing.T
+    stub    *shimtest.MockStub
+    txCount int
+}
+
+func newTestPayments(t *testing.T, encounters ...EncounterSummary) *testPayments {
+    patientCare := &testPatientCare{encounters: map[string]EncounterSummary{}}
+    for _, encounter := range encounters {
+        patientCare.encounters[encounter.ID] = encounter
+    }
+    stub := shimtest.NewMockStub("payment", new(PaymentChaincode))
+    stub.MockPeerChaincode(patientCareChaincodeName, shimtest.NewMockStub(patientCareChaincodeName, patientCare), "")
+
+    eth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    saved := ETH_URL
+    ETH_URL = eth.URL
+    t.Cleanup(func() {
+        ETH_URL = saved
+        eth.Close()
+    })
+    return &testPayments{t: t, stub: stub}
+}
+
+func (p *testPayments) invoke(creator []byte, function string, args ...string) pb.Response {
+    p.txCount++
+    p.stub.Creator = creator
+    input := [][]byte{[]byte(function)}
+    for _, arg := range args {
+        input = append(input, []byte(arg))
+    }
+    return p.stub.MockInvoke(fmt.Sprintf("tx%04d", p.txCount), input)
+}
+
+func (p *testPayments) mustInvoke(creator []byte, function string, args ...string) []byte {
+    p.t.Helper()
+    response := p.invoke(creator, function, args...)
+    if response.Status != shim.OK {
+        p.t.Fatalf("%s: %s", function, response.Message)
+    }
+    return response.Payload
+}
+
+func (p *testPayments) mustFail(want string, creator []byte, function string, args ...string) {
+    p.t.Helper()
+    response := p.invoke(creator, function, args...)
+    if response.Status == shim.OK {
+        p.t.Fatalf("%s succeeded, want error %q", function, want)
+    }
+    if !strings.Contains(response.Message, want) {
+        p.t.Fatalf("%s: error %q, want %q", function, response.Message, want)
+    }
+}
+
+func (p *testPayments) balance(userID string) float64 {
+    p.t.Helper()
+    var balance TokenBalance
+    if err := json.Unmarshal(p.stub.State[userID], &balance); err != nil {
+        p.t.Fatal(err)
+    }
+    return balance.Balance
+}
+
+func TestBillEncounterChargesScheduledFee(t *testing.T) {
+    payments := newTestPayments(t,
+        EncounterSummary{ID: "e1", PatientID: "p1", DoctorID: "d1", Status: "closed"},
+        EncounterSummary{ID: "e2", PatientID: "p1", DoctorID: "d2", Status: "closed"},
+    )
+    admin := testCreator(t, "admin1", "admin")
+    billing := testCreator(t, "billing1", "billing")
+    for _, userID := range []string{"p1", "d1", "d2"} {
+        payments.mustInvoke(admin, "initializeToken", userID, "0")
+    }
+    payments.mustInvoke(admin, "initializeToken", "p1", "200")
+    payments.mustInvoke(admin, "setEncounterFee", "*", "50")
+    payments.mustInvoke(admin, "setEncounterFee", "d1", "80")
+
+    var bill EncounterBill
+    if err := json.Unmarshal(payments.mustInvoke(billing, "billEncounter", "e1"), &bill); err != nil {
+        t.Fatal(err)
+    }
+    if bill.Amount != 80 || payments.balance("p1") != 120 || payments.balance("d1") != 80 {
+        t.Fatalf("billed %g, balances p1 %g d1 %g; want d1's fee of 80 moved", bill.Amount, payments.balance("p1"), payments.balance("d1"))
+    }
+
+    // d2 has no fee of their own and is billed the default
+    payments.mustInvoke(billing, "billEncounter", "e2")
+    if payments.balance("p1") != 70 || payments.balance("d2") != 50 {
+        t.Fatalf("balances p1 %g d2 %g, want the default fee of 50 moved", payments.balance("p1"), payments.balance("d2"))
+    }
+    payments.mustFail("has already been billed", billing, "billEncounter", "e2")
+}
+
+func TestBillEncounterRefusals(t *testing.T) {
+    payments := newTestPayments(t,
+        EncounterSummary{ID: "e1", PatientID: "p1", DoctorID: "d1", Status: "closed"},
+        EncounterSummary{ID: "e2", PatientID: "p1", DoctorID: "d1", Status: "open"},
+    )
+    admin := testCreator(t, "admin1", "admin")
+    billing := testCreator(t, "billing1", "billing")
+    payments.mustInvoke(admin, "initializeToken", "p1", "20")
+    payments.mustInvoke(admin, "initializeToken", "d1", "0")
+
+    // The caller cannot name the amount
+    payments.mustFail("Please provide the encounter ID to bill", billing, "billEncounter", "e1", "1000")
+    payments.mustFail("no encounter fee for doctor d1", billing, "billEncounter", "e1")
+
+    payments.mustFail("only admins can set encounter fees", billing, "setEncounterFee", "*", "50")
+    payments.mustFail("isn’t valid", admin, "setEncounterFee", "*", "-5")
+    payments.mustInvoke(admin, "setEncounterFee", "*", "50")
+
+    payments.mustFail("only billing staff or admins", testCreator(t, "p1", "patient"), "billEncounter", "e1")
+    payments.mustFail("is not closed yet", billing, "billEncounter", "e2")
+    payments.mustFail("could not be read", billing, "billEncounter", "e9")
+    payments.mustFail("insufficient balance", billing, "billEncounter", "e1")
+    if payments.balance("p1") != 20 || payments.balance("d1") != 0 {
+        t.Fatal("a refused bill moved tokens")
+    }
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// LedgerEvent is the payload of the chaincode event every state-changing
+// function emits, named by Type (for example RecordCreated). It carries
+// identifiers only and never PHI: no hashes of clinical content, free-text
+// reasons, diagnosis codes or DID attributes. Listeners fetch the object
+// itself, with their own access rights, when they need more.
+//
+//   type            event name, <Object><Past-tense action>
+//   object_id       ID of the object that changed
+//   patient_id      patient the object belongs to, when there is one
+//   actor_id        user ID of the caller, when the chaincode knows it
+//   status          status of the object after the change, if it has one
+//   counterparty_id other user of a two-party change, such as a transfer
+//   amount          token amount of a reward or transfer
+//   tx_id, at       transaction ID and ledger time
+//
+// This holds for RecordErased, ClinicalAlert, ControlledSubstanceFlag,
+// TrialConsentWithdrawn and EncounterClosed too: the erasure certificate,
+// alert, flag, withdrawn contributions and diagnoses are read with their
+// getters. ControlledSubstanceFlag also leaves out patient_id.
+type LedgerEvent struct {
+    Type           string    `json:"type"`
+    ObjectID       string    `json:"object_id"`
+    PatientID      string    `json:"patient_id,omitempty"`
+    ActorID        string    `json:"actor_id,omitempty"`
+    Status         string    `json:"status,omitempty"`
+    CounterpartyID string    `json:"counterparty_id,omitempty"`
+    Amount         float64   `json:"amount,omitempty"`
+    TxID           string    `json:"tx_id"`
+    At             time.Time `json:"at"`
+}
+
+// Set event as the transaction's chaincode event, stamping it with the
+// transaction ID and ledger time
+func emitLedgerEvent(stub shim.ChaincodeStubInterface, event LedgerEvent) error {
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return err
+    }
+    event.TxID = stub.GetTxID()
+    event.At = now
+    eventJSON, err := json.Marshal(event)
+    if err != nil {
+        return errors.New("Failed to marshal event JSON")
+    }
+    if err := stub.SetEvent(event.Type, eventJSON); err != nil {
+        return errors.New("Failed to emit " + event.Type + " event")
+    }
+    return nil
+}
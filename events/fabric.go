@@ -0,0 +1,75 @@
+package events
+
+import (
+    "context"
+    "errors"
+    "log"
+    "time"
+
+    "github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// RetryDelay is how long Run waits before resubscribing after the event
+// stream breaks
+var RetryDelay = 5 * time.Second
+
+// Checkpointer records the last event handled, so a restarted or
+// resubscribed listener resumes after it. The *client.FileCheckpointer from
+// client.NewFileCheckpointer satisfies it.
+type Checkpointer interface {
+    client.Checkpoint
+    CheckpointChaincodeEvent(event *client.ChaincodeEvent) error
+}
+
+// errStreamClosed means the peer ended the event stream
+var errStreamClosed = errors.New("events: event stream closed")
+
+// Run dispatches the events of one chaincode until ctx is done, returning
+// ctx's error. A broken stream is resumed from the checkpoint after
+// RetryDelay; a handler error is returned without checkpointing the event.
+// opts, such as client.WithStartBlock(0) to replay from genesis, apply
+// only while checkpointer has no position yet.
+func (l *Listener) Run(ctx context.Context, network *client.Network, chaincode string, checkpointer Checkpointer, opts ...client.ChaincodeEventsOption) error {
+    for {
+        err := l.subscribe(ctx, network, chaincode, checkpointer, opts)
+        if ctx.Err() != nil {
+            return ctx.Err()
+        }
+        var handlerErr *handlerError
+        if errors.As(err, &handlerErr) {
+            return handlerErr.err
+        }
+        log.Printf("events: %s subscription: %v; resubscribing in %s", chaincode, err, RetryDelay)
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(RetryDelay):
+        }
+    }
+}
+
+// handlerError marks a handler failure, which Run does not retry
+type handlerError struct {
+    err error
+}
+
+func (e *handlerError) Error() string { return e.err.Error() }
+
+func (l *Listener) subscribe(ctx context.Context, network *client.Network, chaincode string, checkpointer Checkpointer, opts []client.ChaincodeEventsOption) error {
+    streamCtx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    options := append(append([]client.ChaincodeEventsOption(nil), opts...), client.WithCheckpoint(checkpointer))
+    stream, err := network.ChaincodeEvents(streamCtx, chaincode, options...)
+    if err != nil {
+        return err
+    }
+    for event := range stream {
+        if err := l.Dispatch(ctx, event.ChaincodeName, event.EventName, event.BlockNumber, event.TransactionID, event.Payload); err != nil {
+            return &handlerError{err: err}
+        }
+        if err := checkpointer.CheckpointChaincodeEvent(event); err != nil {
+            return &handlerError{err: err}
+        }
+    }
+    return errStreamClosed
+}
@@ -0,0 +1,100 @@
+// Package events subscribes to the chaincode events that every state change
+// on PatientCareChaincode, IdentityChaincode and PaymentChaincode emits and
+// turns them into callbacks. Event names and the payload schema are
+// documented on LedgerEvent in events.go at the repository root.
+package events
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "sync"
+    "time"
+)
+
+// Event is one chaincode event, carrying the common ledger event payload
+// decoded into the fields below. The raw payload is kept in Payload.
+type Event struct {
+    Chaincode      string          `json:"-"`
+    Name           string          `json:"-"`
+    BlockNumber    uint64          `json:"-"`
+    Payload        json.RawMessage `json:"-"`
+
+    Type           string          `json:"type"`
+    ObjectID       string          `json:"object_id"`
+    PatientID      string          `json:"patient_id"`
+    ActorID        string          `json:"actor_id"`
+    Status         string          `json:"status"`
+    CounterpartyID string          `json:"counterparty_id"`
+    Amount         float64         `json:"amount"`
+    TxID           string          `json:"tx_id"`
+    At             time.Time       `json:"at"`
+}
+
+// Handler is called for each event it is registered for. An error stops
+// the listener before the event is checkpointed, so it is delivered again.
+type Handler func(ctx context.Context, event Event) error
+
+// Listener routes events to handlers by event name
+type Listener struct {
+    mu       sync.RWMutex
+    handlers map[string][]Handler
+    any      []Handler
+}
+
+// NewListener creates a listener with no handlers
+func NewListener() *Listener {
+    return &Listener{handlers: map[string][]Handler{}}
+}
+
+// On registers handler for events named name, e.g. "RecordCreated"
+func (l *Listener) On(name string, handler Handler) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.handlers[name] = append(l.handlers[name], handler)
+}
+
+// OnAny registers handler for every event
+func (l *Listener) OnAny(handler Handler) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.any = append(l.any, handler)
+}
+
+// Decode builds an Event from a chaincode event's name and payload
+func Decode(chaincode, name string, blockNumber uint64, txID string, payload []byte) (Event, error) {
+    event := Event{}
+    if len(payload) > 0 {
+        if err := json.Unmarshal(payload, &event); err != nil {
+            return Event{}, fmt.Errorf("events: decoding %s payload in tx %s: %w", name, txID, err)
+        }
+    }
+    event.Chaincode = chaincode
+    event.Name = name
+    event.BlockNumber = blockNumber
+    event.Payload = append(json.RawMessage(nil), payload...)
+    if event.TxID == "" {
+        event.TxID = txID
+    }
+    return event, nil
+}
+
+// Dispatch decodes an event payload and calls the handlers for its name,
+// then the handlers for every event, in the order they were registered. It
+// stops at the first handler error.
+func (l *Listener) Dispatch(ctx context.Context, chaincode, name string, blockNumber uint64, txID string, payload []byte) error {
+    event, err := Decode(chaincode, name, blockNumber, txID, payload)
+    if err != nil {
+        return err
+    }
+
+    l.mu.RLock()
+    handlers := append(append([]Handler(nil), l.handlers[name]...), l.any...)
+    l.mu.RUnlock()
+    for _, handler := range handlers {
+        if err := handler(ctx, event); err != nil {
+            return fmt.Errorf("events: handling %s in tx %s: %w", name, txID, err)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,121 @@
+// Package gateway connects the Go services to a Fabric Gateway peer with
+// the identity named by the FABRIC_* environment variables.
+package gateway
+
+import (
+    "crypto/ecdsa"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "os"
+    "time"
+
+    "github.com/hyperledger/fabric-gateway/pkg/client"
+    "github.com/hyperledger/fabric-gateway/pkg/identity"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/credentials"
+)
+
+// DefaultChannel is the channel the chaincodes are deployed on
+const DefaultChannel = "mediNetChannel"
+
+// Connection is an open gateway with the identity's signing key, which the
+// services also need for chaincode nonce signatures
+type Connection struct {
+    Gateway *client.Gateway
+    Key     *ecdsa.PrivateKey
+    conn    *grpc.ClientConn
+}
+
+// Close closes the gateway and its gRPC connection
+func (c *Connection) Close() {
+    c.Gateway.Close()
+    c.conn.Close()
+}
+
+// Network returns FABRIC_CHANNEL, or DefaultChannel
+func (c *Connection) Network() *client.Network {
+    channel := os.Getenv("FABRIC_CHANNEL")
+    if channel == "" {
+        channel = DefaultChannel
+    }
+    return c.Gateway.GetNetwork(channel)
+}
+
+func readEnvFile(name string) ([]byte, error) {
+    path := os.Getenv(name)
+    if path == "" {
+        return nil, fmt.Errorf("%s is required", name)
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("reading %s: %w", name, err)
+    }
+    return data, nil
+}
+
+// ConnectFromEnv dials FABRIC_PEER_ENDPOINT over TLS (FABRIC_TLS_CERT,
+// optional FABRIC_PEER_HOST override) as the identity in FABRIC_MSP_ID,
+// FABRIC_CERT and FABRIC_KEY
+func ConnectFromEnv() (*Connection, error) {
+    endpoint := os.Getenv("FABRIC_PEER_ENDPOINT")
+    mspID := os.Getenv("FABRIC_MSP_ID")
+    if endpoint == "" || mspID == "" {
+        return nil, errors.New("FABRIC_PEER_ENDPOINT and FABRIC_MSP_ID are required")
+    }
+    tlsPEM, err := readEnvFile("FABRIC_TLS_CERT")
+    if err != nil {
+        return nil, err
+    }
+    tlsCert, err := identity.CertificateFromPEM(tlsPEM)
+    if err != nil {
+        return nil, fmt.Errorf("parsing TLS certificate: %w", err)
+    }
+    certPEM, err := readEnvFile("FABRIC_CERT")
+    if err != nil {
+        return nil, err
+    }
+    cert, err := identity.CertificateFromPEM(certPEM)
+    if err != nil {
+        return nil, fmt.Errorf("parsing certificate: %w", err)
+    }
+    id, err := identity.NewX509Identity(mspID, cert)
+    if err != nil {
+        return nil, err
+    }
+    keyPEM, err := readEnvFile("FABRIC_KEY")
+    if err != nil {
+        return nil, err
+    }
+    privateKey, err := identity.PrivateKeyFromPEM(keyPEM)
+    if err != nil {
+        return nil, fmt.Errorf("parsing private key: %w", err)
+    }
+    key, ok := privateKey.(*ecdsa.PrivateKey)
+    if !ok {
+        return nil, errors.New("private key must be ECDSA")
+    }
+    sign, err := identity.NewPrivateKeySign(privateKey)
+    if err != nil {
+        return nil, err
+    }
+
+    pool := x509.NewCertPool()
+    pool.AddCert(tlsCert)
+    conn, err := grpc.Dial(endpoint, grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(pool, os.Getenv("FABRIC_PEER_HOST"))))
+    if err != nil {
+        return nil, fmt.Errorf("connecting to peer: %w", err)
+    }
+    gw, err := client.Connect(id,
+        client.WithSign(sign),
+        client.WithClientConnection(conn),
+        client.WithEndorseTimeout(15*time.Second),
+        client.WithSubmitTimeout(5*time.Second),
+        client.WithCommitStatusTimeout(time.Minute),
+    )
+    if err != nil {
+        conn.Close()
+        return nil, fmt.Errorf("connecting to gateway: %w", err)
+    }
+    return &Connection{Gateway: gw, Key: key, conn: conn}, nil
+}
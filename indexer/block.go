@@ -0,0 +1,168 @@
+package indexer
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/asn1"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math/big"
+    "time"
+
+    "github.com/hyperledger/fabric-protos-go-apiv2/common"
+    "github.com/hyperledger/fabric-protos-go-apiv2/peer"
+    "google.golang.org/protobuf/proto"
+)
+
+// Block is the part of a Fabric block the read model keeps
+type Block struct {
+    Number       uint64
+    Hash         string
+    PreviousHash string
+    DataHash     string
+    Transactions []Transaction
+}
+
+// Transaction is one envelope of a block. Function is the chaincode
+// function invoked; its arguments are never kept.
+type Transaction struct {
+    Index          int
+    ID             string
+    Type           string
+    Timestamp      time.Time
+    ValidationCode string
+    Valid          bool
+    Chaincode      string
+    Function       string
+    Event          *peer.ChaincodeEvent
+}
+
+// asn1Header is the encoding Fabric hashes to chain block headers
+type asn1Header struct {
+    Number       *big.Int
+    PreviousHash []byte
+    DataHash     []byte
+}
+
+// headerHash is the hash the next block's header points back to
+func headerHash(header *common.BlockHeader) (string, error) {
+    encoded, err := asn1.Marshal(asn1Header{
+        Number:       new(big.Int).SetUint64(header.Number),
+        PreviousHash: header.PreviousHash,
+        DataHash:     header.DataHash,
+    })
+    if err != nil {
+        return "", err
+    }
+    sum := sha256.Sum256(encoded)
+    return hex.EncodeToString(sum[:]), nil
+}
+
+// ParseBlock checks a block's data hash and extracts its transactions and
+// the chaincode event of each
+func ParseBlock(block *common.Block) (*Block, error) {
+    if block.GetHeader() == nil || block.GetData() == nil {
+        return nil, errors.New("indexer: block has no header or data")
+    }
+    number := block.Header.Number
+    dataHash := sha256.Sum256(bytes.Join(block.Data.Data, nil))
+    if !bytes.Equal(dataHash[:], block.Header.DataHash) {
+        return nil, fmt.Errorf("indexer: block %d data does not match its data hash", number)
+    }
+    hash, err := headerHash(block.Header)
+    if err != nil {
+        return nil, fmt.Errorf("indexer: hashing block %d header: %w", number, err)
+    }
+    var filter []byte
+    if metadata := block.GetMetadata().GetMetadata(); len(metadata) > int(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+        filter = metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER]
+    }
+
+    parsed := &Block{
+        Number:       number,
+        Hash:         hash,
+        PreviousHash: hex.EncodeToString(block.Header.PreviousHash),
+        DataHash:     hex.EncodeToString(block.Header.DataHash),
+    }
+    for i, data := range block.Data.Data {
+        tx, err := parseTransaction(data)
+        if err != nil {
+            return nil, fmt.Errorf("indexer: block %d transaction %d: %w", number, i, err)
+        }
+        tx.Index = i
+        code := peer.TxValidationCode_VALID
+        if i < len(filter) {
+            code = peer.TxValidationCode(filter[i])
+        }
+        tx.ValidationCode = code.String()
+        tx.Valid = code == peer.TxValidationCode_VALID
+        parsed.Transactions = append(parsed.Transactions, *tx)
+    }
+    return parsed, nil
+}
+
+func parseTransaction(data []byte) (*Transaction, error) {
+    envelope := &common.Envelope{}
+    if err := proto.Unmarshal(data, envelope); err != nil {
+        return nil, err
+    }
+    payload := &common.Payload{}
+    if err := proto.Unmarshal(envelope.Payload, payload); err != nil {
+        return nil, err
+    }
+    channelHeader := &common.ChannelHeader{}
+    if err := proto.Unmarshal(payload.GetHeader().GetChannelHeader(), channelHeader); err != nil {
+        return nil, err
+    }
+    tx := &Transaction{
+        ID:        channelHeader.TxId,
+        Type:      common.HeaderType(channelHeader.Type).String(),
+        Timestamp: channelHeader.GetTimestamp().AsTime().UTC(),
+    }
+    if common.HeaderType(channelHeader.Type) != common.HeaderType_ENDORSER_TRANSACTION {
+        return tx, nil
+    }
+
+    transaction := &peer.Transaction{}
+    if err := proto.Unmarshal(payload.Data, transaction); err != nil {
+        return nil, err
+    }
+    if len(transaction.Actions) == 0 {
+        return tx, nil
+    }
+    actionPayload := &peer.ChaincodeActionPayload{}
+    if err := proto.Unmarshal(transaction.Actions[0].Payload, actionPayload); err != nil {
+        return nil, err
+    }
+    proposalPayload := &peer.ChaincodeProposalPayload{}
+    if err := proto.Unmarshal(actionPayload.ChaincodeProposalPayload, proposalPayload); err != nil {
+        return nil, err
+    }
+    invocation := &peer.ChaincodeInvocationSpec{}
+    if err := proto.Unmarshal(proposalPayload.Input, invocation); err != nil {
+        return nil, err
+    }
+    if args := invocation.GetChaincodeSpec().GetInput().GetArgs(); len(args) > 0 {
+        tx.Function = string(args[0])
+    }
+    responsePayload := &peer.ProposalResponsePayload{}
+    if err := proto.Unmarshal(actionPayload.GetAction().GetProposalResponsePayload(), responsePayload); err != nil {
+        return nil, err
+    }
+    action := &peer.ChaincodeAction{}
+    if err := proto.Unmarshal(responsePayload.Extension, action); err != nil {
+        return nil, err
+    }
+    tx.Chaincode = action.GetChaincodeId().GetName()
+    if len(action.Events) > 0 {
+        event := &peer.ChaincodeEvent{}
+        if err := proto.Unmarshal(action.Events, event); err != nil {
+            return nil, err
+        }
+        if event.EventName != "" {
+            tx.Event = event
+        }
+    }
+    return tx, nil
+}
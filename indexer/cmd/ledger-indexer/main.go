@@ -0,0 +1,74 @@
+// Command ledger-indexer keeps the PostgreSQL read model of the ledger up
+// to date from the channel's block events. With -replay it empties the
+// read model first and rebuilds it from genesis; with -verify it rebuilds
+// a scratch copy from genesis, compares it with the live read model and
+// exits non-zero if they differ.
+package main
+
+import (
+    "context"
+    "flag"
+    "log"
+    "os"
+    "os/signal"
+    "syscall"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+    "github.com/tyuvic777/tyuabc777/gateway"
+    "github.com/tyuvic777/tyuabc777/indexer"
+)
+
+func main() {
+    replay := flag.Bool("replay", false, "rebuild the read model from genesis before indexing")
+    verify := flag.Bool("verify", false, "check the read model against a replay from genesis and exit")
+    flag.Parse()
+
+    databaseURL := os.Getenv("INDEXER_DATABASE_URL")
+    if databaseURL == "" {
+        log.Fatalf("INDEXER_DATABASE_URL must be set")
+    }
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    pool, err := pgxpool.New(ctx, databaseURL)
+    if err != nil {
+        log.Fatalf("Failed to connect to PostgreSQL: %v", err)
+    }
+    defer pool.Close()
+    store := indexer.NewStore(pool, os.Getenv("INDEXER_SCHEMA"))
+    if err := store.Migrate(ctx); err != nil {
+        log.Fatalf("Failed to migrate schema %s: %v", store.Schema, err)
+    }
+
+    conn, err := gateway.ConnectFromEnv()
+    if err != nil {
+        log.Fatalf("Failed to connect to Fabric: %v", err)
+    }
+    defer conn.Close()
+    ix := &indexer.Indexer{Network: conn.Network(), Store: store}
+
+    if *verify {
+        scratch := os.Getenv("INDEXER_VERIFY_SCHEMA")
+        if scratch == "" {
+            scratch = "ledger_verify"
+        }
+        if err := indexer.Verify(ctx, ix, scratch); err != nil {
+            log.Fatalf("Verify: %v", err)
+        }
+        return
+    }
+    if *replay {
+        if err := store.Reset(ctx); err != nil {
+            log.Fatalf("Failed to reset read model: %v", err)
+        }
+        log.Printf("Read model reset; replaying from genesis")
+    }
+    next, err := store.NextBlock(ctx)
+    if err != nil {
+        log.Fatalf("Failed to read checkpoint: %v", err)
+    }
+    log.Printf("Ledger indexer starting at block %d into schema %s", next, store.Schema)
+    if err := ix.Run(ctx); err != nil && ctx.Err() == nil {
+        log.Fatalf("Indexer stopped: %v", err)
+    }
+}
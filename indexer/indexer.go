@@ -0,0 +1,105 @@
+// Package indexer keeps a PostgreSQL read model of the ledger. It reads
+// every block from the channel in order, verifies that each one chains to
+// the last, and writes its transactions, chaincode events and the latest
+// state of the objects they touched. The checkpoint moves in the same
+// database transaction as the rows, so a crash never loses or repeats a
+// block, and a rebuild from genesis produces the same tables.
+package indexer
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/hyperledger/fabric-gateway/pkg/client"
+    "github.com/hyperledger/fabric-protos-go-apiv2/common"
+)
+
+// RetryDelay is how long Run waits before resubscribing after the block
+// stream breaks
+var RetryDelay = 5 * time.Second
+
+// errStreamClosed means the peer ended the block stream
+var errStreamClosed = errors.New("indexer: block stream closed")
+
+// BlockSource streams the blocks of a channel; *client.Network is one
+type BlockSource interface {
+    BlockEvents(ctx context.Context, options ...client.BlockEventsOption) (<-chan *common.Block, error)
+}
+
+// Indexer feeds the blocks of a channel into a Store
+type Indexer struct {
+    Network BlockSource
+    Store   *Store
+}
+
+// Run indexes blocks from the store's checkpoint until ctx is done,
+// returning ctx's error. A broken stream is resumed after RetryDelay; a
+// block that cannot be parsed or written stops Run with its error.
+func (i *Indexer) Run(ctx context.Context) error {
+    return i.run(ctx, 0)
+}
+
+// CatchUp indexes blocks until the store reaches block target
+func (i *Indexer) CatchUp(ctx context.Context, target uint64) error {
+    next, err := i.Store.NextBlock(ctx)
+    if err != nil || next >= target {
+        return err
+    }
+    return i.run(ctx, target)
+}
+
+// run indexes until the checkpoint reaches until, or forever if until is 0
+func (i *Indexer) run(ctx context.Context, until uint64) error {
+    for {
+        done, err := i.subscribe(ctx, until)
+        if done || ctx.Err() != nil {
+            return ctx.Err()
+        }
+        var applyErr *applyError
+        if errors.As(err, &applyErr) {
+            return applyErr.err
+        }
+        log.Printf("indexer: block subscription: %v; resubscribing in %s", err, RetryDelay)
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(RetryDelay):
+        }
+    }
+}
+
+// applyError marks a block that failed to index, which run does not retry
+type applyError struct {
+    err error
+}
+
+func (e *applyError) Error() string { return e.err.Error() }
+
+func (i *Indexer) subscribe(ctx context.Context, until uint64) (bool, error) {
+    next, err := i.Store.NextBlock(ctx)
+    if err != nil {
+        return false, &applyError{err: fmt.Errorf("indexer: reading checkpoint: %w", err)}
+    }
+    streamCtx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    stream, err := i.Network.BlockEvents(streamCtx, client.WithStartBlock(next))
+    if err != nil {
+        return false, err
+    }
+    for block := range stream {
+        parsed, err := ParseBlock(block)
+        if err != nil {
+            return false, &applyError{err: err}
+        }
+        if err := i.Store.ApplyBlock(ctx, parsed); err != nil {
+            return false, &applyError{err: err}
+        }
+        if until > 0 && parsed.Number+1 >= until {
+            return true, nil
+        }
+    }
+    return false, errStreamClosed
+}
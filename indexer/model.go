@@ -0,0 +1,128 @@
+package indexer
+
+import (
+    "github.com/tyuvic777/tyuabc777/events"
+)
+
+// objectKind says which ledger object an event changes and, for events
+// that carry no status, the status the object has afterwards
+type objectKind struct {
+    kind   string
+    status string
+}
+
+// Events that change a ledger object. Events not listed, such as
+// TrialRecordContributed, are kept in ledger_events only.
+var eventObjects = map[string]objectKind{
+    "RecordCreated":              {kind: "record"},
+    "RecordUpdated":              {kind: "record"},
+    "RecordCoSigned":             {kind: "record"},
+    "RecordErased":               {kind: "record", status: "erased"},
+    "AttachmentAdded":            {kind: "record"},
+    "AttachmentRemoved":          {kind: "record"},
+    "BundleAnchored":             {kind: "bundle", status: "anchored"},
+    "GrantCreated":               {kind: "grant", status: "active"},
+    "GrantRevoked":               {kind: "grant", status: "revoked"},
+    "CarePlanCreated":            {kind: "carePlan"},
+    "CarePlanRevised":            {kind: "carePlan"},
+    "CareTeamMemberAssigned":     {kind: "carePlan"},
+    "CarePlanActivityCompleted":  {kind: "carePlan"},
+    "CarePlanSuspended":          {kind: "carePlan"},
+    "CarePlanResumed":            {kind: "carePlan"},
+    "CarePlanCompleted":          {kind: "carePlan"},
+    "AppointmentRequested":       {kind: "appointment"},
+    "AppointmentApproved":        {kind: "appointment"},
+    "AppointmentRejected":        {kind: "appointment"},
+    "AppointmentRescheduled":     {kind: "appointment"},
+    "AppointmentCancelled":       {kind: "appointment"},
+    "AppointmentCheckedIn":       {kind: "appointment"},
+    "AppointmentNoShow":          {kind: "appointment"},
+    "EncounterReferenceAdded":    {kind: "encounter"},
+    "EncounterClosed":            {kind: "encounter", status: "closed"},
+    "PrescriptionIssued":         {kind: "prescription"},
+    "PrescriptionDispensed":      {kind: "prescription"},
+    "PrescriptionCancelled":      {kind: "prescription"},
+    "ControlledSubstanceFlag":    {kind: "prescription", status: "active"},
+    "ControlledSubstanceUpdated": {kind: "controlledSubstance", status: "active"},
+    "PrescriberLicenseUpdated":   {kind: "prescriberLicense", status: "active"},
+    "TelemetryBatchAnchored":     {kind: "telemetryBatch", status: "anchored"},
+    "DeviceRegistered":           {kind: "device"},
+    "DeviceDeregistered":         {kind: "device"},
+    "AlertRuleDefined":           {kind: "alertRule", status: "active"},
+    "AlertRuleRetired":           {kind: "alertRule", status: "retired"},
+    "ClinicalAlert":              {kind: "alert"},
+    "AlertAcknowledged":          {kind: "alert"},
+    "LaboratoryRegistered":       {kind: "laboratory", status: "active"},
+    "LaboratoryDeregistered":     {kind: "laboratory", status: "deregistered"},
+    "LabOrderCreated":            {kind: "labOrder"},
+    "LabResultAnchored":          {kind: "labResult"},
+    "LabResultAmended":           {kind: "labResult"},
+    "LabResultCorrected":         {kind: "labResult"},
+    "ReferralCreated":            {kind: "referral"},
+    "ReferralAccepted":           {kind: "referral"},
+    "ReferralDeclined":           {kind: "referral"},
+    "ReferralCancelled":          {kind: "referral"},
+    "ReferralClosed":             {kind: "referral"},
+    "PatientsMerged":             {kind: "patientMerge"},
+    "PatientsUnmerged":           {kind: "patientMerge"},
+    "TrialRegistered":            {kind: "trial"},
+    "TrialRevised":               {kind: "trial"},
+    "TrialClosed":                {kind: "trial"},
+    "TrialConsentGiven":          {kind: "trialConsent"},
+    "TrialConsentWithdrawn":      {kind: "trialConsent", status: "withdrawn"},
+    "DIDCreated":                 {kind: "did"},
+    "DIDUpdated":                 {kind: "did"},
+    "DIDRevoked":                 {kind: "did"},
+}
+
+// ObjectChange is the ledger_objects row an event leaves behind
+type ObjectChange struct {
+    Kind      string
+    ID        string
+    PatientID string
+    Status    string
+}
+
+// objectChange maps an event to the object it changed, or nil
+func objectChange(event events.Event) *ObjectChange {
+    object, ok := eventObjects[event.Name]
+    if !ok {
+        return nil
+    }
+    change := &ObjectChange{Kind: object.kind, ID: event.ObjectID, PatientID: event.PatientID, Status: event.Status}
+    if change.Status == "" {
+        change.Status = object.status
+    }
+    // A trial has one consent per patient
+    if change.Kind == "trialConsent" {
+        change.ID += "/" + change.PatientID
+    }
+    if change.ID == "" {
+        return nil
+    }
+    return change
+}
+
+// BalanceChange is a change to a ledger_token_balances row. Set replaces
+// the balance; otherwise Delta is added to it.
+type BalanceChange struct {
+    UserID string
+    Set    bool
+    Delta  float64
+}
+
+// balanceChanges maps a payment event to the balances it changed
+func balanceChanges(event events.Event) []BalanceChange {
+    switch event.Name {
+    case "TokensInitialized":
+        return []BalanceChange{{UserID: event.ObjectID, Set: true, Delta: event.Amount}}
+    case "PatientRewarded", "DoctorRewarded":
+        return []BalanceChange{{UserID: event.ObjectID, Delta: event.Amount}}
+    case "TokensTransferred":
+        return []BalanceChange{{UserID: event.ObjectID, Delta: -event.Amount}, {UserID: event.CounterpartyID, Delta: event.Amount}}
+    case "EncounterBilled":
+        // The patient pays the encounter's doctor
+        return []BalanceChange{{UserID: event.PatientID, Delta: -event.Amount}, {UserID: event.CounterpartyID, Delta: event.Amount}}
+    }
+    return nil
+}
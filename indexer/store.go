@@ -0,0 +1,305 @@
+package indexer
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "github.com/tyuvic777/tyuabc777/events"
+)
+
+// Tables of the read model, in the order they are created. Times in rows
+// come from the ledger, never the indexer's clock, so indexing the same
+// blocks twice gives identical tables.
+var tables = []string{
+    "ledger_checkpoint",
+    "ledger_blocks",
+    "ledger_transactions",
+    "ledger_events",
+    "ledger_objects",
+    "ledger_token_balances",
+}
+
+const schemaSQL = `
+CREATE TABLE IF NOT EXISTS {ledger_checkpoint} (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    next_block BIGINT NOT NULL,
+    block_hash TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS {ledger_blocks} (
+    block_number BIGINT PRIMARY KEY,
+    block_hash TEXT NOT NULL,
+    previous_hash TEXT NOT NULL,
+    data_hash TEXT NOT NULL,
+    tx_count INT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS {ledger_transactions} (
+    block_number BIGINT NOT NULL,
+    tx_index INT NOT NULL,
+    tx_id TEXT NOT NULL,
+    tx_type TEXT NOT NULL,
+    chaincode TEXT NOT NULL,
+    function TEXT NOT NULL,
+    validation_code TEXT NOT NULL,
+    valid BOOLEAN NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (block_number, tx_index)
+);
+CREATE INDEX IF NOT EXISTS ledger_transactions_tx_id ON {ledger_transactions} (tx_id);
+CREATE TABLE IF NOT EXISTS {ledger_events} (
+    block_number BIGINT NOT NULL,
+    tx_index INT NOT NULL,
+    tx_id TEXT NOT NULL,
+    chaincode TEXT NOT NULL,
+    name TEXT NOT NULL,
+    object_id TEXT NOT NULL,
+    patient_id TEXT NOT NULL,
+    actor_id TEXT NOT NULL,
+    status TEXT NOT NULL,
+    counterparty_id TEXT NOT NULL,
+    amount DOUBLE PRECISION NOT NULL,
+    at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (block_number, tx_index)
+);
+CREATE INDEX IF NOT EXISTS ledger_events_patient_id ON {ledger_events} (patient_id);
+CREATE INDEX IF NOT EXISTS ledger_events_name ON {ledger_events} (name);
+CREATE TABLE IF NOT EXISTS {ledger_objects} (
+    chaincode TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    object_id TEXT NOT NULL,
+    patient_id TEXT NOT NULL,
+    status TEXT NOT NULL,
+    last_event TEXT NOT NULL,
+    last_tx_id TEXT NOT NULL,
+    block_number BIGINT NOT NULL,
+    tx_index INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (chaincode, kind, object_id)
+);
+CREATE INDEX IF NOT EXISTS ledger_objects_patient_id ON {ledger_objects} (patient_id);
+CREATE INDEX IF NOT EXISTS ledger_objects_kind_status ON {ledger_objects} (kind, status);
+CREATE TABLE IF NOT EXISTS {ledger_token_balances} (
+    user_id TEXT PRIMARY KEY,
+    balance DOUBLE PRECISION NOT NULL,
+    block_number BIGINT NOT NULL,
+    tx_index INT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+INSERT INTO {ledger_checkpoint} (next_block, block_hash) VALUES (0, '') ON CONFLICT (id) DO NOTHING;
+`
+
+// Upserts only apply an event later on the ledger than the one the row
+// already reflects, so a block written twice changes nothing
+const upsertObjectSQL = `
+INSERT INTO {ledger_objects} AS o (chaincode, kind, object_id, patient_id, status, last_event, last_tx_id, block_number, tx_index, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10)
+ON CONFLICT (chaincode, kind, object_id) DO UPDATE SET
+    patient_id = COALESCE(NULLIF(EXCLUDED.patient_id, ''), o.patient_id),
+    status = COALESCE(NULLIF(EXCLUDED.status, ''), o.status),
+    last_event = EXCLUDED.last_event,
+    last_tx_id = EXCLUDED.last_tx_id,
+    block_number = EXCLUDED.block_number,
+    tx_index = EXCLUDED.tx_index,
+    updated_at = EXCLUDED.updated_at
+WHERE (o.block_number, o.tx_index) < (EXCLUDED.block_number, EXCLUDED.tx_index)`
+
+const upsertBalanceSQL = `
+INSERT INTO {ledger_token_balances} AS b (user_id, balance, block_number, tx_index, updated_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (user_id) DO UPDATE SET
+    balance = CASE WHEN $6 THEN EXCLUDED.balance ELSE b.balance + EXCLUDED.balance END,
+    block_number = EXCLUDED.block_number,
+    tx_index = EXCLUDED.tx_index,
+    updated_at = EXCLUDED.updated_at
+WHERE (b.block_number, b.tx_index) < (EXCLUDED.block_number, EXCLUDED.tx_index)`
+
+// ErrBrokenChain means a block does not follow the last block indexed
+var ErrBrokenChain = errors.New("indexer: block does not chain to the last indexed block")
+
+// errBehind means two stores compared by Diff are at different blocks
+var errBehind = errors.New("indexer: stores are at different blocks")
+
+// Store writes the read model into one PostgreSQL schema
+type Store struct {
+    Pool   *pgxpool.Pool
+    Schema string
+}
+
+// NewStore uses schema, or public when schema is empty
+func NewStore(pool *pgxpool.Pool, schema string) *Store {
+    if schema == "" {
+        schema = "public"
+    }
+    return &Store{Pool: pool, Schema: schema}
+}
+
+// sql qualifies every {table} in query with the store's schema
+func (s *Store) sql(query string) string {
+    for _, table := range tables {
+        query = strings.ReplaceAll(query, "{"+table+"}", pgx.Identifier{s.Schema, table}.Sanitize())
+    }
+    return query
+}
+
+// Migrate creates the schema and its tables if they do not exist
+func (s *Store) Migrate(ctx context.Context) error {
+    if _, err := s.Pool.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+pgx.Identifier{s.Schema}.Sanitize()); err != nil {
+        return err
+    }
+    _, err := s.Pool.Exec(ctx, s.sql(schemaSQL))
+    return err
+}
+
+// Reset empties the read model so the next run replays from genesis
+func (s *Store) Reset(ctx context.Context) error {
+    names := make([]string, len(tables))
+    for i, table := range tables {
+        names[i] = "{" + table + "}"
+    }
+    tx, err := s.Pool.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+    if _, err := tx.Exec(ctx, s.sql("TRUNCATE "+strings.Join(names, ", "))); err != nil {
+        return err
+    }
+    if _, err := tx.Exec(ctx, s.sql("INSERT INTO {ledger_checkpoint} (next_block, block_hash) VALUES (0, '')")); err != nil {
+        return err
+    }
+    return tx.Commit(ctx)
+}
+
+// NextBlock is the number of the next block to index
+func (s *Store) NextBlock(ctx context.Context) (uint64, error) {
+    var next int64
+    err := s.Pool.QueryRow(ctx, s.sql("SELECT next_block FROM {ledger_checkpoint}")).Scan(&next)
+    return uint64(next), err
+}
+
+// ApplyBlock writes a block and moves the checkpoint past it in one
+// transaction. A block already indexed is skipped; a block that skips
+// ahead or does not chain to the last one is refused.
+func (s *Store) ApplyBlock(ctx context.Context, block *Block) error {
+    tx, err := s.Pool.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    var next int64
+    var lastHash string
+    if err := tx.QueryRow(ctx, s.sql("SELECT next_block, block_hash FROM {ledger_checkpoint} FOR UPDATE")).Scan(&next, &lastHash); err != nil {
+        return fmt.Errorf("indexer: reading checkpoint: %w", err)
+    }
+    if block.Number < uint64(next) {
+        return nil
+    }
+    if block.Number > uint64(next) {
+        return fmt.Errorf("indexer: got block %d, expected block %d", block.Number, next)
+    }
+    if block.Number > 0 && block.PreviousHash != lastHash {
+        return fmt.Errorf("%w: block %d", ErrBrokenChain, block.Number)
+    }
+
+    if _, err := tx.Exec(ctx, s.sql(`INSERT INTO {ledger_blocks} (block_number, block_hash, previous_hash, data_hash, tx_count)
+        VALUES ($1, $2, $3, $4, $5) ON CONFLICT (block_number) DO NOTHING`),
+        int64(block.Number), block.Hash, block.PreviousHash, block.DataHash, len(block.Transactions)); err != nil {
+        return err
+    }
+    for _, transaction := range block.Transactions {
+        if err := s.applyTransaction(ctx, tx, block.Number, transaction); err != nil {
+            return fmt.Errorf("indexer: block %d transaction %s: %w", block.Number, transaction.ID, err)
+        }
+    }
+    if _, err := tx.Exec(ctx, s.sql("UPDATE {ledger_checkpoint} SET next_block = $1, block_hash = $2"), int64(block.Number)+1, block.Hash); err != nil {
+        return err
+    }
+    return tx.Commit(ctx)
+}
+
+func (s *Store) applyTransaction(ctx context.Context, tx pgx.Tx, blockNumber uint64, transaction Transaction) error {
+    if _, err := tx.Exec(ctx, s.sql(`INSERT INTO {ledger_transactions} (block_number, tx_index, tx_id, tx_type, chaincode, function, validation_code, valid, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT (block_number, tx_index) DO NOTHING`),
+        int64(blockNumber), transaction.Index, transaction.ID, transaction.Type, transaction.Chaincode, transaction.Function,
+        transaction.ValidationCode, transaction.Valid, transaction.Timestamp); err != nil {
+        return err
+    }
+    // Events of invalidated transactions never happened
+    if !transaction.Valid || transaction.Event == nil {
+        return nil
+    }
+    event, err := events.Decode(transaction.Chaincode, transaction.Event.EventName, blockNumber, transaction.ID, transaction.Event.Payload)
+    if err != nil {
+        return err
+    }
+    at := event.At
+    if at.IsZero() {
+        at = transaction.Timestamp
+    }
+    at = at.UTC().Truncate(time.Microsecond)
+    if _, err := tx.Exec(ctx, s.sql(`INSERT INTO {ledger_events} (block_number, tx_index, tx_id, chaincode, name, object_id, patient_id, actor_id, status, counterparty_id, amount, at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) ON CONFLICT (block_number, tx_index) DO NOTHING`),
+        int64(blockNumber), transaction.Index, transaction.ID, event.Chaincode, event.Name, event.ObjectID, event.PatientID,
+        event.ActorID, event.Status, event.CounterpartyID, event.Amount, at); err != nil {
+        return err
+    }
+
+    if change := objectChange(event); change != nil {
+        if _, err := tx.Exec(ctx, s.sql(upsertObjectSQL), event.Chaincode, change.Kind, change.ID, change.PatientID, change.Status,
+            event.Name, transaction.ID, int64(blockNumber), transaction.Index, at); err != nil {
+            return err
+        }
+    }
+    for _, change := range balanceChanges(event) {
+        if _, err := tx.Exec(ctx, s.sql(upsertBalanceSQL), change.UserID, change.Delta, int64(blockNumber), transaction.Index, at, change.Set); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Diff counts the rows of each table that differ between this store and
+// other, read in one snapshot. Both must have reached the same block.
+func (s *Store) Diff(ctx context.Context, other *Store) (map[string]int64, error) {
+    tx, err := s.Pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback(ctx)
+
+    var mine, theirs int64
+    if err := tx.QueryRow(ctx, s.sql("SELECT next_block FROM {ledger_checkpoint}")).Scan(&mine); err != nil {
+        return nil, err
+    }
+    if err := tx.QueryRow(ctx, other.sql("SELECT next_block FROM {ledger_checkpoint}")).Scan(&theirs); err != nil {
+        return nil, err
+    }
+    if mine != theirs {
+        return nil, fmt.Errorf("%w: %d and %d", errBehind, mine, theirs)
+    }
+    diff := map[string]int64{}
+    for _, table := range tables {
+        a, b := s.sql("{"+table+"}"), other.sql("{"+table+"}")
+        var count int64
+        query := fmt.Sprintf("SELECT count(*) FROM ((TABLE %s EXCEPT ALL TABLE %s) UNION ALL (TABLE %s EXCEPT ALL TABLE %s)) d", a, b, b, a)
+        if err := tx.QueryRow(ctx, query).Scan(&count); err != nil {
+            return nil, err
+        }
+        if count > 0 {
+            diff[table] = count
+        }
+    }
+    return diff, nil
+}
+
+// Drop removes the store's schema and everything in it
+func (s *Store) Drop(ctx context.Context) error {
+    _, err := s.Pool.Exec(ctx, "DROP SCHEMA IF EXISTS "+pgx.Identifier{s.Schema}.Sanitize()+" CASCADE")
+    return err
+}
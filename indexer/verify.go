@@ -0,0 +1,61 @@
+package indexer
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "sort"
+    "strings"
+)
+
+// verifyAttempts bounds how often Verify chases a live store that keeps
+// moving ahead while the copy is rebuilt
+const verifyAttempts = 5
+
+// Verify replays the ledger from genesis into a scratch schema and checks
+// that it matches the live read model row for row. It returns an error
+// listing the tables that differ. The scratch schema is dropped afterwards.
+func Verify(ctx context.Context, indexer *Indexer, scratchSchema string) error {
+    live := indexer.Store
+    if scratchSchema == "" || scratchSchema == live.Schema {
+        return errors.New("indexer: verify needs a scratch schema other than the live one")
+    }
+    scratch := NewStore(live.Pool, scratchSchema)
+    if err := scratch.Drop(ctx); err != nil {
+        return err
+    }
+    defer scratch.Drop(context.Background())
+    if err := scratch.Migrate(ctx); err != nil {
+        return err
+    }
+    rebuild := &Indexer{Network: indexer.Network, Store: scratch}
+
+    for attempt := 0; attempt < verifyAttempts; attempt++ {
+        target, err := live.NextBlock(ctx)
+        if err != nil {
+            return err
+        }
+        if err := rebuild.CatchUp(ctx, target); err != nil {
+            return fmt.Errorf("indexer: rebuilding to block %d: %w", target, err)
+        }
+        diff, err := live.Diff(ctx, scratch)
+        if errors.Is(err, errBehind) {
+            continue
+        }
+        if err != nil {
+            return err
+        }
+        if len(diff) == 0 {
+            log.Printf("indexer: read model matches a replay of blocks 0-%d", int64(target)-1)
+            return nil
+        }
+        names := make([]string, 0, len(diff))
+        for table, count := range diff {
+            names = append(names, fmt.Sprintf("%s (%d rows)", table, count))
+        }
+        sort.Strings(names)
+        return fmt.Errorf("indexer: read model differs from a replay of blocks 0-%d in %s", int64(target)-1, strings.Join(names, ", "))
+    }
+    return errors.New("indexer: live read model kept moving; stop the indexer and verify again")
+}
@@ -0,0 +1,172 @@
+package merkle
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+    "sort"
+    "strconv"
+    "strings"
+    "unicode/utf16"
+)
+
+// CanonicalJSON serializes a decoded JSON value with the JSON
+// Canonicalization Scheme of RFC 8785, so any JCS implementation reproduces
+// the same bytes: object members sorted by the UTF-16 code units of their
+// names, no whitespace, strings escaped only where JSON requires it, and
+// numbers written the way ECMAScript prints an IEEE 754 double. value is
+// what encoding/json decodes into interface{}, with or without UseNumber.
+func CanonicalJSON(value interface{}) ([]byte, error) {
+    var buf bytes.Buffer
+    if err := writeCanonical(&buf, value); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+func writeCanonical(buf *bytes.Buffer, value interface{}) error {
+    switch v := value.(type) {
+    case nil:
+        buf.WriteString("null")
+    case bool:
+        if v {
+            buf.WriteString("true")
+        } else {
+            buf.WriteString("false")
+        }
+    case string:
+        writeCanonicalString(buf, v)
+    case json.Number:
+        f, err := strconv.ParseFloat(string(v), 64)
+        if err != nil {
+            return fmt.Errorf("merkle: number %s is not an IEEE 754 double", v)
+        }
+        return writeCanonicalNumber(buf, f)
+    case float64:
+        return writeCanonicalNumber(buf, v)
+    case []interface{}:
+        buf.WriteByte('[')
+        for i, element := range v {
+            if i > 0 {
+                buf.WriteByte(',')
+            }
+            if err := writeCanonical(buf, element); err != nil {
+                return err
+            }
+        }
+        buf.WriteByte(']')
+    case map[string]interface{}:
+        names := make([]string, 0, len(v))
+        for name := range v {
+            names = append(names, name)
+        }
+        sort.Slice(names, func(i, j int) bool { return lessUTF16(names[i], names[j]) })
+        buf.WriteByte('{')
+        for i, name := range names {
+            if i > 0 {
+                buf.WriteByte(',')
+            }
+            writeCanonicalString(buf, name)
+            buf.WriteByte(':')
+            if err := writeCanonical(buf, v[name]); err != nil {
+                return err
+            }
+        }
+        buf.WriteByte('}')
+    default:
+        return fmt.Errorf("merkle: cannot canonicalize %T", value)
+    }
+    return nil
+}
+
+// lessUTF16 orders strings by their UTF-16 code units, as RFC 8785 requires
+func lessUTF16(a, b string) bool {
+    ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+    for i := 0; i < len(ua) && i < len(ub); i++ {
+        if ua[i] != ub[i] {
+            return ua[i] < ub[i]
+        }
+    }
+    return len(ua) < len(ub)
+}
+
+// writeCanonicalString escapes only quote, backslash and control characters;
+// the short escapes are used where JSON has them
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+    buf.WriteByte('"')
+    for _, r := range s {
+        switch r {
+        case '"':
+            buf.WriteString(`\"`)
+        case '\\':
+            buf.WriteString(`\\`)
+        case '\b':
+            buf.WriteString(`\b`)
+        case '\f':
+            buf.WriteString(`\f`)
+        case '\n':
+            buf.WriteString(`\n`)
+        case '\r':
+            buf.WriteString(`\r`)
+        case '\t':
+            buf.WriteString(`\t`)
+        default:
+            if r < 0x20 {
+                fmt.Fprintf(buf, `\u%04x`, r)
+            } else {
+                buf.WriteRune(r)
+            }
+        }
+    }
+    buf.WriteByte('"')
+}
+
+// writeCanonicalNumber prints a double as ECMAScript's Number.prototype.toString
+func writeCanonicalNumber(buf *bytes.Buffer, f float64) error {
+    if math.IsNaN(f) || math.IsInf(f, 0) {
+        return errors.New("merkle: JSON numbers must be finite")
+    }
+    if f == 0 {
+        buf.WriteByte('0')
+        return nil
+    }
+    if f < 0 {
+        buf.WriteByte('-')
+        f = -f
+    }
+    // Shortest round-tripping digits d.ddd and exponent e, so that
+    // f = 0.dddd × 10^n with n = e+1
+    formatted := strconv.FormatFloat(f, 'e', -1, 64)
+    mantissa, exponent, _ := strings.Cut(formatted, "e")
+    digits := strings.Replace(mantissa, ".", "", 1)
+    e, _ := strconv.Atoi(exponent)
+    n, k := e+1, len(digits)
+
+    switch {
+    case k <= n && n <= 21:
+        buf.WriteString(digits)
+        buf.WriteString(strings.Repeat("0", n-k))
+    case 0 < n && n <= 21:
+        buf.WriteString(digits[:n])
+        buf.WriteByte('.')
+        buf.WriteString(digits[n:])
+    case -6 < n && n <= 0:
+        buf.WriteString("0.")
+        buf.WriteString(strings.Repeat("0", -n))
+        buf.WriteString(digits)
+    default:
+        buf.WriteString(digits[:1])
+        if k > 1 {
+            buf.WriteByte('.')
+            buf.WriteString(digits[1:])
+        }
+        buf.WriteByte('e')
+        if n-1 >= 0 {
+            buf.WriteByte('+')
+        }
+        buf.WriteString(strconv.Itoa(n - 1))
+    }
+    return nil
+}
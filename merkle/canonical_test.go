@@ -0,0 +1,117 @@
+package merkle
+
+import (
+    "bytes"
+    "encoding/json"
+    "math"
+    "testing"
+)
+
+func decodeJSON(t *testing.T, input string) interface{} {
+    t.Helper()
+    decoder := json.NewDecoder(bytes.NewReader([]byte(input)))
+    decoder.UseNumber()
+    var value interface{}
+    if err := decoder.Decode(&value); err != nil {
+        t.Fatal(err)
+    }
+    return value
+}
+
+// Examples from RFC 8785 sections 3.2.2 and 3.2.3
+func TestCanonicalJSONRFC8785(t *testing.T) {
+    tests := []struct {
+        input string
+        want  string
+    }{
+        {
+            input: `{
+                "numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+                "string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+                "literals": [null, true, false]
+            }`,
+            want: `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`,
+        },
+        {
+            input: `{"\u20ac": 1, "\r": 2, "\ufb33": 3, "1": 4, "\ud83d\ude00": 5, "\u0080": 6, "\u00f6": 7}`,
+            want:  "{\"\\r\":2,\"1\":4,\"\u0080\":6,\"ö\":7,\"€\":1,\"😀\":5,\"\ufb33\":3}",
+        },
+        {
+            input: `{"b": "<tag> & more", "a": {"d": [], "c": {}}}`,
+            want:  `{"a":{"c":{},"d":[]},"b":"<tag> & more"}`,
+        },
+    }
+    for _, test := range tests {
+        got, err := CanonicalJSON(decodeJSON(t, test.input))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if string(got) != test.want {
+            t.Errorf("CanonicalJSON(%s)\n got %s\nwant %s", test.input, got, test.want)
+        }
+    }
+}
+
+// Number serialization samples from RFC 8785 appendix B
+func TestCanonicalJSONNumbers(t *testing.T) {
+    tests := []struct {
+        bits uint64
+        want string
+    }{
+        {0x0000000000000000, "0"},
+        {0x8000000000000000, "0"},
+        {0x0000000000000001, "5e-324"},
+        {0x8000000000000001, "-5e-324"},
+        {0x7fefffffffffffff, "1.7976931348623157e+308"},
+        {0x4340000000000000, "9007199254740992"},
+        {0xc340000000000000, "-9007199254740992"},
+        {0x4430000000000000, "295147905179352830000"},
+        {0x44b52d02c7e14af5, "9.999999999999997e+22"},
+        {0x44b52d02c7e14af6, "1e+23"},
+        {0x44b52d02c7e14af7, "1.0000000000000001e+23"},
+        {0x444b1ae4d6e2ef4e, "999999999999999700000"},
+        {0x444b1ae4d6e2ef4f, "999999999999999900000"},
+        {0x444b1ae4d6e2ef50, "1e+21"},
+        {0x3eb0c6f7a0b5ed8c, "9.999999999999997e-7"},
+        {0x3eb0c6f7a0b5ed8d, "0.000001"},
+        {0x41b3de4355555553, "333333333.3333332"},
+        {0x41b3de4355555554, "333333333.33333325"},
+        {0x41b3de4355555555, "333333333.3333333"},
+        {0x41b3de4355555556, "333333333.3333334"},
+        {0x41b3de4355555557, "333333333.33333343"},
+        {0xbecbf647612f3696, "-0.0000033333333333333333"},
+        {0x43143ff3c1cb0959, "1424953923781206.2"},
+    }
+    for _, test := range tests {
+        got, err := CanonicalJSON(math.Float64frombits(test.bits))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if string(got) != test.want {
+            t.Errorf("%#016x: got %s, want %s", test.bits, got, test.want)
+        }
+    }
+    if _, err := CanonicalJSON(math.NaN()); err == nil {
+        t.Errorf("NaN should not canonicalize")
+    }
+}
+
+func TestCanonicalFHIRHashIgnoresMetaAndFormatting(t *testing.T) {
+    a := []byte(`{"resourceType":"Observation","id":"o1","meta":{"versionId":"1"},"status":"final","valueQuantity":{"value":4.50,"unit":"mmol/L"}}`)
+    b := []byte(`{
+        "valueQuantity": {"unit": "mmol/L", "value": 4.5},
+        "status": "final", "id": "o1", "resourceType": "Observation",
+        "meta": {"versionId": "2"}, "text": {"div": "<div>narrative</div>"}
+    }`)
+    hashA, err := CanonicalFHIRHash(a)
+    if err != nil {
+        t.Fatal(err)
+    }
+    hashB, err := CanonicalFHIRHash(b)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if hashA != hashB {
+        t.Errorf("equivalent resources hash differently: %s != %s", hashA, hashB)
+    }
+}
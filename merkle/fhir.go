@@ -0,0 +1,92 @@
+package merkle
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "time"
+)
+
+// BundleAnchor mirrors the anchor stored by PatientCareChaincode.anchorBundle
+type BundleAnchor struct {
+    ID          string    `json:"id"`
+    BundleID    string    `json:"bundle_id"`
+    BundleType  string    `json:"bundle_type"`
+    EntryCount  int       `json:"entry_count"`
+    MerkleRoot  string    `json:"merkle_root"`
+    CreatedAt   time.Time `json:"created_at"`
+    Nonce       string    `json:"nonce"`
+}
+
+// CanonicalFHIRHash returns the hex SHA-256 over the canonical form of a
+// FHIR resource: the resource without its meta and text elements,
+// serialized with CanonicalJSON (RFC 8785). Matches the chaincode.
+func CanonicalFHIRHash(resourceJSON []byte) (string, error) {
+    decoder := json.NewDecoder(bytes.NewReader(resourceJSON))
+    decoder.UseNumber()
+    var resource map[string]interface{}
+    if err := decoder.Decode(&resource); err != nil {
+        return "", errors.New("merkle: invalid FHIR resource JSON")
+    }
+    delete(resource, "meta")
+    delete(resource, "text")
+    canonicalJSON, err := CanonicalJSON(resource)
+    if err != nil {
+        return "", errors.New("merkle: failed to canonicalize FHIR resource")
+    }
+    hash := sha256.Sum256(canonicalJSON)
+    return hex.EncodeToString(hash[:]), nil
+}
+
+// FHIREntryLeafHash returns the Merkle leaf hash for one bundle entry resource
+func FHIREntryLeafHash(resourceJSON []byte) ([]byte, error) {
+    canonicalHash, err := CanonicalFHIRHash(resourceJSON)
+    if err != nil {
+        return nil, err
+    }
+    hashBytes, _ := hex.DecodeString(canonicalHash)
+    return LeafHash(hashBytes), nil
+}
+
+// NewFHIRBundleTree builds the tree the chaincode anchors for a FHIR Bundle,
+// so a client can issue per-entry proofs after calling anchorBundle
+func NewFHIRBundleTree(bundleJSON []byte) (*Tree, error) {
+    var bundle struct {
+        ResourceType string `json:"resourceType"`
+        Entry        []struct {
+            Resource json.RawMessage `json:"resource"`
+        } `json:"entry"`
+    }
+    if err := json.Unmarshal(bundleJSON, &bundle); err != nil {
+        return nil, errors.New("merkle: invalid FHIR Bundle JSON")
+    }
+    if bundle.ResourceType != "Bundle" {
+        return nil, errors.New("merkle: FHIR resource is not a Bundle")
+    }
+
+    leaves := make([][]byte, 0, len(bundle.Entry))
+    for i, entry := range bundle.Entry {
+        leaf, err := FHIREntryLeafHash(entry.Resource)
+        if err != nil {
+            return nil, fmt.Errorf("merkle: bundle entry %d: %v", i, err)
+        }
+        leaves = append(leaves, leaf)
+    }
+    return NewTreeFromHashes(leaves)
+}
+
+// VerifyFHIRBundleEntry confirms a bundle entry resource against the
+// on-ledger anchor returned by getBundle, at position proof.Index
+func VerifyFHIRBundleEntry(resourceJSON []byte, proof Proof, anchor BundleAnchor) error {
+    if proof.Index < 0 || proof.Index >= anchor.EntryCount {
+        return fmt.Errorf("merkle: entry index %d out of range for bundle %s", proof.Index, anchor.ID)
+    }
+    leaf, err := FHIREntryLeafHash(resourceJSON)
+    if err != nil {
+        return err
+    }
+    return VerifyAt(leaf, proof, anchor.EntryCount, anchor.MerkleRoot)
+}
@@ -0,0 +1,182 @@
+// Package merkle builds and verifies the Merkle trees anchored by
+// PatientCareChaincode. Hashing follows RFC 6962 domain separation:
+// leaves are SHA-256(0x00 || data) and interior nodes are
+// SHA-256(0x01 || left || right). An unpaired node at the end of a level
+// is carried up unchanged. PatientCareChaincode builds its trees with this
+// package.
+package merkle
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+)
+
+// ProofStep is one sibling on the path from a leaf to the root
+type ProofStep struct {
+    Hash string `json:"hash"`
+    Left bool   `json:"left"` // sibling sits to the left of the running hash
+}
+
+// Proof is an inclusion proof for the leaf at Index
+type Proof struct {
+    Index int         `json:"index"`
+    Steps []ProofStep `json:"steps"`
+}
+
+// ErrEmptyTree is returned when a tree has no leaves
+var ErrEmptyTree = errors.New("merkle: tree has no leaves")
+
+// LeafHash hashes leaf data with the leaf prefix
+func LeafHash(data []byte) []byte {
+    hash := sha256.Sum256(append([]byte{0x00}, data...))
+    return hash[:]
+}
+
+// NodeHash hashes two children with the interior node prefix
+func NodeHash(left, right []byte) []byte {
+    buf := make([]byte, 0, 1+len(left)+len(right))
+    buf = append(buf, 0x01)
+    buf = append(buf, left...)
+    buf = append(buf, right...)
+    hash := sha256.Sum256(buf)
+    return hash[:]
+}
+
+// Tree holds every level of a Merkle tree, leaves first
+type Tree struct {
+    levels [][][]byte
+}
+
+// NewTree builds a tree over raw leaf data
+func NewTree(leaves [][]byte) (*Tree, error) {
+    hashes := make([][]byte, len(leaves))
+    for i, leaf := range leaves {
+        hashes[i] = LeafHash(leaf)
+    }
+    return NewTreeFromHashes(hashes)
+}
+
+// NewTreeFromHashes builds a tree over leaves that are already leaf-hashed
+func NewTreeFromHashes(leafHashes [][]byte) (*Tree, error) {
+    if len(leafHashes) == 0 {
+        return nil, ErrEmptyTree
+    }
+    level := leafHashes
+    levels := [][][]byte{level}
+    for len(level) > 1 {
+        next := make([][]byte, 0, (len(level)+1)/2)
+        for i := 0; i < len(level); i += 2 {
+            if i+1 == len(level) {
+                next = append(next, level[i])
+                continue
+            }
+            next = append(next, NodeHash(level[i], level[i+1]))
+        }
+        levels = append(levels, next)
+        level = next
+    }
+    return &Tree{levels: levels}, nil
+}
+
+// Root returns the tree root
+func (t *Tree) Root() []byte {
+    return t.levels[len(t.levels)-1][0]
+}
+
+// RootHex returns the tree root hex-encoded, as stored on the ledger
+func (t *Tree) RootHex() string {
+    return hex.EncodeToString(t.Root())
+}
+
+// Size returns the number of leaves
+func (t *Tree) Size() int {
+    return len(t.levels[0])
+}
+
+// Proof builds the inclusion proof for the leaf at index
+func (t *Tree) Proof(index int) (Proof, error) {
+    if index < 0 || index >= t.Size() {
+        return Proof{}, fmt.Errorf("merkle: leaf index %d out of range", index)
+    }
+    proof := Proof{Index: index}
+    position := index
+    for _, level := range t.levels[:len(t.levels)-1] {
+        sibling := position ^ 1
+        // An unpaired node has no sibling at this level
+        if sibling < len(level) {
+            proof.Steps = append(proof.Steps, ProofStep{
+                Hash: hex.EncodeToString(level[sibling]),
+                Left: sibling < position,
+            })
+        }
+        position /= 2
+    }
+    return proof, nil
+}
+
+// RootFromProof recomputes the root from a leaf hash and its proof
+func RootFromProof(leafHash []byte, proof Proof) ([]byte, error) {
+    running := leafHash
+    for _, step := range proof.Steps {
+        sibling, err := hex.DecodeString(step.Hash)
+        if err != nil || len(sibling) != sha256.Size {
+            return nil, errors.New("merkle: invalid proof step hash")
+        }
+        if step.Left {
+            running = NodeHash(sibling, running)
+        } else {
+            running = NodeHash(running, sibling)
+        }
+    }
+    return running, nil
+}
+
+// Verify checks that a leaf hash is included under the hex-encoded root. It
+// takes the sides of the path from the proof, so it proves membership only;
+// use VerifyAt to also prove the leaf's position.
+func Verify(leafHash []byte, proof Proof, rootHex string) error {
+    root, err := hex.DecodeString(rootHex)
+    if err != nil {
+        return errors.New("merkle: invalid root")
+    }
+    computed, err := RootFromProof(leafHash, proof)
+    if err != nil {
+        return err
+    }
+    if !bytes.Equal(computed, root) {
+        return errors.New("merkle: leaf is not included under root")
+    }
+    return nil
+}
+
+// VerifyAt checks that a leaf hash is the leaf at proof.Index of a tree of
+// size leaves under the hex-encoded root. The side of each step is derived
+// from the index and size, and a proof whose Left flags disagree is rejected.
+func VerifyAt(leafHash []byte, proof Proof, size int, rootHex string) error {
+    if proof.Index < 0 || proof.Index >= size {
+        return fmt.Errorf("merkle: leaf index %d out of range for %d leaves", proof.Index, size)
+    }
+    position, width, step := proof.Index, size, 0
+    for width > 1 {
+        sibling := position ^ 1
+        // An unpaired node has no sibling at this level
+        if sibling < width {
+            if step >= len(proof.Steps) {
+                return errors.New("merkle: proof is too short for its index")
+            }
+            if proof.Steps[step].Left != (sibling < position) {
+                return fmt.Errorf("merkle: proof step %d is on the wrong side for index %d", step, proof.Index)
+            }
+            step++
+        }
+        position /= 2
+        width = (width + 1) / 2
+    }
+    if step != len(proof.Steps) {
+        return errors.New("merkle: proof is too long for its index")
+    }
+    return Verify(leafHash, proof, rootHex)
+}
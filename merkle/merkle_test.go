@@ -0,0 +1,160 @@
+package merkle
+
+import (
+    "bytes"
+    "encoding/hex"
+    "fmt"
+    "testing"
+)
+
+func testLeaves(n int) [][]byte {
+    leaves := make([][]byte, n)
+    for i := range leaves {
+        leaves[i] = []byte(fmt.Sprintf("leaf-%d", i))
+    }
+    return leaves
+}
+
+func TestSingleLeafTree(t *testing.T) {
+    leaf := []byte("only")
+    tree, err := NewTree([][]byte{leaf})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(tree.Root(), LeafHash(leaf)) {
+        t.Fatalf("root of a single leaf tree should be its leaf hash")
+    }
+    proof, err := tree.Proof(0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(proof.Steps) != 0 {
+        t.Fatalf("single leaf proof has %d steps, want 0", len(proof.Steps))
+    }
+    if err := Verify(LeafHash(leaf), proof, tree.RootHex()); err != nil {
+        t.Fatalf("Verify: %v", err)
+    }
+    if _, err := tree.Proof(1); err == nil {
+        t.Fatalf("Proof(1) on a single leaf tree should fail")
+    }
+}
+
+func TestEmptyTree(t *testing.T) {
+    if _, err := NewTree(nil); err != ErrEmptyTree {
+        t.Fatalf("NewTree(nil) = %v, want ErrEmptyTree", err)
+    }
+}
+
+func TestOddLeafCounts(t *testing.T) {
+    // Three leaves: the third is carried up unpaired
+    leaves := testLeaves(3)
+    tree, err := NewTree(leaves)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := NodeHash(NodeHash(LeafHash(leaves[0]), LeafHash(leaves[1])), LeafHash(leaves[2]))
+    if !bytes.Equal(tree.Root(), want) {
+        t.Fatalf("root of 3 leaves = %x, want %x", tree.Root(), want)
+    }
+
+    for _, n := range []int{3, 5, 7, 9, 11} {
+        leaves := testLeaves(n)
+        tree, err := NewTree(leaves)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for i, leaf := range leaves {
+            proof, err := tree.Proof(i)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if err := Verify(LeafHash(leaf), proof, tree.RootHex()); err != nil {
+                t.Fatalf("%d leaves: leaf %d: %v", n, i, err)
+            }
+        }
+    }
+}
+
+func TestTamperedProof(t *testing.T) {
+    leaves := testLeaves(5)
+    tree, err := NewTree(leaves)
+    if err != nil {
+        t.Fatal(err)
+    }
+    proof, err := tree.Proof(2)
+    if err != nil {
+        t.Fatal(err)
+    }
+    leaf := LeafHash(leaves[2])
+
+    if err := Verify(LeafHash([]byte("forged")), proof, tree.RootHex()); err == nil {
+        t.Errorf("proof verified a different leaf")
+    }
+
+    sibling, _ := hex.DecodeString(proof.Steps[0].Hash)
+    sibling[0] ^= 0xff
+    tampered := Proof{Index: proof.Index, Steps: append([]ProofStep(nil), proof.Steps...)}
+    tampered.Steps[0].Hash = hex.EncodeToString(sibling)
+    if err := Verify(leaf, tampered, tree.RootHex()); err == nil {
+        t.Errorf("proof with a changed sibling hash verified")
+    }
+
+    flipped := Proof{Index: proof.Index, Steps: append([]ProofStep(nil), proof.Steps...)}
+    flipped.Steps[0].Left = !flipped.Steps[0].Left
+    if err := Verify(leaf, flipped, tree.RootHex()); err == nil {
+        t.Errorf("proof with a flipped side verified")
+    }
+
+    truncated := Proof{Index: proof.Index, Steps: proof.Steps[:len(proof.Steps)-1]}
+    if err := Verify(leaf, truncated, tree.RootHex()); err == nil {
+        t.Errorf("truncated proof verified")
+    }
+
+    malformed := Proof{Index: proof.Index, Steps: []ProofStep{{Hash: "zz"}}}
+    if err := Verify(leaf, malformed, tree.RootHex()); err == nil {
+        t.Errorf("proof with a malformed step verified")
+    }
+}
+
+func TestVerifyAtChecksPosition(t *testing.T) {
+    for _, n := range []int{1, 2, 3, 6, 7} {
+        leaves := testLeaves(n)
+        tree, err := NewTree(leaves)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for i, leaf := range leaves {
+            proof, err := tree.Proof(i)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if err := VerifyAt(LeafHash(leaf), proof, n, tree.RootHex()); err != nil {
+                t.Fatalf("%d leaves: leaf %d: %v", n, i, err)
+            }
+        }
+    }
+
+    leaves := testLeaves(4)
+    tree, err := NewTree(leaves)
+    if err != nil {
+        t.Fatal(err)
+    }
+    proof, err := tree.Proof(1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // The path still proves membership, but not at another position
+    moved := Proof{Index: 0, Steps: proof.Steps}
+    if err := Verify(LeafHash(leaves[1]), moved, tree.RootHex()); err != nil {
+        t.Fatalf("Verify should only check membership: %v", err)
+    }
+    if err := VerifyAt(LeafHash(leaves[1]), moved, 4, tree.RootHex()); err == nil {
+        t.Errorf("VerifyAt accepted a proof at the wrong index")
+    }
+    if err := VerifyAt(LeafHash(leaves[1]), proof, 5, tree.RootHex()); err == nil {
+        t.Errorf("VerifyAt accepted a proof for the wrong tree size")
+    }
+    if err := VerifyAt(LeafHash(leaves[1]), Proof{Index: 4, Steps: proof.Steps}, 4, tree.RootHex()); err == nil {
+        t.Errorf("VerifyAt accepted an index out of range")
+    }
+}
@@ -0,0 +1,65 @@
+// Package nonce builds the trailing [nonce, expiry, signature] arguments
+// that PatientCareChaincode requires on every state-changing call. The
+// digest must stay in step with nonceDigest in the chaincode.
+package nonce
+
+import (
+    "crypto/ecdsa"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "strconv"
+    "time"
+)
+
+// DefaultTTL keeps expiries well inside the chaincode's 10 minute limit
+const DefaultTTL = 5 * time.Minute
+
+// Digest is the SHA-256 the chaincode verifies: function, payload, nonce
+// and expiry, each terminated by a zero byte
+func Digest(function string, payload []string, nonce, expiry string) []byte {
+    hash := sha256.New()
+    fields := append(append([]string{function}, payload...), nonce, expiry)
+    for _, field := range fields {
+        hash.Write([]byte(field))
+        hash.Write([]byte{0})
+    }
+    return hash.Sum(nil)
+}
+
+// New returns a random 32-byte nonce, hex-encoded
+func New() (string, error) {
+    buf := make([]byte, 32)
+    if _, err := rand.Read(buf); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(buf), nil
+}
+
+// Sign returns the nonce arguments for a call, signed with the enrollment
+// key of the identity that will submit the transaction
+func Sign(key *ecdsa.PrivateKey, function string, payload []string, now time.Time, ttl time.Duration) ([]string, error) {
+    if key == nil {
+        return nil, errors.New("nonce: no signing key")
+    }
+    nonce, err := New()
+    if err != nil {
+        return nil, err
+    }
+    expiry := strconv.FormatInt(now.Add(ttl).Unix(), 10)
+    signature, err := ecdsa.SignASN1(rand.Reader, key, Digest(function, payload, nonce, expiry))
+    if err != nil {
+        return nil, err
+    }
+    return []string{nonce, expiry, hex.EncodeToString(signature)}, nil
+}
+
+// Args appends signed nonce arguments to a call's payload
+func Args(key *ecdsa.PrivateKey, function string, payload ...string) ([]string, error) {
+    nonceArgs, err := Sign(key, function, payload, time.Now(), DefaultTTL)
+    if err != nil {
+        return nil, err
+    }
+    return append(append([]string{}, payload...), nonceArgs...), nil
+}
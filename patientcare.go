@@ -1,171 +1,639 @@
-package main
-
-import (
-    "encoding/json"
-    "fmt"
-    "crypto/sha256"
-    "encoding/hex"
-    "time"
-    "strconv"
-    "errors"
-    "github.com/hyperledger/fabric-chaincode-go/shim"
-    pb "github.com/hyperledger/fabric-protos-go/peer"
-)
-
-// Structs
-type PatientRecord struct {
-    ID             string    `json:"id"`
-    DataHash       string    `json:"data_hash"`
-    CreatedAt      time.Time `json:"created_at"`
-    UpdatedAt      time.Time `json:"updated_at"`
-    Nonce          string    `json:"nonce"`
-}
-
-type PatientCareChaincode struct {}
-
-// Init function
-func (t *PatientCareChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
-    return shim.Success(nil)
-}
-
-// Invoke function with input validation and nonce-based replay protection
-func (t *PatientCareChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
-    function, args := stub.GetFunctionAndParameters()
-
-    switch function {
-    case "createRecord":
-        return t.createRecord(stub, args)
-    case "updateRecord":
-        return t.updateRecord(stub, args)
-    case "getRecord":
-        return t.getRecord(stub, args)
-    default:
-        return shim.Error("Invalid function name. Supported: createRecord, updateRecord, getRecord")
-    }
-}
-
-// Helper function for secure hash generation
-func generateHash(data string) string {
-    hash := sha256.Sum256([]byte(data))
-    return hex.EncodeToString(hash[:])
-}
-
-// Validate input length and format
-func validateInput(input string, maxLength int) error {
-    if len(input) == 0 || len(input) > maxLength {
-        return errors.New("Invalid input length")
-    }
-    return nil
-}
-
-// Validate nonce for replay attack prevention
-func validateNonce(stub shim.ChaincodeStubInterface, nonce string) error {
-    existing, err := stub.GetState(nonce)
-    if err != nil {
-        return errors.New("Error checking nonce")
-    }
-    if existing != nil {
-        return errors.New("Replay attack detected: Nonce already used")
-    }
-    return stub.PutState(nonce, []byte("used"))
-}
-
-// Create a new patient record securely
-func (t *PatientCareChaincode) createRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-    if len(args) != 2 {
-        return shim.Error("Expected arguments: record ID, data hash")
-    }
-
-    id, dataHash := args[0], args[1]
-    nonce := fmt.Sprintf("nonce-%s-%d", id, time.Now().UnixNano())
-
-    if err := validateInput(id, 50); err != nil {
-        return shim.Error(err.Error())
-    }
-    if err := validateInput(dataHash, 64); err != nil {
-        return shim.Error(err.Error())
-    }
-    if err := validateNonce(stub, nonce); err != nil {
-        return shim.Error(err.Error())
-    }
-
-    record := PatientRecord{
-        ID:        id,
-        DataHash:  dataHash,
-        CreatedAt: time.Now(),
-        UpdatedAt: time.Now(),
-        Nonce:     nonce,
-    }
-
-    recordJSON, err := json.Marshal(record)
-    if err != nil {
-        return shim.Error("Failed to marshal record JSON")
-    }
-    stub.PutState(id, recordJSON)
-
-    return shim.Success([]byte("Record created successfully"))
-}
-
-// Retrieve a patient record
-func (t *PatientCareChaincode) getRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-    if len(args) != 1 {
-        return shim.Error("Expected argument: record ID")
-    }
-    id := args[0]
-    if err := validateInput(id, 50); err != nil {
-        return shim.Error(err.Error())
-    }
-
-    recordBytes, err := stub.GetState(id)
-    if err != nil || recordBytes == nil {
-        return shim.Error("Record not found")
-    }
-
-    return shim.Success(recordBytes)
-}
-
-// Update an existing patient record
-func (t *PatientCareChaincode) updateRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-    if len(args) != 2 {
-        return shim.Error("Expected arguments: record ID, new data hash")
-    }
-    id, newDataHash := args[0], args[1]
-    nonce := fmt.Sprintf("nonce-%s-%d", id, time.Now().UnixNano())
-
-    if err := validateInput(id, 50); err != nil {
-        return shim.Error(err.Error())
-    }
-    if err := validateInput(newDataHash, 64); err != nil {
-        return shim.Error(err.Error())
-    }
-    if err := validateNonce(stub, nonce); err != nil {
-        return shim.Error(err.Error())
-    }
-
-    existingBytes, err := stub.GetState(id)
-    if err != nil || existingBytes == nil {
-        return shim.Error("Record does not exist")
-    }
-
-    var record PatientRecord
-    json.Unmarshal(existingBytes, &record)
-    record.DataHash = newDataHash
-    record.UpdatedAt = time.Now()
-    record.Nonce = nonce
-
-    recordJSON, err := json.Marshal(record)
-    if err != nil {
-        return shim.Error("Failed to marshal updated record JSON")
-    }
-    stub.PutState(id, recordJSON)
-
-    return shim.Success([]byte("Record updated successfully"))
-}
-
-// Main function
-func main() {
-    err := shim.Start(new(PatientCareChaincode))
-    if err != nil {
-        fmt.Printf("Error starting PatientCareChaincode: %s", err)
-    }
-}
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "crypto/sha256"
+    "encoding/hex"
+    "time"
+    "errors"
+    "github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Structs
+type PatientRecord struct {
+    ID             string    `json:"id"`
+    PatientID      string    `json:"patient_id,omitempty"`
+    DataHash       string    `json:"data_hash"`
+    CreatedAt      time.Time `json:"created_at"`
+    UpdatedAt      time.Time `json:"updated_at"`
+    Nonce          string    `json:"nonce"`
+    PHIHash        string    `json:"phi_hash,omitempty"`
+    Collection     string    `json:"collection,omitempty"`
+    Category       string    `json:"category,omitempty"`
+    FHIR           *FHIRAnchor `json:"fhir,omitempty"`
+    AttachmentsHash string   `json:"attachments_hash,omitempty"`
+    DEKID          string    `json:"dek_id,omitempty"`
+    Status         string    `json:"status,omitempty"`
+    ErasedAt       *time.Time `json:"erased_at,omitempty"`
+    Author         *SignatureAttestation  `json:"author,omitempty"`
+    CoSignatures   []SignatureAttestation `json:"co_signatures,omitempty"`
+}
+
+// PHI metadata kept in the hospital org's private data collection.
+// Only the salted hash of this struct is written to the channel.
+type PatientRecordPHI struct {
+    RecordID       string    `json:"record_id"`
+    DiagnosisCodes []string  `json:"diagnosis_codes"`
+    IPFSCID        string    `json:"ipfs_cid"`
+    Doctor         string    `json:"doctor"`
+    Salt           string    `json:"salt"`
+}
+
+// Transient map key carrying PatientRecordPHI and the collection name prefix
+const (
+    phiTransientKey     = "record_phi"
+    phiCollectionPrefix = "phiCollection"
+    minPHISaltLength    = 32
+)
+
+type PatientCareChaincode struct {}
+
+// Init function
+func (t *PatientCareChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+    return shim.Success(nil)
+}
+
+// Invoke function with input validation and client-signed nonce replay protection
+func (t *PatientCareChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+    function, args := stub.GetFunctionAndParameters()
+
+    switch function {
+    case "createRecord":
+        return t.createRecord(stub, args)
+    case "updateRecord":
+        return t.updateRecord(stub, args)
+    case "getRecord":
+        return t.getRecord(stub, args)
+    case "getRecordPHI":
+        return t.getRecordPHI(stub, args)
+    case "anchorFHIRResource":
+        return t.anchorFHIRResource(stub, args)
+    case "verifyFHIRResource":
+        return t.verifyFHIRResource(stub, args)
+    case "anchorBundle":
+        return t.anchorBundle(stub, args)
+    case "getBundle":
+        return t.getBundle(stub, args)
+    case "pruneNonces":
+        return t.pruneNonces(stub, args)
+    case "addAttachment":
+        return t.addAttachment(stub, args)
+    case "removeAttachment":
+        return t.removeAttachment(stub, args)
+    case "getAttachments":
+        return t.getAttachments(stub, args)
+    case "eraseRecord":
+        return t.eraseRecord(stub, args)
+    case "getErasureCertificate":
+        return t.getErasureCertificate(stub, args)
+    case "getRecordsByPatient":
+        return t.getRecordsByPatient(stub, args)
+    case "getRecordsByPatientAndCategory":
+        return t.getRecordsByPatientAndCategory(stub, args)
+    case "getRecordsUpdatedBetween":
+        return t.getRecordsUpdatedBetween(stub, args)
+    case "createCarePlan":
+        return t.createCarePlan(stub, args)
+    case "reviseCarePlan":
+        return t.reviseCarePlan(stub, args)
+    case "assignCareTeamMember":
+        return t.assignCareTeamMember(stub, args)
+    case "completeCarePlanActivity":
+        return t.completeCarePlanActivity(stub, args)
+    case "suspendCarePlan":
+        return t.suspendCarePlan(stub, args)
+    case "resumeCarePlan":
+        return t.resumeCarePlan(stub, args)
+    case "completeCarePlan":
+        return t.completeCarePlan(stub, args)
+    case "getCarePlan":
+        return t.getCarePlan(stub, args)
+    case "getCarePlanContent":
+        return t.getCarePlanContent(stub, args)
+    case "requestAppointment":
+        return t.requestAppointment(stub, args)
+    case "approveAppointment":
+        return t.approveAppointment(stub, args)
+    case "rejectAppointment":
+        return t.rejectAppointment(stub, args)
+    case "rescheduleAppointment":
+        return t.rescheduleAppointment(stub, args)
+    case "cancelAppointment":
+        return t.cancelAppointment(stub, args)
+    case "checkInAppointment":
+        return t.checkInAppointment(stub, args)
+    case "markAppointmentNoShow":
+        return t.markAppointmentNoShow(stub, args)
+    case "getAppointment":
+        return t.getAppointment(stub, args)
+    case "issuePrescription":
+        return t.issuePrescription(stub, args)
+    case "dispensePrescription":
+        return t.dispensePrescription(stub, args)
+    case "cancelPrescription":
+        return t.cancelPrescription(stub, args)
+    case "getPrescription":
+        return t.getPrescription(stub, args)
+    case "getPrescriptionDetails":
+        return t.getPrescriptionDetails(stub, args)
+    case "setControlledSubstance":
+        return t.setControlledSubstance(stub, args)
+    case "setPrescriberLicense":
+        return t.setPrescriberLicense(stub, args)
+    case "getControlledSubstanceHistory":
+        return t.getControlledSubstanceHistory(stub, args)
+    case "anchorTelemetryBatch":
+        return t.anchorTelemetryBatch(stub, args)
+    case "getTelemetryBatch":
+        return t.getTelemetryBatch(stub, args)
+    case "registerDevice":
+        return t.registerDevice(stub, args)
+    case "deregisterDevice":
+        return t.deregisterDevice(stub, args)
+    case "getDevice":
+        return t.getDevice(stub, args)
+    case "verifySignedReading":
+        return t.verifySignedReading(stub, args)
+    case "putAlertRule":
+        return t.putAlertRule(stub, args)
+    case "retireAlertRule":
+        return t.retireAlertRule(stub, args)
+    case "getAlertRule":
+        return t.getAlertRule(stub, args)
+    case "getActiveAlertRules":
+        return t.getActiveAlertRules(stub, args)
+    case "recordAlert":
+        return t.recordAlert(stub, args)
+    case "acknowledgeAlert":
+        return t.acknowledgeAlert(stub, args)
+    case "getAlert":
+        return t.getAlert(stub, args)
+    case "getAlertsByPatient":
+        return t.getAlertsByPatient(stub, args)
+    case "registerLaboratory":
+        return t.registerLaboratory(stub, args)
+    case "deregisterLaboratory":
+        return t.deregisterLaboratory(stub, args)
+    case "createLabOrder":
+        return t.createLabOrder(stub, args)
+    case "anchorLabResult":
+        return t.anchorLabResult(stub, args)
+    case "amendLabResult":
+        return t.amendLabResult(stub, args)
+    case "correctLabResult":
+        return t.correctLabResult(stub, args)
+    case "getLabOrder":
+        return t.getLabOrder(stub, args)
+    case "getLabResult":
+        return t.getLabResult(stub, args)
+    case "createReferral":
+        return t.createReferral(stub, args)
+    case "acceptReferral":
+        return t.acceptReferral(stub, args)
+    case "declineReferral":
+        return t.declineReferral(stub, args)
+    case "cancelReferral":
+        return t.cancelReferral(stub, args)
+    case "closeReferral":
+        return t.closeReferral(stub, args)
+    case "getReferral":
+        return t.getReferral(stub, args)
+    case "mergePatients":
+        return t.mergePatients(stub, args)
+    case "unmergePatients":
+        return t.unmergePatients(stub, args)
+    case "getPatientMerges":
+        return t.getPatientMerges(stub, args)
+    case "registerTrial":
+        return t.registerTrial(stub, args)
+    case "reviseTrial":
+        return t.reviseTrial(stub, args)
+    case "closeTrial":
+        return t.closeTrial(stub, args)
+    case "consentToTrial":
+        return t.consentToTrial(stub, args)
+    case "withdrawTrialConsent":
+        return t.withdrawTrialConsent(stub, args)
+    case "contributeTrialRecord":
+        return t.contributeTrialRecord(stub, args)
+    case "getTrial":
+        return t.getTrial(stub, args)
+    case "getTrialConsent":
+        return t.getTrialConsent(stub, args)
+    case "addEncounterReference":
+        return t.addEncounterReference(stub, args)
+    case "closeEncounter":
+        return t.closeEncounter(stub, args)
+    case "getEncounter":
+        return t.getEncounter(stub, args)
+    case "getEncounterDiagnoses":
+        return t.getEncounterDiagnoses(stub, args)
+    case "cosignRecord":
+        return t.cosignRecord(stub, args)
+    case "getRecordSignatures":
+        return t.getRecordSignatures(stub, args)
+    case "shareRecord":
+        return t.shareRecord(stub, args)
+    case "revokeGrant":
+        return t.revokeGrant(stub, args)
+    case "requestGrantAccess":
+        return t.requestGrantAccess(stub, args)
+    case "getGrantedRecord":
+        return t.getGrantedRecord(stub, args)
+    case "getGrantAccessLog":
+        return t.getGrantAccessLog(stub, args)
+    default:
+        return shim.Error("Invalid function name. Supported: createRecord, updateRecord, getRecord, getRecordPHI, anchorFHIRResource, verifyFHIRResource, anchorBundle, getBundle, pruneNonces, addAttachment, removeAttachment, getAttachments, eraseRecord, getErasureCertificate, shareRecord, revokeGrant, requestGrantAccess, getGrantedRecord, getGrantAccessLog, getRecordsByPatient, getRecordsByPatientAndCategory, getRecordsUpdatedBetween, createCarePlan, reviseCarePlan, assignCareTeamMember, completeCarePlanActivity, suspendCarePlan, resumeCarePlan, completeCarePlan, getCarePlan, getCarePlanContent, requestAppointment, approveAppointment, rejectAppointment, rescheduleAppointment, cancelAppointment, checkInAppointment, markAppointmentNoShow, getAppointment, issuePrescription, dispensePrescription, cancelPrescription, getPrescription, getPrescriptionDetails, setControlledSubstance, setPrescriberLicense, getControlledSubstanceHistory, anchorTelemetryBatch, getTelemetryBatch, registerDevice, deregisterDevice, getDevice, verifySignedReading, putAlertRule, retireAlertRule, getAlertRule, getActiveAlertRules, recordAlert, acknowledgeAlert, getAlert, getAlertsByPatient, registerLaboratory, deregisterLaboratory, createLabOrder, anchorLabResult, amendLabResult, correctLabResult, getLabOrder, getLabResult, createReferral, acceptReferral, declineReferral, cancelReferral, closeReferral, getReferral, mergePatients, unmergePatients, getPatientMerges, registerTrial, reviseTrial, closeTrial, consentToTrial, withdrawTrialConsent, contributeTrialRecord, getTrial, getTrialConsent, addEncounterReference, closeEncounter, getEncounter, getEncounterDiagnoses, cosignRecord, getRecordSignatures")
+    }
+}
+
+// Helper function for secure hash generation
+func generateHash(data string) string {
+    hash := sha256.Sum256([]byte(data))
+    return hex.EncodeToString(hash[:])
+}
+
+// Collection holding PHI for the invoking client's hospital org
+func phiCollectionName(stub shim.ChaincodeStubInterface) (string, error) {
+    mspID, err := cid.GetMSPID(stub)
+    if err != nil {
+        return "", errors.New("Failed to read client MSP ID")
+    }
+    return phiCollectionPrefix + mspID, nil
+}
+
+// Application user ID of the caller, from the user_id certificate attribute
+func callerUserID(stub shim.ChaincodeStubInterface) (string, error) {
+    userID, found, err := cid.GetAttributeValue(stub, "user_id")
+    if err != nil || !found || userID == "" {
+        return "", errors.New("Client certificate has no user_id attribute")
+    }
+    return userID, nil
+}
+
+// Whether the caller carries the given role attribute
+func hasRole(stub shim.ChaincodeStubInterface, role string) bool {
+    return cid.AssertAttributeValue(stub, "role", role) == nil
+}
+
+// Read PHI for a record from the transient map; nil if none was supplied
+func readTransientPHI(stub shim.ChaincodeStubInterface, id string) (*PatientRecordPHI, error) {
+    transient, err := stub.GetTransient()
+    if err != nil {
+        return nil, errors.New("Failed to read transient data")
+    }
+    phiBytes, ok := transient[phiTransientKey]
+    if !ok {
+        return nil, nil
+    }
+
+    var phi PatientRecordPHI
+    if err := json.Unmarshal(phiBytes, &phi); err != nil {
+        return nil, errors.New("Invalid PHI in transient data")
+    }
+    if phi.RecordID == "" {
+        phi.RecordID = id
+    }
+    if phi.RecordID != id {
+        return nil, errors.New("PHI record ID does not match record ID")
+    }
+    if phi.IPFSCID != "" {
+        if err := validateCID("ipfs_cid", phi.IPFSCID); err != nil {
+            return nil, err
+        }
+    }
+    if phi.Doctor != "" {
+        if err := validateID("doctor", phi.Doctor); err != nil {
+            return nil, err
+        }
+    }
+    for _, code := range phi.DiagnosisCodes {
+        if err := validateID("diagnosis_codes", code); err != nil {
+            return nil, err
+        }
+    }
+    // The salt must come from the client so every endorser computes the same hash
+    if len(phi.Salt) < minPHISaltLength {
+        return nil, fmt.Errorf("PHI salt must be at least %d characters", minPHISaltLength)
+    }
+    return &phi, nil
+}
+
+// Salted hash of the PHI, the only part visible on the channel
+func hashPHI(phi *PatientRecordPHI) (string, error) {
+    phiJSON, err := json.Marshal(phi)
+    if err != nil {
+        return "", errors.New("Failed to marshal PHI JSON")
+    }
+    return generateHash(string(phiJSON)), nil
+}
+
+// Caller's collection for a record's private data; a record's private data
+// stays with the org that first wrote it
+func recordCollection(stub shim.ChaincodeStubInterface, record *PatientRecord) (string, error) {
+    collection, err := phiCollectionName(stub)
+    if err != nil {
+        return "", err
+    }
+    if record.Collection != "" && record.Collection != collection {
+        return "", errors.New("Record PHI is held by another organization")
+    }
+    return collection, nil
+}
+
+// Load a patient record from world state
+func readRecord(stub shim.ChaincodeStubInterface, id string) (*PatientRecord, error) {
+    recordBytes, err := stub.GetState(id)
+    if err != nil || recordBytes == nil {
+        return nil, errors.New("Record not found")
+    }
+    var record PatientRecord
+    if err := json.Unmarshal(recordBytes, &record); err != nil {
+        return nil, errors.New("Failed to unmarshal record JSON")
+    }
+    return &record, nil
+}
+
+// Store a patient record in world state
+func putRecord(stub shim.ChaincodeStubInterface, record *PatientRecord) ([]byte, error) {
+    previousBytes, err := stub.GetState(record.ID)
+    if err != nil {
+        return nil, errors.New("Error reading record")
+    }
+    var previous *PatientRecord
+    if previousBytes != nil {
+        previous = &PatientRecord{}
+        if err := json.Unmarshal(previousBytes, previous); err != nil {
+            return nil, errors.New("Failed to unmarshal record JSON")
+        }
+    }
+    if err := updateRecordIndexes(stub, previous, record); err != nil {
+        return nil, err
+    }
+
+    recordJSON, err := json.Marshal(record)
+    if err != nil {
+        return nil, errors.New("Failed to marshal record JSON")
+    }
+    if err := stub.PutState(record.ID, recordJSON); err != nil {
+        return nil, errors.New("Failed to store record")
+    }
+    return recordJSON, nil
+}
+
+// Write PHI to the caller's collection and anchor its hash on the record
+func putRecordPHI(stub shim.ChaincodeStubInterface, record *PatientRecord, phi *PatientRecordPHI) error {
+    collection, err := recordCollection(stub, record)
+    if err != nil {
+        return err
+    }
+
+    phiHash, err := hashPHI(phi)
+    if err != nil {
+        return err
+    }
+    phiJSON, err := json.Marshal(phi)
+    if err != nil {
+        return errors.New("Failed to marshal PHI JSON")
+    }
+    if err := stub.PutPrivateData(collection, record.ID, phiJSON); err != nil {
+        return errors.New("Failed to store PHI in private data collection")
+    }
+
+    record.PHIHash = phiHash
+    record.Collection = collection
+    return nil
+}
+
+// Create a new patient record securely. The authoring doctor signs the
+// record hash with their DID key.
+func (t *PatientCareChaincode) createRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if (len(args) != 5 && len(args) != 6) || nonceArgs == nil {
+        return shim.Error("Expected arguments: record ID, data hash, patient ID, author DID, DID signature, [data encryption key ID], nonce, expiry, signature")
+    }
+
+    id, dataHash, patientID, authorDID, didSignature := args[0], args[1], args[2], args[3], args[4]
+    nonce := nonceArgs[0]
+    if err := validateID("patient_id", patientID); err != nil {
+        return shim.Error(err.Error())
+    }
+    dekID := ""
+    if len(args) == 6 {
+        dekID = args[5]
+        if err := validateID("dek_id", dekID); err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+
+    if err := validateID("record_id", id); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateHash("data_hash", dataHash); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := consumeNonce(stub, "createRecord", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    // Never overwrite an existing record, in particular an erasure tombstone
+    existing, err := stub.GetState(id)
+    if err != nil {
+        return shim.Error("Error reading record")
+    }
+    if existing != nil {
+        return shim.Error("Record already exists")
+    }
+    author, err := verifyClinicianSignature(stub, authorDID, recordAuthorDigest(id, patientID, dataHash, authorDID), didSignature, now)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    record := PatientRecord{
+        ID:        id,
+        DataHash:  dataHash,
+        CreatedAt: now,
+        UpdatedAt: now,
+        Nonce:     nonce,
+        PatientID: patientID,
+        DEKID:     dekID,
+        Status:    recordStatusActive,
+    }
+    if err := signRecordVersion(stub, &record, author); err != nil {
+        return shim.Error(err.Error())
+    }
+    if dekID != "" {
+        if err := registerDataKey(stub, dekID, id, now); err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+
+    phi, err := readTransientPHI(stub, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if phi != nil {
+        if err := putRecordPHI(stub, &record, phi); err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+
+    if _, err := putRecord(stub, &record); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "RecordCreated", ObjectID: id, PatientID: patientID, Status: record.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success([]byte("Record created successfully"))
+}
+
+// Retrieve a patient record. Callers outside the patient's care read under a
+// sharing grant, by user ID or a grant_token in the transient map, and
+// must submit the call so the logged access commits.
+func (t *PatientCareChaincode) getRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: record ID")
+    }
+    id := args[0]
+    if err := validateID("record_id", id); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    recordBytes, err := stub.GetState(id)
+    if err != nil || recordBytes == nil {
+        return shim.Error("Record not found")
+    }
+    var record PatientRecord
+    if err := json.Unmarshal(recordBytes, &record); err != nil {
+        return shim.Error("Failed to unmarshal record JSON")
+    }
+    if err := authorizeRecordRead(stub, &record); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(recordBytes)
+}
+
+// Retrieve the PHI of a record from the owning org's private data collection
+func (t *PatientCareChaincode) getRecordPHI(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: record ID")
+    }
+    id := args[0]
+    if err := validateID("record_id", id); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    recordBytes, err := stub.GetState(id)
+    if err != nil || recordBytes == nil {
+        return shim.Error("Record not found")
+    }
+    var record PatientRecord
+    if err := json.Unmarshal(recordBytes, &record); err != nil {
+        return shim.Error("Failed to unmarshal record JSON")
+    }
+    if err := requireActiveRecord(&record); err != nil {
+        return shim.Error(err.Error())
+    }
+    if record.Collection == "" {
+        return shim.Error("Record has no private PHI")
+    }
+
+    phiBytes, err := stub.GetPrivateData(record.Collection, id)
+    if err != nil || phiBytes == nil {
+        return shim.Error("PHI not found or not accessible to this organization")
+    }
+    var phi PatientRecordPHI
+    if err := json.Unmarshal(phiBytes, &phi); err != nil {
+        return shim.Error("Failed to unmarshal PHI JSON")
+    }
+    phiHash, err := hashPHI(&phi)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if phiHash != record.PHIHash {
+        return shim.Error("PHI does not match the hash anchored on the channel")
+    }
+
+    return shim.Success(phiBytes)
+}
+
+// Update an existing patient record
+func (t *PatientCareChaincode) updateRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 4 || nonceArgs == nil {
+        return shim.Error("Expected arguments: record ID, new data hash, author DID, DID signature, nonce, expiry, signature")
+    }
+    id, newDataHash, authorDID, didSignature := args[0], args[1], args[2], args[3]
+    nonce := nonceArgs[0]
+
+    if err := validateID("record_id", id); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateHash("data_hash", newDataHash); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := consumeNonce(stub, "updateRecord", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    existingBytes, err := stub.GetState(id)
+    if err != nil || existingBytes == nil {
+        return shim.Error("Record does not exist")
+    }
+
+    var record PatientRecord
+    json.Unmarshal(existingBytes, &record)
+    if err := requireActiveRecord(&record); err != nil {
+        return shim.Error(err.Error())
+    }
+    author, err := verifyClinicianSignature(stub, authorDID, recordAuthorDigest(id, record.PatientID, newDataHash, authorDID), didSignature, now)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    record.DataHash = newDataHash
+    record.UpdatedAt = now
+    record.Nonce = nonce
+    if err := signRecordVersion(stub, &record, author); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    phi, err := readTransientPHI(stub, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if phi != nil {
+        if err := putRecordPHI(stub, &record, phi); err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+
+    if _, err := putRecord(stub, &record); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "RecordUpdated", ObjectID: id, PatientID: record.PatientID, Status: record.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success([]byte("Record updated successfully"))
+}
+
+// Main function
+func main() {
+    err := shim.Start(new(PatientCareChaincode))
+    if err != nil {
+        fmt.Printf("Error starting PatientCareChaincode: %s", err)
+    }
+}
@@ -0,0 +1,637 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// One version of a clinical alert rule. The definition is evaluated by the
+// alert engine; the ledger keeps every version so an alert can be traced
+// to the exact rule that raised it.
+type AlertRule struct {
+    ID             string           `json:"id"`
+    Version        int              `json:"version"`
+    Name           string           `json:"name"`
+    Severity       string           `json:"severity"`
+    Notify         []string         `json:"notify"`
+    Definition     json.RawMessage  `json:"definition"`
+    Authored       SignedTransition `json:"authored"`
+}
+
+// Latest version of a rule, and whether it has been retired
+type AlertRuleHead struct {
+    ID             string     `json:"id"`
+    LatestVersion  int        `json:"latest_version"`
+    RetiredAt      *time.Time `json:"retired_at,omitempty"`
+}
+
+// Alert raised by the alert engine for a patient device. It names the rule
+// and severity but carries no vitals: the readings behind it are anchored
+// by their telemetry batches and the alert keeps only their digest. Raised
+// holds the engine's signed nonce; every recipient acknowledgement is kept
+// too.
+type Alert struct {
+    ID               string             `json:"id"`
+    RuleID           string             `json:"rule_id"`
+    RuleVersion      int                `json:"rule_version"`
+    PatientID        string             `json:"patient_id"`
+    DeviceID         string             `json:"device_id"`
+    Severity         string             `json:"severity"`
+    FiredAt          time.Time          `json:"fired_at"`
+    EvidenceDigest   string             `json:"evidence_digest"`
+    Recipients       []string           `json:"recipients"`
+    Status           string             `json:"status"`
+    Raised           SignedTransition   `json:"raised"`
+    Acknowledgements []SignedTransition `json:"acknowledgements"`
+}
+
+// Page of alerts for a patient
+type AlertPage struct {
+    Alerts         []Alert `json:"alerts"`
+    Bookmark       string  `json:"bookmark"`
+}
+
+const (
+    alertRuleObjectType     = "alertRule"
+    alertRuleHeadObjectType = "alertRuleHead"
+    alertObjectType         = "alert"
+    patientAlertIndex       = "patientAlert"
+    clinicalAlertEvent      = "ClinicalAlert"
+
+    alertOpen         = "open"
+    alertAcknowledged = "acknowledged"
+
+    maxAlertRuleBytes  = 16 * 1024
+    maxAlertRecipients = 20
+)
+
+var alertSeverities = map[string]bool{"info": true, "warning": true, "critical": true}
+
+// Parse and check the parts of a rule definition the ledger relies on.
+// The condition itself is validated by the alert engine.
+func parseAlertRuleDefinition(definition string) (*AlertRule, error) {
+    if len(definition) == 0 || len(definition) > maxAlertRuleBytes {
+        return nil, &ValidationError{Field: "definition", Reason: fmt.Sprintf("must be 1-%d bytes of JSON", maxAlertRuleBytes)}
+    }
+    var parsed struct {
+        Name      string          `json:"name"`
+        Severity  string          `json:"severity"`
+        Notify    []string        `json:"notify"`
+        Condition json.RawMessage `json:"condition"`
+    }
+    if err := json.Unmarshal([]byte(definition), &parsed); err != nil {
+        return nil, &ValidationError{Field: "definition", Reason: "must be a JSON object"}
+    }
+    if parsed.Name == "" || len(parsed.Name) > 100 {
+        return nil, &ValidationError{Field: "name", Reason: "must be 1-100 characters"}
+    }
+    if !alertSeverities[parsed.Severity] {
+        return nil, &ValidationError{Field: "severity", Reason: "must be info, warning or critical"}
+    }
+    if len(parsed.Notify) == 0 || len(parsed.Notify) > maxAlertRecipients {
+        return nil, &ValidationError{Field: "notify", Reason: fmt.Sprintf("must list 1-%d user IDs", maxAlertRecipients)}
+    }
+    for _, userID := range parsed.Notify {
+        if err := validateID("notify", userID); err != nil {
+            return nil, err
+        }
+    }
+    if len(parsed.Condition) == 0 || parsed.Condition[0] != '{' {
+        return nil, &ValidationError{Field: "condition", Reason: "must be a JSON object"}
+    }
+    var compact bytes.Buffer
+    if err := json.Compact(&compact, []byte(definition)); err != nil {
+        return nil, &ValidationError{Field: "definition", Reason: "must be a JSON object"}
+    }
+    return &AlertRule{
+        Name:       parsed.Name,
+        Severity:   parsed.Severity,
+        Notify:     parsed.Notify,
+        Definition: compact.Bytes(),
+    }, nil
+}
+
+// Load a rule's head entry
+func readAlertRuleHead(stub shim.ChaincodeStubInterface, ruleID string) (*AlertRuleHead, string, error) {
+    key, err := stub.CreateCompositeKey(alertRuleHeadObjectType, []string{ruleID})
+    if err != nil {
+        return nil, "", errors.New("Failed to create alert rule key")
+    }
+    headBytes, err := stub.GetState(key)
+    if err != nil {
+        return nil, "", errors.New("Error reading alert rule")
+    }
+    if headBytes == nil {
+        return nil, key, nil
+    }
+    var head AlertRuleHead
+    if err := json.Unmarshal(headBytes, &head); err != nil {
+        return nil, "", errors.New("Failed to unmarshal alert rule JSON")
+    }
+    return &head, key, nil
+}
+
+// Load one version of a rule
+func readAlertRule(stub shim.ChaincodeStubInterface, ruleID string, version int) (*AlertRule, error) {
+    key, err := stub.CreateCompositeKey(alertRuleObjectType, []string{ruleID, fmt.Sprintf("%010d", version)})
+    if err != nil {
+        return nil, errors.New("Failed to create alert rule key")
+    }
+    ruleBytes, err := stub.GetState(key)
+    if err != nil {
+        return nil, errors.New("Error reading alert rule")
+    }
+    if ruleBytes == nil {
+        return nil, errors.New("Alert rule version not found")
+    }
+    var rule AlertRule
+    if err := json.Unmarshal(ruleBytes, &rule); err != nil {
+        return nil, errors.New("Failed to unmarshal alert rule JSON")
+    }
+    return &rule, nil
+}
+
+// Load an alert by ID
+func readAlert(stub shim.ChaincodeStubInterface, alertID string) (*Alert, string, error) {
+    key, err := stub.CreateCompositeKey(alertObjectType, []string{alertID})
+    if err != nil {
+        return nil, "", errors.New("Failed to create alert key")
+    }
+    alertBytes, err := stub.GetState(key)
+    if err != nil {
+        return nil, "", errors.New("Error reading alert")
+    }
+    if alertBytes == nil {
+        return nil, key, nil
+    }
+    var alert Alert
+    if err := json.Unmarshal(alertBytes, &alert); err != nil {
+        return nil, "", errors.New("Failed to unmarshal alert JSON")
+    }
+    return &alert, key, nil
+}
+
+// Whether the caller may read an alert: its patient, a recipient, doctors,
+// admins and the alert engine
+func canReadAlert(stub shim.ChaincodeStubInterface, alert *Alert) bool {
+    if hasRole(stub, "admin") || hasRole(stub, "doctor") || hasRole(stub, "alerting") {
+        return true
+    }
+    callerID, err := callerUserID(stub)
+    if err != nil {
+        return false
+    }
+    if callerID == alert.PatientID {
+        return true
+    }
+    for _, recipient := range alert.Recipients {
+        if recipient == callerID {
+            return true
+        }
+    }
+    return false
+}
+
+// Admin or doctor: store a new version of an alert rule. The first call
+// for a rule ID creates version 1; a retired rule cannot be revised.
+func (t *PatientCareChaincode) putAlertRule(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 2 || nonceArgs == nil {
+        return shim.Error("Expected arguments: rule ID, definition JSON, nonce, expiry, signature")
+    }
+    ruleID := args[0]
+    if err := validateID("rule_id", ruleID); err != nil {
+        return shim.Error(err.Error())
+    }
+    rule, err := parseAlertRuleDefinition(args[1])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !hasRole(stub, "admin") && !hasRole(stub, "doctor") {
+        return shim.Error("Only doctors and admins can define alert rules")
+    }
+    authorID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    head, headKey, err := readAlertRuleHead(stub, ruleID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if head == nil {
+        head = &AlertRuleHead{ID: ruleID}
+    }
+    if head.RetiredAt != nil {
+        return shim.Error("Alert rule has been retired")
+    }
+    if err := consumeNonce(stub, "putAlertRule", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    head.LatestVersion++
+    rule.ID = ruleID
+    rule.Version = head.LatestVersion
+    rule.Authored = newSignedTransition(stub, "define", "", strconv.Itoa(rule.Version), "", authorID, args, nonceArgs, now)
+    ruleJSON, err := json.Marshal(rule)
+    if err != nil {
+        return shim.Error("Failed to marshal alert rule JSON")
+    }
+    ruleKey, err := stub.CreateCompositeKey(alertRuleObjectType, []string{ruleID, fmt.Sprintf("%010d", rule.Version)})
+    if err != nil {
+        return shim.Error("Failed to create alert rule key")
+    }
+    if err := stub.PutState(ruleKey, ruleJSON); err != nil {
+        return shim.Error("Failed to store alert rule")
+    }
+    headJSON, err := json.Marshal(head)
+    if err != nil {
+        return shim.Error("Failed to marshal alert rule JSON")
+    }
+    if err := stub.PutState(headKey, headJSON); err != nil {
+        return shim.Error("Failed to store alert rule")
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "AlertRuleDefined", ObjectID: ruleID, ActorID: authorID}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(ruleJSON)
+}
+
+// Admin or doctor: retire a rule so the engine stops evaluating it. Its
+// versions stay on the ledger for alerts already raised.
+func (t *PatientCareChaincode) retireAlertRule(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 1 || nonceArgs == nil {
+        return shim.Error("Expected arguments: rule ID, nonce, expiry, signature")
+    }
+    ruleID := args[0]
+    if err := validateID("rule_id", ruleID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if !hasRole(stub, "admin") && !hasRole(stub, "doctor") {
+        return shim.Error("Only doctors and admins can retire alert rules")
+    }
+    head, headKey, err := readAlertRuleHead(stub, ruleID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if head == nil {
+        return shim.Error("Alert rule not found")
+    }
+    if head.RetiredAt != nil {
+        return shim.Error("Alert rule is already retired")
+    }
+    if err := consumeNonce(stub, "retireAlertRule", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    head.RetiredAt = &now
+    headJSON, err := json.Marshal(head)
+    if err != nil {
+        return shim.Error("Failed to marshal alert rule JSON")
+    }
+    if err := stub.PutState(headKey, headJSON); err != nil {
+        return shim.Error("Failed to store alert rule")
+    }
+    actorID, _ := callerUserID(stub)
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "AlertRuleRetired", ObjectID: ruleID, ActorID: actorID}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(headJSON)
+}
+
+// Retrieve a rule version, or its latest version when none is given
+func (t *PatientCareChaincode) getAlertRule(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 && len(args) != 2 {
+        return shim.Error("Expected arguments: rule ID, [version]")
+    }
+    ruleID := args[0]
+    if err := validateID("rule_id", ruleID); err != nil {
+        return shim.Error(err.Error())
+    }
+    head, _, err := readAlertRuleHead(stub, ruleID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if head == nil {
+        return shim.Error("Alert rule not found")
+    }
+    version := head.LatestVersion
+    if len(args) == 2 {
+        if version, err = parseBoundedInt("version", args[1], 1, head.LatestVersion); err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+    rule, err := readAlertRule(stub, ruleID, version)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    ruleJSON, err := json.Marshal(rule)
+    if err != nil {
+        return shim.Error("Failed to marshal alert rule JSON")
+    }
+
+    return shim.Success(ruleJSON)
+}
+
+// Latest version of every rule that is not retired, for the alert engine
+func (t *PatientCareChaincode) getActiveAlertRules(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return shim.Error("Expected no arguments")
+    }
+    if !hasRole(stub, "admin") && !hasRole(stub, "doctor") && !hasRole(stub, "alerting") {
+        return shim.Error("Only doctors, admins and the alert engine can list alert rules")
+    }
+    iterator, err := stub.GetStateByPartialCompositeKey(alertRuleHeadObjectType, []string{})
+    if err != nil {
+        return shim.Error("Failed to query alert rules")
+    }
+    defer iterator.Close()
+    rules := []AlertRule{}
+    for iterator.HasNext() {
+        entry, err := iterator.Next()
+        if err != nil {
+            return shim.Error("Failed to iterate alert rules")
+        }
+        var head AlertRuleHead
+        if err := json.Unmarshal(entry.Value, &head); err != nil {
+            return shim.Error("Failed to unmarshal alert rule JSON")
+        }
+        if head.RetiredAt != nil {
+            continue
+        }
+        rule, err := readAlertRule(stub, head.ID, head.LatestVersion)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        rules = append(rules, *rule)
+    }
+    rulesJSON, err := json.Marshal(rules)
+    if err != nil {
+        return shim.Error("Failed to marshal alert rules JSON")
+    }
+
+    return shim.Success(rulesJSON)
+}
+
+// Alert engine only: record an alert raised by a live rule version for a
+// device registered to the patient, and notify its recipients through the
+// ClinicalAlert event
+func (t *PatientCareChaincode) recordAlert(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 7 || nonceArgs == nil {
+        return shim.Error("Expected arguments: alert ID, rule ID, rule version, patient ID, device ID, fired at, evidence digest, nonce, expiry, signature")
+    }
+    alertID, ruleID, patientID, deviceID, evidenceDigest := args[0], args[1], args[3], args[4], args[6]
+    if err := validateID("alert_id", alertID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("rule_id", ruleID); err != nil {
+        return shim.Error(err.Error())
+    }
+    ruleVersion, err := parseBoundedInt("rule_version", args[2], 1, 1<<30)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("patient_id", patientID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("device_id", deviceID); err != nil {
+        return shim.Error(err.Error())
+    }
+    firedAt, err := parseTelemetryTime("fired_at", args[5])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !sha256HexPattern.MatchString(evidenceDigest) {
+        return shim.Error((&ValidationError{Field: "evidence_digest", Reason: "must be lowercase hex SHA-256"}).Error())
+    }
+    if err := requireRole(stub, "alerting"); err != nil {
+        return shim.Error(err.Error())
+    }
+    engineID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    head, _, err := readAlertRuleHead(stub, ruleID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if head == nil {
+        return shim.Error("Alert rule not found")
+    }
+    if head.RetiredAt != nil {
+        return shim.Error("Alert rule has been retired")
+    }
+    rule, err := readAlertRule(stub, ruleID, ruleVersion)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    device, _, err := readDevice(stub, deviceID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if device == nil || device.PatientID != patientID || firedAt.Before(device.RegisteredAt.Add(-maxTelemetryClockSkew)) {
+        return shim.Error("Device is not registered to this patient")
+    }
+    if device.RevokedAt != nil && !firedAt.Before(*device.RevokedAt) {
+        return shim.Error("Device was revoked before the alert fired")
+    }
+    alert, key, err := readAlert(stub, alertID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if alert != nil {
+        return shim.Error("Alert is already recorded")
+    }
+    if err := consumeNonce(stub, "recordAlert", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if firedAt.After(now.Add(maxTelemetryClockSkew)) {
+        return shim.Error(fmt.Sprintf("Alert time is more than %s ahead of the ledger", maxTelemetryClockSkew))
+    }
+
+    alert = &Alert{
+        ID:               alertID,
+        RuleID:           ruleID,
+        RuleVersion:      ruleVersion,
+        PatientID:        patientID,
+        DeviceID:         deviceID,
+        Severity:         rule.Severity,
+        FiredAt:          firedAt,
+        EvidenceDigest:   evidenceDigest,
+        Recipients:       rule.Notify,
+        Status:           alertOpen,
+        Raised:           newSignedTransition(stub, "raise", "", alertOpen, strings.Join(rule.Notify, ","), engineID, args, nonceArgs, now),
+        Acknowledgements: []SignedTransition{},
+    }
+    alertJSON, err := json.Marshal(alert)
+    if err != nil {
+        return shim.Error("Failed to marshal alert JSON")
+    }
+    if err := stub.PutState(key, alertJSON); err != nil {
+        return shim.Error("Failed to store alert")
+    }
+    indexKey, err := stub.CreateCompositeKey(patientAlertIndex, []string{patientID, fmt.Sprintf("%020d", firedAt.Unix()), alertID})
+    if err != nil {
+        return shim.Error("Failed to create patient alert index key")
+    }
+    if err := stub.PutState(indexKey, []byte{0x00}); err != nil {
+        return shim.Error("Failed to store patient alert index")
+    }
+    // Recipients fetch the alert itself with getAlert
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: clinicalAlertEvent, ObjectID: alertID, PatientID: patientID, ActorID: engineID, Status: alert.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(alertJSON)
+}
+
+// Recipient only: acknowledge an alert, recording who saw it and when
+func (t *PatientCareChaincode) acknowledgeAlert(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 1 || nonceArgs == nil {
+        return shim.Error("Expected arguments: alert ID, nonce, expiry, signature")
+    }
+    alertID := args[0]
+    if err := validateID("alert_id", alertID); err != nil {
+        return shim.Error(err.Error())
+    }
+    alert, key, err := readAlert(stub, alertID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if alert == nil {
+        return shim.Error("Alert not found")
+    }
+    callerID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    recipient := false
+    for _, userID := range alert.Recipients {
+        recipient = recipient || userID == callerID
+    }
+    if !recipient {
+        return shim.Error("Access denied: not a recipient of this alert")
+    }
+    for _, ack := range alert.Acknowledgements {
+        if ack.ActorID == callerID {
+            return shim.Error("Alert is already acknowledged by the caller")
+        }
+    }
+    if err := consumeNonce(stub, "acknowledgeAlert", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    alert.Acknowledgements = append(alert.Acknowledgements, newSignedTransition(stub, "acknowledge", alert.Status, alertAcknowledged, "", callerID, args, nonceArgs, now))
+    alert.Status = alertAcknowledged
+    alertJSON, err := json.Marshal(alert)
+    if err != nil {
+        return shim.Error("Failed to marshal alert JSON")
+    }
+    if err := stub.PutState(key, alertJSON); err != nil {
+        return shim.Error("Failed to store alert")
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "AlertAcknowledged", ObjectID: alertID, PatientID: alert.PatientID, ActorID: callerID, Status: alert.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(alertJSON)
+}
+
+// Retrieve an alert
+func (t *PatientCareChaincode) getAlert(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: alert ID")
+    }
+    if err := validateID("alert_id", args[0]); err != nil {
+        return shim.Error(err.Error())
+    }
+    alert, _, err := readAlert(stub, args[0])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if alert == nil {
+        return shim.Error("Alert not found")
+    }
+    if !canReadAlert(stub, alert) {
+        return shim.Error("Access denied: not allowed to read this alert")
+    }
+    alertJSON, err := json.Marshal(alert)
+    if err != nil {
+        return shim.Error("Failed to marshal alert JSON")
+    }
+
+    return shim.Success(alertJSON)
+}
+
+// List a patient's alerts oldest first, one page at a time
+func (t *PatientCareChaincode) getAlertsByPatient(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return shim.Error("Expected arguments: patient ID, page size, bookmark")
+    }
+    patientID := args[0]
+    if err := validateID("patient_id", patientID); err != nil {
+        return shim.Error(err.Error())
+    }
+    pageSize, bookmark, err := parsePageArgs(args[1], args[2])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    iterator, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(patientAlertIndex, []string{patientID}, pageSize, bookmark)
+    if err != nil {
+        return shim.Error("Failed to query patient alerts")
+    }
+    defer iterator.Close()
+    page := AlertPage{Alerts: []Alert{}, Bookmark: metadata.Bookmark}
+    for iterator.HasNext() {
+        entry, err := iterator.Next()
+        if err != nil {
+            return shim.Error("Failed to iterate patient alerts")
+        }
+        _, parts, err := stub.SplitCompositeKey(entry.Key)
+        if err != nil || len(parts) != 3 {
+            return shim.Error("Invalid patient alert index entry")
+        }
+        alert, _, err := readAlert(stub, parts[2])
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if alert == nil || !canReadAlert(stub, alert) {
+            continue
+        }
+        page.Alerts = append(page.Alerts, *alert)
+    }
+    pageJSON, err := json.Marshal(page)
+    if err != nil {
+        return shim.Error("Failed to marshal alert page JSON")
+    }
+
+    return shim.Success(pageJSON)
+}
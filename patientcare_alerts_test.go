@@ -0,0 +1,76 @@
+package main
+
+import (
+    "encoding/json"
+    "strconv"
+    "strings"
+    "testing"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const testAlertRule = `{"name":"Tachycardia","severity":"critical","notify":["d1"],"condition":{"type":"threshold","metric":"heart_rate","op":">","value":130,"for":"10m"}}`
+
+// recordTestAlert submits recordAlert for rule r1 v1 as engine
+func (l *testLedger) recordTestAlert(engine *testClient, alertID, patientID, deviceID string) pb.Response {
+    l.t.Helper()
+    firedAt := strconv.FormatInt(l.now.Unix(), 10)
+    return l.as(engine).invoke("recordAlert", l.signed("recordAlert", alertID, "r1", "1", patientID, deviceID, firedAt, generateHash("evidence"))...)
+}
+
+func TestRecordAlertKeepsVitalsOffTheLedger(t *testing.T) {
+    ledger := newTestLedger(t)
+    patient := ledger.client("p1", "patient")
+    engine := ledger.client("engine", "alerting")
+    ledger.registerTestDevice(patient, "watch-1")
+    ledger.as(ledger.client("d1", "doctor")).mustInvoke("putAlertRule", "r1", testAlertRule)
+
+    response := ledger.recordTestAlert(engine, "al-1", "p1", "watch-1")
+    if response.Status != shim.OK {
+        t.Fatalf("recordAlert: %s", response.Message)
+    }
+    var stored map[string]interface{}
+    if err := json.Unmarshal(response.Payload, &stored); err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := stored["summary"]; ok || strings.Contains(string(response.Payload), "heart_rate") {
+        t.Fatalf("alert carries vitals: %s", response.Payload)
+    }
+    if stored["rule_id"] != "r1" || stored["severity"] != "critical" || stored["evidence_digest"] != generateHash("evidence") {
+        t.Fatalf("alert %s, want rule r1, severity and the evidence digest", response.Payload)
+    }
+}
+
+func TestRecordAlertChecksDeviceAndRule(t *testing.T) {
+    ledger := newTestLedger(t)
+    patient := ledger.client("p1", "patient")
+    doctor := ledger.client("d1", "doctor")
+    engine := ledger.client("engine", "alerting")
+    ledger.registerTestDevice(patient, "watch-1")
+    ledger.registerTestDevice(ledger.client("p2", "patient"), "watch-2")
+    ledger.as(doctor).mustInvoke("putAlertRule", "r1", testAlertRule)
+
+    tests := []struct {
+        name      string
+        patientID string
+        deviceID  string
+        want      string
+    }{
+        {"unregistered device", "p1", "watch-9", "Device is not registered to this patient"},
+        {"another patient's device", "p1", "watch-2", "Device is not registered to this patient"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            response := ledger.recordTestAlert(engine, "al-"+test.deviceID, test.patientID, test.deviceID)
+            if !strings.Contains(response.Message, test.want) {
+                t.Fatalf("recordAlert: %q, want %q", response.Message, test.want)
+            }
+        })
+    }
+
+    ledger.as(doctor).mustInvoke("retireAlertRule", "r1")
+    if response := ledger.recordTestAlert(engine, "al-1", "p1", "watch-1"); !strings.Contains(response.Message, "Alert rule has been retired") {
+        t.Fatalf("recordAlert for a retired rule: %q", response.Message)
+    }
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strconv"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Appointment between a patient and a doctor. History holds every signed
+// transition, so no-show disputes can be settled from the ledger alone.
+type Appointment struct {
+    ID             string             `json:"id"`
+    PatientID      string             `json:"patient_id"`
+    DoctorID       string             `json:"doctor_id"`
+    Status         string             `json:"status"`
+    Start          time.Time          `json:"start"`
+    DurationMins   int                `json:"duration_minutes"`
+    History        []SignedTransition `json:"history"`
+    CreatedAt      time.Time          `json:"created_at"`
+    UpdatedAt      time.Time          `json:"updated_at"`
+}
+
+const (
+    appointmentObjectType = "appointment"
+    doctorSlotObjectType  = "doctorSlot"
+
+    // requested, approved and rejected are the Pending, Approved and Rejected
+    // statuses of the appointments table
+    appointmentStatusRequested = "requested"
+    appointmentStatusApproved  = "approved"
+    appointmentStatusRejected  = "rejected"
+    appointmentStatusCancelled = "cancelled"
+    appointmentStatusCheckedIn = "checked-in"
+    appointmentStatusNoShow    = "no-show"
+
+    appointmentSlot       = 15 * time.Minute
+    maxAppointmentMinutes = 240
+    checkInWindow         = time.Hour
+    noShowGracePeriod     = 15 * time.Minute
+)
+
+// Allowed appointment transitions: action -> current status -> new status.
+// request has no current status and is handled by requestAppointment.
+var appointmentTransitions = map[string]map[string]string{
+    "approve":    {appointmentStatusRequested: appointmentStatusApproved},
+    "reject":     {appointmentStatusRequested: appointmentStatusRejected},
+    "reschedule": {appointmentStatusRequested: appointmentStatusRequested, appointmentStatusApproved: appointmentStatusRequested},
+    "cancel":     {appointmentStatusRequested: appointmentStatusCancelled, appointmentStatusApproved: appointmentStatusCancelled},
+    "checkIn":    {appointmentStatusApproved: appointmentStatusCheckedIn},
+    "noShow":     {appointmentStatusApproved: appointmentStatusNoShow},
+}
+
+// Parties allowed to take each action
+var appointmentActors = map[string][]string{
+    "approve":    {"doctor"},
+    "reject":     {"doctor"},
+    "reschedule": {"patient", "doctor"},
+    "cancel":     {"patient", "doctor", "admin"},
+    "checkIn":    {"doctor", "admin"},
+    "noShow":     {"doctor", "admin"},
+}
+
+// Chaincode event each appointment action emits
+var appointmentEvents = map[string]string{
+    "approve":    "AppointmentApproved",
+    "reject":     "AppointmentRejected",
+    "reschedule": "AppointmentRescheduled",
+    "cancel":     "AppointmentCancelled",
+    "checkIn":    "AppointmentCheckedIn",
+    "noShow":     "AppointmentNoShow",
+}
+
+// Parse an appointment start (unix seconds) and length in minutes. Starts
+// fall on slot boundaries and lengths are whole slots.
+func parseAppointmentTime(startArg, durationArg string) (time.Time, int, error) {
+    startSeconds, err := strconv.ParseInt(startArg, 10, 64)
+    if err != nil {
+        return time.Time{}, 0, &ValidationError{Field: "start", Reason: "must be unix seconds"}
+    }
+    start := time.Unix(startSeconds, 0).UTC()
+    if start.Truncate(appointmentSlot) != start {
+        return time.Time{}, 0, &ValidationError{Field: "start", Reason: fmt.Sprintf("must fall on a %s boundary", appointmentSlot)}
+    }
+    minutes, err := strconv.Atoi(durationArg)
+    slotMinutes := int(appointmentSlot / time.Minute)
+    if err != nil || minutes <= 0 || minutes > maxAppointmentMinutes || minutes%slotMinutes != 0 {
+        return time.Time{}, 0, &ValidationError{Field: "duration_minutes", Reason: fmt.Sprintf("must be a multiple of %d up to %d", slotMinutes, maxAppointmentMinutes)}
+    }
+    return start, minutes, nil
+}
+
+// Slot keys covered by an appointment, one per slot
+func appointmentSlotKeys(stub shim.ChaincodeStubInterface, appointment *Appointment) ([]string, error) {
+    end := appointment.Start.Add(time.Duration(appointment.DurationMins) * time.Minute)
+    keys := []string{}
+    for slot := appointment.Start; slot.Before(end); slot = slot.Add(appointmentSlot) {
+        key, err := stub.CreateCompositeKey(doctorSlotObjectType, []string{appointment.DoctorID, fmt.Sprintf("%020d", slot.Unix())})
+        if err != nil {
+            return nil, errors.New("Failed to create doctor slot key")
+        }
+        keys = append(keys, key)
+    }
+    return keys, nil
+}
+
+// Reserve the doctor's slots for an appointment, failing if any is held by
+// another appointment
+func claimAppointmentSlots(stub shim.ChaincodeStubInterface, appointment *Appointment) error {
+    keys, err := appointmentSlotKeys(stub, appointment)
+    if err != nil {
+        return err
+    }
+    for _, key := range keys {
+        holder, err := stub.GetState(key)
+        if err != nil {
+            return errors.New("Error reading doctor slot")
+        }
+        if holder != nil && string(holder) != appointment.ID {
+            return fmt.Errorf("Doctor is already booked by appointment %s", string(holder))
+        }
+    }
+    for _, key := range keys {
+        if err := stub.PutState(key, []byte(appointment.ID)); err != nil {
+            return errors.New("Failed to reserve doctor slot")
+        }
+    }
+    return nil
+}
+
+// Free the doctor's slots held by an appointment
+func releaseAppointmentSlots(stub shim.ChaincodeStubInterface, appointment *Appointment) error {
+    keys, err := appointmentSlotKeys(stub, appointment)
+    if err != nil {
+        return err
+    }
+    for _, key := range keys {
+        holder, err := stub.GetState(key)
+        if err != nil {
+            return errors.New("Error reading doctor slot")
+        }
+        if string(holder) != appointment.ID {
+            continue
+        }
+        if err := stub.DelState(key); err != nil {
+            return errors.New("Failed to release doctor slot")
+        }
+    }
+    return nil
+}
+
+// Load an appointment by ID
+func readAppointment(stub shim.ChaincodeStubInterface, appointmentID string) (*Appointment, string, error) {
+    key, err := stub.CreateCompositeKey(appointmentObjectType, []string{appointmentID})
+    if err != nil {
+        return nil, "", errors.New("Failed to create appointment key")
+    }
+    appointmentBytes, err := stub.GetState(key)
+    if err != nil {
+        return nil, "", errors.New("Error reading appointment")
+    }
+    if appointmentBytes == nil {
+        return nil, key, nil
+    }
+    var appointment Appointment
+    if err := json.Unmarshal(appointmentBytes, &appointment); err != nil {
+        return nil, "", errors.New("Failed to unmarshal appointment JSON")
+    }
+    return &appointment, key, nil
+}
+
+// Store an appointment and return its JSON
+func putAppointment(stub shim.ChaincodeStubInterface, key string, appointment *Appointment) ([]byte, error) {
+    appointmentJSON, err := json.Marshal(appointment)
+    if err != nil {
+        return nil, errors.New("Failed to marshal appointment JSON")
+    }
+    if err := stub.PutState(key, appointmentJSON); err != nil {
+        return nil, errors.New("Failed to store appointment")
+    }
+    return appointmentJSON, nil
+}
+
+// Which party to an appointment the caller is: patient, doctor or admin
+func appointmentParty(stub shim.ChaincodeStubInterface, appointment *Appointment) (string, string, error) {
+    callerID, err := callerUserID(stub)
+    if err != nil {
+        return "", "", err
+    }
+    switch {
+    case callerID == appointment.DoctorID && hasRole(stub, "doctor"):
+        return "doctor", callerID, nil
+    case callerID == appointment.PatientID:
+        return "patient", callerID, nil
+    case hasRole(stub, "admin"):
+        return "admin", callerID, nil
+    }
+    return "", callerID, errors.New("Access denied: not a party to this appointment")
+}
+
+// Common path for every change to an existing appointment: the caller must
+// be allowed to take the action, the action must be legal from the current
+// status, and the signed nonce is consumed. apply makes the change and may
+// override the new status.
+func (t *PatientCareChaincode) transitionAppointment(stub shim.ChaincodeStubInterface, function, action string, args []string, argCount int, apply func(appointment *Appointment, party string, to *string, now time.Time) (string, error)) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != argCount || nonceArgs == nil {
+        return shim.Error(fmt.Sprintf("Expected %d arguments followed by nonce, expiry, signature", argCount))
+    }
+    appointmentID := args[0]
+    if err := validateID("appointment_id", appointmentID); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    appointment, key, err := readAppointment(stub, appointmentID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if appointment == nil {
+        return shim.Error("Appointment not found")
+    }
+    party, actorID, err := appointmentParty(stub, appointment)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    allowed := false
+    for _, actor := range appointmentActors[action] {
+        allowed = allowed || actor == party
+    }
+    if !allowed {
+        return shim.Error(fmt.Sprintf("A %s cannot %s this appointment", party, action))
+    }
+    to, ok := appointmentTransitions[action][appointment.Status]
+    if !ok {
+        return shim.Error(fmt.Sprintf("Cannot %s an appointment that is %s", action, appointment.Status))
+    }
+    if err := consumeNonce(stub, function, args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    detail, err := apply(appointment, party, &to, now)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    appointment.History = append(appointment.History, newSignedTransition(stub, action, appointment.Status, to, detail, actorID, args, nonceArgs, now))
+    appointment.Status = to
+    appointment.UpdatedAt = now
+
+    appointmentJSON, err := putAppointment(stub, key, appointment)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: appointmentEvents[action], ObjectID: appointmentID, PatientID: appointment.PatientID, ActorID: actorID, Status: appointment.Status, CounterpartyID: appointment.DoctorID}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(appointmentJSON)
+}
+
+// Patient requests an appointment with a doctor. The doctor's slots are
+// held from the request on, so competing requests are refused.
+func (t *PatientCareChaincode) requestAppointment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 4 || nonceArgs == nil {
+        return shim.Error("Expected arguments: appointment ID, doctor ID, start (unix seconds), duration minutes, nonce, expiry, signature")
+    }
+    appointmentID, doctorID := args[0], args[1]
+    if err := validateID("appointment_id", appointmentID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("doctor_id", doctorID); err != nil {
+        return shim.Error(err.Error())
+    }
+    start, minutes, err := parseAppointmentTime(args[2], args[3])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    patientID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if patientID == doctorID {
+        return shim.Error("Doctors cannot book appointments with themselves")
+    }
+
+    existing, key, err := readAppointment(stub, appointmentID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if existing != nil {
+        return shim.Error("Appointment already exists")
+    }
+    if err := consumeNonce(stub, "requestAppointment", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !start.After(now) {
+        return shim.Error("Appointment must start in the future")
+    }
+
+    appointment := &Appointment{
+        ID:           appointmentID,
+        PatientID:    patientID,
+        DoctorID:     doctorID,
+        Status:       appointmentStatusRequested,
+        Start:        start,
+        DurationMins: minutes,
+        CreatedAt:    now,
+        UpdatedAt:    now,
+    }
+    if err := claimAppointmentSlots(stub, appointment); err != nil {
+        return shim.Error(err.Error())
+    }
+    appointment.History = []SignedTransition{newSignedTransition(stub, "request", "", appointmentStatusRequested, "", patientID, args, nonceArgs, now)}
+
+    appointmentJSON, err := putAppointment(stub, key, appointment)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "AppointmentRequested", ObjectID: appointmentID, PatientID: patientID, ActorID: patientID, Status: appointment.Status, CounterpartyID: doctorID}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(appointmentJSON)
+}
+
+// Doctor approves a requested appointment
+func (t *PatientCareChaincode) approveAppointment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return t.transitionAppointment(stub, "approveAppointment", "approve", args, 1, func(appointment *Appointment, party string, to *string, now time.Time) (string, error) {
+        if !appointment.Start.After(now) {
+            return "", errors.New("Appointment start has already passed")
+        }
+        return "", nil
+    })
+}
+
+// Doctor rejects a requested appointment, with a reason code
+func (t *PatientCareChaincode) rejectAppointment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return t.transitionAppointment(stub, "rejectAppointment", "reject", args, 2, func(appointment *Appointment, party string, to *string, now time.Time) (string, error) {
+        if err := validateID("reason_code", args[1]); err != nil {
+            return "", err
+        }
+        return args[1], releaseAppointmentSlots(stub, appointment)
+    })
+}
+
+// Move an appointment to a new time. A patient's move needs the doctor's
+// approval again; a doctor's move stays approved.
+func (t *PatientCareChaincode) rescheduleAppointment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return t.transitionAppointment(stub, "rescheduleAppointment", "reschedule", args, 3, func(appointment *Appointment, party string, to *string, now time.Time) (string, error) {
+        start, minutes, err := parseAppointmentTime(args[1], args[2])
+        if err != nil {
+            return "", err
+        }
+        if !start.After(now) {
+            return "", errors.New("Appointment must start in the future")
+        }
+        previous := appointment.Start
+        if err := releaseAppointmentSlots(stub, appointment); err != nil {
+            return "", err
+        }
+        appointment.Start = start
+        appointment.DurationMins = minutes
+        if err := claimAppointmentSlots(stub, appointment); err != nil {
+            return "", err
+        }
+        if party == "doctor" {
+            *to = appointmentStatusApproved
+        }
+        return fmt.Sprintf("from %s", previous.Format(time.RFC3339)), nil
+    })
+}
+
+// Cancel a requested or approved appointment, with a reason code
+func (t *PatientCareChaincode) cancelAppointment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return t.transitionAppointment(stub, "cancelAppointment", "cancel", args, 2, func(appointment *Appointment, party string, to *string, now time.Time) (string, error) {
+        if err := validateID("reason_code", args[1]); err != nil {
+            return "", err
+        }
+        return args[1], releaseAppointmentSlots(stub, appointment)
+    })
+}
+
+// Check the patient in, from an hour before the start until the end. This
+// opens the visit's encounter under the appointment's ID.
+func (t *PatientCareChaincode) checkInAppointment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return t.transitionAppointment(stub, "checkInAppointment", "checkIn", args, 1, func(appointment *Appointment, party string, to *string, now time.Time) (string, error) {
+        end := appointment.Start.Add(time.Duration(appointment.DurationMins) * time.Minute)
+        if now.Before(appointment.Start.Add(-checkInWindow)) || !now.Before(end) {
+            return "", errors.New("Check-in is only open from an hour before the appointment until its end")
+        }
+        return "", openEncounter(stub, appointment, now)
+    })
+}
+
+// Record that the patient did not attend. Allowed once the grace period
+// after the start has passed without a check-in.
+func (t *PatientCareChaincode) markAppointmentNoShow(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return t.transitionAppointment(stub, "markAppointmentNoShow", "noShow", args, 1, func(appointment *Appointment, party string, to *string, now time.Time) (string, error) {
+        if now.Before(appointment.Start.Add(noShowGracePeriod)) {
+            return "", fmt.Errorf("No-show can only be recorded %s after the start", noShowGracePeriod)
+        }
+        return "", nil
+    })
+}
+
+// Retrieve an appointment with its history; parties and admins only
+func (t *PatientCareChaincode) getAppointment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: appointment ID")
+    }
+    appointmentID := args[0]
+    if err := validateID("appointment_id", appointmentID); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    appointment, key, err := readAppointment(stub, appointmentID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if appointment == nil {
+        return shim.Error("Appointment not found")
+    }
+    if _, _, err := appointmentParty(stub, appointment); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    appointmentBytes, err := stub.GetState(key)
+    if err != nil {
+        return shim.Error("Error reading appointment")
+    }
+    return shim.Success(appointmentBytes)
+}
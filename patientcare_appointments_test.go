@@ -0,0 +1,66 @@
+package main
+
+import (
+    "encoding/json"
+    "strconv"
+    "testing"
+    "time"
+)
+
+// bookAppointment has patient request a half-hour appointment with doctor at
+// start and doctor approve it
+func (l *testLedger) bookAppointment(appointmentID string, patient, doctor *testClient, start time.Time) {
+    l.t.Helper()
+    l.as(patient).mustInvoke("requestAppointment", appointmentID, doctor.userID, strconv.FormatInt(start.Unix(), 10), "30")
+    l.as(doctor).mustInvoke("approveAppointment", appointmentID)
+}
+
+func (l *testLedger) appointmentStatus(appointmentID string) string {
+    l.t.Helper()
+    var appointment Appointment
+    if err := json.Unmarshal(l.mustQuery("getAppointment", appointmentID), &appointment); err != nil {
+        l.t.Fatal(err)
+    }
+    return appointment.Status
+}
+
+func TestAppointmentNoShowWaitsForGracePeriod(t *testing.T) {
+    ledger := newTestLedger(t)
+    patient := ledger.client("p1", "patient")
+    doctor := ledger.client("d1", "doctor")
+    start := ledger.now.Add(24 * time.Hour)
+    ledger.bookAppointment("a1", patient, doctor, start)
+
+    ledger.setTime(start.Add(noShowGracePeriod - time.Minute))
+    ledger.mustFail("No-show can only be recorded", "markAppointmentNoShow", "a1")
+
+    ledger.setTime(start.Add(noShowGracePeriod))
+    ledger.mustInvoke("markAppointmentNoShow", "a1")
+    if ledger.lastEvent != "AppointmentNoShow" {
+        t.Fatalf("event %q, want AppointmentNoShow", ledger.lastEvent)
+    }
+    if got := ledger.appointmentStatus("a1"); got != appointmentStatusNoShow {
+        t.Fatalf("status %q, want %q", got, appointmentStatusNoShow)
+    }
+}
+
+func TestAppointmentCheckInWindow(t *testing.T) {
+    ledger := newTestLedger(t)
+    patient := ledger.client("p1", "patient")
+    doctor := ledger.client("d1", "doctor")
+    start := ledger.now.Add(24 * time.Hour)
+    ledger.bookAppointment("a1", patient, doctor, start)
+
+    ledger.setTime(start.Add(-checkInWindow - time.Minute))
+    ledger.as(doctor).mustFail("Check-in is only open", "checkInAppointment", "a1")
+
+    ledger.setTime(start.Add(-checkInWindow))
+    ledger.mustInvoke("checkInAppointment", "a1")
+    if got := ledger.appointmentStatus("a1"); got != appointmentStatusCheckedIn {
+        t.Fatalf("status %q, want %q", got, appointmentStatusCheckedIn)
+    }
+
+    // A checked-in patient is never a no-show, however late it gets
+    ledger.advance(24 * time.Hour)
+    ledger.mustFail("Cannot noShow an appointment that is "+appointmentStatusCheckedIn, "markAppointmentNoShow", "a1")
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "regexp"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// IPFS object attached to a patient record (test results, imaging)
+type Attachment struct {
+    ID             string    `json:"id"`
+    CID            string    `json:"cid"`
+    MIMEType       string    `json:"mime_type"`
+    Size           int64     `json:"size"`
+    Digest         string    `json:"digest"`
+    KeyRef         string    `json:"key_ref"`
+    UploaderDID    string    `json:"uploader_did"`
+    AddedAt        time.Time `json:"added_at"`
+}
+
+// Attachments of one record. CIDs and uploaders are PHI metadata, so the
+// manifest lives in the record's private data collection and only its hash
+// is written to the channel.
+type AttachmentManifest struct {
+    RecordID       string       `json:"record_id"`
+    Attachments    []Attachment `json:"attachments"`
+    NextID         int          `json:"next_id"`
+    UpdatedAt      time.Time    `json:"updated_at"`
+}
+
+// Transient map key carrying a new Attachment and the private key namespace
+const (
+    attachmentTransientKey = "attachment"
+    attachmentObjectType   = "attachments"
+    maxAttachments         = 100
+)
+
+// MIME type/subtype, lowercase
+var mimeTypePattern = regexp.MustCompile(`^[a-z]+/[a-z0-9][a-z0-9.+\-]*$`)
+
+// Validate an attachment supplied by the client
+func validateAttachment(attachment *Attachment) error {
+    if err := validateCID("cid", attachment.CID); err != nil {
+        return err
+    }
+    if !mimeTypePattern.MatchString(attachment.MIMEType) {
+        return &ValidationError{Field: "mime_type", Reason: "must be a lowercase type/subtype"}
+    }
+    if attachment.Size <= 0 {
+        return &ValidationError{Field: "size", Reason: "must be positive"}
+    }
+    if !sha256HexPattern.MatchString(attachment.Digest) {
+        return &ValidationError{Field: "digest", Reason: "must be lowercase hex SHA-256 of the stored object"}
+    }
+    if err := validateID("key_ref", attachment.KeyRef); err != nil {
+        return err
+    }
+    return validateID("uploader_did", attachment.UploaderDID)
+}
+
+// Load the attachment manifest of a record, or an empty one
+func readAttachmentManifest(stub shim.ChaincodeStubInterface, collection, recordID string) (*AttachmentManifest, string, error) {
+    key, err := stub.CreateCompositeKey(attachmentObjectType, []string{recordID})
+    if err != nil {
+        return nil, "", errors.New("Failed to create attachments key")
+    }
+    manifest := &AttachmentManifest{RecordID: recordID, Attachments: []Attachment{}}
+    if collection == "" {
+        return manifest, key, nil
+    }
+    manifestBytes, err := stub.GetPrivateData(collection, key)
+    if err != nil {
+        return nil, "", errors.New("Failed to read attachment manifest")
+    }
+    if manifestBytes != nil {
+        if err := json.Unmarshal(manifestBytes, manifest); err != nil {
+            return nil, "", errors.New("Failed to unmarshal attachment manifest")
+        }
+    }
+    return manifest, key, nil
+}
+
+// Store the manifest privately and anchor its hash on the record
+func putAttachmentManifest(stub shim.ChaincodeStubInterface, record *PatientRecord, collection, key string, manifest *AttachmentManifest) ([]byte, error) {
+    manifestJSON, err := json.Marshal(manifest)
+    if err != nil {
+        return nil, errors.New("Failed to marshal attachment manifest")
+    }
+    if err := stub.PutPrivateData(collection, key, manifestJSON); err != nil {
+        return nil, errors.New("Failed to store attachment manifest")
+    }
+    record.Collection = collection
+    record.AttachmentsHash = generateHash(string(manifestJSON))
+    record.UpdatedAt = manifest.UpdatedAt
+    return putRecord(stub, record)
+}
+
+// Add an IPFS attachment, passed in the transient map, to a record
+func (t *PatientCareChaincode) addAttachment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 1 || nonceArgs == nil {
+        return shim.Error("Expected arguments: record ID, nonce, expiry, signature")
+    }
+    id := args[0]
+    if err := validateID("record_id", id); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    transient, err := stub.GetTransient()
+    if err != nil {
+        return shim.Error("Failed to read transient data")
+    }
+    attachmentBytes, ok := transient[attachmentTransientKey]
+    if !ok {
+        return shim.Error("Expected attachment in transient key " + attachmentTransientKey)
+    }
+    var attachment Attachment
+    if err := json.Unmarshal(attachmentBytes, &attachment); err != nil {
+        return shim.Error("Invalid attachment in transient data")
+    }
+    if err := validateAttachment(&attachment); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := consumeNonce(stub, "addAttachment", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    record, err := readRecord(stub, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := requireActiveRecord(record); err != nil {
+        return shim.Error(err.Error())
+    }
+    collection, err := recordCollection(stub, record)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    manifest, key, err := readAttachmentManifest(stub, record.Collection, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if len(manifest.Attachments) >= maxAttachments {
+        return shim.Error(fmt.Sprintf("Record already has %d attachments", maxAttachments))
+    }
+    for _, existing := range manifest.Attachments {
+        if existing.CID == attachment.CID {
+            return shim.Error("Attachment CID is already on this record")
+        }
+    }
+
+    manifest.NextID++
+    attachment.ID = fmt.Sprintf("att-%d", manifest.NextID)
+    attachment.AddedAt = now
+    manifest.Attachments = append(manifest.Attachments, attachment)
+    manifest.UpdatedAt = now
+
+    if _, err := putAttachmentManifest(stub, record, collection, key, manifest); err != nil {
+        return shim.Error(err.Error())
+    }
+    actorID, _ := callerUserID(stub)
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "AttachmentAdded", ObjectID: id, PatientID: record.PatientID, ActorID: actorID}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success([]byte(attachment.ID))
+}
+
+// Remove an attachment from a record by attachment ID
+func (t *PatientCareChaincode) removeAttachment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 2 || nonceArgs == nil {
+        return shim.Error("Expected arguments: record ID, attachment ID, nonce, expiry, signature")
+    }
+    id, attachmentID := args[0], args[1]
+    if err := validateID("record_id", id); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("attachment_id", attachmentID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := consumeNonce(stub, "removeAttachment", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    record, err := readRecord(stub, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := requireActiveRecord(record); err != nil {
+        return shim.Error(err.Error())
+    }
+    collection, err := recordCollection(stub, record)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    manifest, key, err := readAttachmentManifest(stub, record.Collection, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    remaining := make([]Attachment, 0, len(manifest.Attachments))
+    for _, attachment := range manifest.Attachments {
+        if attachment.ID != attachmentID {
+            remaining = append(remaining, attachment)
+        }
+    }
+    if len(remaining) == len(manifest.Attachments) {
+        return shim.Error("Attachment not found")
+    }
+    manifest.Attachments = remaining
+    manifest.UpdatedAt = now
+
+    if _, err := putAttachmentManifest(stub, record, collection, key, manifest); err != nil {
+        return shim.Error(err.Error())
+    }
+    actorID, _ := callerUserID(stub)
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "AttachmentRemoved", ObjectID: id, PatientID: record.PatientID, ActorID: actorID}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success([]byte("Attachment removed successfully"))
+}
+
+// Retrieve a record's attachment manifest, checked against the anchored hash
+func (t *PatientCareChaincode) getAttachments(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: record ID")
+    }
+    id := args[0]
+    if err := validateID("record_id", id); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    record, err := readRecord(stub, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if record.AttachmentsHash == "" {
+        return shim.Error("Record has no attachments")
+    }
+    key, err := stub.CreateCompositeKey(attachmentObjectType, []string{id})
+    if err != nil {
+        return shim.Error("Failed to create attachments key")
+    }
+    manifestBytes, err := stub.GetPrivateData(record.Collection, key)
+    if err != nil || manifestBytes == nil {
+        return shim.Error("Attachment manifest not found or not accessible to this organization")
+    }
+    if generateHash(string(manifestBytes)) != record.AttachmentsHash {
+        return shim.Error("Attachment manifest does not match the hash anchored on the channel")
+    }
+
+    return shim.Success(manifestBytes)
+}
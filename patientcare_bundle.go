@@ -0,0 +1,186 @@
+package main
+
+import (
+    "bytes"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+    "github.com/tyuvic777/tyuabc777/merkle"
+)
+
+// Merkle root over the entries of a FHIR Bundle, anchored in one transaction.
+// The tree is built with the merkle package, which also issues and verifies
+// the per-entry inclusion proofs.
+type BundleAnchor struct {
+    ID          string    `json:"id"`
+    BundleID    string    `json:"bundle_id"`
+    BundleType  string    `json:"bundle_type"`
+    EntryCount  int       `json:"entry_count"`
+    MerkleRoot  string    `json:"merkle_root"`
+    CreatedAt   time.Time `json:"created_at"`
+    Nonce       string    `json:"nonce"`
+}
+
+// Transient map key carrying the FHIR Bundle JSON and the state key namespace
+const (
+    bundleTransientKey = "fhir_bundle"
+    bundleObjectType   = "bundle"
+    maxBundleEntries   = 10000
+)
+
+// Leaf hashes for each bundle entry, in bundle order. Each leaf commits to
+// the entry resource's canonical hash, as computed for single-resource anchors.
+func bundleLeafHashes(bundleJSON []byte) (string, string, [][]byte, error) {
+    decoder := json.NewDecoder(bytes.NewReader(bundleJSON))
+    decoder.UseNumber()
+    var bundle struct {
+        ResourceType string `json:"resourceType"`
+        ID           string `json:"id"`
+        Type         string `json:"type"`
+        Entry        []struct {
+            Resource map[string]interface{} `json:"resource"`
+        } `json:"entry"`
+    }
+    if err := decoder.Decode(&bundle); err != nil {
+        return "", "", nil, errors.New("Invalid FHIR Bundle JSON")
+    }
+    if bundle.ResourceType != "Bundle" {
+        return "", "", nil, errors.New("FHIR resource is not a Bundle")
+    }
+    if !fhirIDPattern.MatchString(bundle.ID) {
+        return "", "", nil, errors.New("FHIR Bundle id is missing or invalid")
+    }
+    if bundle.Type == "" {
+        return "", "", nil, errors.New("FHIR Bundle is missing required element type")
+    }
+    if len(bundle.Entry) == 0 || len(bundle.Entry) > maxBundleEntries {
+        return "", "", nil, fmt.Errorf("FHIR Bundle must have between 1 and %d entries", maxBundleEntries)
+    }
+
+    leaves := make([][]byte, 0, len(bundle.Entry))
+    for i, entry := range bundle.Entry {
+        if entry.Resource == nil {
+            return "", "", nil, fmt.Errorf("Bundle entry %d has no resource", i)
+        }
+        resourceType, _ := entry.Resource["resourceType"].(string)
+        if resourceType == "" {
+            return "", "", nil, fmt.Errorf("Bundle entry %d is missing resourceType", i)
+        }
+        // Typed record categories get the same validation as single anchors
+        if validate, ok := fhirValidators[resourceType]; ok {
+            if err := validate(entry.Resource); err != nil {
+                return "", "", nil, fmt.Errorf("Bundle entry %d: %s", i, err.Error())
+            }
+        }
+        canonicalHash, err := canonicalFHIRHash(entry.Resource)
+        if err != nil {
+            return "", "", nil, err
+        }
+        hashBytes, _ := hex.DecodeString(canonicalHash)
+        leaves = append(leaves, merkle.LeafHash(hashBytes))
+    }
+    return bundle.ID, bundle.Type, leaves, nil
+}
+
+// Anchor the Merkle root of a FHIR Bundle passed in the transient map
+func (t *PatientCareChaincode) anchorBundle(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 1 || nonceArgs == nil {
+        return shim.Error("Expected arguments: bundle anchor ID, nonce, expiry, signature")
+    }
+    if err := requireRole(stub, "doctor"); err != nil {
+        return shim.Error(err.Error())
+    }
+    id := args[0]
+    nonce := nonceArgs[0]
+
+    if err := validateID("bundle_anchor_id", id); err != nil {
+        return shim.Error(err.Error())
+    }
+    transient, err := stub.GetTransient()
+    if err != nil {
+        return shim.Error("Failed to read transient data")
+    }
+    bundleJSON, ok := transient[bundleTransientKey]
+    if !ok || len(bundleJSON) == 0 {
+        return shim.Error("Expected FHIR Bundle in transient key " + bundleTransientKey)
+    }
+
+    key, err := stub.CreateCompositeKey(bundleObjectType, []string{id})
+    if err != nil {
+        return shim.Error("Failed to create bundle key")
+    }
+    existing, err := stub.GetState(key)
+    if err != nil {
+        return shim.Error("Error reading bundle anchor")
+    }
+    if existing != nil {
+        return shim.Error("Bundle anchor already exists")
+    }
+
+    bundleID, bundleType, leaves, err := bundleLeafHashes(bundleJSON)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    tree, err := merkle.NewTreeFromHashes(leaves)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := consumeNonce(stub, "anchorBundle", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    anchor := BundleAnchor{
+        ID:         id,
+        BundleID:   bundleID,
+        BundleType: bundleType,
+        EntryCount: len(leaves),
+        MerkleRoot: tree.RootHex(),
+        CreatedAt:  now,
+        Nonce:      nonce,
+    }
+
+    anchorJSON, err := json.Marshal(anchor)
+    if err != nil {
+        return shim.Error("Failed to marshal bundle anchor JSON")
+    }
+    if err := stub.PutState(key, anchorJSON); err != nil {
+        return shim.Error("Failed to store bundle anchor")
+    }
+    actorID, _ := callerUserID(stub)
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "BundleAnchored", ObjectID: id, ActorID: actorID}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(anchorJSON)
+}
+
+// Retrieve a bundle anchor and its Merkle root
+func (t *PatientCareChaincode) getBundle(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: bundle anchor ID")
+    }
+    id := args[0]
+    if err := validateID("bundle_anchor_id", id); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    key, err := stub.CreateCompositeKey(bundleObjectType, []string{id})
+    if err != nil {
+        return shim.Error("Failed to create bundle key")
+    }
+    anchorBytes, err := stub.GetState(key)
+    if err != nil || anchorBytes == nil {
+        return shim.Error("Bundle anchor not found")
+    }
+
+    return shim.Success(anchorBytes)
+}
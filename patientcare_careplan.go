@@ -0,0 +1,600 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Care plan as seen on the channel. Goal and activity wording is PHI and
+// lives in the creating org's private data collection as CarePlanContent;
+// the channel holds its hash, the care team and each activity's progress.
+type CarePlan struct {
+    ID             string             `json:"id"`
+    PatientID      string             `json:"patient_id"`
+    Status         string             `json:"status"`
+    Version        int                `json:"version"`
+    ContentHash    string             `json:"content_hash"`
+    Collection     string             `json:"collection"`
+    GoalIDs        []string           `json:"goal_ids"`
+    Activities     []CarePlanActivity `json:"activities"`
+    CareTeam       []CareTeamMember   `json:"care_team"`
+    History        []SignedTransition `json:"history"`
+    CreatedBy      string             `json:"created_by"`
+    CreatedAt      time.Time          `json:"created_at"`
+    UpdatedAt      time.Time          `json:"updated_at"`
+}
+
+// Progress of one planned activity
+type CarePlanActivity struct {
+    ID             string     `json:"id"`
+    Status         string     `json:"status"`
+    CompletedBy    string     `json:"completed_by,omitempty"`
+    CompletedAt    *time.Time `json:"completed_at,omitempty"`
+}
+
+// Member of a plan's care team
+type CareTeamMember struct {
+    UserID         string    `json:"user_id"`
+    Role           string    `json:"role"`
+    AssignedBy     string    `json:"assigned_by"`
+    AssignedAt     time.Time `json:"assigned_at"`
+}
+
+// Audit entry for a state change, carrying the actor's nonce signature so
+// anyone can re-verify it against the actor's certificate with nonceDigest
+type SignedTransition struct {
+    Action         string    `json:"action"`
+    FromStatus     string    `json:"from_status,omitempty"`
+    ToStatus       string    `json:"to_status"`
+    Detail         string    `json:"detail,omitempty"`
+    ActorID        string    `json:"actor_id"`
+    Payload        []string  `json:"payload"`
+    Nonce          string    `json:"nonce"`
+    NonceExpiry    string    `json:"nonce_expiry"`
+    Signature      string    `json:"signature"`
+    TxID           string    `json:"tx_id"`
+    At             time.Time `json:"at"`
+}
+
+// Private care plan content, passed in the transient map
+type CarePlanContent struct {
+    PlanID         string                 `json:"plan_id"`
+    Title          string                 `json:"title"`
+    Goals          []CarePlanGoal         `json:"goals"`
+    Activities     []CarePlanActivityPlan `json:"activities"`
+    Salt           string                 `json:"salt"`
+}
+
+// Goal of a care plan
+type CarePlanGoal struct {
+    ID             string `json:"id"`
+    Description    string `json:"description"`
+    Target         string `json:"target,omitempty"`
+}
+
+// Planned activity of a care plan
+type CarePlanActivityPlan struct {
+    ID             string `json:"id"`
+    Description    string `json:"description"`
+    GoalID         string `json:"goal_id,omitempty"`
+    Due            string `json:"due,omitempty"`
+}
+
+const (
+    carePlanTransientKey = "care_plan"
+    carePlanObjectType   = "carePlan"
+    carePlanContentType  = "carePlanContent"
+
+    carePlanStatusActive    = "active"
+    carePlanStatusOnHold    = "on-hold"
+    carePlanStatusCompleted = "completed"
+
+    activityStatusNotStarted = "not-started"
+    activityStatusCompleted  = "completed"
+
+    maxCarePlanItems      = 50
+    maxCarePlanTextLength = 2000
+    maxCareTeamSize       = 20
+)
+
+// Allowed care plan transitions: action -> current status -> new status.
+// create has no current status and is handled by createCarePlan.
+var carePlanTransitions = map[string]map[string]string{
+    "revise":           {carePlanStatusActive: carePlanStatusActive, carePlanStatusOnHold: carePlanStatusActive},
+    "assign":           {carePlanStatusActive: carePlanStatusActive, carePlanStatusOnHold: carePlanStatusOnHold},
+    "completeActivity": {carePlanStatusActive: carePlanStatusActive},
+    "suspend":          {carePlanStatusActive: carePlanStatusOnHold},
+    "resume":           {carePlanStatusOnHold: carePlanStatusActive},
+    "complete":         {carePlanStatusActive: carePlanStatusCompleted},
+}
+
+// Chaincode event each care plan action emits
+var carePlanEvents = map[string]string{
+    "revise":           "CarePlanRevised",
+    "assign":           "CareTeamMemberAssigned",
+    "completeActivity": "CarePlanActivityCompleted",
+    "suspend":          "CarePlanSuspended",
+    "resume":           "CarePlanResumed",
+    "complete":         "CarePlanCompleted",
+}
+
+// Care team roles a plan can assign
+var careTeamRoles = map[string]bool{"lead": true, "doctor": true, "nurse": true, "therapist": true, "pharmacist": true, "caregiver": true}
+
+// Build the audit entry for a signed state change. nonceArgs must already
+// have passed consumeNonce for the same function and payload.
+func newSignedTransition(stub shim.ChaincodeStubInterface, action, from, to, detail, actorID string, payload, nonceArgs []string, now time.Time) SignedTransition {
+    return SignedTransition{
+        Action:      action,
+        FromStatus:  from,
+        ToStatus:    to,
+        Detail:      detail,
+        ActorID:     actorID,
+        Payload:     payload,
+        Nonce:       nonceArgs[0],
+        NonceExpiry: nonceArgs[1],
+        Signature:   nonceArgs[2],
+        TxID:        stub.GetTxID(),
+        At:          now,
+    }
+}
+
+// Read care plan content from the transient map and validate it
+func readTransientCarePlan(stub shim.ChaincodeStubInterface, planID string) (*CarePlanContent, error) {
+    transient, err := stub.GetTransient()
+    if err != nil {
+        return nil, errors.New("Failed to read transient data")
+    }
+    contentBytes, ok := transient[carePlanTransientKey]
+    if !ok {
+        return nil, errors.New("Expected care plan in transient key " + carePlanTransientKey)
+    }
+    var content CarePlanContent
+    if err := json.Unmarshal(contentBytes, &content); err != nil {
+        return nil, errors.New("Invalid care plan in transient data")
+    }
+    if content.PlanID == "" {
+        content.PlanID = planID
+    }
+    if content.PlanID != planID {
+        return nil, errors.New("Care plan content ID does not match plan ID")
+    }
+    if err := validateCarePlanText("title", content.Title); err != nil {
+        return nil, err
+    }
+    if len(content.Goals) == 0 || len(content.Goals) > maxCarePlanItems {
+        return nil, fmt.Errorf("A care plan needs 1 to %d goals", maxCarePlanItems)
+    }
+    if len(content.Activities) == 0 || len(content.Activities) > maxCarePlanItems {
+        return nil, fmt.Errorf("A care plan needs 1 to %d activities", maxCarePlanItems)
+    }
+
+    goalIDs := make(map[string]bool, len(content.Goals))
+    for _, goal := range content.Goals {
+        if err := validateID("goal_id", goal.ID); err != nil {
+            return nil, err
+        }
+        if goalIDs[goal.ID] {
+            return nil, &ValidationError{Field: "goal_id", Reason: "duplicate " + goal.ID}
+        }
+        goalIDs[goal.ID] = true
+        if err := validateCarePlanText("goal_description", goal.Description); err != nil {
+            return nil, err
+        }
+    }
+    activityIDs := make(map[string]bool, len(content.Activities))
+    for _, activity := range content.Activities {
+        if err := validateID("activity_id", activity.ID); err != nil {
+            return nil, err
+        }
+        if activityIDs[activity.ID] {
+            return nil, &ValidationError{Field: "activity_id", Reason: "duplicate " + activity.ID}
+        }
+        activityIDs[activity.ID] = true
+        if err := validateCarePlanText("activity_description", activity.Description); err != nil {
+            return nil, err
+        }
+        if activity.GoalID != "" && !goalIDs[activity.GoalID] {
+            return nil, &ValidationError{Field: "goal_id", Reason: "activity " + activity.ID + " references an unknown goal"}
+        }
+    }
+    // The salt must come from the client so every endorser computes the same hash
+    if len(content.Salt) < minPHISaltLength {
+        return nil, fmt.Errorf("Care plan salt must be at least %d characters", minPHISaltLength)
+    }
+    return &content, nil
+}
+
+// Non-empty free text of bounded length
+func validateCarePlanText(field, value string) error {
+    if value == "" {
+        return &ValidationError{Field: field, Reason: "is required"}
+    }
+    if len(value) > maxCarePlanTextLength {
+        return &ValidationError{Field: field, Reason: fmt.Sprintf("exceeds %d characters", maxCarePlanTextLength)}
+    }
+    return nil
+}
+
+// Store plan content privately and point the plan at it. Activities carried
+// over from the previous version keep their progress.
+func putCarePlanContent(stub shim.ChaincodeStubInterface, plan *CarePlan, content *CarePlanContent) error {
+    collection, err := phiCollectionName(stub)
+    if err != nil {
+        return err
+    }
+    if plan.Collection != "" && plan.Collection != collection {
+        return errors.New("Care plan content is held by another organization")
+    }
+    contentJSON, err := json.Marshal(content)
+    if err != nil {
+        return errors.New("Failed to marshal care plan content JSON")
+    }
+    key, err := stub.CreateCompositeKey(carePlanContentType, []string{plan.ID})
+    if err != nil {
+        return errors.New("Failed to create care plan content key")
+    }
+    if err := stub.PutPrivateData(collection, key, contentJSON); err != nil {
+        return errors.New("Failed to store care plan content")
+    }
+
+    previous := make(map[string]CarePlanActivity, len(plan.Activities))
+    for _, activity := range plan.Activities {
+        previous[activity.ID] = activity
+    }
+    activities := make([]CarePlanActivity, 0, len(content.Activities))
+    for _, planned := range content.Activities {
+        activity, ok := previous[planned.ID]
+        if !ok {
+            activity = CarePlanActivity{ID: planned.ID, Status: activityStatusNotStarted}
+        }
+        activities = append(activities, activity)
+    }
+    goalIDs := make([]string, 0, len(content.Goals))
+    for _, goal := range content.Goals {
+        goalIDs = append(goalIDs, goal.ID)
+    }
+
+    plan.Collection = collection
+    plan.ContentHash = generateHash(string(contentJSON))
+    plan.GoalIDs = goalIDs
+    plan.Activities = activities
+    return nil
+}
+
+// Load a care plan by ID
+func readCarePlan(stub shim.ChaincodeStubInterface, planID string) (*CarePlan, string, error) {
+    key, err := stub.CreateCompositeKey(carePlanObjectType, []string{planID})
+    if err != nil {
+        return nil, "", errors.New("Failed to create care plan key")
+    }
+    planBytes, err := stub.GetState(key)
+    if err != nil {
+        return nil, "", errors.New("Error reading care plan")
+    }
+    if planBytes == nil {
+        return nil, key, nil
+    }
+    var plan CarePlan
+    if err := json.Unmarshal(planBytes, &plan); err != nil {
+        return nil, "", errors.New("Failed to unmarshal care plan JSON")
+    }
+    return &plan, key, nil
+}
+
+// Store a care plan and return its JSON
+func putCarePlan(stub shim.ChaincodeStubInterface, key string, plan *CarePlan) ([]byte, error) {
+    planJSON, err := json.Marshal(plan)
+    if err != nil {
+        return nil, errors.New("Failed to marshal care plan JSON")
+    }
+    if err := stub.PutState(key, planJSON); err != nil {
+        return nil, errors.New("Failed to store care plan")
+    }
+    return planJSON, nil
+}
+
+// Whether a user is on a plan's care team
+func onCareTeam(plan *CarePlan, userID string) bool {
+    for _, member := range plan.CareTeam {
+        if member.UserID == userID {
+            return true
+        }
+    }
+    return false
+}
+
+// Care plans are readable by their patient, their care team and admins
+func authorizeCarePlanRead(stub shim.ChaincodeStubInterface, plan *CarePlan) error {
+    if hasRole(stub, "admin") {
+        return nil
+    }
+    callerID, err := callerUserID(stub)
+    if err != nil {
+        return err
+    }
+    if callerID != plan.PatientID && !onCareTeam(plan, callerID) {
+        return errors.New("Access denied: not the patient or on the care team")
+    }
+    return nil
+}
+
+// Common path for every change to an existing plan: the caller must be a
+// doctor on the care team, the action must be allowed from the plan's
+// status, and the signed nonce is consumed. apply makes the change.
+func (t *PatientCareChaincode) transitionCarePlan(stub shim.ChaincodeStubInterface, function, action string, args []string, argCount int, apply func(plan *CarePlan, actorID string, now time.Time) (string, error)) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != argCount || nonceArgs == nil {
+        return shim.Error(fmt.Sprintf("Expected %d arguments followed by nonce, expiry, signature", argCount))
+    }
+    planID := args[0]
+    if err := validateID("care_plan_id", planID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := requireRole(stub, "doctor"); err != nil {
+        return shim.Error(err.Error())
+    }
+    actorID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    plan, key, err := readCarePlan(stub, planID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if plan == nil {
+        return shim.Error("Care plan not found")
+    }
+    if !onCareTeam(plan, actorID) {
+        return shim.Error("Only members of the care team can change this care plan")
+    }
+    to, ok := carePlanTransitions[action][plan.Status]
+    if !ok {
+        return shim.Error(fmt.Sprintf("Cannot %s a care plan that is %s", action, plan.Status))
+    }
+    if err := consumeNonce(stub, function, args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    detail, err := apply(plan, actorID, now)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    plan.History = append(plan.History, newSignedTransition(stub, action, plan.Status, to, detail, actorID, args, nonceArgs, now))
+    plan.Status = to
+    plan.UpdatedAt = now
+
+    planJSON, err := putCarePlan(stub, key, plan)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: carePlanEvents[action], ObjectID: planID, PatientID: plan.PatientID, ActorID: actorID, Status: plan.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(planJSON)
+}
+
+// Doctor-only: create an active care plan for a patient. Goals and
+// activities come in the transient map; the creator leads the care team.
+func (t *PatientCareChaincode) createCarePlan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 2 || nonceArgs == nil {
+        return shim.Error("Expected arguments: care plan ID, patient ID, nonce, expiry, signature")
+    }
+    planID, patientID := args[0], args[1]
+    if err := validateID("care_plan_id", planID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("patient_id", patientID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := requireRole(stub, "doctor"); err != nil {
+        return shim.Error(err.Error())
+    }
+    actorID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    content, err := readTransientCarePlan(stub, planID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    existing, key, err := readCarePlan(stub, planID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if existing != nil {
+        return shim.Error("Care plan already exists")
+    }
+    if err := consumeNonce(stub, "createCarePlan", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    plan := &CarePlan{
+        ID:        planID,
+        PatientID: patientID,
+        Status:    carePlanStatusActive,
+        Version:   1,
+        CareTeam:  []CareTeamMember{{UserID: actorID, Role: "lead", AssignedBy: actorID, AssignedAt: now}},
+        CreatedBy: actorID,
+        CreatedAt: now,
+        UpdatedAt: now,
+    }
+    if err := putCarePlanContent(stub, plan, content); err != nil {
+        return shim.Error(err.Error())
+    }
+    plan.History = []SignedTransition{newSignedTransition(stub, "create", "", carePlanStatusActive, "", actorID, args, nonceArgs, now)}
+
+    planJSON, err := putCarePlan(stub, key, plan)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "CarePlanCreated", ObjectID: planID, PatientID: patientID, ActorID: actorID, Status: plan.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(planJSON)
+}
+
+// Replace a plan's goals and activities with a new version from the
+// transient map; resumes a suspended plan
+func (t *PatientCareChaincode) reviseCarePlan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return t.transitionCarePlan(stub, "reviseCarePlan", "revise", args, 1, func(plan *CarePlan, actorID string, now time.Time) (string, error) {
+        content, err := readTransientCarePlan(stub, plan.ID)
+        if err != nil {
+            return "", err
+        }
+        if err := putCarePlanContent(stub, plan, content); err != nil {
+            return "", err
+        }
+        plan.Version++
+        return fmt.Sprintf("version %d", plan.Version), nil
+    })
+}
+
+// Add a member to a plan's care team
+func (t *PatientCareChaincode) assignCareTeamMember(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return t.transitionCarePlan(stub, "assignCareTeamMember", "assign", args, 3, func(plan *CarePlan, actorID string, now time.Time) (string, error) {
+        userID, role := args[1], args[2]
+        if err := validateID("user_id", userID); err != nil {
+            return "", err
+        }
+        if !careTeamRoles[role] {
+            return "", &ValidationError{Field: "role", Reason: "unknown care team role"}
+        }
+        if onCareTeam(plan, userID) {
+            return "", errors.New("User is already on the care team")
+        }
+        if len(plan.CareTeam) >= maxCareTeamSize {
+            return "", fmt.Errorf("Care team already has %d members", maxCareTeamSize)
+        }
+        plan.CareTeam = append(plan.CareTeam, CareTeamMember{UserID: userID, Role: role, AssignedBy: actorID, AssignedAt: now})
+        return userID + " as " + role, nil
+    })
+}
+
+// Record that a planned activity was carried out
+func (t *PatientCareChaincode) completeCarePlanActivity(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return t.transitionCarePlan(stub, "completeCarePlanActivity", "completeActivity", args, 2, func(plan *CarePlan, actorID string, now time.Time) (string, error) {
+        activityID := args[1]
+        if err := validateID("activity_id", activityID); err != nil {
+            return "", err
+        }
+        for i := range plan.Activities {
+            activity := &plan.Activities[i]
+            if activity.ID != activityID {
+                continue
+            }
+            if activity.Status == activityStatusCompleted {
+                return "", errors.New("Activity is already completed")
+            }
+            activity.Status = activityStatusCompleted
+            activity.CompletedBy = actorID
+            activity.CompletedAt = &now
+            return activityID, nil
+        }
+        return "", errors.New("Activity not found")
+    })
+}
+
+// Put an active plan on hold, with a reason code
+func (t *PatientCareChaincode) suspendCarePlan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return t.transitionCarePlan(stub, "suspendCarePlan", "suspend", args, 2, func(plan *CarePlan, actorID string, now time.Time) (string, error) {
+        if err := validateID("reason_code", args[1]); err != nil {
+            return "", err
+        }
+        return args[1], nil
+    })
+}
+
+// Reactivate a suspended plan
+func (t *PatientCareChaincode) resumeCarePlan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return t.transitionCarePlan(stub, "resumeCarePlan", "resume", args, 1, func(plan *CarePlan, actorID string, now time.Time) (string, error) {
+        return "", nil
+    })
+}
+
+// Close an active plan. Open activities are left as they are and show
+// what was not done.
+func (t *PatientCareChaincode) completeCarePlan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return t.transitionCarePlan(stub, "completeCarePlan", "complete", args, 1, func(plan *CarePlan, actorID string, now time.Time) (string, error) {
+        return "", nil
+    })
+}
+
+// Retrieve a care plan's channel state
+func (t *PatientCareChaincode) getCarePlan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: care plan ID")
+    }
+    planID := args[0]
+    if err := validateID("care_plan_id", planID); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    plan, key, err := readCarePlan(stub, planID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if plan == nil {
+        return shim.Error("Care plan not found")
+    }
+    if err := authorizeCarePlanRead(stub, plan); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    planBytes, err := stub.GetState(key)
+    if err != nil {
+        return shim.Error("Error reading care plan")
+    }
+    return shim.Success(planBytes)
+}
+
+// Retrieve a care plan's goals and activities from the private data
+// collection, checked against the hash anchored on the channel
+func (t *PatientCareChaincode) getCarePlanContent(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: care plan ID")
+    }
+    planID := args[0]
+    if err := validateID("care_plan_id", planID); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    plan, _, err := readCarePlan(stub, planID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if plan == nil {
+        return shim.Error("Care plan not found")
+    }
+    if err := authorizeCarePlanRead(stub, plan); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    key, err := stub.CreateCompositeKey(carePlanContentType, []string{planID})
+    if err != nil {
+        return shim.Error("Failed to create care plan content key")
+    }
+    contentBytes, err := stub.GetPrivateData(plan.Collection, key)
+    if err != nil || contentBytes == nil {
+        return shim.Error("Care plan content not found or not accessible to this organization")
+    }
+    if generateHash(string(contentBytes)) != plan.ContentHash {
+        return shim.Error("Care plan content does not match the hash anchored on the channel")
+    }
+    return shim.Success(contentBytes)
+}
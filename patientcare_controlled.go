@@ -0,0 +1,401 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Rules for a controlled drug, set by admins. Prescriptions for drug codes
+// without an entry are not controlled.
+type ControlledSubstance struct {
+    DrugCode        string    `json:"drug_code"`
+    SubstanceClass  string    `json:"substance_class"`
+    Schedule        string    `json:"schedule"`
+    MaxQuantity     int       `json:"max_quantity"`
+    MaxDaysSupply   int       `json:"max_days_supply"`
+    MaxRefills      int       `json:"max_refills"`
+    EarlyRefillDays int       `json:"early_refill_days"`
+    ValidityDays    int       `json:"validity_days"`
+    UpdatedBy       string    `json:"updated_by"`
+    UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// Controlled-substance schedules a prescriber is licensed for
+type PrescriberLicense struct {
+    PrescriberID   string    `json:"prescriber_id"`
+    LicenseNumber  string    `json:"license_number"`
+    Schedules      []string  `json:"schedules"`
+    ExpiresAt      time.Time `json:"expires_at"`
+    UpdatedBy      string    `json:"updated_by"`
+    UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// A patient's live prescriptions in one substance class
+type SubstanceHistory struct {
+    PatientID      string         `json:"patient_id"`
+    SubstanceClass string         `json:"substance_class"`
+    Prescribers    []string       `json:"prescribers"`
+    Prescriptions  []Prescription `json:"prescriptions"`
+}
+
+// Entry of a patient's substance index, which ties the patient to the
+// substance class and so lives in the prescriber org's private data
+// collection, one document per patient and class
+type substanceIndexEntry struct {
+    PrescriptionID string `json:"prescription_id"`
+    PrescriberID   string `json:"prescriber_id"`
+}
+
+const (
+    controlledSubstanceObjectType = "controlledSubstance"
+    prescriberLicenseObjectType   = "prescriberLicense"
+    patientSubstanceIndex         = "patientSubstance"
+    controlledSubstanceFlagEvent  = "ControlledSubstanceFlag"
+
+    flagMultiplePrescribers = "multiple-prescribers"
+)
+
+// Schedules of the Controlled Substances Act that can be prescribed
+var controlledSchedules = map[string]bool{"II": true, "III": true, "IV": true, "V": true}
+
+// Load the controlled-substance rules for a drug code; nil if uncontrolled
+func readControlledSubstance(stub shim.ChaincodeStubInterface, drugCode string) (*ControlledSubstance, string, error) {
+    key, err := stub.CreateCompositeKey(controlledSubstanceObjectType, []string{drugCode})
+    if err != nil {
+        return nil, "", errors.New("Failed to create controlled substance key")
+    }
+    ruleBytes, err := stub.GetState(key)
+    if err != nil {
+        return nil, "", errors.New("Error reading controlled substance rules")
+    }
+    if ruleBytes == nil {
+        return nil, key, nil
+    }
+    var rule ControlledSubstance
+    if err := json.Unmarshal(ruleBytes, &rule); err != nil {
+        return nil, "", errors.New("Failed to unmarshal controlled substance JSON")
+    }
+    return &rule, key, nil
+}
+
+// Load a prescriber's controlled-substance license; nil if none
+func readPrescriberLicense(stub shim.ChaincodeStubInterface, prescriberID string) (*PrescriberLicense, string, error) {
+    key, err := stub.CreateCompositeKey(prescriberLicenseObjectType, []string{prescriberID})
+    if err != nil {
+        return nil, "", errors.New("Failed to create prescriber license key")
+    }
+    licenseBytes, err := stub.GetState(key)
+    if err != nil {
+        return nil, "", errors.New("Error reading prescriber license")
+    }
+    if licenseBytes == nil {
+        return nil, key, nil
+    }
+    var license PrescriberLicense
+    if err := json.Unmarshal(licenseBytes, &license); err != nil {
+        return nil, "", errors.New("Failed to unmarshal prescriber license JSON")
+    }
+    return &license, key, nil
+}
+
+// Load a patient's private substance index for a class
+func readSubstanceIndex(stub shim.ChaincodeStubInterface, collection, patientID, substanceClass string) ([]substanceIndexEntry, string, error) {
+    key, err := stub.CreateCompositeKey(patientSubstanceIndex, []string{patientID, substanceClass})
+    if err != nil {
+        return nil, "", errors.New("Failed to create substance index key")
+    }
+    indexBytes, err := stub.GetPrivateData(collection, key)
+    if err != nil {
+        return nil, "", errors.New("Failed to read substance index")
+    }
+    entries := []substanceIndexEntry{}
+    if indexBytes == nil {
+        return entries, key, nil
+    }
+    if err := json.Unmarshal(indexBytes, &entries); err != nil {
+        return nil, "", errors.New("Failed to unmarshal substance index JSON")
+    }
+    return entries, key, nil
+}
+
+// Live prescriptions for a patient in a substance class, as far as the
+// caller's org knows them
+func readSubstanceHistory(stub shim.ChaincodeStubInterface, patientID, substanceClass string, now time.Time) (*SubstanceHistory, error) {
+    collection, err := phiCollectionName(stub)
+    if err != nil {
+        return nil, err
+    }
+    entries, _, err := readSubstanceIndex(stub, collection, patientID, substanceClass)
+    if err != nil {
+        return nil, err
+    }
+
+    history := &SubstanceHistory{PatientID: patientID, SubstanceClass: substanceClass, Prescribers: []string{}, Prescriptions: []Prescription{}}
+    prescribers := map[string]bool{}
+    for _, entry := range entries {
+        prescription, _, err := readPrescription(stub, entry.PrescriptionID)
+        if err != nil {
+            return nil, err
+        }
+        if prescription == nil || prescription.Status == prescriptionStatusCancelled || !now.Before(prescription.ExpiresAt) {
+            continue
+        }
+        history.Prescriptions = append(history.Prescriptions, *prescription)
+        if !prescribers[prescription.PrescriberID] {
+            prescribers[prescription.PrescriberID] = true
+            history.Prescribers = append(history.Prescribers, prescription.PrescriberID)
+        }
+    }
+    sort.Strings(history.Prescribers)
+    return history, nil
+}
+
+// Enforce controlled-substance rules on a new prescription: the prescriber's
+// license must cover the schedule, quantity, days' supply and refills must
+// be within limits, and a patient already getting the class from another
+// prescriber is flagged
+func applyControlledSubstanceRules(stub shim.ChaincodeStubInterface, prescription *Prescription, details *PrescriptionDetails, now time.Time) error {
+    rule, _, err := readControlledSubstance(stub, details.DrugCode)
+    if err != nil || rule == nil {
+        return err
+    }
+
+    license, _, err := readPrescriberLicense(stub, prescription.PrescriberID)
+    if err != nil {
+        return err
+    }
+    if license == nil || !now.Before(license.ExpiresAt) {
+        return errors.New("Prescriber has no current controlled-substance license")
+    }
+    licensed := false
+    for _, schedule := range license.Schedules {
+        licensed = licensed || schedule == rule.Schedule
+    }
+    if !licensed {
+        return fmt.Errorf("Prescriber is not licensed for schedule %s substances", rule.Schedule)
+    }
+    if details.Quantity > rule.MaxQuantity {
+        return fmt.Errorf("Quantity exceeds the limit of %d for this substance", rule.MaxQuantity)
+    }
+    if details.DaysSupply > rule.MaxDaysSupply {
+        return fmt.Errorf("Days' supply exceeds the limit of %d for this substance", rule.MaxDaysSupply)
+    }
+    if prescription.Refills > rule.MaxRefills {
+        return fmt.Errorf("Refills exceed the limit of %d for this substance", rule.MaxRefills)
+    }
+
+    if rule.ValidityDays > 0 {
+        prescription.ExpiresAt = prescription.IssuedAt.AddDate(0, 0, rule.ValidityDays)
+    }
+
+    history, err := readSubstanceHistory(stub, details.PatientID, rule.SubstanceClass, now)
+    if err != nil {
+        return err
+    }
+    for _, prescriberID := range history.Prescribers {
+        if prescriberID == prescription.PrescriberID {
+            continue
+        }
+        prescription.Flags = append(prescription.Flags, flagMultiplePrescribers)
+        break
+    }
+
+    entries, indexKey, err := readSubstanceIndex(stub, prescription.Collection, details.PatientID, rule.SubstanceClass)
+    if err != nil {
+        return err
+    }
+    entries = append(entries, substanceIndexEntry{PrescriptionID: prescription.ID, PrescriberID: prescription.PrescriberID})
+    indexJSON, err := json.Marshal(entries)
+    if err != nil {
+        return errors.New("Failed to marshal substance index JSON")
+    }
+    return stub.PutPrivateData(prescription.Collection, indexKey, indexJSON)
+}
+
+// Refuse a controlled refill before the previous fill's supply is nearly
+// used up; the drug's early refill days set how early is allowed
+func checkControlledRefill(stub shim.ChaincodeStubInterface, prescription *Prescription, details *PrescriptionDetails, now time.Time) error {
+    if prescription.LastFilledAt == nil {
+        return nil
+    }
+    rule, _, err := readControlledSubstance(stub, details.DrugCode)
+    if err != nil || rule == nil {
+        return err
+    }
+    earliest := prescription.LastFilledAt.AddDate(0, 0, details.DaysSupply-rule.EarlyRefillDays)
+    if now.Before(earliest) {
+        return fmt.Errorf("Refill is too early; next fill allowed from %s", earliest.Format(time.RFC3339))
+    }
+    return nil
+}
+
+// Admin-only: set the rules for a controlled drug code
+func (t *PatientCareChaincode) setControlledSubstance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 8 || nonceArgs == nil {
+        return shim.Error("Expected arguments: drug code, substance class, schedule, max quantity, max days supply, max refills, early refill days, validity days, nonce, expiry, signature")
+    }
+    drugCode, substanceClass, schedule := args[0], args[1], args[2]
+    if err := validateID("drug_code", drugCode); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("substance_class", substanceClass); err != nil {
+        return shim.Error(err.Error())
+    }
+    if !controlledSchedules[schedule] {
+        return shim.Error((&ValidationError{Field: "schedule", Reason: "must be II, III, IV or V"}).Error())
+    }
+    rule := ControlledSubstance{DrugCode: drugCode, SubstanceClass: substanceClass, Schedule: schedule}
+    var err error
+    if rule.MaxQuantity, err = parseBoundedInt("max_quantity", args[3], 1, maxQuantity); err != nil {
+        return shim.Error(err.Error())
+    }
+    if rule.MaxDaysSupply, err = parseBoundedInt("max_days_supply", args[4], 1, maxDaysSupply); err != nil {
+        return shim.Error(err.Error())
+    }
+    if rule.MaxRefills, err = parseBoundedInt("max_refills", args[5], 0, maxRefills); err != nil {
+        return shim.Error(err.Error())
+    }
+    if rule.EarlyRefillDays, err = parseBoundedInt("early_refill_days", args[6], 0, rule.MaxDaysSupply); err != nil {
+        return shim.Error(err.Error())
+    }
+    if rule.ValidityDays, err = parseBoundedInt("validity_days", args[7], 0, int(prescriptionValidity/(24*time.Hour))); err != nil {
+        return shim.Error(err.Error())
+    }
+    // Schedule II drugs cannot be refilled
+    if schedule == "II" && rule.MaxRefills != 0 {
+        return shim.Error("Schedule II substances cannot have refills")
+    }
+    if err := requireRole(stub, "admin"); err != nil {
+        return shim.Error(err.Error())
+    }
+    if rule.UpdatedBy, err = callerUserID(stub); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := consumeNonce(stub, "setControlledSubstance", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    if rule.UpdatedAt, err = ledgerNow(stub); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    key, err := stub.CreateCompositeKey(controlledSubstanceObjectType, []string{drugCode})
+    if err != nil {
+        return shim.Error("Failed to create controlled substance key")
+    }
+    ruleJSON, err := json.Marshal(rule)
+    if err != nil {
+        return shim.Error("Failed to marshal controlled substance JSON")
+    }
+    if err := stub.PutState(key, ruleJSON); err != nil {
+        return shim.Error("Failed to store controlled substance rules")
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "ControlledSubstanceUpdated", ObjectID: drugCode, ActorID: rule.UpdatedBy}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(ruleJSON)
+}
+
+// Admin-only: set the controlled-substance schedules a prescriber may
+// prescribe, as a comma-separated list such as "II,III"
+func (t *PatientCareChaincode) setPrescriberLicense(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 4 || nonceArgs == nil {
+        return shim.Error("Expected arguments: prescriber ID, license number, schedules, expires at (unix seconds), nonce, expiry, signature")
+    }
+    prescriberID, licenseNumber := args[0], args[1]
+    if err := validateID("prescriber_id", prescriberID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("license_number", licenseNumber); err != nil {
+        return shim.Error(err.Error())
+    }
+    schedules := strings.Split(args[2], ",")
+    for _, schedule := range schedules {
+        if !controlledSchedules[schedule] {
+            return shim.Error((&ValidationError{Field: "schedules", Reason: "must list schedules II, III, IV or V"}).Error())
+        }
+    }
+    expiresSeconds, err := strconv.ParseInt(args[3], 10, 64)
+    if err != nil {
+        return shim.Error((&ValidationError{Field: "expires_at", Reason: "must be unix seconds"}).Error())
+    }
+    if err := requireRole(stub, "admin"); err != nil {
+        return shim.Error(err.Error())
+    }
+    updatedBy, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := consumeNonce(stub, "setPrescriberLicense", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    license := PrescriberLicense{
+        PrescriberID:  prescriberID,
+        LicenseNumber: licenseNumber,
+        Schedules:     schedules,
+        ExpiresAt:     time.Unix(expiresSeconds, 0).UTC(),
+        UpdatedBy:     updatedBy,
+        UpdatedAt:     now,
+    }
+    key, err := stub.CreateCompositeKey(prescriberLicenseObjectType, []string{prescriberID})
+    if err != nil {
+        return shim.Error("Failed to create prescriber license key")
+    }
+    licenseJSON, err := json.Marshal(license)
+    if err != nil {
+        return shim.Error("Failed to marshal prescriber license JSON")
+    }
+    if err := stub.PutState(key, licenseJSON); err != nil {
+        return shim.Error("Failed to store prescriber license")
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "PrescriberLicenseUpdated", ObjectID: prescriberID, ActorID: updatedBy}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(licenseJSON)
+}
+
+// Cross-prescriber lookup: a patient's live prescriptions in a substance
+// class and everyone who wrote them, from the caller org's private index.
+// Doctors, pharmacists and admins only.
+func (t *PatientCareChaincode) getControlledSubstanceHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error("Expected arguments: patient ID, substance class")
+    }
+    patientID, substanceClass := args[0], args[1]
+    if err := validateID("patient_id", patientID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("substance_class", substanceClass); err != nil {
+        return shim.Error(err.Error())
+    }
+    if !hasRole(stub, "doctor") && !hasRole(stub, "pharmacist") && !hasRole(stub, "admin") {
+        return shim.Error("Only doctors, pharmacists and admins can look up substance history")
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    history, err := readSubstanceHistory(stub, patientID, substanceClass, now)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    historyJSON, err := json.Marshal(history)
+    if err != nil {
+        return shim.Error("Failed to marshal substance history JSON")
+    }
+    return shim.Success(historyJSON)
+}
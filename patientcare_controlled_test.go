@@ -0,0 +1,149 @@
+package main
+
+import (
+    "encoding/json"
+    "strconv"
+    "testing"
+    "time"
+)
+
+// controlOxycodone makes OXY5 a schedule II opioid limited to 90 tablets,
+// 30 days and no refills, and licenses doctors for schedules II and III
+func (l *testLedger) controlOxycodone(admin *testClient, doctors ...*testClient) {
+    l.t.Helper()
+    l.as(admin).mustInvoke("setControlledSubstance", "OXY5", "opioid", "II", "90", "30", "0", "2", "30")
+    expires := strconv.FormatInt(l.now.AddDate(1, 0, 0).Unix(), 10)
+    for _, doctor := range doctors {
+        l.as(admin).mustInvoke("setPrescriberLicense", doctor.userID, "LIC-"+doctor.userID, "II,III", expires)
+    }
+}
+
+// issueControlled has doctor issue OXY5 with the given limits and returns
+// the issued prescription
+func (l *testLedger) issueControlled(doctor *testClient, prescriptionID, patientID string, quantity, daysSupply, refills int) (*Prescription, PrescriptionDetails) {
+    l.t.Helper()
+    details := l.prescriptionDetails(doctor, prescriptionID, patientID, "OXY5", quantity, daysSupply, refills)
+    payload := l.as(doctor).withPrescription(details).mustInvoke("issuePrescription", prescriptionID, "did:mediNet:"+doctor.userID, strconv.Itoa(refills))
+    var prescription Prescription
+    if err := json.Unmarshal(payload, &prescription); err != nil {
+        l.t.Fatal(err)
+    }
+    return &prescription, details
+}
+
+// failControlled expects issuing OXY5 to fail with want
+func (l *testLedger) failControlled(want string, doctor *testClient, quantity, daysSupply, refills int) {
+    l.t.Helper()
+    details := l.prescriptionDetails(doctor, "rx-bad", "p1", "OXY5", quantity, daysSupply, refills)
+    l.as(doctor).withPrescription(details).mustFail(want, "issuePrescription", "rx-bad", "did:mediNet:"+doctor.userID, strconv.Itoa(refills))
+}
+
+func TestControlledSubstanceNeedsLicenseForSchedule(t *testing.T) {
+    ledger := newTestLedger(t)
+    admin := ledger.client("admin1", "admin")
+    doctor := ledger.client("d1", "doctor")
+    ledger.controlOxycodone(admin)
+
+    ledger.failControlled("no current controlled-substance license", doctor, 30, 10, 0)
+
+    expires := strconv.FormatInt(ledger.now.AddDate(1, 0, 0).Unix(), 10)
+    ledger.as(admin).mustInvoke("setPrescriberLicense", "d1", "LIC-d1", "III,IV", expires)
+    ledger.failControlled("not licensed for schedule II", doctor, 30, 10, 0)
+
+    ledger.as(admin).mustInvoke("setPrescriberLicense", "d1", "LIC-d1", "II", strconv.FormatInt(ledger.now.Add(time.Hour).Unix(), 10))
+    ledger.advance(time.Hour)
+    ledger.failControlled("no current controlled-substance license", doctor, 30, 10, 0)
+}
+
+func TestControlledSubstanceLimits(t *testing.T) {
+    ledger := newTestLedger(t)
+    admin := ledger.client("admin1", "admin")
+    doctor := ledger.client("d1", "doctor")
+    ledger.controlOxycodone(admin, doctor)
+
+    tests := []struct {
+        name                          string
+        quantity, daysSupply, refills int
+        want                          string
+    }{
+        {"quantity", 91, 30, 0, "Quantity exceeds the limit of 90"},
+        {"days supply", 90, 31, 0, "Days' supply exceeds the limit of 30"},
+        {"refills", 90, 30, 1, "Refills exceed the limit of 0"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            ledger.failControlled(test.want, doctor, test.quantity, test.daysSupply, test.refills)
+        })
+    }
+
+    prescription, _ := ledger.issueControlled(doctor, "rx1", "p1", 90, 30, 0)
+    if want := prescription.IssuedAt.AddDate(0, 0, 30); !prescription.ExpiresAt.Equal(want) {
+        t.Fatalf("expires %s, want the substance's 30 day validity %s", prescription.ExpiresAt, want)
+    }
+}
+
+func TestScheduleIISubstancesCannotHaveRefills(t *testing.T) {
+    ledger := newTestLedger(t)
+    ledger.as(ledger.client("admin1", "admin"))
+    ledger.mustFail("Schedule II substances cannot have refills", "setControlledSubstance", "OXY5", "opioid", "II", "90", "30", "1", "2", "30")
+    ledger.mustInvoke("setControlledSubstance", "COD30", "opioid", "III", "90", "30", "1", "2", "30")
+}
+
+func TestControlledRefillWaitsForSupply(t *testing.T) {
+    ledger := newTestLedger(t)
+    admin := ledger.client("admin1", "admin")
+    doctor := ledger.client("d1", "doctor")
+    pharmacist := ledger.client("ph1", "pharmacist")
+    ledger.as(admin).mustInvoke("setControlledSubstance", "COD30", "opioid", "III", "90", "30", "2", "2", "180")
+    ledger.as(admin).mustInvoke("setPrescriberLicense", "d1", "LIC-d1", "III", strconv.FormatInt(ledger.now.AddDate(1, 0, 0).Unix(), 10))
+
+    details := ledger.prescriptionDetails(doctor, "rx1", "p1", "COD30", 60, 30, 2)
+    ledger.as(doctor).withPrescription(details).mustInvoke("issuePrescription", "rx1", "did:mediNet:d1", "2")
+    ledger.dispense(pharmacist, details, 1)
+
+    // 30 days' supply with 2 early refill days: the refill opens on day 28
+    ledger.advance(28*24*time.Hour - time.Minute)
+    ledger.withPrescription(details).mustFail("Refill is too early", "dispensePrescription", "rx1", "2")
+    ledger.advance(time.Minute)
+    if prescription := ledger.dispense(pharmacist, details, 2); prescription.FillCount != 2 {
+        t.Fatalf("fill count %d, want 2", prescription.FillCount)
+    }
+}
+
+func TestControlledSubstanceFlagsMultiplePrescribers(t *testing.T) {
+    ledger := newTestLedger(t)
+    admin := ledger.client("admin1", "admin")
+    first := ledger.client("d1", "doctor")
+    second := ledger.client("d2", "doctor")
+    ledger.controlOxycodone(admin, first, second)
+
+    prescription, _ := ledger.issueControlled(first, "rx1", "p1", 30, 10, 0)
+    prescription, _ = ledger.issueControlled(first, "rx2", "p1", 30, 10, 0)
+    if len(prescription.Flags) != 0 || ledger.lastEvent != "PrescriptionIssued" {
+        t.Fatalf("same prescriber flagged: %v, event %q", prescription.Flags, ledger.lastEvent)
+    }
+    prescription, _ = ledger.issueControlled(second, "rx3", "p2", 30, 10, 0)
+    if len(prescription.Flags) != 0 {
+        t.Fatalf("another patient's prescription flagged: %v", prescription.Flags)
+    }
+
+    prescription, _ = ledger.issueControlled(second, "rx4", "p1", 30, 10, 0)
+    if len(prescription.Flags) != 1 || prescription.Flags[0] != flagMultiplePrescribers {
+        t.Fatalf("flags %v, want %s", prescription.Flags, flagMultiplePrescribers)
+    }
+    if event := ledger.lastLedgerEvent(); event.Type != controlledSubstanceFlagEvent || event.PatientID != "" {
+        t.Fatalf("event %+v, want %s without a patient", event, controlledSubstanceFlagEvent)
+    }
+
+    // The index tying the patient to the class never reaches the channel
+    if n := ledger.stateKeys(patientSubstanceIndex); n != 0 {
+        t.Fatalf("%d substance index keys on the channel", n)
+    }
+    var history SubstanceHistory
+    if err := json.Unmarshal(ledger.as(first).mustQuery("getControlledSubstanceHistory", "p1", "opioid"), &history); err != nil {
+        t.Fatal(err)
+    }
+    if len(history.Prescriptions) != 3 || len(history.Prescribers) != 2 {
+        t.Fatalf("history has %d prescriptions from %v, want 3 from d1 and d2", len(history.Prescriptions), history.Prescribers)
+    }
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "strings"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Wearable bound to one patient. The device holds the private key of its
+// DID, registered by the patient in the identity chaincode, and signs
+// every reading with it.
+type Device struct {
+    ID             string     `json:"id"`
+    DID            string     `json:"did"`
+    PublicKey      string     `json:"public_key"`
+    PatientID      string     `json:"patient_id"`
+    Status         string     `json:"status"`
+    RegisteredBy   string     `json:"registered_by"`
+    RegisteredAt   time.Time  `json:"registered_at"`
+    RevokedBy      string     `json:"revoked_by,omitempty"`
+    RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+    RevokeReason   string     `json:"revoke_reason,omitempty"`
+}
+
+const (
+    deviceObjectType    = "device"
+    deviceDIDObjectType = "deviceDID"
+    patientDeviceIndex  = "patientDevice"
+
+    deviceActive  = "active"
+    deviceRevoked = "revoked"
+)
+
+// Load a device by ID
+func readDevice(stub shim.ChaincodeStubInterface, deviceID string) (*Device, string, error) {
+    key, err := stub.CreateCompositeKey(deviceObjectType, []string{deviceID})
+    if err != nil {
+        return nil, "", errors.New("Failed to create device key")
+    }
+    deviceBytes, err := stub.GetState(key)
+    if err != nil {
+        return nil, "", errors.New("Error reading device")
+    }
+    if deviceBytes == nil {
+        return nil, key, nil
+    }
+    var device Device
+    if err := json.Unmarshal(deviceBytes, &device); err != nil {
+        return nil, "", errors.New("Failed to unmarshal device JSON")
+    }
+    return &device, key, nil
+}
+
+// Check a device's signature over a reading and return the reading digest.
+// The reading must fall inside the device's registration, allowing for clock
+// skew at the start; readings after revocation are never trusted.
+func verifyDeviceReading(device *Device, reading *TelemetryReading) ([]byte, error) {
+    if reading.DeviceID != device.ID || device.PatientID != reading.PatientID {
+        return nil, errors.New("Device is not registered to this patient")
+    }
+    recordedAt := reading.RecordedAt
+    if device.Status != deviceActive && (device.RevokedAt == nil || !recordedAt.Before(*device.RevokedAt)) {
+        return nil, errors.New("Device has been deregistered")
+    }
+    if recordedAt.Before(device.RegisteredAt.Add(-maxTelemetryClockSkew)) {
+        return nil, errors.New("Reading predates the device registration")
+    }
+    digest, err := telemetryReadingDigest(*reading)
+    if err != nil {
+        return nil, err
+    }
+    if err := verifyP256Signature("device", device.PublicKey, digest, "device_signature", reading.Signature); err != nil {
+        return nil, err
+    }
+    return digest, nil
+}
+
+// Patient or admin: bind a device DID and its public key to a patient
+func (t *PatientCareChaincode) registerDevice(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 4 || nonceArgs == nil {
+        return shim.Error("Expected arguments: device ID, device DID, public key, patient ID, nonce, expiry, signature")
+    }
+    deviceID, didID, publicKey, patientID := args[0], args[1], strings.ToLower(args[2]), args[3]
+    if err := validateID("device_id", deviceID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("patient_id", patientID); err != nil {
+        return shim.Error(err.Error())
+    }
+    callerID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if callerID != patientID && !hasRole(stub, "admin") {
+        return shim.Error("Access denied: only the patient or an admin can register a device")
+    }
+
+    // The device DID belongs to the patient and carries the same key
+    did, err := resolveDID(stub, didID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if did.Owner != patientID {
+        return shim.Error("Device DID is not owned by the patient")
+    }
+    if did.PublicKey != publicKey {
+        return shim.Error("Public key does not match the device DID")
+    }
+
+    device, key, err := readDevice(stub, deviceID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if device != nil {
+        return shim.Error("Device is already registered")
+    }
+    didKey, err := stub.CreateCompositeKey(deviceDIDObjectType, []string{didID})
+    if err != nil {
+        return shim.Error("Failed to create device DID key")
+    }
+    boundDevice, err := stub.GetState(didKey)
+    if err != nil {
+        return shim.Error("Error reading device DID")
+    }
+    if boundDevice != nil {
+        return shim.Error("DID is already bound to device " + string(boundDevice))
+    }
+    if err := consumeNonce(stub, "registerDevice", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    device = &Device{
+        ID:           deviceID,
+        DID:          didID,
+        PublicKey:    publicKey,
+        PatientID:    patientID,
+        Status:       deviceActive,
+        RegisteredBy: callerID,
+        RegisteredAt: now,
+    }
+    deviceJSON, err := json.Marshal(device)
+    if err != nil {
+        return shim.Error("Failed to marshal device JSON")
+    }
+    if err := stub.PutState(key, deviceJSON); err != nil {
+        return shim.Error("Failed to store device")
+    }
+    if err := stub.PutState(didKey, []byte(deviceID)); err != nil {
+        return shim.Error("Failed to store device DID")
+    }
+    indexKey, err := stub.CreateCompositeKey(patientDeviceIndex, []string{patientID, deviceID})
+    if err != nil {
+        return shim.Error("Failed to create patient device index key")
+    }
+    if err := stub.PutState(indexKey, []byte{0x00}); err != nil {
+        return shim.Error("Failed to store patient device index")
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "DeviceRegistered", ObjectID: deviceID, PatientID: patientID, ActorID: callerID, Status: device.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(deviceJSON)
+}
+
+// Patient or admin: revoke a device. Readings it signed before revocation
+// stay verifiable; later ones and new telemetry anchors are refused.
+func (t *PatientCareChaincode) deregisterDevice(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 2 || nonceArgs == nil {
+        return shim.Error("Expected arguments: device ID, reason, nonce, expiry, signature")
+    }
+    deviceID, reason := args[0], args[1]
+    if err := validateID("device_id", deviceID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if reason == "" || len(reason) > 500 {
+        return shim.Error((&ValidationError{Field: "reason", Reason: "must be 1-500 characters"}).Error())
+    }
+    device, key, err := readDevice(stub, deviceID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if device == nil {
+        return shim.Error("Device not found")
+    }
+    callerID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if callerID != device.PatientID && !hasRole(stub, "admin") {
+        return shim.Error("Access denied: only the patient or an admin can deregister a device")
+    }
+    if device.Status != deviceActive {
+        return shim.Error("Device is already deregistered")
+    }
+    if err := consumeNonce(stub, "deregisterDevice", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    device.Status = deviceRevoked
+    device.RevokedBy = callerID
+    device.RevokedAt = &now
+    device.RevokeReason = reason
+    deviceJSON, err := json.Marshal(device)
+    if err != nil {
+        return shim.Error("Failed to marshal device JSON")
+    }
+    if err := stub.PutState(key, deviceJSON); err != nil {
+        return shim.Error("Failed to store device")
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "DeviceDeregistered", ObjectID: deviceID, PatientID: device.PatientID, ActorID: callerID, Status: device.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(deviceJSON)
+}
+
+// Retrieve a device. Its public key and status are what the ingestion
+// service checks reading signatures against.
+func (t *PatientCareChaincode) getDevice(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: device ID")
+    }
+    if err := validateID("device_id", args[0]); err != nil {
+        return shim.Error(err.Error())
+    }
+    device, _, err := readDevice(stub, args[0])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if device == nil {
+        return shim.Error("Device not found")
+    }
+    if !hasRole(stub, "admin") && !hasRole(stub, "doctor") && !hasRole(stub, "telemetry") {
+        callerID, err := callerUserID(stub)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if callerID != device.PatientID {
+            return shim.Error("Access denied: not the patient")
+        }
+    }
+    deviceJSON, err := json.Marshal(device)
+    if err != nil {
+        return shim.Error("Failed to marshal device JSON")
+    }
+
+    return shim.Success(deviceJSON)
+}
+
+// Check one reading taken from a telemetry batch: that it is in the
+// anchored batch, given its leaf data and inclusion proof, and that its
+// device signed it while registered
+func (t *PatientCareChaincode) verifySignedReading(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return shim.Error("Expected arguments: batch ID, reading, reading proof")
+    }
+    batchID := args[0]
+    if err := validateID("batch_id", batchID); err != nil {
+        return shim.Error(err.Error())
+    }
+    batch, _, err := readTelemetryBatch(stub, batchID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if batch == nil {
+        return shim.Error("Telemetry batch not found")
+    }
+    reading, _, err := readingInBatch([]byte(args[1]), args[2], batch.ReadingCount, batch.MerkleRoot)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if reading.PatientID != batch.PatientID || reading.DeviceID != batch.DeviceID {
+        return shim.Error("Reading is from another patient device")
+    }
+    device, _, err := readDevice(stub, batch.DeviceID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if device == nil {
+        return shim.Error("Device not found")
+    }
+    if _, err := verifyDeviceReading(device, reading); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success([]byte("Reading is in the batch and its signature is valid"))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "strings"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Name of the identity chaincode holding DIDs, on the same channel
+const identityChaincodeName = "identity"
+
+// The parts of an identity chaincode DID needed to check signatures
+type ResolvedDID struct {
+    ID             string `json:"id"`
+    Owner          string `json:"owner"`
+    PublicKey      string `json:"public_key"`
+    Revoked        bool   `json:"revoked"`
+}
+
+// Look a DID up in the identity chaincode. getDID answers with a message
+// line followed by the DID JSON.
+func resolveDID(stub shim.ChaincodeStubInterface, didID string) (*ResolvedDID, error) {
+    if !strings.HasPrefix(didID, "did:") || len(didID) > 128 {
+        return nil, &ValidationError{Field: "did", Reason: "must be a did: URI of at most 128 characters"}
+    }
+    response := stub.InvokeChaincode(identityChaincodeName, [][]byte{[]byte("getDID"), []byte(didID)}, "")
+    if response.Status != shim.OK {
+        return nil, errors.New("DID not found")
+    }
+    payload := string(response.Payload)
+    didJSON := payload[strings.LastIndex(payload, "\n")+1:]
+    var did ResolvedDID
+    if err := json.Unmarshal([]byte(didJSON), &did); err != nil || did.ID != didID {
+        return nil, errors.New("Invalid DID document from identity chaincode")
+    }
+    if did.Revoked {
+        return nil, errors.New("DID has been revoked")
+    }
+    return &did, nil
+}
+
+// Resolve a DID and check that the caller owns it
+func resolveCallerDID(stub shim.ChaincodeStubInterface, didID string) (*ResolvedDID, error) {
+    did, err := resolveDID(stub, didID)
+    if err != nil {
+        return nil, err
+    }
+    callerID, err := callerUserID(stub)
+    if err != nil {
+        return nil, err
+    }
+    if did.Owner != callerID {
+        return nil, errors.New("DID is not owned by the caller")
+    }
+    return did, nil
+}
+
+// Check a hex ASN.1 ECDSA signature over digest against a DID's P-256 key
+func verifyDIDSignature(did *ResolvedDID, digest []byte, signature string) error {
+    return verifyP256Signature("DID", did.PublicKey, digest, "did_signature", signature)
+}
+
+// Check a hex ASN.1 ECDSA signature over digest against a hex-encoded
+// uncompressed P-256 public key; label names the key owner in errors
+func verifyP256Signature(label, publicKey string, digest []byte, field, signature string) error {
+    publicKeyBytes, err := hex.DecodeString(publicKey)
+    if err != nil {
+        return errors.New(label + " public key is not hex-encoded")
+    }
+    x, y := elliptic.Unmarshal(elliptic.P256(), publicKeyBytes)
+    if x == nil {
+        return errors.New(label + " public key is not an uncompressed P-256 key")
+    }
+    signatureBytes, err := hex.DecodeString(signature)
+    if err != nil {
+        return &ValidationError{Field: field, Reason: "must be hex-encoded"}
+    }
+    if !ecdsa.VerifyASN1(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, digest, signatureBytes) {
+        return errors.New("Invalid " + label + " signature")
+    }
+    return nil
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// What happened in a visit. An encounter opens when its appointment is
+// checked in and shares the appointment's ID. The doctor links the notes,
+// orders and prescriptions made during the visit and closes it with the
+// diagnosis codes, which are PHI and stay in the org's private data
+// collection as EncounterDiagnoses; the channel holds their hash.
+type Encounter struct {
+    ID             string               `json:"id"`
+    AppointmentID  string               `json:"appointment_id"`
+    PatientID      string               `json:"patient_id"`
+    DoctorID       string               `json:"doctor_id"`
+    Status         string               `json:"status"`
+    References     []EncounterReference `json:"references"`
+    DiagnosisHash  string               `json:"diagnosis_hash,omitempty"`
+    DiagnosisCount int                  `json:"diagnosis_count,omitempty"`
+    Collection     string               `json:"collection,omitempty"`
+    OpenedAt       time.Time            `json:"opened_at"`
+    Closed         *SignedTransition    `json:"closed,omitempty"`
+}
+
+// Link from an encounter to something made during it
+type EncounterReference struct {
+    Kind           string    `json:"kind"`
+    ReferenceID    string    `json:"reference_id"`
+    AddedBy        string    `json:"added_by"`
+    AddedAt        time.Time `json:"added_at"`
+    TxID           string    `json:"tx_id"`
+}
+
+// Private diagnosis codes of a closed encounter, passed in the transient map
+type EncounterDiagnoses struct {
+    EncounterID    string   `json:"encounter_id"`
+    Codes          []string `json:"codes"`
+    Salt           string   `json:"salt"`
+}
+
+const (
+    encounterObjectType    = "encounter"
+    encounterDiagnosesType = "encounterDiagnoses"
+    encounterTransientKey  = "encounter_diagnoses"
+    encounterClosedEvent   = "EncounterClosed"
+
+    encounterOpen   = "open"
+    encounterClosed = "closed"
+
+    maxEncounterReferences = 100
+    maxEncounterDiagnoses  = 50
+)
+
+// Kinds of reference an encounter can hold
+var encounterReferenceKinds = map[string]bool{"note": true, "labOrder": true, "referral": true, "prescription": true}
+
+// Load an encounter by ID
+func readEncounter(stub shim.ChaincodeStubInterface, encounterID string) (*Encounter, string, error) {
+    key, err := stub.CreateCompositeKey(encounterObjectType, []string{encounterID})
+    if err != nil {
+        return nil, "", errors.New("Failed to create encounter key")
+    }
+    encounterBytes, err := stub.GetState(key)
+    if err != nil {
+        return nil, "", errors.New("Error reading encounter")
+    }
+    if encounterBytes == nil {
+        return nil, key, nil
+    }
+    var encounter Encounter
+    if err := json.Unmarshal(encounterBytes, &encounter); err != nil {
+        return nil, "", errors.New("Failed to unmarshal encounter JSON")
+    }
+    return &encounter, key, nil
+}
+
+// Store an encounter under its key
+func putEncounter(stub shim.ChaincodeStubInterface, key string, encounter *Encounter) ([]byte, error) {
+    encounterJSON, err := json.Marshal(encounter)
+    if err != nil {
+        return nil, errors.New("Failed to marshal encounter JSON")
+    }
+    if err := stub.PutState(key, encounterJSON); err != nil {
+        return nil, errors.New("Failed to store encounter")
+    }
+    return encounterJSON, nil
+}
+
+// Open the encounter for an appointment being checked in
+func openEncounter(stub shim.ChaincodeStubInterface, appointment *Appointment, now time.Time) error {
+    existing, key, err := readEncounter(stub, appointment.ID)
+    if err != nil {
+        return err
+    }
+    if existing != nil {
+        return errors.New("Encounter already exists for this appointment")
+    }
+    encounter := &Encounter{
+        ID:            appointment.ID,
+        AppointmentID: appointment.ID,
+        PatientID:     appointment.PatientID,
+        DoctorID:      appointment.DoctorID,
+        Status:        encounterOpen,
+        References:    []EncounterReference{},
+        OpenedAt:      now,
+    }
+    _, err = putEncounter(stub, key, encounter)
+    return err
+}
+
+// Patient ID of the item a reference points at, checking it exists
+func encounterReferencePatient(stub shim.ChaincodeStubInterface, kind, referenceID string) (string, error) {
+    switch kind {
+    case "note":
+        record, err := readRecord(stub, referenceID)
+        if err != nil {
+            return "", err
+        }
+        return record.PatientID, nil
+    case "labOrder":
+        var order LabOrder
+        _, found, err := readLabObject(stub, labOrderObjectType, referenceID, "lab order", &order)
+        if err != nil {
+            return "", err
+        }
+        if !found {
+            return "", errors.New("Lab order not found")
+        }
+        return order.PatientID, nil
+    case "referral":
+        referral, _, err := readReferral(stub, referenceID)
+        if err != nil {
+            return "", err
+        }
+        if referral == nil {
+            return "", errors.New("Referral not found")
+        }
+        return referral.PatientID, nil
+    case "prescription":
+        prescription, _, err := readPrescription(stub, referenceID)
+        if err != nil {
+            return "", err
+        }
+        if prescription == nil {
+            return "", errors.New("Prescription not found")
+        }
+        details, err := readPrescriptionDetails(stub, prescription)
+        if err != nil {
+            return "", err
+        }
+        return details.PatientID, nil
+    }
+    return "", &ValidationError{Field: "kind", Reason: "must be note, labOrder, referral or prescription"}
+}
+
+// Read the diagnosis codes from the transient map and validate them
+func readTransientDiagnoses(stub shim.ChaincodeStubInterface, encounterID string) (*EncounterDiagnoses, error) {
+    transient, err := stub.GetTransient()
+    if err != nil {
+        return nil, errors.New("Failed to read transient data")
+    }
+    diagnosesBytes, ok := transient[encounterTransientKey]
+    if !ok {
+        return nil, errors.New("Expected diagnosis codes in transient key " + encounterTransientKey)
+    }
+    var diagnoses EncounterDiagnoses
+    if err := json.Unmarshal(diagnosesBytes, &diagnoses); err != nil {
+        return nil, errors.New("Invalid diagnosis codes in transient data")
+    }
+    if diagnoses.EncounterID == "" {
+        diagnoses.EncounterID = encounterID
+    }
+    if diagnoses.EncounterID != encounterID {
+        return nil, errors.New("Diagnosis codes are for a different encounter")
+    }
+    if len(diagnoses.Codes) == 0 || len(diagnoses.Codes) > maxEncounterDiagnoses {
+        return nil, fmt.Errorf("An encounter closes with 1 to %d diagnosis codes", maxEncounterDiagnoses)
+    }
+    for _, code := range diagnoses.Codes {
+        if err := validateID("diagnosis_codes", code); err != nil {
+            return nil, err
+        }
+    }
+    // The salt must come from the client so every endorser computes the same hash
+    if len(diagnoses.Salt) < minPHISaltLength {
+        return nil, fmt.Errorf("Diagnosis salt must be at least %d characters", minPHISaltLength)
+    }
+    return &diagnoses, nil
+}
+
+// Load an encounter the caller is the doctor of, for a change
+func readOwnOpenEncounter(stub shim.ChaincodeStubInterface, encounterID string) (*Encounter, string, string, error) {
+    if err := validateID("encounter_id", encounterID); err != nil {
+        return nil, "", "", err
+    }
+    if err := requireRole(stub, "doctor"); err != nil {
+        return nil, "", "", err
+    }
+    doctorID, err := callerUserID(stub)
+    if err != nil {
+        return nil, "", "", err
+    }
+    encounter, key, err := readEncounter(stub, encounterID)
+    if err != nil {
+        return nil, "", "", err
+    }
+    if encounter == nil {
+        return nil, "", "", errors.New("Encounter not found")
+    }
+    if encounter.DoctorID != doctorID {
+        return nil, "", "", errors.New("Only the encounter's doctor can change it")
+    }
+    if encounter.Status != encounterOpen {
+        return nil, "", "", errors.New("Encounter is closed")
+    }
+    return encounter, key, doctorID, nil
+}
+
+// Doctor links a note (record), lab order, referral or prescription for the
+// same patient to an open encounter
+func (t *PatientCareChaincode) addEncounterReference(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 3 || nonceArgs == nil {
+        return shim.Error("Expected arguments: encounter ID, kind (note|labOrder|referral|prescription), reference ID, nonce, expiry, signature")
+    }
+    kind, referenceID := args[1], args[2]
+    if !encounterReferenceKinds[kind] {
+        return shim.Error((&ValidationError{Field: "kind", Reason: "must be note, labOrder, referral or prescription"}).Error())
+    }
+    if err := validateID("reference_id", referenceID); err != nil {
+        return shim.Error(err.Error())
+    }
+    encounter, key, doctorID, err := readOwnOpenEncounter(stub, args[0])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if len(encounter.References) >= maxEncounterReferences {
+        return shim.Error(fmt.Sprintf("An encounter holds at most %d references", maxEncounterReferences))
+    }
+    for _, reference := range encounter.References {
+        if reference.Kind == kind && reference.ReferenceID == referenceID {
+            return shim.Error("Reference is already linked to this encounter")
+        }
+    }
+    patientID, err := encounterReferencePatient(stub, kind, referenceID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    same, err := samePatient(stub, encounter.PatientID, patientID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !same {
+        return shim.Error("Reference belongs to a different patient")
+    }
+    if err := consumeNonce(stub, "addEncounterReference", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    encounter.References = append(encounter.References, EncounterReference{
+        Kind:        kind,
+        ReferenceID: referenceID,
+        AddedBy:     doctorID,
+        AddedAt:     now,
+        TxID:        stub.GetTxID(),
+    })
+    encounterJSON, err := putEncounter(stub, key, encounter)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "EncounterReferenceAdded", ObjectID: encounter.ID, PatientID: encounter.PatientID, ActorID: doctorID, Status: encounter.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(encounterJSON)
+}
+
+// Doctor closes an encounter with its diagnosis codes, passed in the
+// transient map, and emits EncounterClosed for billing, which reads the
+// encounter with getEncounter and the codes with getEncounterDiagnoses
+func (t *PatientCareChaincode) closeEncounter(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 1 || nonceArgs == nil {
+        return shim.Error("Expected arguments: encounter ID, nonce, expiry, signature (diagnosis codes in transient key " + encounterTransientKey + ")")
+    }
+    encounter, key, doctorID, err := readOwnOpenEncounter(stub, args[0])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    diagnoses, err := readTransientDiagnoses(stub, encounter.ID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    collection, err := phiCollectionName(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := consumeNonce(stub, "closeEncounter", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    diagnosesJSON, err := json.Marshal(diagnoses)
+    if err != nil {
+        return shim.Error("Failed to marshal diagnosis codes JSON")
+    }
+    diagnosesKey, err := stub.CreateCompositeKey(encounterDiagnosesType, []string{encounter.ID})
+    if err != nil {
+        return shim.Error("Failed to create encounter diagnoses key")
+    }
+    if err := stub.PutPrivateData(collection, diagnosesKey, diagnosesJSON); err != nil {
+        return shim.Error("Failed to store diagnosis codes")
+    }
+
+    closed := newSignedTransition(stub, "close", encounterOpen, encounterClosed, "", doctorID, args, nonceArgs, now)
+    encounter.Status = encounterClosed
+    encounter.DiagnosisHash = generateHash(string(diagnosesJSON))
+    encounter.DiagnosisCount = len(diagnoses.Codes)
+    encounter.Collection = collection
+    encounter.Closed = &closed
+    encounterJSON, err := putEncounter(stub, key, encounter)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: encounterClosedEvent, ObjectID: encounter.ID, PatientID: encounter.PatientID, ActorID: doctorID, Status: encounter.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(encounterJSON)
+}
+
+// Whether the caller is the encounter's patient or doctor, billing or an
+// admin. The encounter holds no diagnosis codes, only their hash.
+func authorizeEncounterRead(stub shim.ChaincodeStubInterface, encounter *Encounter) error {
+    if hasRole(stub, "admin") || hasRole(stub, "billing") {
+        return nil
+    }
+    callerID, err := callerUserID(stub)
+    if err != nil {
+        return err
+    }
+    if callerID == encounter.DoctorID || callerID == encounter.PatientID {
+        return nil
+    }
+    return errors.New("Access denied: not a party to this encounter")
+}
+
+// Retrieve an encounter
+func (t *PatientCareChaincode) getEncounter(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: encounter ID")
+    }
+    if err := validateID("encounter_id", args[0]); err != nil {
+        return shim.Error(err.Error())
+    }
+    encounter, _, err := readEncounter(stub, args[0])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if encounter == nil {
+        return shim.Error("Encounter not found")
+    }
+    if err := authorizeEncounterRead(stub, encounter); err != nil {
+        return shim.Error(err.Error())
+    }
+    encounterJSON, err := json.Marshal(encounter)
+    if err != nil {
+        return shim.Error("Failed to marshal encounter JSON")
+    }
+
+    return shim.Success(encounterJSON)
+}
+
+// Retrieve a closed encounter's diagnosis codes from the private data
+// collection, checked against the hash anchored on the channel. Billing
+// identities of the holding org may read them as well as the parties.
+func (t *PatientCareChaincode) getEncounterDiagnoses(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: encounter ID")
+    }
+    if err := validateID("encounter_id", args[0]); err != nil {
+        return shim.Error(err.Error())
+    }
+    encounter, _, err := readEncounter(stub, args[0])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if encounter == nil {
+        return shim.Error("Encounter not found")
+    }
+    if encounter.Status != encounterClosed {
+        return shim.Error("Encounter is not closed")
+    }
+    if err := authorizeEncounterRead(stub, encounter); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    key, err := stub.CreateCompositeKey(encounterDiagnosesType, []string{encounter.ID})
+    if err != nil {
+        return shim.Error("Failed to create encounter diagnoses key")
+    }
+    diagnosesBytes, err := stub.GetPrivateData(encounter.Collection, key)
+    if err != nil || diagnosesBytes == nil {
+        return shim.Error("Diagnosis codes not found or not accessible to this organization")
+    }
+    if generateHash(string(diagnosesBytes)) != encounter.DiagnosisHash {
+        return shim.Error("Diagnosis codes do not match the hash anchored on the channel")
+    }
+    return shim.Success(diagnosesBytes)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Per-record data encryption key. The key material lives in the off-chain
+// KMS; the ledger only records which record it protects and whether it has
+// been destroyed, which the KMS must honour before releasing it.
+type DataKey struct {
+    ID             string     `json:"id"`
+    RecordID       string     `json:"record_id"`
+    Status         string     `json:"status"`
+    CreatedAt      time.Time  `json:"created_at"`
+    DestroyedAt    *time.Time `json:"destroyed_at,omitempty"`
+}
+
+// Proof for the patient that a record was crypto-shredded
+type ErasureCertificate struct {
+    RecordID        string    `json:"record_id"`
+    DEKID           string    `json:"dek_id,omitempty"`
+    KeyDestroyed    bool      `json:"key_destroyed"`
+    ReasonCode      string    `json:"reason_code"`
+    RequestedBy     string    `json:"requested_by"`
+    ErasedAt        time.Time `json:"erased_at"`
+    TxID            string    `json:"tx_id"`
+    CertificateHash string    `json:"certificate_hash"`
+}
+
+const (
+    recordStatusActive = "active"
+    recordStatusErased = "erased"
+
+    dataKeyStatusActive    = "active"
+    dataKeyStatusDestroyed = "destroyed"
+
+    dataKeyObjectType            = "dek"
+    erasureCertificateObjectType = "erasureCertificate"
+    recordErasedEvent            = "RecordErased"
+)
+
+// Reject writes and PHI reads on erased records. Records written before
+// statuses existed have an empty status and count as active.
+func requireActiveRecord(record *PatientRecord) error {
+    if record.Status == recordStatusErased {
+        return errors.New("Record has been erased")
+    }
+    return nil
+}
+
+// Register the data encryption key protecting a new record
+func registerDataKey(stub shim.ChaincodeStubInterface, dekID, recordID string, now time.Time) error {
+    key, err := stub.CreateCompositeKey(dataKeyObjectType, []string{dekID})
+    if err != nil {
+        return errors.New("Failed to create data key key")
+    }
+    existing, err := stub.GetState(key)
+    if err != nil {
+        return errors.New("Error reading data key")
+    }
+    if existing != nil {
+        return errors.New("Data encryption key is already bound to a record")
+    }
+
+    dataKey := DataKey{ID: dekID, RecordID: recordID, Status: dataKeyStatusActive, CreatedAt: now}
+    dataKeyJSON, err := json.Marshal(dataKey)
+    if err != nil {
+        return errors.New("Failed to marshal data key JSON")
+    }
+    return stub.PutState(key, dataKeyJSON)
+}
+
+// Mark a record's data encryption key destroyed
+func destroyDataKey(stub shim.ChaincodeStubInterface, dekID, recordID string, now time.Time) error {
+    key, err := stub.CreateCompositeKey(dataKeyObjectType, []string{dekID})
+    if err != nil {
+        return errors.New("Failed to create data key key")
+    }
+    dataKeyBytes, err := stub.GetState(key)
+    if err != nil || dataKeyBytes == nil {
+        return errors.New("Data encryption key not found")
+    }
+    var dataKey DataKey
+    if err := json.Unmarshal(dataKeyBytes, &dataKey); err != nil {
+        return errors.New("Failed to unmarshal data key JSON")
+    }
+    if dataKey.RecordID != recordID {
+        return errors.New("Data encryption key belongs to another record")
+    }
+
+    dataKey.Status = dataKeyStatusDestroyed
+    dataKey.DestroyedAt = &now
+    dataKeyJSON, err := json.Marshal(dataKey)
+    if err != nil {
+        return errors.New("Failed to marshal data key JSON")
+    }
+    return stub.PutState(key, dataKeyJSON)
+}
+
+// Admin-only: crypto-shred a record for a right-to-erasure request. The
+// record's key is marked destroyed, its private PHI and attachment manifest
+// are deleted, and the record is left as a tombstone with an erasure
+// certificate for the patient.
+func (t *PatientCareChaincode) eraseRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 2 || nonceArgs == nil {
+        return shim.Error("Expected arguments: record ID, reason code, nonce, expiry, signature")
+    }
+    id, reasonCode := args[0], args[1]
+    if err := validateID("record_id", id); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("reason_code", reasonCode); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := requireRole(stub, "admin"); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := consumeNonce(stub, "eraseRecord", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    record, err := readRecord(stub, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := requireActiveRecord(record); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if record.DEKID != "" {
+        if err := destroyDataKey(stub, record.DEKID, id, now); err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+    if record.Collection != "" {
+        if err := stub.DelPrivateData(record.Collection, id); err != nil {
+            return shim.Error("Failed to delete record PHI")
+        }
+        attachmentsKey, err := stub.CreateCompositeKey(attachmentObjectType, []string{id})
+        if err != nil {
+            return shim.Error("Failed to create attachments key")
+        }
+        if err := stub.DelPrivateData(record.Collection, attachmentsKey); err != nil {
+            return shim.Error("Failed to delete attachment manifest")
+        }
+    }
+
+    record.DataHash = ""
+    record.PHIHash = ""
+    record.AttachmentsHash = ""
+    record.FHIR = nil
+    record.Status = recordStatusErased
+    record.ErasedAt = &now
+    record.UpdatedAt = now
+    record.Nonce = nonceArgs[0]
+    if _, err := putRecord(stub, record); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    requestedBy, err := cid.GetID(stub)
+    if err != nil {
+        return shim.Error("Failed to read client identity")
+    }
+    certificate := ErasureCertificate{
+        RecordID:     id,
+        DEKID:        record.DEKID,
+        KeyDestroyed: record.DEKID != "",
+        ReasonCode:   reasonCode,
+        RequestedBy:  requestedBy,
+        ErasedAt:     now,
+        TxID:         stub.GetTxID(),
+    }
+    unsignedJSON, err := json.Marshal(certificate)
+    if err != nil {
+        return shim.Error("Failed to marshal erasure certificate JSON")
+    }
+    certificate.CertificateHash = generateHash(string(unsignedJSON))
+    certificateJSON, err := json.Marshal(certificate)
+    if err != nil {
+        return shim.Error("Failed to marshal erasure certificate JSON")
+    }
+
+    certificateKey, err := stub.CreateCompositeKey(erasureCertificateObjectType, []string{id})
+    if err != nil {
+        return shim.Error("Failed to create erasure certificate key")
+    }
+    if err := stub.PutState(certificateKey, certificateJSON); err != nil {
+        return shim.Error("Failed to store erasure certificate")
+    }
+    actorID, _ := callerUserID(stub)
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: recordErasedEvent, ObjectID: id, PatientID: record.PatientID, ActorID: actorID, Status: record.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(certificateJSON)
+}
+
+// Retrieve the erasure certificate of an erased record
+func (t *PatientCareChaincode) getErasureCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: record ID")
+    }
+    id := args[0]
+    if err := validateID("record_id", id); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    certificateKey, err := stub.CreateCompositeKey(erasureCertificateObjectType, []string{id})
+    if err != nil {
+        return shim.Error("Failed to create erasure certificate key")
+    }
+    certificateBytes, err := stub.GetState(certificateKey)
+    if err != nil || certificateBytes == nil {
+        return shim.Error("Erasure certificate not found")
+    }
+
+    return shim.Success(certificateBytes)
+}
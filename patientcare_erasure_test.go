@@ -0,0 +1,34 @@
+package main
+
+import (
+    "encoding/json"
+    "strings"
+    "testing"
+)
+
+func TestRecordErasedEventCarriesIdentifiersOnly(t *testing.T) {
+    ledger := newTestLedger(t)
+    ledger.createRecord(ledger.client("d1", "doctor"), "r1", "p1")
+    record, err := readRecord(ledger.stub, "r1")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    ledger.as(ledger.client("a1", "admin")).mustInvoke("eraseRecord", "r1", "gdpr-art17")
+    if ledger.lastEvent != recordErasedEvent {
+        t.Fatalf("event %q, want %s", ledger.lastEvent, recordErasedEvent)
+    }
+    var event LedgerEvent
+    if err := json.Unmarshal(ledger.lastEventPayload, &event); err != nil {
+        t.Fatal(err)
+    }
+    if event.Type != recordErasedEvent || event.ObjectID != "r1" || event.PatientID != "p1" || event.ActorID != "a1" {
+        t.Fatalf("event %+v", event)
+    }
+    payload := string(ledger.lastEventPayload)
+    for _, phi := range []string{"gdpr-art17", record.DataHash} {
+        if strings.Contains(payload, phi) {
+            t.Errorf("RecordErased payload contains %q: %s", phi, payload)
+        }
+    }
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "regexp"
+    "strings"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+    "github.com/tyuvic777/tyuabc777/merkle"
+)
+
+// FHIR resource anchored by a patient record. Only identifiers and the
+// canonical hash go on the channel; the resource itself stays in the FHIR server.
+type FHIRAnchor struct {
+    ResourceType   string    `json:"resource_type"`
+    ResourceID     string    `json:"resource_id"`
+    VersionID      string    `json:"version_id"`
+    CanonicalHash  string    `json:"canonical_hash"`
+}
+
+// Transient map key carrying the FHIR resource JSON
+const fhirTransientKey = "fhir_resource"
+
+// FHIR id and versionId datatype: [A-Za-z0-9\-\.]{1,64}
+var fhirIDPattern = regexp.MustCompile(`^[A-Za-z0-9\-\.]{1,64}$`)
+
+// Supported record categories and their per-resource validation
+var fhirValidators = map[string]func(resource map[string]interface{}) error{
+    "Observation":       validateObservation,
+    "Condition":         validateCondition,
+    "MedicationRequest": validateMedicationRequest,
+    "DiagnosticReport":  validateDiagnosticReport,
+    "CarePlan":          validateCarePlan,
+    "Encounter":         validateEncounter,
+}
+
+// Allowed status codes per resource type (FHIR R4 required bindings)
+var fhirStatusCodes = map[string][]string{
+    "Observation":       {"registered", "preliminary", "final", "amended", "corrected", "cancelled", "entered-in-error", "unknown"},
+    "MedicationRequest": {"active", "on-hold", "cancelled", "completed", "entered-in-error", "stopped", "draft", "unknown"},
+    "DiagnosticReport":  {"registered", "partial", "preliminary", "final", "amended", "corrected", "appended", "cancelled", "entered-in-error", "unknown"},
+    "CarePlan":          {"draft", "active", "on-hold", "revoked", "completed", "entered-in-error", "unknown"},
+    "Encounter":         {"planned", "arrived", "triaged", "in-progress", "onleave", "finished", "cancelled", "entered-in-error", "unknown"},
+}
+
+// Check that the listed elements are present and non-empty
+func requireFHIRElements(resource map[string]interface{}, names ...string) error {
+    for _, name := range names {
+        value, ok := resource[name]
+        if !ok || value == nil || value == "" {
+            return fmt.Errorf("%s is missing required element %s", resource["resourceType"], name)
+        }
+    }
+    return nil
+}
+
+// Check that at least one choice-type variant (e.g. medication[x]) is present
+func requireFHIRChoice(resource map[string]interface{}, base string, variants ...string) error {
+    for _, variant := range variants {
+        if value, ok := resource[base+variant]; ok && value != nil {
+            return nil
+        }
+    }
+    return fmt.Errorf("%s is missing required element %s[x]", resource["resourceType"], base)
+}
+
+// Check the resource status against the type's value set
+func requireFHIRStatus(resource map[string]interface{}) error {
+    resourceType, _ := resource["resourceType"].(string)
+    status, _ := resource["status"].(string)
+    for _, code := range fhirStatusCodes[resourceType] {
+        if status == code {
+            return nil
+        }
+    }
+    return fmt.Errorf("%s has invalid status %q", resourceType, status)
+}
+
+func validateObservation(resource map[string]interface{}) error {
+    if err := requireFHIRElements(resource, "status", "code", "subject"); err != nil {
+        return err
+    }
+    return requireFHIRStatus(resource)
+}
+
+func validateCondition(resource map[string]interface{}) error {
+    return requireFHIRElements(resource, "code", "subject")
+}
+
+func validateMedicationRequest(resource map[string]interface{}) error {
+    if err := requireFHIRElements(resource, "status", "intent", "subject"); err != nil {
+        return err
+    }
+    if err := requireFHIRChoice(resource, "medication", "CodeableConcept", "Reference"); err != nil {
+        return err
+    }
+    return requireFHIRStatus(resource)
+}
+
+func validateDiagnosticReport(resource map[string]interface{}) error {
+    if err := requireFHIRElements(resource, "status", "code", "subject"); err != nil {
+        return err
+    }
+    return requireFHIRStatus(resource)
+}
+
+func validateCarePlan(resource map[string]interface{}) error {
+    if err := requireFHIRElements(resource, "status", "intent", "subject"); err != nil {
+        return err
+    }
+    return requireFHIRStatus(resource)
+}
+
+func validateEncounter(resource map[string]interface{}) error {
+    if err := requireFHIRElements(resource, "status", "class", "subject"); err != nil {
+        return err
+    }
+    return requireFHIRStatus(resource)
+}
+
+// Patient ID from a resource's subject reference ("Patient/<id>"), if any
+func fhirSubjectPatientID(resource map[string]interface{}) string {
+    subject, _ := resource["subject"].(map[string]interface{})
+    reference, _ := subject["reference"].(string)
+    if !strings.HasPrefix(reference, "Patient/") {
+        return ""
+    }
+    return strings.TrimPrefix(reference, "Patient/")
+}
+
+// Parse a FHIR resource, run its category validation and build the anchor.
+// Also returns the subject patient ID, or "" when the subject is not a Patient.
+func parseFHIRResource(resourceJSON []byte) (*FHIRAnchor, string, error) {
+    decoder := json.NewDecoder(bytes.NewReader(resourceJSON))
+    decoder.UseNumber()
+    var resource map[string]interface{}
+    if err := decoder.Decode(&resource); err != nil {
+        return nil, "", errors.New("Invalid FHIR resource JSON")
+    }
+
+    resourceType, _ := resource["resourceType"].(string)
+    validate, ok := fhirValidators[resourceType]
+    if !ok {
+        return nil, "", fmt.Errorf("Unsupported FHIR resource type %q", resourceType)
+    }
+
+    resourceID, _ := resource["id"].(string)
+    if !fhirIDPattern.MatchString(resourceID) {
+        return nil, "", errors.New("FHIR resource id is missing or invalid")
+    }
+    meta, _ := resource["meta"].(map[string]interface{})
+    versionID, _ := meta["versionId"].(string)
+    if !fhirIDPattern.MatchString(versionID) {
+        return nil, "", errors.New("FHIR resource meta.versionId is missing or invalid")
+    }
+
+    if err := validate(resource); err != nil {
+        return nil, "", err
+    }
+
+    canonicalHash, err := canonicalFHIRHash(resource)
+    if err != nil {
+        return nil, "", err
+    }
+
+    patientID := fhirSubjectPatientID(resource)
+    if patientID != "" {
+        if err := validateID("patient_id", patientID); err != nil {
+            return nil, "", err
+        }
+    }
+
+    return &FHIRAnchor{
+        ResourceType:  resourceType,
+        ResourceID:    resourceID,
+        VersionID:     versionID,
+        CanonicalHash: canonicalHash,
+    }, patientID, nil
+}
+
+// SHA-256 over the canonical form of a resource: the RFC 8785 (JCS)
+// serialization of the resource with the server-managed meta and narrative
+// text removed, so the hash only covers clinical content and any JCS
+// implementation can reproduce it.
+func canonicalFHIRHash(resource map[string]interface{}) (string, error) {
+    canonical := make(map[string]interface{}, len(resource))
+    for key, value := range resource {
+        if key == "meta" || key == "text" {
+            continue
+        }
+        canonical[key] = value
+    }
+    canonicalJSON, err := merkle.CanonicalJSON(canonical)
+    if err != nil {
+        return "", errors.New("Failed to canonicalize FHIR resource")
+    }
+    return generateHash(string(canonicalJSON)), nil
+}
+
+// Read the FHIR resource JSON from the transient map
+func readTransientFHIR(stub shim.ChaincodeStubInterface) ([]byte, error) {
+    transient, err := stub.GetTransient()
+    if err != nil {
+        return nil, errors.New("Failed to read transient data")
+    }
+    resourceJSON, ok := transient[fhirTransientKey]
+    if !ok || len(resourceJSON) == 0 {
+        return nil, errors.New("Expected FHIR resource in transient key " + fhirTransientKey)
+    }
+    return resourceJSON, nil
+}
+
+// Doctor only: anchor a FHIR resource version to a patient record. The
+// resource is passed in the transient map so no PHI reaches the channel. The
+// authoring doctor signs the canonical hash with their DID key, as for
+// createRecord. A new version of the same resource replaces the anchor on
+// the existing record, with its author, and drops the co-signatures given
+// for the previous version.
+func (t *PatientCareChaincode) anchorFHIRResource(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 3 || nonceArgs == nil {
+        return shim.Error("Expected arguments: record ID, author DID, DID signature, nonce, expiry, signature")
+    }
+    id, authorDID, didSignature := args[0], args[1], args[2]
+    nonce := nonceArgs[0]
+
+    if err := validateID("record_id", id); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := requireRole(stub, "doctor"); err != nil {
+        return shim.Error(err.Error())
+    }
+    resourceJSON, err := readTransientFHIR(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    anchor, patientID, err := parseFHIRResource(resourceJSON)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := consumeNonce(stub, "anchorFHIRResource", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    var record PatientRecord
+    existingBytes, err := stub.GetState(id)
+    if err != nil {
+        return shim.Error("Error reading record")
+    }
+    if existingBytes == nil {
+        record = PatientRecord{
+            ID:        id,
+            PatientID: patientID,
+            CreatedAt: now,
+            Status:    recordStatusActive,
+        }
+    } else {
+        if err := json.Unmarshal(existingBytes, &record); err != nil {
+            return shim.Error("Failed to unmarshal record JSON")
+        }
+        if err := requireActiveRecord(&record); err != nil {
+            return shim.Error(err.Error())
+        }
+        if record.FHIR == nil {
+            return shim.Error("Record exists and does not anchor a FHIR resource")
+        }
+        if record.FHIR.ResourceType != anchor.ResourceType || record.FHIR.ResourceID != anchor.ResourceID {
+            return shim.Error("Record anchors a different FHIR resource")
+        }
+        if record.FHIR.VersionID == anchor.VersionID {
+            return shim.Error("FHIR resource version is already anchored")
+        }
+        if record.PatientID != "" && patientID != record.PatientID {
+            return shim.Error("FHIR resource subject does not match the record's patient")
+        }
+    }
+
+    author, err := verifyClinicianSignature(stub, authorDID, recordAuthorDigest(id, patientID, anchor.CanonicalHash, authorDID), didSignature, now)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    record.Category = anchor.ResourceType
+    record.FHIR = anchor
+    record.DataHash = anchor.CanonicalHash
+    record.UpdatedAt = now
+    record.Nonce = nonce
+    if err := signRecordVersion(stub, &record, author); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    recordJSON, err := putRecord(stub, &record)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    eventType := "RecordUpdated"
+    if existingBytes == nil {
+        eventType = "RecordCreated"
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: eventType, ObjectID: id, PatientID: record.PatientID, Status: record.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(recordJSON)
+}
+
+// Check a FHIR resource from the transient map against the anchored version
+func (t *PatientCareChaincode) verifyFHIRResource(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: record ID")
+    }
+    id := args[0]
+    if err := validateID("record_id", id); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    recordBytes, err := stub.GetState(id)
+    if err != nil || recordBytes == nil {
+        return shim.Error("Record not found")
+    }
+    var record PatientRecord
+    if err := json.Unmarshal(recordBytes, &record); err != nil {
+        return shim.Error("Failed to unmarshal record JSON")
+    }
+    if record.FHIR == nil {
+        return shim.Error("Record does not anchor a FHIR resource")
+    }
+
+    resourceJSON, err := readTransientFHIR(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    anchor, _, err := parseFHIRResource(resourceJSON)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if anchor.ResourceType != record.FHIR.ResourceType || anchor.ResourceID != record.FHIR.ResourceID ||
+        anchor.VersionID != record.FHIR.VersionID || anchor.CanonicalHash != record.FHIR.CanonicalHash {
+        return shim.Error("FHIR resource does not match the anchored version")
+    }
+
+    return shim.Success([]byte("FHIR resource verified"))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "strings"
+    "testing"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+func testObservation(version, value string) []byte {
+    return []byte(fmt.Sprintf(`{"resourceType":"Observation","id":"obs1","meta":{"versionId":%q},"status":"final","code":{"text":"glucose"},"subject":{"reference":"Patient/p1"},"valueQuantity":{"value":%s,"unit":"mmol/L"}}`, version, value))
+}
+
+// anchorFHIR has doctor anchor resourceJSON to recordID, signing its
+// canonical hash with their DID
+func (l *testLedger) anchorFHIR(doctor *testClient, recordID string, resourceJSON []byte) []string {
+    l.t.Helper()
+    anchor, patientID, err := parseFHIRResource(resourceJSON)
+    if err != nil {
+        l.t.Fatal(err)
+    }
+    didID := l.registerDID(doctor)
+    didSignature := doctor.sign(l.t, recordAuthorDigest(recordID, patientID, anchor.CanonicalHash, didID))
+    l.transient = map[string][]byte{fhirTransientKey: resourceJSON}
+    return l.as(doctor).signed("anchorFHIRResource", recordID, didID, didSignature)
+}
+
+func (l *testLedger) record(recordID string) PatientRecord {
+    l.t.Helper()
+    var record PatientRecord
+    if err := json.Unmarshal(l.stub.State[recordID], &record); err != nil {
+        l.t.Fatal(err)
+    }
+    return record
+}
+
+// signatures reads a record's signatures from the private data collection
+// as reader
+func (l *testLedger) signatures(reader *testClient, recordID string) RecordSignatures {
+    l.t.Helper()
+    var signatures RecordSignatures
+    if err := json.Unmarshal(l.as(reader).mustQuery("getRecordSignatures", recordID), &signatures); err != nil {
+        l.t.Fatal(err)
+    }
+    return signatures
+}
+
+func TestAnchorFHIRResourceRequiresSignedDoctor(t *testing.T) {
+    ledger := newTestLedger(t)
+    doctor := ledger.client("d1", "doctor")
+
+    patient := ledger.client("p1", "patient")
+    ledger.registerDID(patient)
+    args := ledger.anchorFHIR(patient, "r1", testObservation("1", "5.4"))
+    if response := ledger.invoke("anchorFHIRResource", args...); !strings.Contains(response.Message, "doctor") {
+        t.Fatalf("patient anchoring: %q", response.Message)
+    }
+
+    // A signature over another version's hash does not carry over
+    args = ledger.anchorFHIR(doctor, "r1", testObservation("1", "5.4"))
+    ledger.transient = map[string][]byte{fhirTransientKey: testObservation("1", "7.9")}
+    if response := ledger.invoke("anchorFHIRResource", args...); response.Status == shim.OK {
+        t.Fatalf("anchored a resource the author did not sign")
+    }
+
+    if response := ledger.invoke("anchorFHIRResource", ledger.anchorFHIR(doctor, "r1", testObservation("1", "5.4"))...); response.Message != "" {
+        t.Fatalf("anchorFHIRResource: %s", response.Message)
+    }
+    record := ledger.record("r1")
+    if record.Author == nil || record.Author.Status != signatureVerified || record.DataHash != record.FHIR.CanonicalHash {
+        t.Fatalf("record %+v is not signed by its author", record)
+    }
+    if signatures := ledger.signatures(doctor, "r1"); signatures.Author.SignerID != "d1" {
+        t.Fatalf("signed by %s, want d1", signatures.Author.SignerID)
+    }
+}
+
+func TestAnchorFHIRResourceUpdateResetsSignatures(t *testing.T) {
+    ledger := newTestLedger(t)
+    resident := ledger.client("d1", "doctor")
+    attending := ledger.client("d2", "doctor")
+    ledger.invoke("anchorFHIRResource", ledger.anchorFHIR(resident, "r1", testObservation("1", "5.4"))...)
+
+    record := ledger.record("r1")
+    attendingDID := ledger.registerDID(attending)
+    coSignature := attending.sign(t, recordCoSignDigest("r1", "p1", record.DataHash, ledger.signatures(resident, "r1").Author.DID, attendingDID))
+    ledger.as(attending).mustInvoke("cosignRecord", "r1", record.DataHash, attendingDID, coSignature)
+    if got := len(ledger.record("r1").CoSignatures); got != 1 {
+        t.Fatalf("%d co-signatures, want 1", got)
+    }
+
+    if response := ledger.invoke("anchorFHIRResource", ledger.anchorFHIR(attending, "r1", testObservation("2", "6.1"))...); response.Message != "" {
+        t.Fatalf("anchoring version 2: %s", response.Message)
+    }
+    record = ledger.record("r1")
+    signatures := ledger.signatures(attending, "r1")
+    if signatures.Author.SignerID != "d2" || len(record.CoSignatures) != 0 || len(signatures.CoSignatures) != 0 || record.FHIR.VersionID != "2" {
+        t.Fatalf("after a new version: author %s, %d co-signatures, version %s", signatures.Author.SignerID, len(record.CoSignatures), record.FHIR.VersionID)
+    }
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "strconv"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Laboratory allowed to attest results, identified by its DID in the
+// identity chaincode. OwnerID is the lab user who owns that DID.
+type Laboratory struct {
+    ID             string     `json:"id"`
+    DID            string     `json:"did"`
+    Name           string     `json:"name"`
+    OwnerID        string     `json:"owner_id"`
+    Active         bool       `json:"active"`
+    RegisteredAt   time.Time  `json:"registered_at"`
+    DeregisteredAt *time.Time `json:"deregistered_at,omitempty"`
+}
+
+// Test ordered by a doctor from a laboratory
+type LabOrder struct {
+    ID               string    `json:"id"`
+    PatientID        string    `json:"patient_id"`
+    OrderingDoctorID string    `json:"ordering_doctor_id"`
+    LabID            string    `json:"lab_id"`
+    TestCode         string    `json:"test_code"`
+    Status           string    `json:"status"`
+    LatestResultID   string    `json:"latest_result_id,omitempty"`
+    CreatedAt        time.Time `json:"created_at"`
+}
+
+// Lab-signed hash of a result document. An amendment adds to a result and
+// a correction replaces it; either way the earlier result stays on record,
+// linked to the one that superseded it.
+type LabResult struct {
+    ID             string    `json:"id"`
+    OrderID        string    `json:"order_id"`
+    PatientID      string    `json:"patient_id"`
+    LabID          string    `json:"lab_id"`
+    LabDID         string    `json:"lab_did"`
+    TestCode       string    `json:"test_code"`
+    ResultHash     string    `json:"result_hash"`
+    ReportedAt     time.Time `json:"reported_at"`
+    Kind           string    `json:"kind"`
+    Supersedes     string    `json:"supersedes,omitempty"`
+    SupersededBy   string    `json:"superseded_by,omitempty"`
+    Reason         string    `json:"reason,omitempty"`
+    Status         string    `json:"status"`
+    LabSignature   string    `json:"lab_signature"`
+    RecordedAt     time.Time `json:"recorded_at"`
+    TxID           string    `json:"tx_id"`
+}
+
+const (
+    laboratoryObjectType    = "laboratory"
+    laboratoryDIDObjectType = "laboratoryDID"
+    labOrderObjectType      = "labOrder"
+    labResultObjectType     = "labResult"
+
+    labOrderOrdered  = "ordered"
+    labOrderResulted = "resulted"
+
+    labResultOriginal   = "original"
+    labResultAmendment  = "amendment"
+    labResultCorrection = "correction"
+
+    labResultFinal     = "final"
+    labResultAmended   = "amended"
+    labResultCorrected = "corrected"
+)
+
+// Chaincode event each kind of lab result emits
+var labResultEvents = map[string]string{
+    labResultOriginal:   "LabResultAnchored",
+    labResultAmendment:  "LabResultAmended",
+    labResultCorrection: "LabResultCorrected",
+}
+
+// Digest a laboratory signs with its DID key
+func labResultDigest(kind, resultID, orderID, patientID, testCode, resultHash string, reportedAt int64, supersedes, reason string) []byte {
+    return fieldDigest([]string{"labResult", kind, resultID, orderID, patientID, testCode, resultHash, strconv.FormatInt(reportedAt, 10), supersedes, reason})
+}
+
+// Load a JSON object stored under a single-attribute composite key
+func readLabObject(stub shim.ChaincodeStubInterface, objectType, id, name string, out interface{}) (string, bool, error) {
+    key, err := stub.CreateCompositeKey(objectType, []string{id})
+    if err != nil {
+        return "", false, errors.New("Failed to create " + name + " key")
+    }
+    objectBytes, err := stub.GetState(key)
+    if err != nil {
+        return "", false, errors.New("Error reading " + name)
+    }
+    if objectBytes == nil {
+        return key, false, nil
+    }
+    if err := json.Unmarshal(objectBytes, out); err != nil {
+        return "", false, errors.New("Failed to unmarshal " + name + " JSON")
+    }
+    return key, true, nil
+}
+
+// Store a JSON object under key
+func putLabObject(stub shim.ChaincodeStubInterface, key, name string, object interface{}) ([]byte, error) {
+    objectJSON, err := json.Marshal(object)
+    if err != nil {
+        return nil, errors.New("Failed to marshal " + name + " JSON")
+    }
+    if err := stub.PutState(key, objectJSON); err != nil {
+        return nil, errors.New("Failed to store " + name)
+    }
+    return objectJSON, nil
+}
+
+// Whether the caller may read an order or its results: admins, the
+// patient, the ordering doctor and the laboratory
+func canReadLabOrder(stub shim.ChaincodeStubInterface, order *LabOrder) (bool, error) {
+    if hasRole(stub, "admin") {
+        return true, nil
+    }
+    callerID, err := callerUserID(stub)
+    if err != nil {
+        return false, err
+    }
+    if callerID == order.PatientID || callerID == order.OrderingDoctorID {
+        return true, nil
+    }
+    var lab Laboratory
+    _, found, err := readLabObject(stub, laboratoryObjectType, order.LabID, "laboratory", &lab)
+    if err != nil {
+        return false, err
+    }
+    return found && lab.OwnerID == callerID, nil
+}
+
+// Admin only: register a laboratory by its DID
+func (t *PatientCareChaincode) registerLaboratory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 3 || nonceArgs == nil {
+        return shim.Error("Expected arguments: lab ID, lab DID, name, nonce, expiry, signature")
+    }
+    labID, labDID, name := args[0], args[1], args[2]
+    if err := validateID("lab_id", labID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if name == "" || len(name) > 200 {
+        return shim.Error((&ValidationError{Field: "name", Reason: "must be 1-200 characters"}).Error())
+    }
+    if err := requireRole(stub, "admin"); err != nil {
+        return shim.Error(err.Error())
+    }
+    did, err := resolveDID(stub, labDID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    var existing Laboratory
+    key, found, err := readLabObject(stub, laboratoryObjectType, labID, "laboratory", &existing)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if found {
+        return shim.Error("Laboratory is already registered")
+    }
+    didKey, err := stub.CreateCompositeKey(laboratoryDIDObjectType, []string{labDID})
+    if err != nil {
+        return shim.Error("Failed to create laboratory DID key")
+    }
+    boundLab, err := stub.GetState(didKey)
+    if err != nil {
+        return shim.Error("Error reading laboratory DID")
+    }
+    if boundLab != nil {
+        return shim.Error("DID is already registered to laboratory " + string(boundLab))
+    }
+    if err := consumeNonce(stub, "registerLaboratory", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    lab := Laboratory{ID: labID, DID: labDID, Name: name, OwnerID: did.Owner, Active: true, RegisteredAt: now}
+    labJSON, err := putLabObject(stub, key, "laboratory", lab)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := stub.PutState(didKey, []byte(labID)); err != nil {
+        return shim.Error("Failed to store laboratory DID")
+    }
+    actorID, _ := callerUserID(stub)
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "LaboratoryRegistered", ObjectID: labID, ActorID: actorID}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(labJSON)
+}
+
+// Admin only: stop a laboratory from attesting results. Results it has
+// already attested stay valid.
+func (t *PatientCareChaincode) deregisterLaboratory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 1 || nonceArgs == nil {
+        return shim.Error("Expected arguments: lab ID, nonce, expiry, signature")
+    }
+    labID := args[0]
+    if err := validateID("lab_id", labID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := requireRole(stub, "admin"); err != nil {
+        return shim.Error(err.Error())
+    }
+    var lab Laboratory
+    key, found, err := readLabObject(stub, laboratoryObjectType, labID, "laboratory", &lab)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !found {
+        return shim.Error("Laboratory not found")
+    }
+    if !lab.Active {
+        return shim.Error("Laboratory is already deregistered")
+    }
+    if err := consumeNonce(stub, "deregisterLaboratory", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    lab.Active = false
+    lab.DeregisteredAt = &now
+    labJSON, err := putLabObject(stub, key, "laboratory", lab)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    actorID, _ := callerUserID(stub)
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "LaboratoryDeregistered", ObjectID: labID, ActorID: actorID}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(labJSON)
+}
+
+// Doctor only: order a test for a patient from a registered laboratory
+func (t *PatientCareChaincode) createLabOrder(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 4 || nonceArgs == nil {
+        return shim.Error("Expected arguments: order ID, patient ID, lab ID, test code, nonce, expiry, signature")
+    }
+    orderID, patientID, labID, testCode := args[0], args[1], args[2], args[3]
+    if err := validateID("order_id", orderID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("patient_id", patientID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("lab_id", labID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("test_code", testCode); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := requireRole(stub, "doctor"); err != nil {
+        return shim.Error(err.Error())
+    }
+    doctorID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    var lab Laboratory
+    _, found, err := readLabObject(stub, laboratoryObjectType, labID, "laboratory", &lab)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !found || !lab.Active {
+        return shim.Error("Laboratory is not registered")
+    }
+    var existing LabOrder
+    key, found, err := readLabObject(stub, labOrderObjectType, orderID, "lab order", &existing)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if found {
+        return shim.Error("Lab order already exists")
+    }
+    if err := consumeNonce(stub, "createLabOrder", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    order := LabOrder{
+        ID:               orderID,
+        PatientID:        patientID,
+        OrderingDoctorID: doctorID,
+        LabID:            labID,
+        TestCode:         testCode,
+        Status:           labOrderOrdered,
+        CreatedAt:        now,
+    }
+    orderJSON, err := putLabObject(stub, key, "lab order", order)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "LabOrderCreated", ObjectID: orderID, PatientID: patientID, ActorID: doctorID, Status: order.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(orderJSON)
+}
+
+// Result arguments shared by anchorLabResult, amendLabResult and
+// correctLabResult
+type labAttestation struct {
+    resultID     string
+    labDID       string
+    resultHash   string
+    reportedAt   string
+    reason       string
+    labSignature string
+}
+
+// Store a lab-signed result for an order. The caller must own the DID of
+// the order's laboratory. previous is nil for an original result.
+func (t *PatientCareChaincode) attestLabResult(stub shim.ChaincodeStubInterface, function, kind string, args, nonceArgs []string, order *LabOrder, orderKey string, previous *LabResult, previousKey string, attestation labAttestation) pb.Response {
+    resultID, labDID, resultHash, reason, labSignature := attestation.resultID, attestation.labDID, attestation.resultHash, attestation.reason, attestation.labSignature
+    if err := validateID("result_id", resultID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if !sha256HexPattern.MatchString(resultHash) {
+        return shim.Error((&ValidationError{Field: "result_hash", Reason: "must be lowercase hex SHA-256"}).Error())
+    }
+    reportedAtUnix, err := strconv.ParseInt(attestation.reportedAt, 10, 64)
+    if err != nil || reportedAtUnix <= 0 {
+        return shim.Error((&ValidationError{Field: "reported_at", Reason: "must be unix seconds"}).Error())
+    }
+    if err := requireRole(stub, "lab"); err != nil {
+        return shim.Error(err.Error())
+    }
+    did, err := resolveCallerDID(stub, labDID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    var lab Laboratory
+    _, found, err := readLabObject(stub, laboratoryObjectType, order.LabID, "laboratory", &lab)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !found || !lab.Active || lab.DID != labDID {
+        return shim.Error("DID is not that of the order's registered laboratory")
+    }
+    supersedes := ""
+    if previous != nil {
+        supersedes = previous.ID
+    }
+    digest := labResultDigest(kind, resultID, order.ID, order.PatientID, order.TestCode, resultHash, reportedAtUnix, supersedes, reason)
+    if err := verifyDIDSignature(did, digest, labSignature); err != nil {
+        return shim.Error(err.Error())
+    }
+    var existing LabResult
+    key, found, err := readLabObject(stub, labResultObjectType, resultID, "lab result", &existing)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if found {
+        return shim.Error("Lab result already exists")
+    }
+    if err := consumeNonce(stub, function, args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    reportedAt := time.Unix(reportedAtUnix, 0).UTC()
+    if reportedAt.After(now.Add(maxNonceLifetime)) {
+        return shim.Error("Report time is in the future")
+    }
+
+    result := LabResult{
+        ID:           resultID,
+        OrderID:      order.ID,
+        PatientID:    order.PatientID,
+        LabID:        order.LabID,
+        LabDID:       labDID,
+        TestCode:     order.TestCode,
+        ResultHash:   resultHash,
+        ReportedAt:   reportedAt,
+        Kind:         kind,
+        Supersedes:   supersedes,
+        Reason:       reason,
+        Status:       labResultFinal,
+        LabSignature: labSignature,
+        RecordedAt:   now,
+        TxID:         stub.GetTxID(),
+    }
+    resultJSON, err := putLabObject(stub, key, "lab result", result)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if previous != nil {
+        previous.SupersededBy = resultID
+        previous.Status = labResultAmended
+        if kind == labResultCorrection {
+            previous.Status = labResultCorrected
+        }
+        if _, err := putLabObject(stub, previousKey, "lab result", previous); err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+    order.Status = labOrderResulted
+    order.LatestResultID = resultID
+    if _, err := putLabObject(stub, orderKey, "lab order", order); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: labResultEvents[kind], ObjectID: resultID, PatientID: order.PatientID, ActorID: did.Owner, Status: result.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(resultJSON)
+}
+
+// Laboratory only: anchor the first result for an order, signed with the
+// laboratory's DID key
+func (t *PatientCareChaincode) anchorLabResult(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 6 || nonceArgs == nil {
+        return shim.Error("Expected arguments: result ID, order ID, lab DID, result hash, reported at, lab signature, nonce, expiry, signature")
+    }
+    orderID := args[1]
+    if err := validateID("order_id", orderID); err != nil {
+        return shim.Error(err.Error())
+    }
+    var order LabOrder
+    orderKey, found, err := readLabObject(stub, labOrderObjectType, orderID, "lab order", &order)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !found {
+        return shim.Error("Lab order not found")
+    }
+    if order.Status != labOrderOrdered {
+        return shim.Error("Lab order already has a result; amend or correct it instead")
+    }
+    return t.attestLabResult(stub, "anchorLabResult", labResultOriginal, args, nonceArgs, &order, orderKey, nil, "", labAttestation{
+        resultID:     args[0],
+        labDID:       args[2],
+        resultHash:   args[3],
+        reportedAt:   args[4],
+        labSignature: args[5],
+    })
+}
+
+// Amend or correct the latest result of an order, keeping the result it
+// supersedes on record
+func (t *PatientCareChaincode) reviseLabResult(stub shim.ChaincodeStubInterface, function, kind string, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 7 || nonceArgs == nil {
+        return shim.Error("Expected arguments: result ID, previous result ID, lab DID, result hash, reported at, reason, lab signature, nonce, expiry, signature")
+    }
+    previousID, reason := args[1], args[5]
+    if err := validateID("previous_result_id", previousID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if reason == "" || len(reason) > 500 {
+        return shim.Error((&ValidationError{Field: "reason", Reason: "must be 1-500 characters"}).Error())
+    }
+    var previous LabResult
+    previousKey, found, err := readLabObject(stub, labResultObjectType, previousID, "lab result", &previous)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !found {
+        return shim.Error("Lab result not found")
+    }
+    if previous.SupersededBy != "" {
+        return shim.Error("Lab result has been superseded by " + previous.SupersededBy)
+    }
+    var order LabOrder
+    orderKey, found, err := readLabObject(stub, labOrderObjectType, previous.OrderID, "lab order", &order)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !found {
+        return shim.Error("Lab order not found")
+    }
+    return t.attestLabResult(stub, function, kind, args, nonceArgs, &order, orderKey, &previous, previousKey, labAttestation{
+        resultID:     args[0],
+        labDID:       args[2],
+        resultHash:   args[3],
+        reportedAt:   args[4],
+        reason:       reason,
+        labSignature: args[6],
+    })
+}
+
+// Laboratory only: add to a result, e.g. a late test or comment
+func (t *PatientCareChaincode) amendLabResult(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return t.reviseLabResult(stub, "amendLabResult", labResultAmendment, args)
+}
+
+// Laboratory only: replace a result that was wrong
+func (t *PatientCareChaincode) correctLabResult(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return t.reviseLabResult(stub, "correctLabResult", labResultCorrection, args)
+}
+
+// Retrieve a lab order
+func (t *PatientCareChaincode) getLabOrder(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: order ID")
+    }
+    if err := validateID("order_id", args[0]); err != nil {
+        return shim.Error(err.Error())
+    }
+    var order LabOrder
+    _, found, err := readLabObject(stub, labOrderObjectType, args[0], "lab order", &order)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !found {
+        return shim.Error("Lab order not found")
+    }
+    allowed, err := canReadLabOrder(stub, &order)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !allowed {
+        return shim.Error("Access denied: not the patient, ordering doctor or laboratory")
+    }
+    orderJSON, err := json.Marshal(order)
+    if err != nil {
+        return shim.Error("Failed to marshal lab order JSON")
+    }
+
+    return shim.Success(orderJSON)
+}
+
+// Retrieve a lab result with every result before and after it for the same
+// order, oldest first, so a reader always sees what was amended or corrected
+func (t *PatientCareChaincode) getLabResult(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: result ID")
+    }
+    if err := validateID("result_id", args[0]); err != nil {
+        return shim.Error(err.Error())
+    }
+    var result LabResult
+    _, found, err := readLabObject(stub, labResultObjectType, args[0], "lab result", &result)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !found {
+        return shim.Error("Lab result not found")
+    }
+    var order LabOrder
+    if _, found, err = readLabObject(stub, labOrderObjectType, result.OrderID, "lab order", &order); err != nil || !found {
+        return shim.Error("Lab order not found")
+    }
+    allowed, err := canReadLabOrder(stub, &order)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !allowed {
+        return shim.Error("Access denied: not the patient, ordering doctor or laboratory")
+    }
+
+    // Walk back to the original, then forward to the latest
+    for result.Supersedes != "" {
+        var previous LabResult
+        if _, found, err = readLabObject(stub, labResultObjectType, result.Supersedes, "lab result", &previous); err != nil || !found {
+            return shim.Error("Broken lab result history")
+        }
+        result = previous
+    }
+    history := []LabResult{result}
+    for result.SupersededBy != "" {
+        var next LabResult
+        if _, found, err = readLabObject(stub, labResultObjectType, result.SupersededBy, "lab result", &next); err != nil || !found {
+            return shim.Error("Broken lab result history")
+        }
+        history = append(history, next)
+        result = next
+    }
+    historyJSON, err := json.Marshal(map[string]interface{}{
+        "requested_id": args[0],
+        "history":      history,
+    })
+    if err != nil {
+        return shim.Error("Failed to marshal lab result JSON")
+    }
+
+    return shim.Success(historyJSON)
+}
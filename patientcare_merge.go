@@ -0,0 +1,381 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "sort"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Survivorship link recording that two patient IDs belong to one person.
+// Records keep the patient ID they were created under; while the merge is
+// active, queries on either ID cover both. Unmerging ends the link but
+// keeps this entry, so every merge ever made stays on the ledger.
+type PatientMerge struct {
+    ID             string            `json:"id"`
+    SurvivorID     string            `json:"survivor_id"`
+    MergedID       string            `json:"merged_id"`
+    Status         string            `json:"status"`
+    Merged         SignedTransition  `json:"merged"`
+    Unmerged       *SignedTransition `json:"unmerged,omitempty"`
+}
+
+const (
+    patientMergeObjectType  = "patientMerge"
+    patientMergeLinkIndex   = "patientMergeLink"
+    patientMergeMemberIndex = "patientMergeMember"
+    patientMergeHistIndex   = "patientMergeHistory"
+
+    patientMergeActive   = "active"
+    patientMergeReversed = "reversed"
+)
+
+// Load a merge by ID
+func readPatientMerge(stub shim.ChaincodeStubInterface, mergeID string) (*PatientMerge, string, error) {
+    key, err := stub.CreateCompositeKey(patientMergeObjectType, []string{mergeID})
+    if err != nil {
+        return nil, "", errors.New("Failed to create patient merge key")
+    }
+    mergeBytes, err := stub.GetState(key)
+    if err != nil {
+        return nil, "", errors.New("Error reading patient merge")
+    }
+    if mergeBytes == nil {
+        return nil, key, nil
+    }
+    var merge PatientMerge
+    if err := json.Unmarshal(mergeBytes, &merge); err != nil {
+        return nil, "", errors.New("Failed to unmarshal patient merge JSON")
+    }
+    return &merge, key, nil
+}
+
+// Store a merge under its key
+func putPatientMerge(stub shim.ChaincodeStubInterface, key string, merge *PatientMerge) ([]byte, error) {
+    mergeJSON, err := json.Marshal(merge)
+    if err != nil {
+        return nil, errors.New("Failed to marshal patient merge JSON")
+    }
+    if err := stub.PutState(key, mergeJSON); err != nil {
+        return nil, errors.New("Failed to store patient merge")
+    }
+    return mergeJSON, nil
+}
+
+// ID of the active merge that folded patientID into another patient, or ""
+func activeMergeOf(stub shim.ChaincodeStubInterface, patientID string) (string, error) {
+    key, err := stub.CreateCompositeKey(patientMergeLinkIndex, []string{patientID})
+    if err != nil {
+        return "", errors.New("Failed to create patient merge link key")
+    }
+    mergeID, err := stub.GetState(key)
+    if err != nil {
+        return "", errors.New("Error reading patient merge link")
+    }
+    return string(mergeID), nil
+}
+
+// Patient IDs merged into survivorID, in key order
+func mergedPatientIDs(stub shim.ChaincodeStubInterface, survivorID string) ([]string, error) {
+    iterator, err := stub.GetStateByPartialCompositeKey(patientMergeMemberIndex, []string{survivorID})
+    if err != nil {
+        return nil, errors.New("Failed to query merged patients")
+    }
+    defer iterator.Close()
+    merged := []string{}
+    for iterator.HasNext() {
+        entry, err := iterator.Next()
+        if err != nil {
+            return nil, errors.New("Failed to iterate merged patients")
+        }
+        _, parts, err := stub.SplitCompositeKey(entry.Key)
+        if err != nil || len(parts) != 2 {
+            return nil, errors.New("Invalid merged patient entry")
+        }
+        merged = append(merged, parts[1])
+    }
+    return merged, nil
+}
+
+// Every patient ID that currently identifies the same person as patientID,
+// survivor first. Merges are one level deep, so this is the survivor and
+// the IDs merged into it.
+func patientIDGroup(stub shim.ChaincodeStubInterface, patientID string) ([]string, error) {
+    survivorID := patientID
+    mergeID, err := activeMergeOf(stub, patientID)
+    if err != nil {
+        return nil, err
+    }
+    if mergeID != "" {
+        merge, _, err := readPatientMerge(stub, mergeID)
+        if err != nil {
+            return nil, err
+        }
+        if merge == nil {
+            return nil, errors.New("Patient merge not found")
+        }
+        survivorID = merge.SurvivorID
+    }
+    merged, err := mergedPatientIDs(stub, survivorID)
+    if err != nil {
+        return nil, err
+    }
+    sort.Strings(merged)
+    return append([]string{survivorID}, merged...), nil
+}
+
+// Whether two patient IDs currently identify the same person
+func samePatient(stub shim.ChaincodeStubInterface, a, b string) (bool, error) {
+    if a == b {
+        return true, nil
+    }
+    group, err := patientIDGroup(stub, a)
+    if err != nil {
+        return false, err
+    }
+    for _, id := range group {
+        if id == b {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+// Add or remove the index entries for an active merge
+func setPatientMergeLinks(stub shim.ChaincodeStubInterface, merge *PatientMerge, active bool) error {
+    linkKey, err := stub.CreateCompositeKey(patientMergeLinkIndex, []string{merge.MergedID})
+    if err != nil {
+        return errors.New("Failed to create patient merge link key")
+    }
+    memberKey, err := stub.CreateCompositeKey(patientMergeMemberIndex, []string{merge.SurvivorID, merge.MergedID})
+    if err != nil {
+        return errors.New("Failed to create merged patient key")
+    }
+    if !active {
+        if err := stub.DelState(linkKey); err != nil {
+            return errors.New("Failed to delete patient merge link")
+        }
+        if err := stub.DelState(memberKey); err != nil {
+            return errors.New("Failed to delete merged patient entry")
+        }
+        return nil
+    }
+    if err := stub.PutState(linkKey, []byte(merge.ID)); err != nil {
+        return errors.New("Failed to store patient merge link")
+    }
+    if err := stub.PutState(memberKey, []byte{0x00}); err != nil {
+        return errors.New("Failed to store merged patient entry")
+    }
+    return nil
+}
+
+// Admin-only: merge a duplicate patient ID into the surviving one. The
+// duplicate must not already be merged or have IDs merged into it, and the
+// survivor must not itself be merged, so groups stay one level deep.
+func (t *PatientCareChaincode) mergePatients(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 4 || nonceArgs == nil {
+        return shim.Error("Expected arguments: merge ID, surviving patient ID, merged patient ID, reason, nonce, expiry, signature")
+    }
+    mergeID, survivorID, mergedID, reason := args[0], args[1], args[2], args[3]
+    if err := validateID("merge_id", mergeID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("survivor_id", survivorID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("merged_id", mergedID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if survivorID == mergedID {
+        return shim.Error("A patient cannot be merged into itself")
+    }
+    if reason == "" || len(reason) > 500 {
+        return shim.Error((&ValidationError{Field: "reason", Reason: "must be 1-500 characters"}).Error())
+    }
+    if err := requireRole(stub, "admin"); err != nil {
+        return shim.Error(err.Error())
+    }
+    adminID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    for _, id := range []string{survivorID, mergedID} {
+        existing, err := activeMergeOf(stub, id)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if existing != "" {
+            return shim.Error("Patient " + id + " is already merged by " + existing)
+        }
+    }
+    members, err := mergedPatientIDs(stub, mergedID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if len(members) > 0 {
+        return shim.Error("Patient " + mergedID + " has patients merged into it; unmerge them first")
+    }
+    merge, key, err := readPatientMerge(stub, mergeID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if merge != nil {
+        return shim.Error("Patient merge already exists")
+    }
+    if err := consumeNonce(stub, "mergePatients", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    merge = &PatientMerge{
+        ID:         mergeID,
+        SurvivorID: survivorID,
+        MergedID:   mergedID,
+        Status:     patientMergeActive,
+        Merged:     newSignedTransition(stub, "merge", "", patientMergeActive, reason, adminID, args, nonceArgs, now),
+    }
+    if err := setPatientMergeLinks(stub, merge, true); err != nil {
+        return shim.Error(err.Error())
+    }
+    for _, id := range []string{survivorID, mergedID} {
+        histKey, err := stub.CreateCompositeKey(patientMergeHistIndex, []string{id, mergeID})
+        if err != nil {
+            return shim.Error("Failed to create patient merge history key")
+        }
+        if err := stub.PutState(histKey, []byte{0x00}); err != nil {
+            return shim.Error("Failed to store patient merge history entry")
+        }
+    }
+    mergeJSON, err := putPatientMerge(stub, key, merge)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "PatientsMerged", ObjectID: mergeID, PatientID: survivorID, ActorID: adminID, Status: merge.Status, CounterpartyID: mergedID}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(mergeJSON)
+}
+
+// Admin-only: reverse an active merge, so each patient ID again covers only
+// its own records. The merge stays on the ledger marked reversed.
+func (t *PatientCareChaincode) unmergePatients(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 2 || nonceArgs == nil {
+        return shim.Error("Expected arguments: merge ID, reason, nonce, expiry, signature")
+    }
+    mergeID, reason := args[0], args[1]
+    if err := validateID("merge_id", mergeID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if reason == "" || len(reason) > 500 {
+        return shim.Error((&ValidationError{Field: "reason", Reason: "must be 1-500 characters"}).Error())
+    }
+    if err := requireRole(stub, "admin"); err != nil {
+        return shim.Error(err.Error())
+    }
+    adminID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    merge, key, err := readPatientMerge(stub, mergeID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if merge == nil {
+        return shim.Error("Patient merge not found")
+    }
+    if merge.Status != patientMergeActive {
+        return shim.Error("Patient merge is already reversed")
+    }
+    if err := consumeNonce(stub, "unmergePatients", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err := setPatientMergeLinks(stub, merge, false); err != nil {
+        return shim.Error(err.Error())
+    }
+    unmerged := newSignedTransition(stub, "unmerge", patientMergeActive, patientMergeReversed, reason, adminID, args, nonceArgs, now)
+    merge.Unmerged = &unmerged
+    merge.Status = patientMergeReversed
+    mergeJSON, err := putPatientMerge(stub, key, merge)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "PatientsUnmerged", ObjectID: mergeID, PatientID: merge.SurvivorID, ActorID: adminID, Status: merge.Status, CounterpartyID: merge.MergedID}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(mergeJSON)
+}
+
+// Every merge, active or reversed, that involved a patient ID, along with
+// the IDs it currently resolves to. Admins and the patient only.
+func (t *PatientCareChaincode) getPatientMerges(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: patient ID")
+    }
+    patientID := args[0]
+    if err := validateID("patient_id", patientID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if !hasRole(stub, "admin") {
+        callerID, err := callerUserID(stub)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        same, err := samePatient(stub, callerID, patientID)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if !same {
+            return shim.Error("Access denied: admins and the patient only")
+        }
+    }
+    group, err := patientIDGroup(stub, patientID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    iterator, err := stub.GetStateByPartialCompositeKey(patientMergeHistIndex, []string{patientID})
+    if err != nil {
+        return shim.Error("Failed to query patient merges")
+    }
+    defer iterator.Close()
+    merges := []PatientMerge{}
+    for iterator.HasNext() {
+        entry, err := iterator.Next()
+        if err != nil {
+            return shim.Error("Failed to iterate patient merges")
+        }
+        _, parts, err := stub.SplitCompositeKey(entry.Key)
+        if err != nil || len(parts) != 2 {
+            return shim.Error("Invalid patient merge history entry")
+        }
+        merge, _, err := readPatientMerge(stub, parts[1])
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if merge != nil {
+            merges = append(merges, *merge)
+        }
+    }
+    resultJSON, err := json.Marshal(map[string]interface{}{
+        "patient_id":  patientID,
+        "patient_ids": group,
+        "merges":      merges,
+    })
+    if err != nil {
+        return shim.Error("Failed to marshal patient merges JSON")
+    }
+
+    return shim.Success(resultJSON)
+}
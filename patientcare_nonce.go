@@ -0,0 +1,205 @@
+package main
+
+import (
+    "crypto/ecdsa"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "regexp"
+    "strconv"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Client nonce recorded on first use, kept until it expires and is pruned
+type UsedNonce struct {
+    Nonce          string    `json:"nonce"`
+    ExpiresAt      time.Time `json:"expires_at"`
+    TxID           string    `json:"tx_id"`
+}
+
+// Composite key namespaces for used nonces and their expiry index
+const (
+    nonceObjectType       = "nonce"
+    nonceExpiryObjectType = "nonceExpiry"
+    nonceArgCount         = 3
+    maxNonceLifetime      = 10 * time.Minute
+    defaultPruneLimit     = 1000
+)
+
+// Nonces are opaque client-generated tokens, e.g. 32 random bytes as hex
+var noncePattern = regexp.MustCompile(`^[A-Za-z0-9_\-]{16,128}$`)
+
+// Split the trailing [nonce, expiry, signature] arguments off a call
+func splitNonceArgs(args []string) ([]string, []string) {
+    if len(args) < nonceArgCount {
+        return args, nil
+    }
+    cut := len(args) - nonceArgCount
+    return args[:cut], args[cut:]
+}
+
+// SHA-256 over fields, each terminated by a zero byte so fields cannot be
+// shifted between each other
+func fieldDigest(fields []string) []byte {
+    hash := sha256.New()
+    for _, field := range fields {
+        hash.Write([]byte(field))
+        hash.Write([]byte{0})
+    }
+    return hash.Sum(nil)
+}
+
+// Digest signed by the client: function, payload arguments, nonce and expiry
+func nonceDigest(function string, payload []string, nonce, expiry string) []byte {
+    return fieldDigest(append(append([]string{function}, payload...), nonce, expiry))
+}
+
+// Verify a client-signed nonce and record it so it cannot be replayed.
+// nonceArgs is [nonce, expiry (unix seconds), signature (hex ASN.1 ECDSA)]
+// and the signature must come from the submitting client's enrollment key.
+func consumeNonce(stub shim.ChaincodeStubInterface, function string, payload []string, nonceArgs []string) error {
+    if len(nonceArgs) != nonceArgCount {
+        return errors.New("Expected nonce, expiry and signature arguments")
+    }
+    nonce, expiry, signature := nonceArgs[0], nonceArgs[1], nonceArgs[2]
+
+    if !noncePattern.MatchString(nonce) {
+        return &ValidationError{Field: "nonce", Reason: "must be 16-128 letters, digits, '_' or '-'"}
+    }
+    expirySeconds, err := strconv.ParseInt(expiry, 10, 64)
+    if err != nil {
+        return &ValidationError{Field: "expiry", Reason: "must be unix seconds"}
+    }
+    expiresAt := time.Unix(expirySeconds, 0).UTC()
+
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return err
+    }
+    if !now.Before(expiresAt) {
+        return errors.New("Nonce has expired")
+    }
+    if expiresAt.Sub(now) > maxNonceLifetime {
+        return fmt.Errorf("Nonce expiry must be within %s of the transaction time", maxNonceLifetime)
+    }
+
+    cert, err := cid.GetX509Certificate(stub)
+    if err != nil || cert == nil {
+        return errors.New("Failed to read client certificate")
+    }
+    publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+    if !ok {
+        return errors.New("Client certificate does not hold an ECDSA key")
+    }
+    signatureBytes, err := hex.DecodeString(signature)
+    if err != nil {
+        return &ValidationError{Field: "signature", Reason: "must be hex-encoded"}
+    }
+    if !ecdsa.VerifyASN1(publicKey, nonceDigest(function, payload, nonce, expiry), signatureBytes) {
+        return errors.New("Invalid nonce signature")
+    }
+
+    nonceKey, err := stub.CreateCompositeKey(nonceObjectType, []string{nonce})
+    if err != nil {
+        return errors.New("Failed to create nonce key")
+    }
+    existing, err := stub.GetState(nonceKey)
+    if err != nil {
+        return errors.New("Error checking nonce")
+    }
+    if existing != nil {
+        return errors.New("Replay attack detected: Nonce already used")
+    }
+
+    used := UsedNonce{Nonce: nonce, ExpiresAt: expiresAt, TxID: stub.GetTxID()}
+    usedJSON, err := json.Marshal(used)
+    if err != nil {
+        return errors.New("Failed to marshal nonce JSON")
+    }
+    if err := stub.PutState(nonceKey, usedJSON); err != nil {
+        return errors.New("Failed to store nonce")
+    }
+
+    // Zero-padded expiry keeps the index in time order for pruning
+    expiryKey, err := stub.CreateCompositeKey(nonceExpiryObjectType, []string{fmt.Sprintf("%020d", expirySeconds), nonce})
+    if err != nil {
+        return errors.New("Failed to create nonce expiry key")
+    }
+    return stub.PutState(expiryKey, []byte{0})
+}
+
+// Require the client to carry the given role attribute
+func requireRole(stub shim.ChaincodeStubInterface, role string) error {
+    if err := cid.AssertAttributeValue(stub, "role", role); err != nil {
+        return fmt.Errorf("Only %s users can perform this action", role)
+    }
+    return nil
+}
+
+// Admin-only: delete used nonces whose expiry has passed. Expired nonces are
+// rejected by the expiry check, so they no longer need to be remembered.
+func (t *PatientCareChaincode) pruneNonces(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) > 1 {
+        return shim.Error("Expected optional argument: limit")
+    }
+    if err := requireRole(stub, "admin"); err != nil {
+        return shim.Error(err.Error())
+    }
+    limit := defaultPruneLimit
+    if len(args) == 1 {
+        parsed, err := strconv.Atoi(args[0])
+        if err != nil || parsed <= 0 {
+            return shim.Error("Invalid limit")
+        }
+        limit = parsed
+    }
+
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    iterator, err := stub.GetStateByPartialCompositeKey(nonceExpiryObjectType, []string{})
+    if err != nil {
+        return shim.Error("Failed to query nonce expiry index")
+    }
+    defer iterator.Close()
+
+    pruned := 0
+    for iterator.HasNext() && pruned < limit {
+        entry, err := iterator.Next()
+        if err != nil {
+            return shim.Error("Failed to read nonce expiry index")
+        }
+        _, parts, err := stub.SplitCompositeKey(entry.Key)
+        if err != nil || len(parts) != 2 {
+            return shim.Error("Malformed nonce expiry key")
+        }
+        expirySeconds, err := strconv.ParseInt(parts[0], 10, 64)
+        if err != nil {
+            return shim.Error("Malformed nonce expiry key")
+        }
+        if now.Before(time.Unix(expirySeconds, 0)) {
+            break
+        }
+
+        nonceKey, err := stub.CreateCompositeKey(nonceObjectType, []string{parts[1]})
+        if err != nil {
+            return shim.Error("Failed to create nonce key")
+        }
+        if err := stub.DelState(nonceKey); err != nil {
+            return shim.Error("Failed to delete nonce")
+        }
+        if err := stub.DelState(entry.Key); err != nil {
+            return shim.Error("Failed to delete nonce expiry entry")
+        }
+        pruned++
+    }
+
+    return shim.Success([]byte(fmt.Sprintf("Pruned %d expired nonces", pruned)))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestNonceExpiryFollowsLedgerClock(t *testing.T) {
+    ledger := newTestLedger(t)
+    doctor := ledger.client("d1", "doctor")
+    didID := ledger.registerDID(doctor)
+    ledger.as(doctor)
+    recordArgs := func(recordID string) []string {
+        dataHash := generateHash(recordID)
+        return []string{recordID, dataHash, "p1", didID, doctor.sign(t, recordAuthorDigest(recordID, "p1", dataHash, didID))}
+    }
+
+    stale := ledger.signed("createRecord", recordArgs("r1")...)
+    ledger.advance(2 * time.Minute)
+    if response := ledger.invoke("createRecord", stale...); response.Message != "Nonce has expired" {
+        t.Fatalf("stale nonce: got %q, want it to have expired", response.Message)
+    }
+
+    distant := ledger.signedFor(maxNonceLifetime+time.Minute, "createRecord", recordArgs("r1")...)
+    if response := ledger.invoke("createRecord", distant...); !strings.Contains(response.Message, "Nonce expiry must be within") {
+        t.Fatalf("distant expiry: got %q", response.Message)
+    }
+
+    fresh := ledger.signed("createRecord", recordArgs("r1")...)
+    if response := ledger.invoke("createRecord", fresh...); response.Message != "" {
+        t.Fatalf("createRecord: %s", response.Message)
+    }
+    if response := ledger.invoke("createRecord", fresh...); !strings.Contains(response.Message, "Replay attack detected") {
+        t.Fatalf("replayed nonce: got %q", response.Message)
+    }
+}
+
+func TestPruneNoncesKeepsUnexpired(t *testing.T) {
+    ledger := newTestLedger(t)
+    ledger.createRecord(ledger.client("d1", "doctor"), "r1", "p1")
+    if got := ledger.stateKeys(nonceObjectType); got != 1 {
+        t.Fatalf("%d nonces stored, want 1", got)
+    }
+
+    ledger.as(ledger.client("a1", "admin"))
+    ledger.mustQuery("pruneNonces")
+    if got := ledger.stateKeys(nonceObjectType); got != 1 {
+        t.Fatalf("%d nonces after pruning before expiry, want 1", got)
+    }
+
+    ledger.advance(time.Hour)
+    if got := string(ledger.mustQuery("pruneNonces")); got != "Pruned 1 expired nonces" {
+        t.Fatalf("pruneNonces: %s", got)
+    }
+    if got := ledger.stateKeys(nonceObjectType) + ledger.stateKeys(nonceExpiryObjectType); got != 0 {
+        t.Fatalf("%d nonce keys left after pruning past expiry, want 0", got)
+    }
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "regexp"
+    "strconv"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Prescription issued by a doctor's DID. The clinical fields are PHI and
+// stay in the prescriber org's private data collection as
+// PrescriptionDetails; the channel holds their hash, the fill counters and
+// the status, so a pharmacy can check the details it was handed and that
+// each fill is dispensed once.
+type Prescription struct {
+    ID               string             `json:"id"`
+    PrescriberDID    string             `json:"prescriber_did"`
+    PrescriberID     string             `json:"prescriber_id"`
+    Refills          int                `json:"refills"`
+    RefillsRemaining int                `json:"refills_remaining"`
+    FillCount        int                `json:"fill_count"`
+    Status           string             `json:"status"`
+    DetailsHash      string             `json:"details_hash"`
+    Collection       string             `json:"collection"`
+    IssuedAt         time.Time          `json:"issued_at"`
+    ExpiresAt        time.Time          `json:"expires_at"`
+    LastFilledAt     *time.Time         `json:"last_filled_at,omitempty"`
+    Flags            []string           `json:"flags,omitempty"`
+    History          []SignedTransition `json:"history"`
+}
+
+// Private clinical fields of a prescription, passed in the transient map.
+// The DID signature covers them, so a pharmacy can tell a genuine
+// prescription from a counterfeit one before dispensing.
+type PrescriptionDetails struct {
+    PrescriptionID string `json:"prescription_id"`
+    PatientID      string `json:"patient_id"`
+    DrugCode       string `json:"drug_code"`
+    Dose           string `json:"dose"`
+    Quantity       int    `json:"quantity"`
+    DaysSupply     int    `json:"days_supply"`
+    DIDSignature   string `json:"did_signature"`
+    Salt           string `json:"salt"`
+}
+
+const (
+    prescriptionObjectType   = "prescription"
+    prescriptionDetailsType  = "prescriptionDetails"
+    prescriptionTransientKey = "prescription"
+
+    prescriptionStatusActive    = "active"
+    prescriptionStatusCompleted = "completed"
+    prescriptionStatusCancelled = "cancelled"
+
+    maxRefills           = 12
+    maxDoseLength        = 200
+    maxQuantity          = 10000
+    maxDaysSupply        = 365
+    prescriptionValidity = 365 * 24 * time.Hour
+)
+
+// Dose instructions: printable text, e.g. "10 mg orally twice daily"
+var dosePattern = regexp.MustCompile(`^[A-Za-z0-9 .,/%()+\-]+$`)
+
+// Digest the prescriber signs with their DID key
+func prescriptionDigest(prescriptionID, patientID, prescriberDID, drugCode, dose string, quantity, daysSupply, refills int) []byte {
+    return fieldDigest([]string{"prescription", prescriptionID, patientID, prescriberDID, drugCode, dose, strconv.Itoa(quantity), strconv.Itoa(daysSupply), strconv.Itoa(refills)})
+}
+
+// Parse a bounded positive integer argument
+func parseBoundedInt(field, value string, min, max int) (int, error) {
+    n, err := strconv.Atoi(value)
+    if err != nil || n < min || n > max {
+        return 0, &ValidationError{Field: field, Reason: fmt.Sprintf("must be between %d and %d", min, max)}
+    }
+    return n, nil
+}
+
+// Load a prescription by ID
+func readPrescription(stub shim.ChaincodeStubInterface, prescriptionID string) (*Prescription, string, error) {
+    key, err := stub.CreateCompositeKey(prescriptionObjectType, []string{prescriptionID})
+    if err != nil {
+        return nil, "", errors.New("Failed to create prescription key")
+    }
+    prescriptionBytes, err := stub.GetState(key)
+    if err != nil {
+        return nil, "", errors.New("Error reading prescription")
+    }
+    if prescriptionBytes == nil {
+        return nil, key, nil
+    }
+    var prescription Prescription
+    if err := json.Unmarshal(prescriptionBytes, &prescription); err != nil {
+        return nil, "", errors.New("Failed to unmarshal prescription JSON")
+    }
+    return &prescription, key, nil
+}
+
+// Store a prescription and return its JSON
+func putPrescription(stub shim.ChaincodeStubInterface, key string, prescription *Prescription) ([]byte, error) {
+    prescriptionJSON, err := json.Marshal(prescription)
+    if err != nil {
+        return nil, errors.New("Failed to marshal prescription JSON")
+    }
+    if err := stub.PutState(key, prescriptionJSON); err != nil {
+        return nil, errors.New("Failed to store prescription")
+    }
+    return prescriptionJSON, nil
+}
+
+// Read a prescription's details from the transient map and validate them
+func readTransientPrescription(stub shim.ChaincodeStubInterface, prescriptionID string) (*PrescriptionDetails, error) {
+    transient, err := stub.GetTransient()
+    if err != nil {
+        return nil, errors.New("Failed to read transient data")
+    }
+    detailsBytes, ok := transient[prescriptionTransientKey]
+    if !ok {
+        return nil, errors.New("Expected prescription details in transient key " + prescriptionTransientKey)
+    }
+    var details PrescriptionDetails
+    if err := json.Unmarshal(detailsBytes, &details); err != nil {
+        return nil, errors.New("Invalid prescription details in transient data")
+    }
+    if details.PrescriptionID == "" {
+        details.PrescriptionID = prescriptionID
+    }
+    if details.PrescriptionID != prescriptionID {
+        return nil, errors.New("Prescription details are for a different prescription")
+    }
+    if err := validateID("patient_id", details.PatientID); err != nil {
+        return nil, err
+    }
+    if err := validateID("drug_code", details.DrugCode); err != nil {
+        return nil, err
+    }
+    if len(details.Dose) == 0 || len(details.Dose) > maxDoseLength || !dosePattern.MatchString(details.Dose) {
+        return nil, &ValidationError{Field: "dose", Reason: fmt.Sprintf("must be 1-%d characters of plain text", maxDoseLength)}
+    }
+    if details.Quantity < 1 || details.Quantity > maxQuantity {
+        return nil, &ValidationError{Field: "quantity", Reason: fmt.Sprintf("must be between %d and %d", 1, maxQuantity)}
+    }
+    if details.DaysSupply < 1 || details.DaysSupply > maxDaysSupply {
+        return nil, &ValidationError{Field: "days_supply", Reason: fmt.Sprintf("must be between %d and %d", 1, maxDaysSupply)}
+    }
+    // The salt must come from the client so every endorser computes the same hash
+    if len(details.Salt) < minPHISaltLength {
+        return nil, fmt.Errorf("Prescription salt must be at least %d characters", minPHISaltLength)
+    }
+    return &details, nil
+}
+
+// Hash of a prescription's details, the only part visible on the channel
+func hashPrescriptionDetails(details *PrescriptionDetails) (string, []byte, error) {
+    detailsJSON, err := json.Marshal(details)
+    if err != nil {
+        return "", nil, errors.New("Failed to marshal prescription details JSON")
+    }
+    return generateHash(string(detailsJSON)), detailsJSON, nil
+}
+
+// Load a prescription's details from the private data collection, checked
+// against the hash anchored on the channel
+func readPrescriptionDetails(stub shim.ChaincodeStubInterface, prescription *Prescription) (*PrescriptionDetails, error) {
+    key, err := stub.CreateCompositeKey(prescriptionDetailsType, []string{prescription.ID})
+    if err != nil {
+        return nil, errors.New("Failed to create prescription details key")
+    }
+    detailsBytes, err := stub.GetPrivateData(prescription.Collection, key)
+    if err != nil || detailsBytes == nil {
+        return nil, errors.New("Prescription details not found or not accessible to this organization")
+    }
+    if generateHash(string(detailsBytes)) != prescription.DetailsHash {
+        return nil, errors.New("Prescription details do not match the hash anchored on the channel")
+    }
+    var details PrescriptionDetails
+    if err := json.Unmarshal(detailsBytes, &details); err != nil {
+        return nil, errors.New("Failed to unmarshal prescription details JSON")
+    }
+    return &details, nil
+}
+
+// Doctor issues a prescription signed with their DID key. The clinical
+// fields come in the transient map and are kept in the org's private data
+// collection; the channel gets the prescription with their hash.
+func (t *PatientCareChaincode) issuePrescription(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 3 || nonceArgs == nil {
+        return shim.Error("Expected arguments: prescription ID, prescriber DID, refills, nonce, expiry, signature (details in transient key " + prescriptionTransientKey + ")")
+    }
+    prescriptionID, prescriberDID := args[0], args[1]
+    if err := validateID("prescription_id", prescriptionID); err != nil {
+        return shim.Error(err.Error())
+    }
+    refills, err := parseBoundedInt("refills", args[2], 0, maxRefills)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    details, err := readTransientPrescription(stub, prescriptionID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := requireRole(stub, "doctor"); err != nil {
+        return shim.Error(err.Error())
+    }
+    prescriberID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if prescriberID == details.PatientID {
+        return shim.Error("Doctors cannot prescribe for themselves")
+    }
+    did, err := resolveCallerDID(stub, prescriberDID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := verifyDIDSignature(did, prescriptionDigest(prescriptionID, details.PatientID, prescriberDID, details.DrugCode, details.Dose, details.Quantity, details.DaysSupply, refills), details.DIDSignature); err != nil {
+        return shim.Error(err.Error())
+    }
+    collection, err := phiCollectionName(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    existing, key, err := readPrescription(stub, prescriptionID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if existing != nil {
+        return shim.Error("Prescription already exists")
+    }
+    if err := consumeNonce(stub, "issuePrescription", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    detailsHash, detailsJSON, err := hashPrescriptionDetails(details)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    prescription := &Prescription{
+        ID:               prescriptionID,
+        PrescriberDID:    prescriberDID,
+        PrescriberID:     prescriberID,
+        Refills:          refills,
+        RefillsRemaining: refills,
+        Status:           prescriptionStatusActive,
+        DetailsHash:      detailsHash,
+        Collection:       collection,
+        IssuedAt:         now,
+        ExpiresAt:        now.Add(prescriptionValidity),
+    }
+    if err := applyControlledSubstanceRules(stub, prescription, details, now); err != nil {
+        return shim.Error(err.Error())
+    }
+    prescription.History = []SignedTransition{newSignedTransition(stub, "issue", "", prescriptionStatusActive, "", prescriberID, args, nonceArgs, now)}
+
+    detailsKey, err := stub.CreateCompositeKey(prescriptionDetailsType, []string{prescriptionID})
+    if err != nil {
+        return shim.Error("Failed to create prescription details key")
+    }
+    if err := stub.PutPrivateData(collection, detailsKey, detailsJSON); err != nil {
+        return shim.Error("Failed to store prescription details")
+    }
+    prescriptionJSON, err := putPrescription(stub, key, prescription)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    // A flagged issue is announced as a ControlledSubstanceFlag instead;
+    // reviewers read the flags with getPrescription
+    event := LedgerEvent{Type: "PrescriptionIssued", ObjectID: prescriptionID, ActorID: prescriberID, Status: prescription.Status}
+    if len(prescription.Flags) > 0 {
+        event.Type = controlledSubstanceFlagEvent
+    }
+    if err := emitLedgerEvent(stub, event); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(prescriptionJSON)
+}
+
+// Pharmacist records a dispense. The fill number must be the next one due,
+// so a fill already recorded, at this or any other pharmacy, is rejected.
+// Fill 1 is the original fill; every later fill uses up a refill. The
+// pharmacy passes the details it was handed in the transient map; they must
+// match the hash on the channel.
+func (t *PatientCareChaincode) dispensePrescription(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 2 || nonceArgs == nil {
+        return shim.Error("Expected arguments: prescription ID, fill number, nonce, expiry, signature (details in transient key " + prescriptionTransientKey + ")")
+    }
+    prescriptionID := args[0]
+    if err := validateID("prescription_id", prescriptionID); err != nil {
+        return shim.Error(err.Error())
+    }
+    fillNumber, err := strconv.Atoi(args[1])
+    if err != nil || fillNumber < 1 {
+        return shim.Error((&ValidationError{Field: "fill_number", Reason: "must be a positive integer"}).Error())
+    }
+    if err := requireRole(stub, "pharmacist"); err != nil {
+        return shim.Error(err.Error())
+    }
+    pharmacistID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    pharmacyMSP, err := cid.GetMSPID(stub)
+    if err != nil {
+        return shim.Error("Failed to read client MSP ID")
+    }
+
+    prescription, key, err := readPrescription(stub, prescriptionID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if prescription == nil {
+        return shim.Error("Prescription not found")
+    }
+    if prescription.Status != prescriptionStatusActive {
+        return shim.Error(fmt.Sprintf("Cannot dispense a prescription that is %s", prescription.Status))
+    }
+    if fillNumber <= prescription.FillCount {
+        return shim.Error(fmt.Sprintf("Fill %d has already been dispensed", fillNumber))
+    }
+    if fillNumber != prescription.FillCount+1 {
+        return shim.Error(fmt.Sprintf("Next fill due is %d", prescription.FillCount+1))
+    }
+    if prescription.FillCount > 0 && prescription.RefillsRemaining == 0 {
+        return shim.Error("No refills remaining")
+    }
+    details, err := readTransientPrescription(stub, prescriptionID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    detailsHash, _, err := hashPrescriptionDetails(details)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if detailsHash != prescription.DetailsHash {
+        return shim.Error("Prescription details do not match the hash anchored on the channel")
+    }
+    if err := consumeNonce(stub, "dispensePrescription", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !now.Before(prescription.ExpiresAt) {
+        return shim.Error("Prescription has expired")
+    }
+    if err := checkControlledRefill(stub, prescription, details, now); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if prescription.FillCount > 0 {
+        prescription.RefillsRemaining--
+    }
+    prescription.FillCount++
+    prescription.LastFilledAt = &now
+    to := prescriptionStatusActive
+    if prescription.RefillsRemaining == 0 {
+        to = prescriptionStatusCompleted
+    }
+    detail := fmt.Sprintf("fill %d at %s", fillNumber, pharmacyMSP)
+    prescription.History = append(prescription.History, newSignedTransition(stub, "dispense", prescription.Status, to, detail, pharmacistID, args, nonceArgs, now))
+    prescription.Status = to
+
+    prescriptionJSON, err := putPrescription(stub, key, prescription)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "PrescriptionDispensed", ObjectID: prescriptionID, ActorID: pharmacistID, Status: prescription.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(prescriptionJSON)
+}
+
+// Cancel an active prescription, with a reason code; the prescriber or an
+// admin only
+func (t *PatientCareChaincode) cancelPrescription(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 2 || nonceArgs == nil {
+        return shim.Error("Expected arguments: prescription ID, reason code, nonce, expiry, signature")
+    }
+    prescriptionID, reasonCode := args[0], args[1]
+    if err := validateID("prescription_id", prescriptionID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("reason_code", reasonCode); err != nil {
+        return shim.Error(err.Error())
+    }
+    actorID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    prescription, key, err := readPrescription(stub, prescriptionID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if prescription == nil {
+        return shim.Error("Prescription not found")
+    }
+    if actorID != prescription.PrescriberID && !hasRole(stub, "admin") {
+        return shim.Error("Only the prescriber or an admin can cancel a prescription")
+    }
+    if prescription.Status != prescriptionStatusActive {
+        return shim.Error(fmt.Sprintf("Cannot cancel a prescription that is %s", prescription.Status))
+    }
+    if err := consumeNonce(stub, "cancelPrescription", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    prescription.History = append(prescription.History, newSignedTransition(stub, "cancel", prescription.Status, prescriptionStatusCancelled, reasonCode, actorID, args, nonceArgs, now))
+    prescription.Status = prescriptionStatusCancelled
+
+    prescriptionJSON, err := putPrescription(stub, key, prescription)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "PrescriptionCancelled", ObjectID: prescriptionID, ActorID: actorID, Status: prescription.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(prescriptionJSON)
+}
+
+// Whether the caller may read a prescription: its prescriber, pharmacists,
+// admins or, where the details are readable, its patient
+func authorizePrescriptionRead(stub shim.ChaincodeStubInterface, prescription *Prescription) error {
+    if hasRole(stub, "admin") || hasRole(stub, "pharmacist") {
+        return nil
+    }
+    callerID, err := callerUserID(stub)
+    if err != nil {
+        return err
+    }
+    if callerID == prescription.PrescriberID {
+        return nil
+    }
+    details, err := readPrescriptionDetails(stub, prescription)
+    if err != nil || callerID != details.PatientID {
+        return errors.New("Access denied: not the patient or prescriber")
+    }
+    return nil
+}
+
+// Retrieve a prescription's channel record
+func (t *PatientCareChaincode) getPrescription(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: prescription ID")
+    }
+    prescriptionID := args[0]
+    if err := validateID("prescription_id", prescriptionID); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    prescription, key, err := readPrescription(stub, prescriptionID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if prescription == nil {
+        return shim.Error("Prescription not found")
+    }
+    if err := authorizePrescriptionRead(stub, prescription); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    prescriptionBytes, err := stub.GetState(key)
+    if err != nil {
+        return shim.Error("Error reading prescription")
+    }
+    return shim.Success(prescriptionBytes)
+}
+
+// Retrieve a prescription's details from the private data collection,
+// checked against the hash anchored on the channel
+func (t *PatientCareChaincode) getPrescriptionDetails(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: prescription ID")
+    }
+    if err := validateID("prescription_id", args[0]); err != nil {
+        return shim.Error(err.Error())
+    }
+    prescription, _, err := readPrescription(stub, args[0])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if prescription == nil {
+        return shim.Error("Prescription not found")
+    }
+    if err := authorizePrescriptionRead(stub, prescription); err != nil {
+        return shim.Error(err.Error())
+    }
+    details, err := readPrescriptionDetails(stub, prescription)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    detailsJSON, err := json.Marshal(details)
+    if err != nil {
+        return shim.Error("Failed to marshal prescription details JSON")
+    }
+    return shim.Success(detailsJSON)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+    "encoding/json"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+const testPrescriptionSalt = "0123456789abcdef0123456789abcdef"
+
+// prescriptionDetails returns the details doctor hands the patient for a
+// prescription, signed with doctor's DID
+func (l *testLedger) prescriptionDetails(doctor *testClient, prescriptionID, patientID, drugCode string, quantity, daysSupply, refills int) PrescriptionDetails {
+    l.t.Helper()
+    didID := l.registerDID(doctor)
+    details := PrescriptionDetails{
+        PrescriptionID: prescriptionID,
+        PatientID:      patientID,
+        DrugCode:       drugCode,
+        Dose:           "10 mg orally twice daily",
+        Quantity:       quantity,
+        DaysSupply:     daysSupply,
+        Salt:           testPrescriptionSalt,
+    }
+    details.DIDSignature = doctor.sign(l.t, prescriptionDigest(prescriptionID, patientID, didID, drugCode, details.Dose, quantity, daysSupply, refills))
+    return details
+}
+
+// withPrescription puts details in the transient map for the next invoke
+func (l *testLedger) withPrescription(details PrescriptionDetails) *testLedger {
+    detailsJSON, err := json.Marshal(details)
+    if err != nil {
+        l.t.Fatal(err)
+    }
+    l.transient = map[string][]byte{prescriptionTransientKey: detailsJSON}
+    return l
+}
+
+// issuePrescription has doctor issue a 30-day prescription and returns its
+// details
+func (l *testLedger) issuePrescription(doctor *testClient, prescriptionID, patientID, drugCode string, refills int) PrescriptionDetails {
+    l.t.Helper()
+    details := l.prescriptionDetails(doctor, prescriptionID, patientID, drugCode, 60, 30, refills)
+    l.as(doctor).withPrescription(details).mustInvoke("issuePrescription", prescriptionID, "did:mediNet:"+doctor.userID, strconv.Itoa(refills))
+    return details
+}
+
+// dispense has pharmacist record fill of prescriptionID with details
+func (l *testLedger) dispense(pharmacist *testClient, details PrescriptionDetails, fill int) *Prescription {
+    l.t.Helper()
+    var prescription Prescription
+    payload := l.as(pharmacist).withPrescription(details).mustInvoke("dispensePrescription", details.PrescriptionID, strconv.Itoa(fill))
+    if err := json.Unmarshal(payload, &prescription); err != nil {
+        l.t.Fatal(err)
+    }
+    return &prescription
+}
+
+func (l *testLedger) lastLedgerEvent() LedgerEvent {
+    l.t.Helper()
+    var event LedgerEvent
+    if err := json.Unmarshal(l.lastEventPayload, &event); err != nil {
+        l.t.Fatal(err)
+    }
+    return event
+}
+
+func TestPrescriptionDetailsStayPrivate(t *testing.T) {
+    ledger := newTestLedger(t)
+    doctor := ledger.client("d1", "doctor")
+    patient := ledger.client("p1", "patient")
+    details := ledger.issuePrescription(doctor, "rx1", "p1", "RX123", 0)
+
+    if ledger.lastEvent != "PrescriptionIssued" || ledger.lastLedgerEvent().PatientID != "" {
+        t.Fatalf("event %q %s, want PrescriptionIssued without a patient", ledger.lastEvent, ledger.lastEventPayload)
+    }
+    key, _ := ledger.stub.CreateCompositeKey(prescriptionObjectType, []string{"rx1"})
+    public := string(ledger.stub.State[key])
+    for _, phi := range []string{"p1", "RX123", details.Dose, details.DIDSignature} {
+        if strings.Contains(public, phi) {
+            t.Fatalf("channel record %s contains %q", public, phi)
+        }
+    }
+
+    var got PrescriptionDetails
+    if err := json.Unmarshal(ledger.as(patient).mustQuery("getPrescriptionDetails", "rx1"), &got); err != nil {
+        t.Fatal(err)
+    }
+    if got != details {
+        t.Fatalf("details %+v, want %+v", got, details)
+    }
+    response := ledger.as(ledger.client("p2", "patient")).invoke("getPrescription", "rx1")
+    if !strings.Contains(response.Message, "not the patient or prescriber") {
+        t.Fatalf("other patient read the prescription: %q", response.Message)
+    }
+}
+
+func TestPrescriptionIssueVerifiesDIDSignature(t *testing.T) {
+    ledger := newTestLedger(t)
+    doctor := ledger.client("d1", "doctor")
+    details := ledger.prescriptionDetails(doctor, "rx1", "p1", "RX123", 60, 30, 0)
+    details.Quantity = 600
+    ledger.as(doctor).withPrescription(details).mustFail("signature", "issuePrescription", "rx1", "did:mediNet:d1", "0")
+    if ledger.stateKeys(prescriptionObjectType) != 0 {
+        t.Fatal("prescription with a forged quantity was issued")
+    }
+}
+
+func TestPrescriptionDispenseChecksDetails(t *testing.T) {
+    ledger := newTestLedger(t)
+    doctor := ledger.client("d1", "doctor")
+    // The pharmacy is in another org and cannot read the private details
+    pharmacist := newTestClient(t, "Org2MSP", "ph1", "pharmacist")
+    details := ledger.issuePrescription(doctor, "rx1", "p1", "RX123", 1)
+
+    tampered := details
+    tampered.Quantity = 600
+    ledger.as(pharmacist).withPrescription(tampered).mustFail("do not match the hash", "dispensePrescription", "rx1", "1")
+    ledger.mustFail("Expected prescription details", "dispensePrescription", "rx1", "1")
+
+    prescription := ledger.dispense(pharmacist, details, 1)
+    if prescription.FillCount != 1 || prescription.RefillsRemaining != 1 || prescription.Status != prescriptionStatusActive {
+        t.Fatalf("after fill 1: %+v", prescription)
+    }
+    if event := ledger.lastLedgerEvent(); event.Type != "PrescriptionDispensed" || event.PatientID != "" {
+        t.Fatalf("event %+v, want PrescriptionDispensed without a patient", event)
+    }
+}
+
+func TestPrescriptionFillIsDispensedOnce(t *testing.T) {
+    ledger := newTestLedger(t)
+    doctor := ledger.client("d1", "doctor")
+    pharmacist := ledger.client("ph1", "pharmacist")
+    otherPharmacist := newTestClient(t, "Org2MSP", "ph2", "pharmacist")
+    details := ledger.issuePrescription(doctor, "rx1", "p1", "RX123", 2)
+
+    ledger.dispense(pharmacist, details, 1)
+    ledger.as(otherPharmacist).withPrescription(details).mustFail("Fill 1 has already been dispensed", "dispensePrescription", "rx1", "1")
+    ledger.withPrescription(details).mustFail("Next fill due is 2", "dispensePrescription", "rx1", "3")
+}
+
+func TestPrescriptionRefillsRunOut(t *testing.T) {
+    ledger := newTestLedger(t)
+    doctor := ledger.client("d1", "doctor")
+    pharmacist := ledger.client("ph1", "pharmacist")
+    details := ledger.issuePrescription(doctor, "rx1", "p1", "RX123", 1)
+
+    ledger.dispense(pharmacist, details, 1)
+    prescription := ledger.dispense(pharmacist, details, 2)
+    if prescription.FillCount != 2 || prescription.RefillsRemaining != 0 || prescription.Status != prescriptionStatusCompleted {
+        t.Fatalf("after the refill: %+v", prescription)
+    }
+    ledger.withPrescription(details).mustFail("Cannot dispense a prescription that is completed", "dispensePrescription", "rx1", "3")
+}
+
+func TestPrescriptionCancel(t *testing.T) {
+    ledger := newTestLedger(t)
+    doctor := ledger.client("d1", "doctor")
+    pharmacist := ledger.client("ph1", "pharmacist")
+    details := ledger.issuePrescription(doctor, "rx1", "p1", "RX123", 3)
+
+    ledger.as(ledger.client("d2", "doctor")).mustFail("Only the prescriber or an admin", "cancelPrescription", "rx1", "ADVERSE-REACTION")
+    ledger.as(doctor).mustInvoke("cancelPrescription", "rx1", "ADVERSE-REACTION")
+    if event := ledger.lastLedgerEvent(); event.Type != "PrescriptionCancelled" || event.PatientID != "" {
+        t.Fatalf("event %+v, want PrescriptionCancelled without a patient", event)
+    }
+    ledger.mustFail("Cannot cancel a prescription that is cancelled", "cancelPrescription", "rx1", "ADVERSE-REACTION")
+    ledger.as(pharmacist).withPrescription(details).mustFail("Cannot dispense a prescription that is cancelled", "dispensePrescription", "rx1", "1")
+}
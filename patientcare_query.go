@@ -0,0 +1,313 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// One page of a record query. Records the caller may not read are left out
+// without a trace, so a page can be short or empty; only an empty Bookmark
+// marks the last page. Pass it back unchanged to fetch the next one.
+type RecordPage struct {
+    Records        []PatientRecord `json:"records"`
+    Bookmark       string          `json:"bookmark"`
+}
+
+// Index namespaces. Each index entry is a composite key ending in the record
+// ID with a placeholder value; the record itself stays under its plain ID.
+const (
+    patientRecordIndex         = "patientRecord"
+    patientCategoryRecordIndex = "patientCategoryRecord"
+    recordUpdatedIndex         = "recordUpdated"
+
+    defaultPageSize = 20
+    maxPageSize     = 100
+)
+
+// Index keys for a record, in a fixed order so old and new sets can be diffed
+func recordIndexKeys(stub shim.ChaincodeStubInterface, record *PatientRecord) ([]string, error) {
+    keys := []string{}
+    if record.PatientID != "" {
+        key, err := stub.CreateCompositeKey(patientRecordIndex, []string{record.PatientID, record.ID})
+        if err != nil {
+            return nil, errors.New("Failed to create patient index key")
+        }
+        keys = append(keys, key)
+        if record.Category != "" {
+            key, err := stub.CreateCompositeKey(patientCategoryRecordIndex, []string{record.PatientID, record.Category, record.ID})
+            if err != nil {
+                return nil, errors.New("Failed to create category index key")
+            }
+            keys = append(keys, key)
+        }
+    }
+    if !record.UpdatedAt.IsZero() {
+        key, err := stub.CreateCompositeKey(recordUpdatedIndex, []string{updatedIndexTime(record.UpdatedAt), record.ID})
+        if err != nil {
+            return nil, errors.New("Failed to create update index key")
+        }
+        keys = append(keys, key)
+    }
+    return keys, nil
+}
+
+// Zero-padded unix seconds, so index keys sort in time order
+func updatedIndexTime(t time.Time) string {
+    return fmt.Sprintf("%020d", t.Unix())
+}
+
+// Bring the query indexes in line with a record about to be written.
+// previous is nil for a new record.
+func updateRecordIndexes(stub shim.ChaincodeStubInterface, previous, record *PatientRecord) error {
+    newKeys, err := recordIndexKeys(stub, record)
+    if err != nil {
+        return err
+    }
+    keep := make(map[string]bool, len(newKeys))
+    for _, key := range newKeys {
+        keep[key] = true
+    }
+    if previous != nil {
+        oldKeys, err := recordIndexKeys(stub, previous)
+        if err != nil {
+            return err
+        }
+        for _, key := range oldKeys {
+            if keep[key] {
+                delete(keep, key)
+                continue
+            }
+            if err := stub.DelState(key); err != nil {
+                return errors.New("Failed to delete record index entry")
+            }
+        }
+    }
+    for _, key := range newKeys {
+        if !keep[key] {
+            continue
+        }
+        if err := stub.PutState(key, []byte{0x00}); err != nil {
+            return errors.New("Failed to store record index entry")
+        }
+    }
+    return nil
+}
+
+// Parse page size and bookmark arguments
+func parsePageArgs(pageSizeArg, bookmark string) (int32, string, error) {
+    pageSize := defaultPageSize
+    if pageSizeArg != "" {
+        n, err := strconv.Atoi(pageSizeArg)
+        if err != nil || n <= 0 || n > maxPageSize {
+            return 0, "", fmt.Errorf("Page size must be between 1 and %d", maxPageSize)
+        }
+        pageSize = n
+    }
+    if len(bookmark) > 1024 {
+        return 0, "", errors.New("Invalid bookmark")
+    }
+    return int32(pageSize), bookmark, nil
+}
+
+// Collect the records behind a page of index entries, stopping at endKey if
+// one is given. Records the caller may not read without a grant are left
+// out; the number of index entries consumed is returned for paging only.
+func collectRecordPage(stub shim.ChaincodeStubInterface, iterator shim.StateQueryIteratorInterface, metadata *pb.QueryResponseMetadata, endKey string) (*RecordPage, int32, error) {
+    defer iterator.Close()
+    callerID, _ := callerUserID(stub)
+    page := &RecordPage{Records: []PatientRecord{}, Bookmark: metadata.Bookmark}
+    fetched := int32(0)
+    if endKey != "" && page.Bookmark >= endKey {
+        page.Bookmark = ""
+    }
+    for iterator.HasNext() {
+        entry, err := iterator.Next()
+        if err != nil {
+            return nil, 0, errors.New("Failed to iterate record index")
+        }
+        if endKey != "" && entry.Key >= endKey {
+            page.Bookmark = ""
+            break
+        }
+        fetched++
+        _, parts, err := stub.SplitCompositeKey(entry.Key)
+        if err != nil || len(parts) == 0 {
+            return nil, 0, errors.New("Invalid record index entry")
+        }
+        record, err := readRecord(stub, parts[len(parts)-1])
+        if err != nil {
+            return nil, 0, err
+        }
+        allowed, err := canReadRecord(stub, record, callerID)
+        if err != nil {
+            return nil, 0, err
+        }
+        if allowed {
+            page.Records = append(page.Records, *record)
+        }
+    }
+    return page, fetched, nil
+}
+
+// Page through a patient index for every patient ID merged with patientID.
+// A patient with no merges gets Fabric's own bookmark; a merged group walks
+// its IDs in turn, with bookmarks of the form "<ID position>:<bookmark>".
+func collectPatientRecordPage(stub shim.ChaincodeStubInterface, index, patientID string, attributes []string, pageSize int32, bookmark string) (*RecordPage, error) {
+    group, err := patientIDGroup(stub, patientID)
+    if err != nil {
+        return nil, err
+    }
+    if len(group) == 1 {
+        iterator, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(index, append([]string{patientID}, attributes...), pageSize, bookmark)
+        if err != nil {
+            return nil, errors.New("Failed to query patient records")
+        }
+        page, _, err := collectRecordPage(stub, iterator, metadata, "")
+        return page, err
+    }
+
+    position, inner := 0, ""
+    if bookmark != "" {
+        separator := strings.IndexByte(bookmark, ':')
+        if separator < 0 {
+            return nil, errors.New("Invalid bookmark")
+        }
+        position, err = strconv.Atoi(bookmark[:separator])
+        if err != nil || position < 0 || position >= len(group) {
+            return nil, errors.New("Invalid bookmark")
+        }
+        inner = bookmark[separator+1:]
+    }
+    page := &RecordPage{Records: []PatientRecord{}}
+    remaining := pageSize
+    for ; position < len(group) && remaining > 0; position++ {
+        iterator, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(index, append([]string{group[position]}, attributes...), remaining, inner)
+        if err != nil {
+            return nil, errors.New("Failed to query patient records")
+        }
+        part, fetched, err := collectRecordPage(stub, iterator, metadata, "")
+        if err != nil {
+            return nil, err
+        }
+        page.Records = append(page.Records, part.Records...)
+        remaining -= fetched
+        if part.Bookmark != "" {
+            page.Bookmark = fmt.Sprintf("%d:%s", position, part.Bookmark)
+            return page, nil
+        }
+        inner = ""
+    }
+    if position < len(group) {
+        page.Bookmark = fmt.Sprintf("%d:", position)
+    }
+    return page, nil
+}
+
+// Marshal a page into a chaincode response
+func recordPageResponse(page *RecordPage) pb.Response {
+    pageJSON, err := json.Marshal(page)
+    if err != nil {
+        return shim.Error("Failed to marshal record page JSON")
+    }
+    return shim.Success(pageJSON)
+}
+
+// List a patient's records, one page at a time
+func (t *PatientCareChaincode) getRecordsByPatient(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return shim.Error("Expected arguments: patient ID, page size, bookmark")
+    }
+    patientID := args[0]
+    if err := validateID("patient_id", patientID); err != nil {
+        return shim.Error(err.Error())
+    }
+    pageSize, bookmark, err := parsePageArgs(args[1], args[2])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    page, err := collectPatientRecordPage(stub, patientRecordIndex, patientID, nil, pageSize, bookmark)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    return recordPageResponse(page)
+}
+
+// List a patient's records of one category (FHIR resource type)
+func (t *PatientCareChaincode) getRecordsByPatientAndCategory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 4 {
+        return shim.Error("Expected arguments: patient ID, category, page size, bookmark")
+    }
+    patientID, category := args[0], args[1]
+    if err := validateID("patient_id", patientID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("category", category); err != nil {
+        return shim.Error(err.Error())
+    }
+    pageSize, bookmark, err := parsePageArgs(args[2], args[3])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    page, err := collectPatientRecordPage(stub, patientCategoryRecordIndex, patientID, []string{category}, pageSize, bookmark)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    return recordPageResponse(page)
+}
+
+// List records last updated in [from, to), both in unix seconds. Fabric
+// rejects composite keys in plain range reads, so the scan runs over the
+// whole index namespace and starts from the first key at or after from;
+// the peer treats a bookmark as the key to resume at.
+func (t *PatientCareChaincode) getRecordsUpdatedBetween(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 4 {
+        return shim.Error("Expected arguments: from (unix seconds), to (unix seconds), page size, bookmark")
+    }
+    from, err := strconv.ParseInt(args[0], 10, 64)
+    if err != nil || from < 0 {
+        return shim.Error("Invalid from time")
+    }
+    to, err := strconv.ParseInt(args[1], 10, 64)
+    if err != nil || to <= from {
+        return shim.Error("Invalid to time; it must be after from")
+    }
+    pageSize, bookmark, err := parsePageArgs(args[2], args[3])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !hasRole(stub, "admin") && !hasRole(stub, "doctor") {
+        return shim.Error("Only admins and doctors can query records by time")
+    }
+
+    startKey, err := stub.CreateCompositeKey(recordUpdatedIndex, []string{updatedIndexTime(time.Unix(from, 0))})
+    if err != nil {
+        return shim.Error("Failed to create update index key")
+    }
+    endKey, err := stub.CreateCompositeKey(recordUpdatedIndex, []string{updatedIndexTime(time.Unix(to, 0))})
+    if err != nil {
+        return shim.Error("Failed to create update index key")
+    }
+    if bookmark == "" {
+        bookmark = startKey
+    } else if bookmark < startKey || bookmark >= endKey {
+        return shim.Error("Invalid bookmark")
+    }
+
+    iterator, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(recordUpdatedIndex, []string{}, pageSize, bookmark)
+    if err != nil {
+        return shim.Error("Failed to query records")
+    }
+    page, _, err := collectRecordPage(stub, iterator, metadata, endKey)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    return recordPageResponse(page)
+}
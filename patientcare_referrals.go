@@ -0,0 +1,376 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Referral of a patient from one doctor to a specialist. While accepted,
+// the specialist can read the attached records; closing it anchors the
+// specialist's consult note.
+type Referral struct {
+    ID                string             `json:"id"`
+    PatientID         string             `json:"patient_id"`
+    ReferringDoctorID string             `json:"referring_doctor_id"`
+    SpecialistID      string             `json:"specialist_id"`
+    Reason            string             `json:"reason"`
+    RecordIDs         []string           `json:"record_ids"`
+    Status            string             `json:"status"`
+    ConsultNoteHash   string             `json:"consult_note_hash,omitempty"`
+    History           []SignedTransition `json:"history"`
+    CreatedAt         time.Time          `json:"created_at"`
+    UpdatedAt         time.Time          `json:"updated_at"`
+}
+
+const (
+    referralObjectType  = "referral"
+    referralRecordIndex = "referralRecord"
+
+    referralPending   = "pending"
+    referralAccepted  = "accepted"
+    referralDeclined  = "declined"
+    referralCancelled = "cancelled"
+    referralClosed    = "closed"
+
+    maxReferralRecords = 50
+)
+
+// Allowed referral transitions: action -> current status -> new status.
+// create has no current status and is handled by createReferral.
+var referralTransitions = map[string]map[string]string{
+    "accept":  {referralPending: referralAccepted},
+    "decline": {referralPending: referralDeclined},
+    "cancel":  {referralPending: referralCancelled, referralAccepted: referralCancelled},
+    "close":   {referralAccepted: referralClosed},
+}
+
+// Parties allowed to take each action
+var referralActors = map[string][]string{
+    "accept":  {"specialist"},
+    "decline": {"specialist"},
+    "cancel":  {"referrer", "admin"},
+    "close":   {"specialist"},
+}
+
+// Chaincode event each referral action emits
+var referralEvents = map[string]string{
+    "accept":  "ReferralAccepted",
+    "decline": "ReferralDeclined",
+    "cancel":  "ReferralCancelled",
+    "close":   "ReferralClosed",
+}
+
+// Load a referral by ID
+func readReferral(stub shim.ChaincodeStubInterface, referralID string) (*Referral, string, error) {
+    key, err := stub.CreateCompositeKey(referralObjectType, []string{referralID})
+    if err != nil {
+        return nil, "", errors.New("Failed to create referral key")
+    }
+    referralBytes, err := stub.GetState(key)
+    if err != nil {
+        return nil, "", errors.New("Error reading referral")
+    }
+    if referralBytes == nil {
+        return nil, key, nil
+    }
+    var referral Referral
+    if err := json.Unmarshal(referralBytes, &referral); err != nil {
+        return nil, "", errors.New("Failed to unmarshal referral JSON")
+    }
+    return &referral, key, nil
+}
+
+// Store a referral under its key
+func putReferral(stub shim.ChaincodeStubInterface, key string, referral *Referral) ([]byte, error) {
+    referralJSON, err := json.Marshal(referral)
+    if err != nil {
+        return nil, errors.New("Failed to marshal referral JSON")
+    }
+    if err := stub.PutState(key, referralJSON); err != nil {
+        return nil, errors.New("Failed to store referral")
+    }
+    return referralJSON, nil
+}
+
+// Which party to a referral the caller is: referrer, specialist, patient
+// or admin
+func referralParty(stub shim.ChaincodeStubInterface, referral *Referral) (string, string, error) {
+    callerID, err := callerUserID(stub)
+    if err != nil {
+        return "", "", err
+    }
+    switch {
+    case callerID == referral.ReferringDoctorID && hasRole(stub, "doctor"):
+        return "referrer", callerID, nil
+    case callerID == referral.SpecialistID && hasRole(stub, "doctor"):
+        return "specialist", callerID, nil
+    case callerID == referral.PatientID:
+        return "patient", callerID, nil
+    case hasRole(stub, "admin"):
+        return "admin", callerID, nil
+    }
+    return "", callerID, errors.New("Access denied: not a party to this referral")
+}
+
+// Grant or withdraw the specialist's read access to the attached records
+func setReferralRecordAccess(stub shim.ChaincodeStubInterface, referral *Referral, active bool) error {
+    for _, recordID := range referral.RecordIDs {
+        key, err := stub.CreateCompositeKey(referralRecordIndex, []string{referral.SpecialistID, recordID, referral.ID})
+        if err != nil {
+            return errors.New("Failed to create referral record key")
+        }
+        if active {
+            err = stub.PutState(key, []byte{0x00})
+        } else {
+            err = stub.DelState(key)
+        }
+        if err != nil {
+            return errors.New("Failed to update referral record access")
+        }
+    }
+    return nil
+}
+
+// Whether an accepted referral gives the caller access to a record
+func referralCoversRecord(stub shim.ChaincodeStubInterface, recordID, callerID string) (bool, error) {
+    iterator, err := stub.GetStateByPartialCompositeKey(referralRecordIndex, []string{callerID, recordID})
+    if err != nil {
+        return false, errors.New("Failed to query referral records")
+    }
+    defer iterator.Close()
+    return iterator.HasNext(), nil
+}
+
+// Common path for every change to an existing referral, as for
+// appointments. apply makes the change and returns the transition detail.
+func (t *PatientCareChaincode) transitionReferral(stub shim.ChaincodeStubInterface, function, action string, args []string, argCount int, apply func(referral *Referral, now time.Time) (string, error)) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != argCount || nonceArgs == nil {
+        return shim.Error(fmt.Sprintf("Expected %d arguments followed by nonce, expiry, signature", argCount))
+    }
+    referralID := args[0]
+    if err := validateID("referral_id", referralID); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    referral, key, err := readReferral(stub, referralID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if referral == nil {
+        return shim.Error("Referral not found")
+    }
+    party, actorID, err := referralParty(stub, referral)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    allowed := false
+    for _, actor := range referralActors[action] {
+        allowed = allowed || actor == party
+    }
+    if !allowed {
+        return shim.Error(fmt.Sprintf("A %s cannot %s this referral", party, action))
+    }
+    to, ok := referralTransitions[action][referral.Status]
+    if !ok {
+        return shim.Error(fmt.Sprintf("Cannot %s a referral that is %s", action, referral.Status))
+    }
+    if err := consumeNonce(stub, function, args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    detail, err := apply(referral, now)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    // Attached records are shared exactly while the referral is accepted
+    if to == referralAccepted || referral.Status == referralAccepted {
+        if err := setReferralRecordAccess(stub, referral, to == referralAccepted); err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+    referral.History = append(referral.History, newSignedTransition(stub, action, referral.Status, to, detail, actorID, args, nonceArgs, now))
+    referral.Status = to
+    referral.UpdatedAt = now
+
+    referralJSON, err := putReferral(stub, key, referral)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: referralEvents[action], ObjectID: referralID, PatientID: referral.PatientID, ActorID: actorID, Status: referral.Status, CounterpartyID: referral.SpecialistID}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(referralJSON)
+}
+
+// Doctor refers a patient to a specialist with a reason and the records
+// the specialist needs. The referring doctor must be able to read them.
+func (t *PatientCareChaincode) createReferral(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 5 || nonceArgs == nil {
+        return shim.Error("Expected arguments: referral ID, patient ID, specialist ID, reason, record IDs (comma-separated), nonce, expiry, signature")
+    }
+    referralID, patientID, specialistID, reason := args[0], args[1], args[2], args[3]
+    if err := validateID("referral_id", referralID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("patient_id", patientID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("specialist_id", specialistID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if reason == "" || len(reason) > 1000 {
+        return shim.Error((&ValidationError{Field: "reason", Reason: "must be 1-1000 characters"}).Error())
+    }
+    recordIDs := []string{}
+    if args[4] != "" {
+        recordIDs = strings.Split(args[4], ",")
+    }
+    if len(recordIDs) > maxReferralRecords {
+        return shim.Error((&ValidationError{Field: "record_ids", Reason: fmt.Sprintf("at most %d records", maxReferralRecords)}).Error())
+    }
+    if err := requireRole(stub, "doctor"); err != nil {
+        return shim.Error(err.Error())
+    }
+    referrerID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if specialistID == referrerID {
+        return shim.Error("A doctor cannot refer a patient to themselves")
+    }
+    seen := map[string]bool{}
+    for _, recordID := range recordIDs {
+        if err := validateID("record_id", recordID); err != nil {
+            return shim.Error(err.Error())
+        }
+        if seen[recordID] {
+            return shim.Error("Record " + recordID + " is attached twice")
+        }
+        seen[recordID] = true
+        record, err := readRecord(stub, recordID)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if record.PatientID != patientID {
+            return shim.Error("Record " + recordID + " does not belong to the patient")
+        }
+        allowed, err := canReadRecord(stub, record, referrerID)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if !allowed {
+            return shim.Error("Access denied: cannot attach record " + recordID)
+        }
+    }
+    referral, key, err := readReferral(stub, referralID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if referral != nil {
+        return shim.Error("Referral already exists")
+    }
+    if err := consumeNonce(stub, "createReferral", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    referral = &Referral{
+        ID:                referralID,
+        PatientID:         patientID,
+        ReferringDoctorID: referrerID,
+        SpecialistID:      specialistID,
+        Reason:            reason,
+        RecordIDs:         recordIDs,
+        Status:            referralPending,
+        CreatedAt:         now,
+        UpdatedAt:         now,
+    }
+    referral.History = []SignedTransition{newSignedTransition(stub, "create", "", referralPending, "", referrerID, args, nonceArgs, now)}
+    referralJSON, err := putReferral(stub, key, referral)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "ReferralCreated", ObjectID: referralID, PatientID: patientID, ActorID: referrerID, Status: referral.Status, CounterpartyID: specialistID}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(referralJSON)
+}
+
+// Specialist accepts a referral, which shares the attached records
+func (t *PatientCareChaincode) acceptReferral(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return t.transitionReferral(stub, "acceptReferral", "accept", args, 1, func(referral *Referral, now time.Time) (string, error) {
+        return "", nil
+    })
+}
+
+// Specialist declines a referral with a reason
+func (t *PatientCareChaincode) declineReferral(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return t.transitionReferral(stub, "declineReferral", "decline", args, 2, func(referral *Referral, now time.Time) (string, error) {
+        if args[1] == "" || len(args[1]) > 500 {
+            return "", &ValidationError{Field: "reason", Reason: "must be 1-500 characters"}
+        }
+        return args[1], nil
+    })
+}
+
+// Referring doctor or admin withdraws a referral, ending any sharing
+func (t *PatientCareChaincode) cancelReferral(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return t.transitionReferral(stub, "cancelReferral", "cancel", args, 2, func(referral *Referral, now time.Time) (string, error) {
+        if args[1] == "" || len(args[1]) > 500 {
+            return "", &ValidationError{Field: "reason", Reason: "must be 1-500 characters"}
+        }
+        return args[1], nil
+    })
+}
+
+// Specialist closes an accepted referral with the SHA-256 of the consult
+// note, ending the sharing of the attached records
+func (t *PatientCareChaincode) closeReferral(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return t.transitionReferral(stub, "closeReferral", "close", args, 2, func(referral *Referral, now time.Time) (string, error) {
+        if !sha256HexPattern.MatchString(args[1]) {
+            return "", &ValidationError{Field: "consult_note_hash", Reason: "must be lowercase hex SHA-256"}
+        }
+        referral.ConsultNoteHash = args[1]
+        return args[1], nil
+    })
+}
+
+// Retrieve a referral; its parties only
+func (t *PatientCareChaincode) getReferral(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: referral ID")
+    }
+    if err := validateID("referral_id", args[0]); err != nil {
+        return shim.Error(err.Error())
+    }
+    referral, _, err := readReferral(stub, args[0])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if referral == nil {
+        return shim.Error("Referral not found")
+    }
+    if _, _, err := referralParty(stub, referral); err != nil {
+        return shim.Error(err.Error())
+    }
+    referralJSON, err := json.Marshal(referral)
+    if err != nil {
+        return shim.Error("Failed to marshal referral JSON")
+    }
+
+    return shim.Success(referralJSON)
+}
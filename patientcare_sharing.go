@@ -0,0 +1,588 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "strconv"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Patient-issued grant letting someone outside the patient's care read one
+// record, or every record in a category, until it expires or is revoked.
+// The grantee is named by user ID, or holds a bearer token whose SHA-256
+// hash is stored here; the token itself is only ever passed in the
+// transient map, so it never reaches the ledger.
+type RecordGrant struct {
+    ID             string     `json:"id"`
+    PatientID      string     `json:"patient_id"`
+    GranteeID      string     `json:"grantee_id,omitempty"`
+    RecordID       string     `json:"record_id,omitempty"`
+    Category       string     `json:"category,omitempty"`
+    TokenHash      string     `json:"token_hash,omitempty"`
+    ExpiresAt      time.Time  `json:"expires_at"`
+    RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+    CreatedAt      time.Time  `json:"created_at"`
+}
+
+// One use of a grant to read a record. The transaction ID doubles as the
+// access ID the reader presents to getGrantedRecord.
+type GrantAccess struct {
+    GrantID          string    `json:"grant_id"`
+    RecordID         string    `json:"record_id"`
+    AccessorID       string    `json:"accessor_id,omitempty"`
+    AccessorIdentity string    `json:"accessor_identity"`
+    AccessedAt       time.Time `json:"accessed_at"`
+    TxID             string    `json:"tx_id"`
+}
+
+const (
+    grantObjectType       = "grant"
+    grantTokenObjectType  = "grantToken"
+    granteeObjectType     = "grantee"
+    grantAccessObjectType = "grantAccess"
+
+    grantScopeRecord   = "record"
+    grantScopeCategory = "category"
+
+    grantTokenTransientKey = "grant_token"
+
+    maxGrantLifetime = 90 * 24 * time.Hour
+    // How long a committed grant access lets its reader fetch the record
+    grantAccessWindow = 5 * time.Minute
+)
+
+// Load a grant by ID
+func readGrant(stub shim.ChaincodeStubInterface, grantID string) (*RecordGrant, string, error) {
+    key, err := stub.CreateCompositeKey(grantObjectType, []string{grantID})
+    if err != nil {
+        return nil, "", errors.New("Failed to create grant key")
+    }
+    grantBytes, err := stub.GetState(key)
+    if err != nil {
+        return nil, "", errors.New("Error reading grant")
+    }
+    if grantBytes == nil {
+        return nil, key, nil
+    }
+    var grant RecordGrant
+    if err := json.Unmarshal(grantBytes, &grant); err != nil {
+        return nil, "", errors.New("Failed to unmarshal grant JSON")
+    }
+    return &grant, key, nil
+}
+
+// Whether a grant is live at now and covers the record
+func grantCovers(grant *RecordGrant, record *PatientRecord, now time.Time) bool {
+    if grant.RevokedAt != nil || !now.Before(grant.ExpiresAt) {
+        return false
+    }
+    if grant.PatientID != record.PatientID {
+        return false
+    }
+    if grant.RecordID != "" {
+        return grant.RecordID == record.ID
+    }
+    return grant.Category != "" && grant.Category == record.Category
+}
+
+// Find a live grant covering the record, either by bearer token or issued to
+// the caller's user ID. Returns nil if there is none.
+func findRecordGrant(stub shim.ChaincodeStubInterface, record *PatientRecord, grantToken, callerID string, now time.Time) (*RecordGrant, error) {
+    if grantToken != "" {
+        tokenKey, err := stub.CreateCompositeKey(grantTokenObjectType, []string{generateHash(grantToken)})
+        if err != nil {
+            return nil, errors.New("Failed to create grant token key")
+        }
+        grantID, err := stub.GetState(tokenKey)
+        if err != nil {
+            return nil, errors.New("Error reading grant token")
+        }
+        if grantID == nil {
+            return nil, nil
+        }
+        grant, _, err := readGrant(stub, string(grantID))
+        if err != nil || grant == nil {
+            return nil, err
+        }
+        if grantCovers(grant, record, now) {
+            return grant, nil
+        }
+        return nil, nil
+    }
+    if callerID == "" || record.PatientID == "" {
+        return nil, nil
+    }
+
+    iterator, err := stub.GetStateByPartialCompositeKey(granteeObjectType, []string{callerID, record.PatientID})
+    if err != nil {
+        return nil, errors.New("Failed to query grants")
+    }
+    defer iterator.Close()
+    for iterator.HasNext() {
+        entry, err := iterator.Next()
+        if err != nil {
+            return nil, errors.New("Failed to iterate grants")
+        }
+        _, parts, err := stub.SplitCompositeKey(entry.Key)
+        if err != nil || len(parts) != 3 {
+            continue
+        }
+        grant, _, err := readGrant(stub, parts[2])
+        if err != nil {
+            return nil, err
+        }
+        if grant != nil && grantCovers(grant, record, now) {
+            return grant, nil
+        }
+    }
+    return nil, nil
+}
+
+// Log a read made under a grant
+func logGrantAccess(stub shim.ChaincodeStubInterface, grant *RecordGrant, recordID, accessorID string, now time.Time) (*GrantAccess, error) {
+    accessorIdentity, err := cid.GetID(stub)
+    if err != nil {
+        return nil, errors.New("Failed to read caller identity")
+    }
+    access := GrantAccess{
+        GrantID:          grant.ID,
+        RecordID:         recordID,
+        AccessorID:       accessorID,
+        AccessorIdentity: accessorIdentity,
+        AccessedAt:       now,
+        TxID:             stub.GetTxID(),
+    }
+    accessJSON, err := json.Marshal(access)
+    if err != nil {
+        return nil, errors.New("Failed to marshal grant access JSON")
+    }
+    key, err := stub.CreateCompositeKey(grantAccessObjectType, []string{grant.ID, stub.GetTxID()})
+    if err != nil {
+        return nil, errors.New("Failed to create grant access key")
+    }
+    if err := stub.PutState(key, accessJSON); err != nil {
+        return nil, errors.New("Failed to store grant access")
+    }
+    return &access, nil
+}
+
+// Read the grant token from the transient map; empty if none was supplied
+func readTransientGrantToken(stub shim.ChaincodeStubInterface) (string, error) {
+    transient, err := stub.GetTransient()
+    if err != nil {
+        return "", errors.New("Failed to read transient data")
+    }
+    return string(transient[grantTokenTransientKey]), nil
+}
+
+// Whether the caller may read a record without a grant: admins, the
+// patient under any of their merged IDs, doctors of the org holding the
+// record, and specialists it is attached to through an accepted referral
+func canReadRecord(stub shim.ChaincodeStubInterface, record *PatientRecord, callerID string) (bool, error) {
+    // Records created before patient IDs existed keep the old,
+    // unrestricted read behaviour
+    if record.PatientID == "" || hasRole(stub, "admin") {
+        return true, nil
+    }
+    if callerID != "" && callerID == record.PatientID {
+        return true, nil
+    }
+    if hasRole(stub, "doctor") {
+        collection, err := phiCollectionName(stub)
+        if err != nil {
+            return false, err
+        }
+        if record.Collection == "" || record.Collection == collection {
+            return true, nil
+        }
+        if callerID != "" {
+            return referralCoversRecord(stub, record.ID, callerID)
+        }
+        return false, nil
+    }
+    if callerID != "" {
+        // Patients also read records filed under any ID merged with theirs
+        return samePatient(stub, callerID, record.PatientID)
+    }
+    return false, nil
+}
+
+// Decide whether the caller may read a record. Anyone else needs a live
+// grant, found by a bearer token in the transient map or by their user ID.
+// Every read under a grant is logged in the same transaction, so it has to
+// be submitted for the log entry to commit.
+func authorizeRecordRead(stub shim.ChaincodeStubInterface, record *PatientRecord) error {
+    callerID, _ := callerUserID(stub)
+    allowed, err := canReadRecord(stub, record, callerID)
+    if err != nil {
+        return err
+    }
+    if allowed {
+        return nil
+    }
+    grantToken, err := readTransientGrantToken(stub)
+    if err != nil {
+        return err
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return err
+    }
+    grant, err := findRecordGrant(stub, record, grantToken, callerID, now)
+    if err != nil {
+        return err
+    }
+    if grant == nil {
+        return errors.New("Access denied: no valid grant for this record")
+    }
+    _, err = logGrantAccess(stub, grant, record.ID, callerID, now)
+    return err
+}
+
+// Patient-only: share one record or category with a grantee until a ledger
+// time. A grant names a grantee user ID, a bearer token hash, or both.
+func (t *PatientCareChaincode) shareRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 6 || nonceArgs == nil {
+        return shim.Error("Expected arguments: grant ID, grantee ID, scope (record|category), scope value, expires at (unix seconds), token hash, nonce, expiry, signature")
+    }
+    grantID, granteeID, scope, scopeValue, expiresAtArg, tokenHash := args[0], args[1], args[2], args[3], args[4], args[5]
+    if err := validateID("grant_id", grantID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if granteeID == "" && tokenHash == "" {
+        return shim.Error("A grant needs a grantee ID, a token hash, or both")
+    }
+    if granteeID != "" {
+        if err := validateID("grantee_id", granteeID); err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+    if tokenHash != "" && !sha256HexPattern.MatchString(tokenHash) {
+        return shim.Error((&ValidationError{Field: "token_hash", Reason: "must be lowercase hex SHA-256 of the grant token"}).Error())
+    }
+    if scope != grantScopeRecord && scope != grantScopeCategory {
+        return shim.Error("Grant scope must be record or category")
+    }
+    scopeField := "record_id"
+    if scope == grantScopeCategory {
+        scopeField = "category"
+    }
+    if err := validateID(scopeField, scopeValue); err != nil {
+        return shim.Error(err.Error())
+    }
+    expiresAtUnix, err := strconv.ParseInt(expiresAtArg, 10, 64)
+    if err != nil {
+        return shim.Error("Invalid expiry time")
+    }
+    expiresAt := time.Unix(expiresAtUnix, 0).UTC()
+
+    patientID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if granteeID == patientID {
+        return shim.Error("Patients cannot grant access to themselves")
+    }
+    if err := consumeNonce(stub, "shareRecord", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !expiresAt.After(now) {
+        return shim.Error("Grant expiry must be in the future")
+    }
+    if expiresAt.Sub(now) > maxGrantLifetime {
+        return shim.Error("Grant expiry is more than 90 days away")
+    }
+
+    grant := RecordGrant{
+        ID:        grantID,
+        PatientID: patientID,
+        GranteeID: granteeID,
+        TokenHash: tokenHash,
+        ExpiresAt: expiresAt,
+        CreatedAt: now,
+    }
+    if scope == grantScopeRecord {
+        record, err := readRecord(stub, scopeValue)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if record.PatientID != patientID {
+            return shim.Error("Only the record's patient can share it")
+        }
+        if err := requireActiveRecord(record); err != nil {
+            return shim.Error(err.Error())
+        }
+        grant.RecordID = scopeValue
+    } else {
+        grant.Category = scopeValue
+    }
+
+    existing, grantKey, err := readGrant(stub, grantID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if existing != nil {
+        return shim.Error("Grant already exists")
+    }
+    grantJSON, err := json.Marshal(grant)
+    if err != nil {
+        return shim.Error("Failed to marshal grant JSON")
+    }
+    if err := stub.PutState(grantKey, grantJSON); err != nil {
+        return shim.Error("Failed to store grant")
+    }
+
+    if tokenHash != "" {
+        tokenKey, err := stub.CreateCompositeKey(grantTokenObjectType, []string{tokenHash})
+        if err != nil {
+            return shim.Error("Failed to create grant token key")
+        }
+        taken, err := stub.GetState(tokenKey)
+        if err != nil {
+            return shim.Error("Error reading grant token")
+        }
+        if taken != nil {
+            return shim.Error("Grant token is already in use")
+        }
+        if err := stub.PutState(tokenKey, []byte(grantID)); err != nil {
+            return shim.Error("Failed to store grant token")
+        }
+    }
+    if granteeID != "" {
+        granteeKey, err := stub.CreateCompositeKey(granteeObjectType, []string{granteeID, patientID, grantID})
+        if err != nil {
+            return shim.Error("Failed to create grantee key")
+        }
+        if err := stub.PutState(granteeKey, []byte{0x00}); err != nil {
+            return shim.Error("Failed to store grantee index")
+        }
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "GrantCreated", ObjectID: grantID, PatientID: patientID, ActorID: patientID, CounterpartyID: granteeID}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(grantJSON)
+}
+
+// Revoke a grant before it expires; the granting patient or an admin only
+func (t *PatientCareChaincode) revokeGrant(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 1 || nonceArgs == nil {
+        return shim.Error("Expected arguments: grant ID, nonce, expiry, signature")
+    }
+    grantID := args[0]
+    if err := validateID("grant_id", grantID); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    grant, grantKey, err := readGrant(stub, grantID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if grant == nil {
+        return shim.Error("Grant not found")
+    }
+    if !hasRole(stub, "admin") {
+        callerID, err := callerUserID(stub)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if callerID != grant.PatientID {
+            return shim.Error("Only the granting patient or an admin can revoke a grant")
+        }
+    }
+    if grant.RevokedAt != nil {
+        return shim.Error("Grant is already revoked")
+    }
+    if err := consumeNonce(stub, "revokeGrant", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    grant.RevokedAt = &now
+    grantJSON, err := json.Marshal(grant)
+    if err != nil {
+        return shim.Error("Failed to marshal grant JSON")
+    }
+    if err := stub.PutState(grantKey, grantJSON); err != nil {
+        return shim.Error("Failed to store grant")
+    }
+    actorID, _ := callerUserID(stub)
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "GrantRevoked", ObjectID: grantID, PatientID: grant.PatientID, ActorID: actorID, CounterpartyID: grant.GranteeID}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(grantJSON)
+}
+
+// Open a read of a record under a grant. Must be submitted: the access is
+// logged in this transaction, and getGrantedRecord only serves the record
+// once the log entry is committed. A bearer token goes in the transient map
+// under grant_token; without one the grant is looked up by the caller's
+// user ID. Returns the access, whose tx_id is the access ID.
+func (t *PatientCareChaincode) requestGrantAccess(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: record ID")
+    }
+    recordID := args[0]
+    if err := validateID("record_id", recordID); err != nil {
+        return shim.Error(err.Error())
+    }
+    grantToken, err := readTransientGrantToken(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    record, err := readRecord(stub, recordID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    callerID, _ := callerUserID(stub)
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    grant, err := findRecordGrant(stub, record, grantToken, callerID, now)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if grant == nil {
+        return shim.Error("Access denied: no valid grant for this record")
+    }
+    access, err := logGrantAccess(stub, grant, recordID, callerID, now)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    accessJSON, err := json.Marshal(access)
+    if err != nil {
+        return shim.Error("Failed to marshal grant access JSON")
+    }
+    return shim.Success(accessJSON)
+}
+
+// Read a record through a committed grant access. Only the identity that
+// opened the access can use it, for a short window, and only while the
+// grant still covers the record.
+func (t *PatientCareChaincode) getGrantedRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error("Expected arguments: grant ID, access ID")
+    }
+    grantID, accessID := args[0], args[1]
+    if err := validateID("grant_id", grantID); err != nil {
+        return shim.Error(err.Error())
+    }
+    key, err := stub.CreateCompositeKey(grantAccessObjectType, []string{grantID, accessID})
+    if err != nil {
+        return shim.Error("Failed to create grant access key")
+    }
+    accessBytes, err := stub.GetState(key)
+    if err != nil {
+        return shim.Error("Error reading grant access")
+    }
+    if accessBytes == nil {
+        return shim.Error("Grant access not found; submit requestGrantAccess and wait for it to commit")
+    }
+    var access GrantAccess
+    if err := json.Unmarshal(accessBytes, &access); err != nil {
+        return shim.Error("Failed to unmarshal grant access JSON")
+    }
+    accessorIdentity, err := cid.GetID(stub)
+    if err != nil {
+        return shim.Error("Failed to read caller identity")
+    }
+    if accessorIdentity != access.AccessorIdentity {
+        return shim.Error("Grant access was opened by another identity")
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !now.Before(access.AccessedAt.Add(grantAccessWindow)) {
+        return shim.Error("Grant access has expired; request a new one")
+    }
+
+    grant, _, err := readGrant(stub, grantID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    record, err := readRecord(stub, access.RecordID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if grant == nil || !grantCovers(grant, record, now) {
+        return shim.Error("Access denied: no valid grant for this record")
+    }
+    if err := requireActiveRecord(record); err != nil {
+        return shim.Error(err.Error())
+    }
+    recordJSON, err := json.Marshal(record)
+    if err != nil {
+        return shim.Error("Failed to marshal record JSON")
+    }
+    return shim.Success(recordJSON)
+}
+
+// Every logged read under a grant, for the granting patient and admins
+func (t *PatientCareChaincode) getGrantAccessLog(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: grant ID")
+    }
+    grantID := args[0]
+    if err := validateID("grant_id", grantID); err != nil {
+        return shim.Error(err.Error())
+    }
+    grant, _, err := readGrant(stub, grantID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if grant == nil {
+        return shim.Error("Grant not found")
+    }
+    if !hasRole(stub, "admin") {
+        callerID, err := callerUserID(stub)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        allowed, err := samePatient(stub, callerID, grant.PatientID)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if !allowed {
+            return shim.Error("Only the granting patient or an admin can read a grant's access log")
+        }
+    }
+
+    iterator, err := stub.GetStateByPartialCompositeKey(grantAccessObjectType, []string{grantID})
+    if err != nil {
+        return shim.Error("Failed to query grant access log")
+    }
+    defer iterator.Close()
+    accesses := []GrantAccess{}
+    for iterator.HasNext() {
+        entry, err := iterator.Next()
+        if err != nil {
+            return shim.Error("Failed to iterate grant access log")
+        }
+        var access GrantAccess
+        if err := json.Unmarshal(entry.Value, &access); err != nil {
+            return shim.Error("Failed to unmarshal grant access JSON")
+        }
+        accesses = append(accesses, access)
+    }
+    accessesJSON, err := json.Marshal(accesses)
+    if err != nil {
+        return shim.Error("Failed to marshal grant access log JSON")
+    }
+    return shim.Success(accessesJSON)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+    "encoding/json"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+const noGrant = "Access denied: no valid grant for this record"
+
+func TestGrantExpiresOnLedgerClock(t *testing.T) {
+    ledger := newTestLedger(t)
+    patient := ledger.client("p1", "patient")
+    researcher := ledger.client("u1", "researcher")
+    ledger.createRecord(ledger.client("d1", "doctor"), "r1", "p1")
+
+    ledger.as(researcher)
+    if _, response := ledger.readUnderGrant("r1", ""); response.Message != noGrant {
+        t.Fatalf("read without a grant: %q", response.Message)
+    }
+
+    expiresAt := strconv.FormatInt(ledger.now.Add(time.Hour).Unix(), 10)
+    ledger.as(patient).mustInvoke("shareRecord", "g1", "u1", grantScopeRecord, "r1", expiresAt, "")
+    ledger.as(researcher)
+    if _, response := ledger.readUnderGrant("r1", ""); response.Status != shim.OK {
+        t.Fatalf("read under grant: %s", response.Message)
+    }
+
+    ledger.advance(time.Hour)
+    if _, response := ledger.readUnderGrant("r1", ""); response.Message != noGrant {
+        t.Fatalf("read after expiry: %q", response.Message)
+    }
+}
+
+func TestRevokedGrantStopsReads(t *testing.T) {
+    ledger := newTestLedger(t)
+    patient := ledger.client("p1", "patient")
+    researcher := ledger.client("u1", "researcher")
+    ledger.createRecord(ledger.client("d1", "doctor"), "r1", "p1")
+
+    expiresAt := strconv.FormatInt(ledger.now.Add(24*time.Hour).Unix(), 10)
+    ledger.as(patient).mustInvoke("shareRecord", "g1", "u1", grantScopeRecord, "r1", expiresAt, "")
+    ledger.mustFail("Grant expiry is more than 90 days away", "shareRecord", "g2", "u1", grantScopeRecord, "r1", strconv.FormatInt(ledger.now.Add(maxGrantLifetime+time.Hour).Unix(), 10), "")
+    ledger.as(researcher)
+    access, response := ledger.readUnderGrant("r1", "")
+    if response.Status != shim.OK {
+        t.Fatalf("read under grant: %s", response.Message)
+    }
+
+    ledger.as(patient).mustInvoke("revokeGrant", "g1")
+    ledger.as(researcher)
+    if response := ledger.invoke("getGrantedRecord", "g1", access.TxID); response.Message != noGrant {
+        t.Fatalf("read with an access opened before revocation: %q", response.Message)
+    }
+    if _, response := ledger.readUnderGrant("r1", ""); response.Message != noGrant {
+        t.Fatalf("read after revocation: %q", response.Message)
+    }
+}
+
+func TestGrantReadsAreLogged(t *testing.T) {
+    ledger := newTestLedger(t)
+    patient := ledger.client("p1", "patient")
+    ledger.createRecord(ledger.client("d1", "doctor"), "r1", "p1")
+    token := "bearer-token-for-r1"
+    expiresAt := strconv.FormatInt(ledger.now.Add(24*time.Hour).Unix(), 10)
+    ledger.as(patient).mustInvoke("shareRecord", "g1", "", grantScopeRecord, "r1", expiresAt, generateHash(token))
+
+    holder := ledger.client("u9", "")
+    ledger.as(holder)
+    if response := ledger.invoke("getRecord", "r1"); response.Message != noGrant {
+        t.Fatalf("getRecord without a token: %q", response.Message)
+    }
+    if response := ledger.invoke("getGrantedRecord", "g1", "tx9999"); response.Status == shim.OK {
+        t.Fatalf("getGrantedRecord served a record without a logged access")
+    }
+    access, response := ledger.readUnderGrant("r1", token)
+    if response.Status != shim.OK {
+        t.Fatalf("read with token: %s", response.Message)
+    }
+    // The token only travels in the transient map
+    for _, value := range ledger.stub.State {
+        if strings.Contains(string(value), token) {
+            t.Fatalf("grant token written to world state")
+        }
+    }
+
+    // The access belongs to the identity that opened it, for a short window
+    ledger.as(ledger.client("u8", ""))
+    if response := ledger.invoke("getGrantedRecord", "g1", access.TxID); response.Status == shim.OK {
+        t.Fatalf("another identity used the grant access")
+    }
+    ledger.as(holder).advance(grantAccessWindow)
+    if response := ledger.invoke("getGrantedRecord", "g1", access.TxID); !strings.Contains(response.Message, "expired") {
+        t.Fatalf("stale grant access: %q", response.Message)
+    }
+
+    if response := ledger.invoke("getGrantAccessLog", "g1"); response.Status == shim.OK {
+        t.Fatalf("grantee read the access log")
+    }
+    var log []GrantAccess
+    if err := json.Unmarshal(ledger.as(patient).mustQuery("getGrantAccessLog", "g1"), &log); err != nil {
+        t.Fatal(err)
+    }
+    if len(log) != 1 || log[0].TxID != access.TxID || log[0].RecordID != "r1" {
+        t.Fatalf("access log %+v, want the one read", log)
+    }
+    ledger.as(ledger.client("a1", "admin")).mustQuery("getGrantAccessLog", "g1")
+}
+
+func TestGetRecordHonorsGrants(t *testing.T) {
+    ledger := newTestLedger(t)
+    patient := ledger.client("p1", "patient")
+    ledger.createRecord(ledger.client("d1", "doctor"), "r1", "p1")
+    token := "bearer-token-for-r1"
+    expiresAt := strconv.FormatInt(ledger.now.Add(24*time.Hour).Unix(), 10)
+    ledger.as(patient).mustInvoke("shareRecord", "g1", "u1", grantScopeRecord, "r1", expiresAt, "")
+    ledger.as(patient).mustInvoke("shareRecord", "g2", "", grantScopeRecord, "r1", expiresAt, generateHash(token))
+
+    // The named grantee reads by identity
+    ledger.as(ledger.client("u1", "researcher")).mustQuery("getRecord", "r1")
+
+    // A token holder reads with the token in the transient map
+    ledger.as(ledger.client("u9", ""))
+    ledger.transient = map[string][]byte{grantTokenTransientKey: []byte(token)}
+    ledger.mustQuery("getRecord", "r1")
+    ledger.transient = map[string][]byte{grantTokenTransientKey: []byte("wrong-token")}
+    if response := ledger.invoke("getRecord", "r1"); response.Message != noGrant {
+        t.Fatalf("getRecord with a wrong token: %q", response.Message)
+    }
+
+    for grantID, accessor := range map[string]string{"g1": "u1", "g2": "u9"} {
+        var log []GrantAccess
+        if err := json.Unmarshal(ledger.as(patient).mustQuery("getGrantAccessLog", grantID), &log); err != nil {
+            t.Fatal(err)
+        }
+        if len(log) != 1 || log[0].AccessorID != accessor || log[0].RecordID != "r1" {
+            t.Fatalf("access log of %s: %+v, want one read by %s", grantID, log, accessor)
+        }
+    }
+}
+
+func TestGrantedRecordMustBeActive(t *testing.T) {
+    ledger := newTestLedger(t)
+    patient := ledger.client("p1", "patient")
+    researcher := ledger.client("u1", "researcher")
+    ledger.createRecord(ledger.client("d1", "doctor"), "r1", "p1")
+    expiresAt := strconv.FormatInt(ledger.now.Add(24*time.Hour).Unix(), 10)
+    ledger.as(patient).mustInvoke("shareRecord", "g1", "u1", grantScopeRecord, "r1", expiresAt, "")
+
+    ledger.as(researcher)
+    access, response := ledger.readUnderGrant("r1", "")
+    if response.Status != shim.OK {
+        t.Fatalf("read under grant: %s", response.Message)
+    }
+    ledger.as(ledger.client("a1", "admin")).mustInvoke("eraseRecord", "r1", "gdpr-art17")
+    ledger.as(researcher)
+    if response := ledger.invoke("getGrantedRecord", "g1", access.TxID); response.Message != "Record has been erased" {
+        t.Fatalf("getGrantedRecord of an erased record: %q", response.Message)
+    }
+}
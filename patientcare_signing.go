@@ -0,0 +1,263 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Clinician's DID signature over a version of a record, as author or as
+// co-signer (an attending countersigning a resident's note). It names the
+// clinician, so it stays in the signing org's private data collection.
+type RecordSignature struct {
+    SignerID       string    `json:"signer_id"`
+    DID            string    `json:"did"`
+    Signature      string    `json:"signature"`
+    SignedAt       time.Time `json:"signed_at"`
+    TxID           string    `json:"tx_id"`
+}
+
+// What the channel keeps of a signature: that it verified, its hash and
+// the collection holding it
+type SignatureAttestation struct {
+    Status         string    `json:"status"`
+    SignatureHash  string    `json:"signature_hash"`
+    Collection     string    `json:"collection"`
+    SignedAt       time.Time `json:"signed_at"`
+    TxID           string    `json:"tx_id"`
+}
+
+// Signatures of a record's current version, kept in the private data
+// collection
+type RecordSignatures struct {
+    RecordID       string            `json:"record_id"`
+    Author         RecordSignature   `json:"author"`
+    CoSignatures   []RecordSignature `json:"co_signatures"`
+}
+
+const (
+    recordSignaturesType  = "recordSignatures"
+    signatureVerified     = "verified"
+    maxRecordCoSignatures = 10
+)
+
+// Attest a verified signature held in collection
+func attestSignature(signature *RecordSignature, collection string) (SignatureAttestation, error) {
+    signatureJSON, err := json.Marshal(signature)
+    if err != nil {
+        return SignatureAttestation{}, errors.New("Failed to marshal signature JSON")
+    }
+    return SignatureAttestation{
+        Status:        signatureVerified,
+        SignatureHash: generateHash(string(signatureJSON)),
+        Collection:    collection,
+        SignedAt:      signature.SignedAt,
+        TxID:          signature.TxID,
+    }, nil
+}
+
+// Write a record's signatures to the caller's collection and attest them
+// on the record
+func putRecordSignatures(stub shim.ChaincodeStubInterface, record *PatientRecord, signatures *RecordSignatures) error {
+    collection, err := phiCollectionName(stub)
+    if err != nil {
+        return err
+    }
+    author, err := attestSignature(&signatures.Author, collection)
+    if err != nil {
+        return err
+    }
+    coSignatures := []SignatureAttestation{}
+    for i := range signatures.CoSignatures {
+        attestation, err := attestSignature(&signatures.CoSignatures[i], collection)
+        if err != nil {
+            return err
+        }
+        coSignatures = append(coSignatures, attestation)
+    }
+
+    key, err := stub.CreateCompositeKey(recordSignaturesType, []string{record.ID})
+    if err != nil {
+        return errors.New("Failed to create record signatures key")
+    }
+    signaturesJSON, err := json.Marshal(signatures)
+    if err != nil {
+        return errors.New("Failed to marshal record signatures JSON")
+    }
+    if err := stub.PutPrivateData(collection, key, signaturesJSON); err != nil {
+        return errors.New("Failed to store record signatures")
+    }
+    record.Author = &author
+    record.CoSignatures = coSignatures
+    return nil
+}
+
+// Sign a new record version: the author's signature replaces the previous
+// one, and co-signatures given for the previous version are dropped
+func signRecordVersion(stub shim.ChaincodeStubInterface, record *PatientRecord, author *RecordSignature) error {
+    return putRecordSignatures(stub, record, &RecordSignatures{RecordID: record.ID, Author: *author, CoSignatures: []RecordSignature{}})
+}
+
+// Load a record's signatures from the private data collection, checked
+// against the attestations on the channel
+func readRecordSignatures(stub shim.ChaincodeStubInterface, record *PatientRecord) (*RecordSignatures, error) {
+    if record.Author == nil {
+        return nil, errors.New("Record has no author signature")
+    }
+    key, err := stub.CreateCompositeKey(recordSignaturesType, []string{record.ID})
+    if err != nil {
+        return nil, errors.New("Failed to create record signatures key")
+    }
+    signaturesBytes, err := stub.GetPrivateData(record.Author.Collection, key)
+    if err != nil || signaturesBytes == nil {
+        return nil, errors.New("Record signatures not found or not accessible to this organization")
+    }
+    var signatures RecordSignatures
+    if err := json.Unmarshal(signaturesBytes, &signatures); err != nil {
+        return nil, errors.New("Failed to unmarshal record signatures JSON")
+    }
+    if len(signatures.CoSignatures) != len(record.CoSignatures) {
+        return nil, errors.New("Record signatures do not match the hashes anchored on the channel")
+    }
+    attestations := append([]SignatureAttestation{*record.Author}, record.CoSignatures...)
+    stored := append([]RecordSignature{signatures.Author}, signatures.CoSignatures...)
+    for i := range stored {
+        attestation, err := attestSignature(&stored[i], record.Author.Collection)
+        if err != nil {
+            return nil, err
+        }
+        if attestation.SignatureHash != attestations[i].SignatureHash {
+            return nil, errors.New("Record signatures do not match the hashes anchored on the channel")
+        }
+    }
+    return &signatures, nil
+}
+
+// Digest the author signs for a record version
+func recordAuthorDigest(recordID, patientID, dataHash, authorDID string) []byte {
+    return fieldDigest([]string{"record", recordID, patientID, dataHash, authorDID})
+}
+
+// Digest a co-signer signs, binding the version and its author
+func recordCoSignDigest(recordID, patientID, dataHash, authorDID, coSignerDID string) []byte {
+    return fieldDigest([]string{"record-cosign", recordID, patientID, dataHash, authorDID, coSignerDID})
+}
+
+// Check that the caller is a doctor owning didID and that signature is
+// theirs over digest, returning the signature to store
+func verifyClinicianSignature(stub shim.ChaincodeStubInterface, didID string, digest []byte, signature string, now time.Time) (*RecordSignature, error) {
+    if err := requireRole(stub, "doctor"); err != nil {
+        return nil, err
+    }
+    did, err := resolveCallerDID(stub, didID)
+    if err != nil {
+        return nil, err
+    }
+    if err := verifyDIDSignature(did, digest, signature); err != nil {
+        return nil, err
+    }
+    return &RecordSignature{
+        SignerID:  did.Owner,
+        DID:       did.ID,
+        Signature: signature,
+        SignedAt:  now,
+        TxID:      stub.GetTxID(),
+    }, nil
+}
+
+// Doctor co-signs the current version of a record. The data hash argument
+// must be the record's current hash, so a co-signature never silently
+// carries over to content the co-signer has not seen.
+func (t *PatientCareChaincode) cosignRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 4 || nonceArgs == nil {
+        return shim.Error("Expected arguments: record ID, data hash, co-signer DID, DID signature, nonce, expiry, signature")
+    }
+    id, dataHash, coSignerDID, didSignature := args[0], args[1], args[2], args[3]
+    if err := validateID("record_id", id); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateHash("data_hash", dataHash); err != nil {
+        return shim.Error(err.Error())
+    }
+    record, err := readRecord(stub, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := requireActiveRecord(record); err != nil {
+        return shim.Error(err.Error())
+    }
+    if dataHash != record.DataHash {
+        return shim.Error("Data hash does not match the record's current version")
+    }
+    if len(record.CoSignatures) >= maxRecordCoSignatures {
+        return shim.Error("Record has the maximum number of co-signatures")
+    }
+    // The author's signature is read from its org's collection, so only
+    // clinicians of that org can co-sign
+    signatures, err := readRecordSignatures(stub, record)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := consumeNonce(stub, "cosignRecord", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    coSignature, err := verifyClinicianSignature(stub, coSignerDID, recordCoSignDigest(id, record.PatientID, dataHash, signatures.Author.DID, coSignerDID), didSignature, now)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if coSignature.SignerID == signatures.Author.SignerID {
+        return shim.Error("The author cannot co-sign their own record")
+    }
+    for _, existing := range signatures.CoSignatures {
+        if existing.SignerID == coSignature.SignerID {
+            return shim.Error("Record is already co-signed by this clinician")
+        }
+    }
+
+    signatures.CoSignatures = append(signatures.CoSignatures, *coSignature)
+    if err := putRecordSignatures(stub, record, signatures); err != nil {
+        return shim.Error(err.Error())
+    }
+    recordJSON, err := putRecord(stub, record)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "RecordCoSigned", ObjectID: id, PatientID: record.PatientID, Status: record.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(recordJSON)
+}
+
+// Retrieve the signatures of a record's current version from the private
+// data collection, checked against the hashes anchored on the channel
+func (t *PatientCareChaincode) getRecordSignatures(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: record ID")
+    }
+    if err := validateID("record_id", args[0]); err != nil {
+        return shim.Error(err.Error())
+    }
+    record, err := readRecord(stub, args[0])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := authorizeRecordRead(stub, record); err != nil {
+        return shim.Error(err.Error())
+    }
+    signatures, err := readRecordSignatures(stub, record)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    signaturesJSON, err := json.Marshal(signatures)
+    if err != nil {
+        return shim.Error("Failed to marshal record signatures JSON")
+    }
+    return shim.Success(signaturesJSON)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+    "strconv"
+    "strings"
+    "testing"
+)
+
+func TestRecordSignaturesStayOffTheChannel(t *testing.T) {
+    ledger := newTestLedger(t)
+    resident := ledger.client("d1", "doctor")
+    attending := ledger.client("d2", "doctor")
+    ledger.createRecord(resident, "r1", "p1")
+    if ledger.lastEvent != "RecordCreated" || ledger.lastLedgerEvent().ActorID != "" {
+        t.Fatalf("event %q %s names the author", ledger.lastEvent, ledger.lastEventPayload)
+    }
+
+    record := ledger.record("r1")
+    attendingDID := ledger.registerDID(attending)
+    authorDID := ledger.signatures(resident, "r1").Author.DID
+    coSignature := attending.sign(t, recordCoSignDigest("r1", "p1", record.DataHash, authorDID, attendingDID))
+    ledger.as(attending).mustInvoke("cosignRecord", "r1", record.DataHash, attendingDID, coSignature)
+    if ledger.lastLedgerEvent().ActorID != "" {
+        t.Fatalf("event %s names the co-signer", ledger.lastEventPayload)
+    }
+
+    public := string(ledger.stub.State["r1"])
+    for _, leaked := range []string{"d1", "d2", authorDID, attendingDID, coSignature} {
+        if strings.Contains(public, strconv.Quote(leaked)) {
+            t.Fatalf("record on the channel carries %q: %s", leaked, public)
+        }
+    }
+    record = ledger.record("r1")
+    signatures := ledger.signatures(attending, "r1")
+    if len(record.CoSignatures) != 1 || record.CoSignatures[0].Status != signatureVerified || signatures.CoSignatures[0].SignerID != "d2" {
+        t.Fatalf("co-signatures %+v on the channel, %+v in the collection", record.CoSignatures, signatures.CoSignatures)
+    }
+    ledger.as(attending).mustFail("already co-signed", "cosignRecord", "r1", record.DataHash, attendingDID, coSignature)
+}
+
+func TestRecordSignaturesMustMatchTheirHashes(t *testing.T) {
+    ledger := newTestLedger(t)
+    doctor := ledger.client("d1", "doctor")
+    ledger.createRecord(doctor, "r1", "p1")
+
+    record := ledger.record("r1")
+    key, _ := ledger.stub.CreateCompositeKey(recordSignaturesType, []string{"r1"})
+    stored := ledger.stub.PvtState[record.Author.Collection][key]
+    ledger.stub.PvtState[record.Author.Collection][key] = []byte(strings.Replace(string(stored), `"d1"`, `"d9"`, 1))
+    response := ledger.as(doctor).invoke("getRecordSignatures", "r1")
+    if !strings.Contains(response.Message, "do not match the hashes anchored on the channel") {
+        t.Fatalf("getRecordSignatures of a tampered copy: %q", response.Message)
+    }
+}
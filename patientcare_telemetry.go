@@ -0,0 +1,287 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strconv"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+    "github.com/tyuvic777/tyuabc777/merkle"
+)
+
+// Merkle root over a batch of wearable readings from one patient device.
+// The readings stay with the ingestion service, which keeps an inclusion
+// proof for each one built with the merkle package. The latest reading is
+// checked against the root when the batch is anchored, and its digest and
+// device signature are kept as evidence that the device produced the batch.
+type TelemetryBatch struct {
+    ID              string    `json:"id"`
+    PatientID       string    `json:"patient_id"`
+    DeviceID        string    `json:"device_id"`
+    ReadingCount    int       `json:"reading_count"`
+    FirstReadingAt  time.Time `json:"first_reading_at"`
+    LastReadingAt   time.Time `json:"last_reading_at"`
+    MerkleRoot      string    `json:"merkle_root"`
+    LatestReading   int       `json:"latest_reading"`
+    ReadingDigest   string    `json:"reading_digest"`
+    DeviceSignature string    `json:"device_signature"`
+    SubmittedBy     string    `json:"submitted_by"`
+    CreatedAt       time.Time `json:"created_at"`
+    Nonce           string    `json:"nonce"`
+}
+
+// One wearable reading as the ingestion service hashes it into a batch.
+// Fields, order and tags match telemetry.Reading, whose JSON encoding is
+// the leaf data of the batch tree.
+type TelemetryReading struct {
+    PatientID      string             `json:"patient_id"`
+    DeviceID       string             `json:"device_id"`
+    RecordedAt     time.Time          `json:"recorded_at"`
+    Metrics        map[string]float64 `json:"metrics"`
+    Signature      string             `json:"signature"`
+}
+
+const (
+    telemetryBatchObjectType     = "telemetryBatch"
+    telemetryReadingTransientKey = "telemetry_reading"
+    deviceTelemetryIndex         = "deviceTelemetry"
+    maxTelemetryReadings         = 100000
+
+    // Readings may carry device clocks slightly ahead of the ledger
+    maxTelemetryClockSkew = 5 * time.Minute
+)
+
+// Parse a reading time in unix seconds
+func parseTelemetryTime(field, value string) (time.Time, error) {
+    seconds, err := strconv.ParseInt(value, 10, 64)
+    if err != nil || seconds <= 0 {
+        return time.Time{}, &ValidationError{Field: field, Reason: "must be unix seconds"}
+    }
+    return time.Unix(seconds, 0).UTC(), nil
+}
+
+// Parse a reading's leaf data and check it against its inclusion proof in a
+// batch tree of readingCount leaves. The leaf must be in the encoding the
+// ingestion service hashes, so the bytes proven are the reading parsed.
+func readingInBatch(leafData []byte, proofJSON string, readingCount int, merkleRootHex string) (*TelemetryReading, *merkle.Proof, error) {
+    var reading TelemetryReading
+    if err := json.Unmarshal(leafData, &reading); err != nil {
+        return nil, nil, errors.New("Invalid telemetry reading JSON")
+    }
+    reading.RecordedAt = reading.RecordedAt.UTC()
+    encoded, err := json.Marshal(reading)
+    if err != nil || !bytes.Equal(encoded, leafData) {
+        return nil, nil, &ValidationError{Field: "reading", Reason: "must be the reading's leaf encoding"}
+    }
+    var proof merkle.Proof
+    if err := json.Unmarshal([]byte(proofJSON), &proof); err != nil {
+        return nil, nil, &ValidationError{Field: "reading_proof", Reason: "must be a Merkle proof in JSON"}
+    }
+    if err := merkle.VerifyAt(merkle.LeafHash(leafData), proof, readingCount, merkleRootHex); err != nil {
+        return nil, nil, fmt.Errorf("Reading is not in the batch: %v", err)
+    }
+    return &reading, &proof, nil
+}
+
+// The SHA-256 a device signs: the reading's leaf encoding with the
+// signature left empty
+func telemetryReadingDigest(reading TelemetryReading) ([]byte, error) {
+    reading.Signature = ""
+    data, err := json.Marshal(reading)
+    if err != nil {
+        return nil, errors.New("Failed to marshal telemetry reading JSON")
+    }
+    sum := sha256.Sum256(data)
+    return sum[:], nil
+}
+
+// Load an anchored telemetry batch by ID
+func readTelemetryBatch(stub shim.ChaincodeStubInterface, batchID string) (*TelemetryBatch, string, error) {
+    key, err := stub.CreateCompositeKey(telemetryBatchObjectType, []string{batchID})
+    if err != nil {
+        return nil, "", errors.New("Failed to create telemetry batch key")
+    }
+    batchBytes, err := stub.GetState(key)
+    if err != nil {
+        return nil, "", errors.New("Error reading telemetry batch")
+    }
+    if batchBytes == nil {
+        return nil, key, nil
+    }
+    var batch TelemetryBatch
+    if err := json.Unmarshal(batchBytes, &batch); err != nil {
+        return nil, "", errors.New("Failed to unmarshal telemetry batch JSON")
+    }
+    return &batch, key, nil
+}
+
+// Ingestion service only: anchor the Merkle root of a telemetry batch from
+// a registered device. The batch's latest reading is passed in the
+// transient map as leaf data, with its inclusion proof as an argument; the
+// chaincode checks the proof against the root, takes the last reading time
+// from the reading and verifies the device signature over its digest. The
+// service checks every other reading's signature before batching it.
+func (t *PatientCareChaincode) anchorTelemetryBatch(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 7 || nonceArgs == nil {
+        return shim.Error("Expected arguments: batch ID, patient ID, device ID, merkle root, reading count, first reading at, latest reading proof, nonce, expiry, signature; the latest reading goes in transient key " + telemetryReadingTransientKey)
+    }
+    batchID, patientID, deviceID, merkleRootHex := args[0], args[1], args[2], args[3]
+    if err := validateID("batch_id", batchID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("patient_id", patientID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("device_id", deviceID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if !sha256HexPattern.MatchString(merkleRootHex) {
+        return shim.Error((&ValidationError{Field: "merkle_root", Reason: "must be lowercase hex SHA-256"}).Error())
+    }
+    readingCount, err := parseBoundedInt("reading_count", args[4], 1, maxTelemetryReadings)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    firstReadingAt, err := parseTelemetryTime("first_reading_at", args[5])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := requireRole(stub, "telemetry"); err != nil {
+        return shim.Error(err.Error())
+    }
+    device, _, err := readDevice(stub, deviceID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if device == nil {
+        return shim.Error("Device is not registered")
+    }
+    if device.Status != deviceActive {
+        return shim.Error("Device has been deregistered")
+    }
+    if firstReadingAt.Before(device.RegisteredAt.Add(-maxTelemetryClockSkew)) {
+        return shim.Error("Batch predates the device registration")
+    }
+    transient, err := stub.GetTransient()
+    if err != nil {
+        return shim.Error("Failed to read transient data")
+    }
+    leafData, ok := transient[telemetryReadingTransientKey]
+    if !ok {
+        return shim.Error("Expected the latest reading in transient key " + telemetryReadingTransientKey)
+    }
+    latest, proof, err := readingInBatch(leafData, args[6], readingCount, merkleRootHex)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if latest.PatientID != patientID || latest.DeviceID != deviceID {
+        return shim.Error("Latest reading is from another patient device")
+    }
+    lastReadingAt := latest.RecordedAt
+    if lastReadingAt.Before(firstReadingAt) {
+        return shim.Error("Last reading time is before the first")
+    }
+    digest, err := verifyDeviceReading(device, latest)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    submittedBy, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    existing, key, err := readTelemetryBatch(stub, batchID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if existing != nil {
+        return shim.Error("Telemetry batch is already anchored")
+    }
+    if err := consumeNonce(stub, "anchorTelemetryBatch", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if lastReadingAt.After(now.Add(maxTelemetryClockSkew)) {
+        return shim.Error(fmt.Sprintf("Readings are more than %s ahead of the ledger", maxTelemetryClockSkew))
+    }
+
+    batch := TelemetryBatch{
+        ID:              batchID,
+        PatientID:       patientID,
+        DeviceID:        deviceID,
+        ReadingCount:    readingCount,
+        FirstReadingAt:  firstReadingAt,
+        LastReadingAt:   lastReadingAt,
+        MerkleRoot:      merkleRootHex,
+        LatestReading:   proof.Index,
+        ReadingDigest:   hex.EncodeToString(digest),
+        DeviceSignature: latest.Signature,
+        SubmittedBy:     submittedBy,
+        CreatedAt:       now,
+        Nonce:           nonceArgs[0],
+    }
+    batchJSON, err := json.Marshal(batch)
+    if err != nil {
+        return shim.Error("Failed to marshal telemetry batch JSON")
+    }
+    if err := stub.PutState(key, batchJSON); err != nil {
+        return shim.Error("Failed to store telemetry batch")
+    }
+    indexKey, err := stub.CreateCompositeKey(deviceTelemetryIndex, []string{patientID, deviceID, fmt.Sprintf("%020d", firstReadingAt.Unix()), batchID})
+    if err != nil {
+        return shim.Error("Failed to create device telemetry index key")
+    }
+    if err := stub.PutState(indexKey, []byte{0x00}); err != nil {
+        return shim.Error("Failed to store device telemetry index")
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "TelemetryBatchAnchored", ObjectID: batchID, PatientID: patientID, ActorID: submittedBy}); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(batchJSON)
+}
+
+// Retrieve an anchored telemetry batch; its patient, doctors, the
+// ingestion service and admins only
+func (t *PatientCareChaincode) getTelemetryBatch(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: batch ID")
+    }
+    batchID := args[0]
+    if err := validateID("batch_id", batchID); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    key, err := stub.CreateCompositeKey(telemetryBatchObjectType, []string{batchID})
+    if err != nil {
+        return shim.Error("Failed to create telemetry batch key")
+    }
+    batchBytes, err := stub.GetState(key)
+    if err != nil || batchBytes == nil {
+        return shim.Error("Telemetry batch not found")
+    }
+    var batch TelemetryBatch
+    if err := json.Unmarshal(batchBytes, &batch); err != nil {
+        return shim.Error("Failed to unmarshal telemetry batch JSON")
+    }
+    if !hasRole(stub, "admin") && !hasRole(stub, "doctor") && !hasRole(stub, "telemetry") {
+        callerID, err := callerUserID(stub)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if callerID != batch.PatientID {
+            return shim.Error("Access denied: not the patient")
+        }
+    }
+
+    return shim.Success(batchBytes)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+    "github.com/tyuvic777/tyuabc777/merkle"
+)
+
+// testBatch is a telemetry batch as the ingestion service keeps it
+type testBatch struct {
+    id       string
+    readings []TelemetryReading
+    proofs   []merkle.Proof
+    root     string
+    latest   int
+}
+
+// registerTestDevice registers a wearable for patient and returns its key
+func (l *testLedger) registerTestDevice(patient *testClient, deviceID string) *ecdsa.PrivateKey {
+    l.t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        l.t.Fatal(err)
+    }
+    didID := "did:mediNet:" + deviceID
+    publicKey := hex.EncodeToString(elliptic.Marshal(elliptic.P256(), key.PublicKey.X, key.PublicKey.Y))
+    l.identity.dids[didID] = ResolvedDID{ID: didID, Owner: patient.userID, PublicKey: publicKey}
+    l.as(patient).mustInvoke("registerDevice", deviceID, didID, publicKey, patient.userID)
+    return key
+}
+
+// signedBatch builds a batch of n signed readings ending a minute ago, with
+// the latest reading first so its position is checked
+func (l *testLedger) signedBatch(key *ecdsa.PrivateKey, patientID, deviceID string, n int) *testBatch {
+    l.t.Helper()
+    batch := &testBatch{id: "tb-" + deviceID, readings: make([]TelemetryReading, n)}
+    leaves := make([][]byte, n)
+    for i := range batch.readings {
+        reading := TelemetryReading{
+            PatientID:  patientID,
+            DeviceID:   deviceID,
+            RecordedAt: l.now.Add(-time.Duration(i+1) * time.Minute),
+            Metrics:    map[string]float64{"heart_rate": float64(60 + i), "spo2": 0.97},
+        }
+        digest, err := telemetryReadingDigest(reading)
+        if err != nil {
+            l.t.Fatal(err)
+        }
+        signature, err := ecdsa.SignASN1(rand.Reader, key, digest)
+        if err != nil {
+            l.t.Fatal(err)
+        }
+        reading.Signature = hex.EncodeToString(signature)
+        batch.readings[i] = reading
+        leaves[i] = leafData(l.t, reading)
+    }
+    tree, err := merkle.NewTree(leaves)
+    if err != nil {
+        l.t.Fatal(err)
+    }
+    batch.proofs = make([]merkle.Proof, n)
+    for i := range leaves {
+        if batch.proofs[i], err = tree.Proof(i); err != nil {
+            l.t.Fatal(err)
+        }
+    }
+    batch.root = tree.RootHex()
+    return batch
+}
+
+// anchorBatch submits anchorTelemetryBatch the way the ingestion service
+// does, presenting leaf with the proof of the reading at index
+func (l *testLedger) anchorBatch(batch *testBatch, leaf []byte, index int) pb.Response {
+    l.t.Helper()
+    proof, _ := json.Marshal(batch.proofs[index])
+    first := batch.readings[len(batch.readings)-1].RecordedAt
+    l.transient = map[string][]byte{telemetryReadingTransientKey: leaf}
+    return l.invoke("anchorTelemetryBatch", l.signed("anchorTelemetryBatch",
+        batch.id, batch.readings[0].PatientID, batch.readings[0].DeviceID, batch.root,
+        strconv.Itoa(len(batch.readings)),
+        strconv.FormatInt(first.Unix(), 10),
+        string(proof))...)
+}
+
+// leafData encodes a reading as telemetry.LeafData does
+func leafData(t *testing.T, reading TelemetryReading) []byte {
+    t.Helper()
+    reading.RecordedAt = reading.RecordedAt.UTC()
+    leaf, err := json.Marshal(reading)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return leaf
+}
+
+func TestAnchorTelemetryBatchChecksLatestReading(t *testing.T) {
+    ledger := newTestLedger(t)
+    patient := ledger.client("p1", "patient")
+    deviceKey := ledger.registerTestDevice(patient, "dev1")
+    ledger.advance(10 * time.Minute)
+    batch := ledger.signedBatch(deviceKey, "p1", "dev1", 5)
+    latest := batch.latest
+    ledger.as(ledger.client("svc", "telemetry"))
+
+    tampered := batch.readings[latest]
+    tampered.Metrics = map[string]float64{"heart_rate": 180, "spo2": 0.97}
+    if response := ledger.anchorBatch(batch, leafData(t, tampered), latest); !strings.Contains(response.Message, "Reading is not in the batch") {
+        t.Fatalf("tampered reading: %q", response.Message)
+    }
+    if response := ledger.anchorBatch(batch, leafData(t, batch.readings[latest]), latest+1); !strings.Contains(response.Message, "Reading is not in the batch") {
+        t.Fatalf("proof of another reading: %q", response.Message)
+    }
+    reencoded := strings.Replace(string(leafData(t, batch.readings[latest])), `{"patient_id"`, `{ "patient_id"`, 1)
+    if response := ledger.anchorBatch(batch, []byte(reencoded), latest); !strings.Contains(response.Message, "leaf encoding") {
+        t.Fatalf("re-encoded reading: %q", response.Message)
+    }
+
+    response := ledger.anchorBatch(batch, leafData(t, batch.readings[latest]), latest)
+    if response.Status != shim.OK {
+        t.Fatalf("anchorTelemetryBatch: %s", response.Message)
+    }
+    var anchored TelemetryBatch
+    if err := json.Unmarshal(response.Payload, &anchored); err != nil {
+        t.Fatal(err)
+    }
+    digest, _ := telemetryReadingDigest(batch.readings[latest])
+    if !anchored.LastReadingAt.Equal(batch.readings[latest].RecordedAt) || anchored.ReadingDigest != hex.EncodeToString(digest) || anchored.LatestReading != latest {
+        t.Fatalf("anchored batch %+v does not describe the latest reading", anchored)
+    }
+
+    for i, reading := range batch.readings {
+        proof, _ := json.Marshal(batch.proofs[i])
+        ledger.mustQuery("verifySignedReading", batch.id, string(leafData(t, reading)), string(proof))
+    }
+    proof, _ := json.Marshal(batch.proofs[latest])
+    if response := ledger.invoke("verifySignedReading", batch.id, string(leafData(t, tampered)), string(proof)); response.Status == shim.OK {
+        t.Fatalf("verifySignedReading accepted a tampered reading")
+    }
+}
+
+func TestAnchorTelemetryBatchRejectsForgedSignature(t *testing.T) {
+    ledger := newTestLedger(t)
+    patient := ledger.client("p1", "patient")
+    ledger.registerTestDevice(patient, "dev1")
+    ledger.advance(10 * time.Minute)
+    otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    batch := ledger.signedBatch(otherKey, "p1", "dev1", 3)
+    latest := batch.latest
+
+    ledger.as(ledger.client("svc", "telemetry"))
+    if response := ledger.anchorBatch(batch, leafData(t, batch.readings[latest]), latest); !strings.Contains(response.Message, "signature") {
+        t.Fatalf("reading signed by another key: %q", response.Message)
+    }
+}
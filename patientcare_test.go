@@ -0,0 +1,260 @@
+package main
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/asn1"
+    "encoding/hex"
+    "encoding/json"
+    "encoding/pem"
+    "fmt"
+    "math/big"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-chaincode-go/shimtest"
+    "github.com/hyperledger/fabric-protos-go/msp"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+    "github.com/golang/protobuf/proto"
+)
+
+// Certificate extension Fabric CA uses for enrollment attributes
+var attributeExtension = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// testClient is an enrolled identity with the user_id and role attributes
+// the chaincode reads, and its signing key
+type testClient struct {
+    userID  string
+    key     *ecdsa.PrivateKey
+    creator []byte
+}
+
+func newTestClient(t *testing.T, mspID, userID, role string) *testClient {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    attrs := map[string]string{"user_id": userID}
+    if role != "" {
+        attrs["role"] = role
+    }
+    attrsJSON, _ := json.Marshal(map[string]interface{}{"attrs": attrs})
+    template := &x509.Certificate{
+        SerialNumber:    big.NewInt(1),
+        Subject:         pkix.Name{CommonName: userID},
+        NotBefore:       time.Unix(0, 0),
+        NotAfter:        time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+        ExtraExtensions: []pkix.Extension{{Id: attributeExtension, Value: attrsJSON}},
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    creator, err := proto.Marshal(&msp.SerializedIdentity{
+        Mspid:   mspID,
+        IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    return &testClient{userID: userID, key: key, creator: creator}
+}
+
+// sign returns a hex ASN.1 signature over digest with the client's key
+func (c *testClient) sign(t *testing.T, digest []byte) string {
+    t.Helper()
+    signature, err := ecdsa.SignASN1(rand.Reader, c.key, digest)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return hex.EncodeToString(signature)
+}
+
+// testIdentityChaincode answers getDID like IdentityChaincode does
+type testIdentityChaincode struct {
+    dids map[string]ResolvedDID
+}
+
+func (c *testIdentityChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+    return shim.Success(nil)
+}
+
+func (c *testIdentityChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+    function, args := stub.GetFunctionAndParameters()
+    did, ok := c.dids[strings.Join(args, "")]
+    if function != "getDID" || !ok {
+        return shim.Error("DID not found")
+    }
+    didJSON, _ := json.Marshal(did)
+    return shim.Success([]byte("DID retrieved\n" + string(didJSON)))
+}
+
+// testLedger drives PatientCareChaincode through a MockStub. Ledger time is
+// a FixedClock the test moves forward, so expiry paths are deterministic.
+type testLedger struct {
+    t                *testing.T
+    stub             *shimtest.MockStub
+    identity         *testIdentityChaincode
+    now              time.Time
+    caller           *testClient
+    transient        map[string][]byte
+    lastEvent        string
+    lastEventPayload []byte
+    txCount          int
+    nonceCount       int
+}
+
+func newTestLedger(t *testing.T) *testLedger {
+    identity := &testIdentityChaincode{dids: map[string]ResolvedDID{}}
+    identityStub := shimtest.NewMockStub(identityChaincodeName, identity)
+    stub := shimtest.NewMockStub("patientcare", new(PatientCareChaincode))
+    stub.MockPeerChaincode(identityChaincodeName, identityStub, "")
+
+    ledger := &testLedger{t: t, stub: stub, identity: identity}
+    ledger.setTime(time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC))
+    t.Cleanup(func() { chaincodeClock = TxClock{} })
+    return ledger
+}
+
+// setTime moves the ledger clock
+func (l *testLedger) setTime(now time.Time) {
+    l.now = now.UTC()
+    chaincodeClock = FixedClock{Time: l.now}
+}
+
+func (l *testLedger) advance(d time.Duration) {
+    l.setTime(l.now.Add(d))
+}
+
+// client enrolls an identity of Org1MSP
+func (l *testLedger) client(userID, role string) *testClient {
+    return newTestClient(l.t, "Org1MSP", userID, role)
+}
+
+// as makes client the submitter of the following invokes
+func (l *testLedger) as(client *testClient) *testLedger {
+    l.caller = client
+    return l
+}
+
+// registerDID registers a DID owned by client in the identity chaincode
+func (l *testLedger) registerDID(client *testClient) string {
+    didID := "did:mediNet:" + client.userID
+    publicKey := elliptic.Marshal(elliptic.P256(), client.key.PublicKey.X, client.key.PublicKey.Y)
+    l.identity.dids[didID] = ResolvedDID{ID: didID, Owner: client.userID, PublicKey: hex.EncodeToString(publicKey)}
+    return didID
+}
+
+// invoke calls function as the current caller and clears the transient map
+func (l *testLedger) invoke(function string, args ...string) pb.Response {
+    l.t.Helper()
+    l.txCount++
+    l.stub.Creator = l.caller.creator
+    l.stub.TransientMap = l.transient
+    input := [][]byte{[]byte(function)}
+    for _, arg := range args {
+        input = append(input, []byte(arg))
+    }
+    response := l.stub.MockInvoke(fmt.Sprintf("tx%04d", l.txCount), input)
+    l.transient = nil
+    // MockStub queues every SetEvent; Fabric keeps only the last one a
+    // transaction sets
+    l.lastEvent, l.lastEventPayload = "", nil
+    for len(l.stub.ChaincodeEventsChannel) > 0 {
+        event := <-l.stub.ChaincodeEventsChannel
+        l.lastEvent, l.lastEventPayload = event.EventName, event.Payload
+    }
+    return response
+}
+
+// signedFor appends a fresh nonce, an expiry lifetime from now and the
+// caller's signature to args
+func (l *testLedger) signedFor(lifetime time.Duration, function string, args ...string) []string {
+    l.nonceCount++
+    nonce := fmt.Sprintf("test-nonce-%08d", l.nonceCount)
+    expiry := strconv.FormatInt(l.now.Add(lifetime).Unix(), 10)
+    signature := l.caller.sign(l.t, nonceDigest(function, args, nonce, expiry))
+    return append(append([]string{}, args...), nonce, expiry, signature)
+}
+
+// signed is signedFor with a one minute nonce lifetime
+func (l *testLedger) signed(function string, args ...string) []string {
+    return l.signedFor(time.Minute, function, args...)
+}
+
+// mustInvoke signs and invokes function and fails the test on an error
+func (l *testLedger) mustInvoke(function string, args ...string) []byte {
+    l.t.Helper()
+    response := l.invoke(function, l.signed(function, args...)...)
+    if response.Status != shim.OK {
+        l.t.Fatalf("%s: %s", function, response.Message)
+    }
+    return response.Payload
+}
+
+// mustQuery invokes an unsigned function and fails the test on an error
+func (l *testLedger) mustQuery(function string, args ...string) []byte {
+    l.t.Helper()
+    response := l.invoke(function, args...)
+    if response.Status != shim.OK {
+        l.t.Fatalf("%s: %s", function, response.Message)
+    }
+    return response.Payload
+}
+
+// mustFail signs and invokes function and expects an error containing want
+func (l *testLedger) mustFail(want, function string, args ...string) {
+    l.t.Helper()
+    response := l.invoke(function, l.signed(function, args...)...)
+    if response.Status == shim.OK {
+        l.t.Fatalf("%s succeeded, want error %q", function, want)
+    }
+    if !strings.Contains(response.Message, want) {
+        l.t.Fatalf("%s: error %q, want %q", function, response.Message, want)
+    }
+}
+
+// createRecord has doctor file a record for patientID, signed with their DID
+func (l *testLedger) createRecord(doctor *testClient, recordID, patientID string) {
+    l.t.Helper()
+    didID := l.registerDID(doctor)
+    dataHash := generateHash(recordID)
+    didSignature := doctor.sign(l.t, recordAuthorDigest(recordID, patientID, dataHash, didID))
+    l.as(doctor).mustInvoke("createRecord", recordID, dataHash, patientID, didID, didSignature)
+}
+
+// readUnderGrant submits requestGrantAccess for recordID, passing token in
+// the transient map if set, and fetches the record with the access it opened
+func (l *testLedger) readUnderGrant(recordID, token string) (GrantAccess, pb.Response) {
+    l.t.Helper()
+    if token != "" {
+        l.transient = map[string][]byte{grantTokenTransientKey: []byte(token)}
+    }
+    var access GrantAccess
+    response := l.invoke("requestGrantAccess", recordID)
+    if response.Status != shim.OK {
+        return access, response
+    }
+    if err := json.Unmarshal(response.Payload, &access); err != nil {
+        l.t.Fatal(err)
+    }
+    return access, l.invoke("getGrantedRecord", access.GrantID, access.TxID)
+}
+
+// stateKeys counts the world state keys under a composite key object type
+func (l *testLedger) stateKeys(objectType string) int {
+    count := 0
+    for key := range l.stub.State {
+        if strings.HasPrefix(key, "\x00"+objectType+"\x00") {
+            count++
+        }
+    }
+    return count
+}
@@ -0,0 +1,617 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strconv"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Clinical trial registry entry. The protocol and consent form live off
+// chain; the ledger pins their hashes and the current form version.
+type Trial struct {
+    ID                 string    `json:"id"`
+    Title              string    `json:"title"`
+    SiteID             string    `json:"site_id"`
+    ProtocolHash       string    `json:"protocol_hash"`
+    ConsentFormVersion int       `json:"consent_form_version"`
+    ConsentFormHash    string    `json:"consent_form_hash"`
+    Status             string    `json:"status"`
+    RegisteredBy       string    `json:"registered_by"`
+    CreatedAt          time.Time `json:"created_at"`
+    UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// A patient's e-consent to one trial. Each consent and the withdrawal are
+// kept with the patient's nonce signature over the protocol hash and form
+// version they agreed to.
+type TrialConsent struct {
+    TrialID            string             `json:"trial_id"`
+    PatientID          string             `json:"patient_id"`
+    Status             string             `json:"status"`
+    ConsentFormVersion int                `json:"consent_form_version"`
+    ConsentFormHash    string             `json:"consent_form_hash"`
+    ProtocolHash       string             `json:"protocol_hash"`
+    History            []SignedTransition `json:"history"`
+    WithdrawnAt        *time.Time         `json:"withdrawn_at,omitempty"`
+}
+
+// De-identified copy of a record handed to the trial's research site
+type TrialContribution struct {
+    TrialID            string    `json:"trial_id"`
+    PatientID          string    `json:"patient_id"`
+    RecordID           string    `json:"record_id"`
+    RecordHash         string    `json:"record_hash"`
+    DeidentifiedHash   string    `json:"deidentified_hash"`
+    ConsentFormVersion int       `json:"consent_form_version"`
+    ContributedBy      string    `json:"contributed_by"`
+    ContributedAt      time.Time `json:"contributed_at"`
+    TxID               string    `json:"tx_id"`
+}
+
+const (
+    trialObjectType        = "trial"
+    trialConsentObjectType = "trialConsent"
+    trialContributionIndex = "trialContribution"
+    trialWithdrawalEvent   = "TrialConsentWithdrawn"
+
+    trialRecruiting = "recruiting"
+    trialClosed     = "closed"
+
+    trialConsented = "consented"
+    trialWithdrawn = "withdrawn"
+
+    maxConsentFormVersion = 1000000
+)
+
+// Load a trial by ID
+func readTrial(stub shim.ChaincodeStubInterface, trialID string) (*Trial, string, error) {
+    key, err := stub.CreateCompositeKey(trialObjectType, []string{trialID})
+    if err != nil {
+        return nil, "", errors.New("Failed to create trial key")
+    }
+    trialBytes, err := stub.GetState(key)
+    if err != nil {
+        return nil, "", errors.New("Error reading trial")
+    }
+    if trialBytes == nil {
+        return nil, key, nil
+    }
+    var trial Trial
+    if err := json.Unmarshal(trialBytes, &trial); err != nil {
+        return nil, "", errors.New("Failed to unmarshal trial JSON")
+    }
+    return &trial, key, nil
+}
+
+// Load a patient's consent to a trial
+func readTrialConsent(stub shim.ChaincodeStubInterface, trialID, patientID string) (*TrialConsent, string, error) {
+    key, err := stub.CreateCompositeKey(trialConsentObjectType, []string{trialID, patientID})
+    if err != nil {
+        return nil, "", errors.New("Failed to create trial consent key")
+    }
+    consentBytes, err := stub.GetState(key)
+    if err != nil {
+        return nil, "", errors.New("Error reading trial consent")
+    }
+    if consentBytes == nil {
+        return nil, key, nil
+    }
+    var consent TrialConsent
+    if err := json.Unmarshal(consentBytes, &consent); err != nil {
+        return nil, "", errors.New("Failed to unmarshal trial consent JSON")
+    }
+    return &consent, key, nil
+}
+
+// Store a trial or consent under its key
+func putTrialObject(stub shim.ChaincodeStubInterface, key, name string, object interface{}) ([]byte, error) {
+    objectJSON, err := json.Marshal(object)
+    if err != nil {
+        return nil, errors.New("Failed to marshal " + name + " JSON")
+    }
+    if err := stub.PutState(key, objectJSON); err != nil {
+        return nil, errors.New("Failed to store " + name)
+    }
+    return objectJSON, nil
+}
+
+// Records a patient has contributed to a trial, in record ID order
+func trialContributions(stub shim.ChaincodeStubInterface, trialID, patientID string) ([]TrialContribution, error) {
+    iterator, err := stub.GetStateByPartialCompositeKey(trialContributionIndex, []string{trialID, patientID})
+    if err != nil {
+        return nil, errors.New("Failed to query trial contributions")
+    }
+    defer iterator.Close()
+    contributions := []TrialContribution{}
+    for iterator.HasNext() {
+        entry, err := iterator.Next()
+        if err != nil {
+            return nil, errors.New("Failed to iterate trial contributions")
+        }
+        var contribution TrialContribution
+        if err := json.Unmarshal(entry.Value, &contribution); err != nil {
+            return nil, errors.New("Failed to unmarshal trial contribution JSON")
+        }
+        contributions = append(contributions, contribution)
+    }
+    return contributions, nil
+}
+
+// Validate the protocol hash, consent form version and form hash arguments
+func parseTrialDocuments(protocolHash, versionArg, formHash string) (int, error) {
+    if !sha256HexPattern.MatchString(protocolHash) {
+        return 0, &ValidationError{Field: "protocol_hash", Reason: "must be lowercase hex SHA-256"}
+    }
+    version, err := parseBoundedInt("consent_form_version", versionArg, 1, maxConsentFormVersion)
+    if err != nil {
+        return 0, err
+    }
+    if !sha256HexPattern.MatchString(formHash) {
+        return 0, &ValidationError{Field: "consent_form_hash", Reason: "must be lowercase hex SHA-256"}
+    }
+    return version, nil
+}
+
+// Admin-only: add a trial to the registry, open for consent
+func (t *PatientCareChaincode) registerTrial(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 6 || nonceArgs == nil {
+        return shim.Error("Expected arguments: trial ID, title, research site ID, protocol hash, consent form version, consent form hash, nonce, expiry, signature")
+    }
+    trialID, title, siteID := args[0], args[1], args[2]
+    if err := validateID("trial_id", trialID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if title == "" || len(title) > 200 {
+        return shim.Error((&ValidationError{Field: "title", Reason: "must be 1-200 characters"}).Error())
+    }
+    if err := validateID("site_id", siteID); err != nil {
+        return shim.Error(err.Error())
+    }
+    version, err := parseTrialDocuments(args[3], args[4], args[5])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := requireRole(stub, "admin"); err != nil {
+        return shim.Error(err.Error())
+    }
+    adminID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    trial, key, err := readTrial(stub, trialID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if trial != nil {
+        return shim.Error("Trial already exists")
+    }
+    if err := consumeNonce(stub, "registerTrial", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    trial = &Trial{
+        ID:                 trialID,
+        Title:              title,
+        SiteID:             siteID,
+        ProtocolHash:       args[3],
+        ConsentFormVersion: version,
+        ConsentFormHash:    args[5],
+        Status:             trialRecruiting,
+        RegisteredBy:       adminID,
+        CreatedAt:          now,
+        UpdatedAt:          now,
+    }
+    trialJSON, err := putTrialObject(stub, key, "trial", trial)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "TrialRegistered", ObjectID: trialID, ActorID: adminID, Status: trial.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(trialJSON)
+}
+
+// Admin-only: publish an amended protocol and consent form. The form
+// version must go up; patients who consented to an earlier version must
+// consent again before more of their records are contributed.
+func (t *PatientCareChaincode) reviseTrial(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 4 || nonceArgs == nil {
+        return shim.Error("Expected arguments: trial ID, protocol hash, consent form version, consent form hash, nonce, expiry, signature")
+    }
+    trialID := args[0]
+    if err := validateID("trial_id", trialID); err != nil {
+        return shim.Error(err.Error())
+    }
+    version, err := parseTrialDocuments(args[1], args[2], args[3])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := requireRole(stub, "admin"); err != nil {
+        return shim.Error(err.Error())
+    }
+    trial, key, err := readTrial(stub, trialID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if trial == nil {
+        return shim.Error("Trial not found")
+    }
+    if trial.Status != trialRecruiting {
+        return shim.Error("Trial is closed")
+    }
+    if version <= trial.ConsentFormVersion {
+        return shim.Error(fmt.Sprintf("Consent form version must be greater than %d", trial.ConsentFormVersion))
+    }
+    if err := consumeNonce(stub, "reviseTrial", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    trial.ProtocolHash = args[1]
+    trial.ConsentFormVersion = version
+    trial.ConsentFormHash = args[3]
+    trial.UpdatedAt = now
+    trialJSON, err := putTrialObject(stub, key, "trial", trial)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    actorID, _ := callerUserID(stub)
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "TrialRevised", ObjectID: trialID, ActorID: actorID, Status: trial.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(trialJSON)
+}
+
+// Admin-only: stop recruiting and contributions. Consents stay on record
+// and can still be withdrawn.
+func (t *PatientCareChaincode) closeTrial(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 1 || nonceArgs == nil {
+        return shim.Error("Expected arguments: trial ID, nonce, expiry, signature")
+    }
+    if err := validateID("trial_id", args[0]); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := requireRole(stub, "admin"); err != nil {
+        return shim.Error(err.Error())
+    }
+    trial, key, err := readTrial(stub, args[0])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if trial == nil {
+        return shim.Error("Trial not found")
+    }
+    if trial.Status == trialClosed {
+        return shim.Error("Trial is already closed")
+    }
+    if err := consumeNonce(stub, "closeTrial", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    trial.Status = trialClosed
+    trial.UpdatedAt = now
+    trialJSON, err := putTrialObject(stub, key, "trial", trial)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    actorID, _ := callerUserID(stub)
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "TrialClosed", ObjectID: trial.ID, ActorID: actorID, Status: trial.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(trialJSON)
+}
+
+// Patient signs the trial's current consent form. The protocol hash, form
+// version and form hash the patient was shown are part of the signed
+// payload and must match the registry. Consenting again is how a patient
+// accepts a revised form; a withdrawn consent cannot be renewed.
+func (t *PatientCareChaincode) consentToTrial(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 4 || nonceArgs == nil {
+        return shim.Error("Expected arguments: trial ID, protocol hash, consent form version, consent form hash, nonce, expiry, signature")
+    }
+    trialID := args[0]
+    if err := validateID("trial_id", trialID); err != nil {
+        return shim.Error(err.Error())
+    }
+    version, err := parseTrialDocuments(args[1], args[2], args[3])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    patientID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    trial, _, err := readTrial(stub, trialID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if trial == nil {
+        return shim.Error("Trial not found")
+    }
+    if trial.Status != trialRecruiting {
+        return shim.Error("Trial is not recruiting")
+    }
+    if args[1] != trial.ProtocolHash || version != trial.ConsentFormVersion || args[3] != trial.ConsentFormHash {
+        return shim.Error("Consent does not match the trial's current protocol and consent form")
+    }
+    consent, key, err := readTrialConsent(stub, trialID, patientID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    from := ""
+    if consent != nil {
+        if consent.Status == trialWithdrawn {
+            return shim.Error("Consent to this trial was withdrawn")
+        }
+        if consent.ConsentFormVersion == version {
+            return shim.Error("Patient has already consented to this form version")
+        }
+        from = consent.Status
+    }
+    if err := consumeNonce(stub, "consentToTrial", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if consent == nil {
+        consent = &TrialConsent{TrialID: trialID, PatientID: patientID, History: []SignedTransition{}}
+    }
+    consent.Status = trialConsented
+    consent.ConsentFormVersion = version
+    consent.ConsentFormHash = args[3]
+    consent.ProtocolHash = args[1]
+    consent.History = append(consent.History, newSignedTransition(stub, "consent", from, trialConsented, strconv.Itoa(version), patientID, args, nonceArgs, now))
+    consentJSON, err := putTrialObject(stub, key, "trial consent", consent)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "TrialConsentGiven", ObjectID: trialID, PatientID: patientID, ActorID: patientID, Status: consent.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(consentJSON)
+}
+
+// Patient withdraws from a trial. No further records can be contributed,
+// and an event listing the records already contributed tells the research
+// site what to remove.
+func (t *PatientCareChaincode) withdrawTrialConsent(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 2 || nonceArgs == nil {
+        return shim.Error("Expected arguments: trial ID, reason, nonce, expiry, signature")
+    }
+    trialID, reason := args[0], args[1]
+    if err := validateID("trial_id", trialID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if len(reason) > 500 {
+        return shim.Error((&ValidationError{Field: "reason", Reason: "must be at most 500 characters"}).Error())
+    }
+    patientID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    consent, key, err := readTrialConsent(stub, trialID, patientID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if consent == nil {
+        return shim.Error("Patient has not consented to this trial")
+    }
+    if consent.Status == trialWithdrawn {
+        return shim.Error("Consent is already withdrawn")
+    }
+    if err := consumeNonce(stub, "withdrawTrialConsent", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    consent.History = append(consent.History, newSignedTransition(stub, "withdraw", consent.Status, trialWithdrawn, reason, patientID, args, nonceArgs, now))
+    consent.Status = trialWithdrawn
+    consent.WithdrawnAt = &now
+    consentJSON, err := putTrialObject(stub, key, "trial consent", consent)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    // The research site reads the contributed records to remove with
+    // getTrialConsent; the event does not list them
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: trialWithdrawalEvent, ObjectID: trialID, PatientID: patientID, ActorID: patientID, Status: consent.Status}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(consentJSON)
+}
+
+// Doctor contributes the de-identified form of one of a consenting
+// patient's records, identified by its SHA-256. The patient's consent must
+// be to the trial's current form version.
+func (t *PatientCareChaincode) contributeTrialRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    args, nonceArgs := splitNonceArgs(args)
+    if len(args) != 3 || nonceArgs == nil {
+        return shim.Error("Expected arguments: trial ID, record ID, de-identified data hash, nonce, expiry, signature")
+    }
+    trialID, recordID, deidentifiedHash := args[0], args[1], args[2]
+    if err := validateID("trial_id", trialID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("record_id", recordID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if !sha256HexPattern.MatchString(deidentifiedHash) {
+        return shim.Error((&ValidationError{Field: "deidentified_hash", Reason: "must be lowercase hex SHA-256"}).Error())
+    }
+    if err := requireRole(stub, "doctor"); err != nil {
+        return shim.Error(err.Error())
+    }
+    doctorID, err := callerUserID(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    trial, _, err := readTrial(stub, trialID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if trial == nil {
+        return shim.Error("Trial not found")
+    }
+    if trial.Status != trialRecruiting {
+        return shim.Error("Trial is closed")
+    }
+    record, err := readRecord(stub, recordID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := requireActiveRecord(record); err != nil {
+        return shim.Error(err.Error())
+    }
+    if record.PatientID == "" {
+        return shim.Error("Record has no patient")
+    }
+    allowed, err := canReadRecord(stub, record, doctorID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !allowed {
+        return shim.Error("Access denied: cannot read this record")
+    }
+    consent, _, err := readTrialConsent(stub, trialID, record.PatientID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if consent == nil || consent.Status != trialConsented {
+        return shim.Error("Patient has not consented to this trial")
+    }
+    if consent.ConsentFormVersion != trial.ConsentFormVersion {
+        return shim.Error("Patient must consent to the current consent form first")
+    }
+    key, err := stub.CreateCompositeKey(trialContributionIndex, []string{trialID, record.PatientID, recordID})
+    if err != nil {
+        return shim.Error("Failed to create trial contribution key")
+    }
+    existing, err := stub.GetState(key)
+    if err != nil {
+        return shim.Error("Error reading trial contribution")
+    }
+    if existing != nil {
+        return shim.Error("Record has already been contributed to this trial")
+    }
+    if err := consumeNonce(stub, "contributeTrialRecord", args, nonceArgs); err != nil {
+        return shim.Error(err.Error())
+    }
+    now, err := ledgerNow(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    contribution := TrialContribution{
+        TrialID:            trialID,
+        PatientID:          record.PatientID,
+        RecordID:           recordID,
+        RecordHash:         record.DataHash,
+        DeidentifiedHash:   deidentifiedHash,
+        ConsentFormVersion: consent.ConsentFormVersion,
+        ContributedBy:      doctorID,
+        ContributedAt:      now,
+        TxID:               stub.GetTxID(),
+    }
+    contributionJSON, err := putTrialObject(stub, key, "trial contribution", contribution)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := emitLedgerEvent(stub, LedgerEvent{Type: "TrialRecordContributed", ObjectID: trialID, PatientID: record.PatientID, ActorID: doctorID}); err != nil {
+        return shim.Error(err.Error())
+    }
+    return shim.Success(contributionJSON)
+}
+
+// Retrieve a trial registry entry
+func (t *PatientCareChaincode) getTrial(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error("Expected argument: trial ID")
+    }
+    if err := validateID("trial_id", args[0]); err != nil {
+        return shim.Error(err.Error())
+    }
+    trial, _, err := readTrial(stub, args[0])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if trial == nil {
+        return shim.Error("Trial not found")
+    }
+    trialJSON, err := json.Marshal(trial)
+    if err != nil {
+        return shim.Error("Failed to marshal trial JSON")
+    }
+
+    return shim.Success(trialJSON)
+}
+
+// A patient's consent to a trial with the records contributed under it,
+// so a withdrawal can be carried through to the research site. Admins,
+// researchers and the patient only.
+func (t *PatientCareChaincode) getTrialConsent(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error("Expected arguments: trial ID, patient ID")
+    }
+    trialID, patientID := args[0], args[1]
+    if err := validateID("trial_id", trialID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if err := validateID("patient_id", patientID); err != nil {
+        return shim.Error(err.Error())
+    }
+    if !hasRole(stub, "admin") && !hasRole(stub, "researcher") {
+        callerID, err := callerUserID(stub)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if callerID != patientID {
+            return shim.Error("Access denied: admins, researchers and the patient only")
+        }
+    }
+    consent, _, err := readTrialConsent(stub, trialID, patientID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if consent == nil {
+        return shim.Error("Trial consent not found")
+    }
+    contributions, err := trialContributions(stub, trialID, patientID)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    resultJSON, err := json.Marshal(map[string]interface{}{
+        "consent":       consent,
+        "contributions": contributions,
+    })
+    if err != nil {
+        return shim.Error("Failed to marshal trial consent JSON")
+    }
+
+    return shim.Success(resultJSON)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+    "encoding/base32"
+    "encoding/binary"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+// ValidationError names the argument that failed validation
+type ValidationError struct {
+    Field  string
+    Reason string
+}
+
+func (e *ValidationError) Error() string {
+    return fmt.Sprintf("Invalid %s: %s", e.Field, e.Reason)
+}
+
+const (
+    maxIDLength   = 50
+    maxHashLength = 256
+)
+
+// IDs start with an alphanumeric and may contain . _ : - after that. This
+// keeps the composite key delimiter (U+0000) and max rune (U+10FFFF) out.
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:\-]*$`)
+
+var sha256HexPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Multihash function codes accepted on records and their digest lengths
+var multihashDigestLengths = map[uint64]uint64{
+    0x12: 32, // sha2-256
+    0x13: 64, // sha2-512
+    0x16: 32, // sha3-256
+}
+
+// CID content codecs accepted for CIDv1
+var cidCodecs = map[uint64]bool{
+    0x55:   true, // raw
+    0x70:   true, // dag-pb
+    0x71:   true, // dag-cbor
+    0x0129: true, // dag-json
+}
+
+// Validate an identifier against the ID grammar
+func validateID(field, value string) error {
+    if len(value) == 0 {
+        return &ValidationError{Field: field, Reason: "must not be empty"}
+    }
+    if len(value) > maxIDLength {
+        return &ValidationError{Field: field, Reason: fmt.Sprintf("must be at most %d characters", maxIDLength)}
+    }
+    if !idPattern.MatchString(value) {
+        return &ValidationError{Field: field, Reason: "must start with a letter or digit and contain only letters, digits, '.', '_', ':' or '-'"}
+    }
+    return nil
+}
+
+// Validate a content hash: hex SHA-256, hex multihash, or IPFS CIDv0/CIDv1
+func validateHash(field, value string) error {
+    if len(value) == 0 {
+        return &ValidationError{Field: field, Reason: "must not be empty"}
+    }
+    if len(value) > maxHashLength {
+        return &ValidationError{Field: field, Reason: fmt.Sprintf("must be at most %d characters", maxHashLength)}
+    }
+
+    switch {
+    case sha256HexPattern.MatchString(value):
+        return nil
+    case strings.HasPrefix(value, "Qm"):
+        if err := validateCIDv0(value); err != nil {
+            return &ValidationError{Field: field, Reason: err.Error()}
+        }
+        return nil
+    case strings.HasPrefix(value, "b") || strings.HasPrefix(value, "z"):
+        if err := validateCIDv1(value); err != nil {
+            return &ValidationError{Field: field, Reason: err.Error()}
+        }
+        return nil
+    }
+
+    raw, err := hex.DecodeString(value)
+    if err != nil || strings.ToLower(value) != value {
+        return &ValidationError{Field: field, Reason: "must be lowercase hex SHA-256, a hex multihash or an IPFS CID"}
+    }
+    if err := validateMultihash(raw); err != nil {
+        return &ValidationError{Field: field, Reason: err.Error()}
+    }
+    return nil
+}
+
+// Validate an IPFS CID (v0 or v1)
+func validateCID(field, value string) error {
+    if value == "" {
+        return &ValidationError{Field: field, Reason: "must not be empty"}
+    }
+    var err error
+    if strings.HasPrefix(value, "Qm") {
+        err = validateCIDv0(value)
+    } else {
+        err = validateCIDv1(value)
+    }
+    if err != nil {
+        return &ValidationError{Field: field, Reason: err.Error()}
+    }
+    return nil
+}
+
+// Multihash: varint function code, varint digest length, digest
+func validateMultihash(raw []byte) error {
+    code, n := binary.Uvarint(raw)
+    if n <= 0 {
+        return errors.New("malformed multihash function code")
+    }
+    length, m := binary.Uvarint(raw[n:])
+    if m <= 0 {
+        return errors.New("malformed multihash digest length")
+    }
+    expected, ok := multihashDigestLengths[code]
+    if !ok {
+        return fmt.Errorf("unsupported multihash function 0x%x", code)
+    }
+    if length != expected || uint64(len(raw[n+m:])) != length {
+        return errors.New("multihash digest length does not match its function")
+    }
+    return nil
+}
+
+// CIDv0 is a base58btc sha2-256 multihash, always 46 characters starting "Qm"
+func validateCIDv0(value string) error {
+    if len(value) != 46 {
+        return errors.New("CIDv0 must be 46 characters")
+    }
+    raw, err := decodeBase58(value)
+    if err != nil {
+        return errors.New("CIDv0 is not valid base58btc")
+    }
+    if len(raw) != 34 || raw[0] != 0x12 || raw[1] != 0x20 {
+        return errors.New("CIDv0 must be a sha2-256 multihash")
+    }
+    return nil
+}
+
+// CIDv1: multibase prefix ('b' base32 or 'z' base58btc), then varint
+// version 1, varint content codec and a multihash
+func validateCIDv1(value string) error {
+    if value == "" {
+        return errors.New("CID must not be empty")
+    }
+    var raw []byte
+    var err error
+    switch value[0] {
+    case 'b':
+        raw, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(value[1:]))
+    case 'z':
+        raw, err = decodeBase58(value[1:])
+    default:
+        return errors.New("CIDv1 must use base32 ('b') or base58btc ('z') multibase")
+    }
+    if err != nil || (value[0] == 'b' && strings.ToLower(value) != value) {
+        return errors.New("CIDv1 multibase encoding is invalid")
+    }
+
+    version, n := binary.Uvarint(raw)
+    if n <= 0 || version != 1 {
+        return errors.New("CID version must be 1")
+    }
+    codec, m := binary.Uvarint(raw[n:])
+    if m <= 0 || !cidCodecs[codec] {
+        return errors.New("unsupported CID content codec")
+    }
+    return validateMultihash(raw[n+m:])
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// Decode a base58btc string (Bitcoin alphabet)
+func decodeBase58(value string) ([]byte, error) {
+    if value == "" {
+        return nil, errors.New("empty base58 string")
+    }
+    result := []byte{}
+    for _, r := range value {
+        digit := strings.IndexRune(base58Alphabet, r)
+        if digit < 0 {
+            return nil, errors.New("invalid base58 character")
+        }
+        carry := digit
+        for i := len(result) - 1; i >= 0; i-- {
+            carry += int(result[i]) * 58
+            result[i] = byte(carry & 0xff)
+            carry >>= 8
+        }
+        for carry > 0 {
+            result = append([]byte{byte(carry & 0xff)}, result...)
+            carry >>= 8
+        }
+    }
+    // Leading '1' characters encode leading zero bytes
+    for _, r := range value {
+        if r != '1' {
+            break
+        }
+        result = append([]byte{0}, result...)
+    }
+    return result, nil
+}
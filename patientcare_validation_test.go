@@ -0,0 +1,27 @@
+package main
+
+import (
+    "errors"
+    "testing"
+)
+
+func TestValidateCIDRejectsEmptyAndMalformed(t *testing.T) {
+    for _, value := range []string{"", "b", "z", "x123", "Qm", "bafy!"} {
+        err := validateCID("cid", value)
+        var validationErr *ValidationError
+        if !errors.As(err, &validationErr) || validationErr.Field != "cid" {
+            t.Errorf("validateCID(%q) = %v, want a ValidationError for cid", value, err)
+        }
+    }
+}
+
+func TestValidateCIDAcceptsV0AndV1(t *testing.T) {
+    for _, value := range []string{
+        "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG",
+        "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi",
+    } {
+        if err := validateCID("cid", value); err != nil {
+            t.Errorf("validateCID(%q) = %v", value, err)
+        }
+    }
+}
@@ -0,0 +1,183 @@
+// Package telemetry buffers wearable readings per patient device, cuts them
+// into batches on a size or age threshold, anchors each batch's Merkle root
+// through PatientCareChaincode.anchorTelemetryBatch and keeps the raw batch
+// with a per-reading inclusion proof in local storage.
+package telemetry
+
+import (
+    "crypto/ecdsa"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "regexp"
+    "strconv"
+    "time"
+
+    "github.com/tyuvic777/tyuabc777/merkle"
+)
+
+// Reading is one measurement set from a wearable. Metrics are numeric
+// values keyed by name, e.g. heart_rate or steps. Signature is the
+// device's hex ASN.1 ECDSA signature over ReadingDigest.
+type Reading struct {
+    PatientID      string             `json:"patient_id"`
+    DeviceID       string             `json:"device_id"`
+    RecordedAt     time.Time          `json:"recorded_at"`
+    Metrics        map[string]float64 `json:"metrics"`
+    Signature      string             `json:"signature"`
+}
+
+// Batch is a run of readings from one patient device with its Merkle root
+// and an inclusion proof per reading, in reading order
+type Batch struct {
+    ID             string         `json:"id"`
+    PatientID      string         `json:"patient_id"`
+    DeviceID       string         `json:"device_id"`
+    Readings       []Reading      `json:"readings"`
+    Proofs         []merkle.Proof `json:"proofs"`
+    MerkleRoot     string         `json:"merkle_root"`
+    FirstReadingAt time.Time      `json:"first_reading_at"`
+    LastReadingAt  time.Time      `json:"last_reading_at"`
+    AnchoredAt     *time.Time     `json:"anchored_at,omitempty"`
+    TxID           string         `json:"tx_id,omitempty"`
+}
+
+// IDs as accepted by the chaincode's validateID
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:\-]{0,49}$`)
+
+// Validate checks a reading before it is buffered
+func (r Reading) Validate() error {
+    if !idPattern.MatchString(r.PatientID) {
+        return errors.New("telemetry: invalid patient_id")
+    }
+    if !idPattern.MatchString(r.DeviceID) {
+        return errors.New("telemetry: invalid device_id")
+    }
+    if r.RecordedAt.IsZero() {
+        return errors.New("telemetry: recorded_at is required")
+    }
+    if len(r.Metrics) == 0 {
+        return errors.New("telemetry: a reading needs at least one metric")
+    }
+    if r.Signature == "" {
+        return errors.New("telemetry: reading is not signed by its device")
+    }
+    return nil
+}
+
+// LeafData is the encoding of a reading hashed into the tree. Metrics
+// marshal with sorted keys, so the encoding is stable.
+func LeafData(r Reading) ([]byte, error) {
+    r.RecordedAt = r.RecordedAt.UTC()
+    return json.Marshal(r)
+}
+
+// ReadingDigest is the SHA-256 a device signs: the reading's leaf encoding
+// with the signature left empty
+func ReadingDigest(r Reading) ([]byte, error) {
+    r.Signature = ""
+    data, err := LeafData(r)
+    if err != nil {
+        return nil, err
+    }
+    sum := sha256.Sum256(data)
+    return sum[:], nil
+}
+
+// SignReading signs a reading with its device key, as device firmware does
+func SignReading(key *ecdsa.PrivateKey, r *Reading) error {
+    digest, err := ReadingDigest(*r)
+    if err != nil {
+        return err
+    }
+    signature, err := ecdsa.SignASN1(rand.Reader, key, digest)
+    if err != nil {
+        return err
+    }
+    r.Signature = hex.EncodeToString(signature)
+    return nil
+}
+
+// Latest returns the index of the batch's most recent reading, whose
+// signature is presented to the chaincode when the batch is anchored
+func (b *Batch) Latest() int {
+    latest := 0
+    for i, reading := range b.Readings {
+        if reading.RecordedAt.After(b.Readings[latest].RecordedAt) {
+            latest = i
+        }
+    }
+    return latest
+}
+
+// BatchID derives a deterministic ID from a batch's device and contents,
+// short enough for the chaincode's 50 character limit
+func BatchID(patientID, deviceID string, first time.Time, root string) string {
+    sum := sha256.Sum256([]byte(patientID + "\x00" + deviceID + "\x00" + strconv.FormatInt(first.UnixNano(), 10) + "\x00" + root))
+    return "tb-" + hex.EncodeToString(sum[:16])
+}
+
+// NewBatch builds the tree over readings from a single patient device and
+// the inclusion proof of every reading
+func NewBatch(readings []Reading) (*Batch, error) {
+    if len(readings) == 0 {
+        return nil, merkle.ErrEmptyTree
+    }
+    patientID, deviceID := readings[0].PatientID, readings[0].DeviceID
+    leaves := make([][]byte, len(readings))
+    first, last := readings[0].RecordedAt.UTC(), readings[0].RecordedAt.UTC()
+    for i, reading := range readings {
+        if reading.PatientID != patientID || reading.DeviceID != deviceID {
+            return nil, errors.New("telemetry: a batch must come from one patient device")
+        }
+        data, err := LeafData(reading)
+        if err != nil {
+            return nil, err
+        }
+        leaves[i] = data
+        if reading.RecordedAt.Before(first) {
+            first = reading.RecordedAt.UTC()
+        }
+        if reading.RecordedAt.After(last) {
+            last = reading.RecordedAt.UTC()
+        }
+    }
+
+    tree, err := merkle.NewTree(leaves)
+    if err != nil {
+        return nil, err
+    }
+    proofs := make([]merkle.Proof, len(readings))
+    for i := range readings {
+        if proofs[i], err = tree.Proof(i); err != nil {
+            return nil, err
+        }
+    }
+    root := tree.RootHex()
+    return &Batch{
+        ID:             BatchID(patientID, deviceID, first, root),
+        PatientID:      patientID,
+        DeviceID:       deviceID,
+        Readings:       readings,
+        Proofs:         proofs,
+        MerkleRoot:     root,
+        FirstReadingAt: first,
+        LastReadingAt:  last,
+    }, nil
+}
+
+// VerifyReading checks the reading at index against the batch root, which
+// should first be compared with the root anchored on the ledger
+func VerifyReading(batch *Batch, index int) error {
+    if index < 0 || index >= len(batch.Readings) || index >= len(batch.Proofs) {
+        return fmt.Errorf("telemetry: no reading %d in batch %s", index, batch.ID)
+    }
+    data, err := LeafData(batch.Readings[index])
+    if err != nil {
+        return err
+    }
+    return merkle.VerifyAt(merkle.LeafHash(data), batch.Proofs[index], len(batch.Readings), batch.MerkleRoot)
+}
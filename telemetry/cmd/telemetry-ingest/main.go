@@ -0,0 +1,98 @@
+// Command telemetry-ingest runs the wearable telemetry ingestion service:
+// readings are POSTed to /readings, batched per patient device and
+// anchored on PatientCareChaincode as Merkle roots.
+package main
+
+import (
+    "context"
+    "errors"
+    "log"
+    "net/http"
+    "os"
+    "os/signal"
+    "strconv"
+    "syscall"
+    "time"
+
+    "github.com/tyuvic777/tyuabc777/gateway"
+    "github.com/tyuvic777/tyuabc777/telemetry"
+)
+
+const chaincodeName = "patientcare"
+
+func getenv(name, fallback string) string {
+    if value := os.Getenv(name); value != "" {
+        return value
+    }
+    return fallback
+}
+
+func loadConfig() telemetry.Config {
+    config := telemetry.DefaultConfig
+    if value := os.Getenv("TELEMETRY_MAX_READINGS"); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n <= 0 {
+            log.Fatalf("TELEMETRY_MAX_READINGS must be a positive integer")
+        }
+        config.MaxReadings = n
+    }
+    if value := os.Getenv("TELEMETRY_MAX_AGE"); value != "" {
+        d, err := time.ParseDuration(value)
+        if err != nil || d <= 0 {
+            log.Fatalf("TELEMETRY_MAX_AGE must be a positive duration")
+        }
+        config.MaxAge = d
+    }
+    return config
+}
+
+func main() {
+    store, err := telemetry.NewFileStore(getenv("TELEMETRY_DIR", "telemetry-data"))
+    if err != nil {
+        log.Fatalf("Failed to open batch store: %v", err)
+    }
+    conn, err := gateway.ConnectFromEnv()
+    if err != nil {
+        log.Fatalf("Failed to connect to Fabric: %v", err)
+    }
+    defer conn.Close()
+    contract := conn.Network().GetContract(chaincodeName)
+
+    registry := &telemetry.CachedRegistry{Registry: &telemetry.GatewayRegistry{Contract: contract}, TTL: time.Minute}
+    service := telemetry.NewService(loadConfig(), store, &telemetry.GatewayAnchorer{Contract: contract, Key: conn.Key}, registry, nil)
+    token := os.Getenv("INGEST_TOKEN")
+    if token == "" {
+        log.Printf("INGEST_TOKEN is not set; /readings accepts unauthenticated requests")
+    }
+    server := &http.Server{
+        Addr:              getenv("TELEMETRY_ADDR", ":8090"),
+        Handler:           telemetry.Handler(service, token),
+        ReadHeaderTimeout: 10 * time.Second,
+    }
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    // Verified readings also stream to the alert engine when one is set up
+    if url := os.Getenv("ALERT_ENGINE_URL"); url != "" {
+        forwarder := telemetry.NewForwarder(url, os.Getenv("ALERT_ENGINE_TOKEN"), 10000)
+        service.Subscribe(forwarder.Send)
+        go forwarder.Run(ctx)
+    }
+
+    go service.Run(ctx)
+    go func() {
+        if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+            log.Fatalf("HTTP server: %v", err)
+        }
+    }()
+    log.Printf("Telemetry ingestion listening on %s", server.Addr)
+
+    <-ctx.Done()
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    server.Shutdown(shutdownCtx)
+    if err := service.Flush(shutdownCtx, true); err != nil {
+        log.Printf("Final flush: %v", err)
+    }
+}
@@ -0,0 +1,96 @@
+package telemetry
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "encoding/hex"
+    "errors"
+    "sync"
+    "time"
+)
+
+// Device is the registry entry returned by the chaincode's getDevice
+type Device struct {
+    ID             string     `json:"id"`
+    PatientID      string     `json:"patient_id"`
+    PublicKey      string     `json:"public_key"`
+    Status         string     `json:"status"`
+    RegisteredAt   time.Time  `json:"registered_at"`
+    RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Registry looks devices up in the ledger's device registry
+type Registry interface {
+    Device(ctx context.Context, deviceID string) (*Device, error)
+}
+
+// ErrUntrustedReading is returned for readings from an unregistered or
+// deregistered device, or whose signature does not verify
+var ErrUntrustedReading = errors.New("telemetry: reading is not from a trusted device")
+
+// VerifySignature checks a reading against its registered device
+func VerifySignature(device *Device, r Reading) error {
+    if device == nil || device.ID != r.DeviceID || device.PatientID != r.PatientID {
+        return ErrUntrustedReading
+    }
+    if device.Status != "active" && (device.RevokedAt == nil || !r.RecordedAt.Before(*device.RevokedAt)) {
+        return ErrUntrustedReading
+    }
+    publicKey, err := hex.DecodeString(device.PublicKey)
+    if err != nil {
+        return ErrUntrustedReading
+    }
+    x, y := elliptic.Unmarshal(elliptic.P256(), publicKey)
+    if x == nil {
+        return ErrUntrustedReading
+    }
+    signature, err := hex.DecodeString(r.Signature)
+    if err != nil {
+        return ErrUntrustedReading
+    }
+    digest, err := ReadingDigest(r)
+    if err != nil {
+        return err
+    }
+    if !ecdsa.VerifyASN1(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, digest, signature) {
+        return ErrUntrustedReading
+    }
+    return nil
+}
+
+type cachedDevice struct {
+    device  *Device
+    fetched time.Time
+}
+
+// CachedRegistry keeps lookups for TTL, so a deregistration is picked up
+// within TTL without a ledger query per reading
+type CachedRegistry struct {
+    Registry Registry
+    TTL      time.Duration
+
+    mu      sync.Mutex
+    devices map[string]cachedDevice
+}
+
+// Device returns a cached entry or fetches it from the wrapped registry
+func (c *CachedRegistry) Device(ctx context.Context, deviceID string) (*Device, error) {
+    c.mu.Lock()
+    entry, ok := c.devices[deviceID]
+    c.mu.Unlock()
+    if ok && time.Since(entry.fetched) < c.TTL {
+        return entry.device, nil
+    }
+    device, err := c.Registry.Device(ctx, deviceID)
+    if err != nil {
+        return nil, err
+    }
+    c.mu.Lock()
+    if c.devices == nil {
+        c.devices = map[string]cachedDevice{}
+    }
+    c.devices[deviceID] = cachedDevice{device: device, fetched: time.Now()}
+    c.mu.Unlock()
+    return device, nil
+}
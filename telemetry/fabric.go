@@ -0,0 +1,98 @@
+package telemetry
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "strings"
+
+    "github.com/hyperledger/fabric-gateway/pkg/client"
+    "github.com/tyuvic777/tyuabc777/nonce"
+)
+
+// GatewayAnchorer anchors batches through the Fabric Gateway. Key must be
+// the enrollment key of the gateway identity, which needs the telemetry
+// role, since the chaincode checks nonce signatures against it.
+type GatewayAnchorer struct {
+    Contract *client.Contract
+    Key      *ecdsa.PrivateKey
+}
+
+// Anchor submits anchorTelemetryBatch and waits for the commit. The latest
+// reading travels in the transient map with its inclusion proof as an
+// argument, so the chaincode can check it against the root and verify the
+// device signature itself. A batch the ledger already holds, e.g. after a
+// crash before the local save, counts as anchored.
+func (a *GatewayAnchorer) Anchor(ctx context.Context, batch *Batch) (string, error) {
+    latest := batch.Latest()
+    leaf, err := LeafData(batch.Readings[latest])
+    if err != nil {
+        return "", err
+    }
+    proof, err := json.Marshal(batch.Proofs[latest])
+    if err != nil {
+        return "", err
+    }
+    args, err := nonce.Args(a.Key, "anchorTelemetryBatch",
+        batch.ID,
+        batch.PatientID,
+        batch.DeviceID,
+        batch.MerkleRoot,
+        strconv.Itoa(len(batch.Readings)),
+        strconv.FormatInt(batch.FirstReadingAt.Unix(), 10),
+        string(proof),
+    )
+    if err != nil {
+        return "", err
+    }
+    proposal, err := a.Contract.NewProposal("anchorTelemetryBatch",
+        client.WithArguments(args...),
+        client.WithTransient(map[string][]byte{"telemetry_reading": leaf}),
+    )
+    if err != nil {
+        return "", err
+    }
+    transaction, err := proposal.EndorseWithContext(ctx)
+    if err != nil {
+        if strings.Contains(err.Error(), "already anchored") {
+            return "", nil
+        }
+        return "", err
+    }
+    commit, err := transaction.SubmitWithContext(ctx)
+    if err != nil {
+        return "", err
+    }
+    status, err := commit.StatusWithContext(ctx)
+    if err != nil {
+        return "", err
+    }
+    if !status.Successful {
+        return "", fmt.Errorf("telemetry: transaction %s failed to commit with status %d", status.TransactionID, int32(status.Code))
+    }
+    return status.TransactionID, nil
+}
+
+// GatewayRegistry reads devices with getDevice. An unregistered device
+// yields a nil device rather than an error.
+type GatewayRegistry struct {
+    Contract *client.Contract
+}
+
+// Device evaluates getDevice for deviceID
+func (g *GatewayRegistry) Device(ctx context.Context, deviceID string) (*Device, error) {
+    result, err := g.Contract.EvaluateWithContext(ctx, "getDevice", client.WithArguments(deviceID))
+    if err != nil {
+        if strings.Contains(err.Error(), "Device not found") {
+            return nil, nil
+        }
+        return nil, err
+    }
+    var device Device
+    if err := json.Unmarshal(result, &device); err != nil {
+        return nil, err
+    }
+    return &device, nil
+}
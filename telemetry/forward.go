@@ -0,0 +1,86 @@
+package telemetry
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "time"
+)
+
+// Forwarder relays verified readings to another service, e.g. the alert
+// engine, in the same JSON the ingestion service accepts. Readings queue
+// in memory and are dropped, with a log line, when the queue is full, so
+// a slow consumer never holds up ingestion.
+type Forwarder struct {
+    URL    string
+    Token  string
+    Client *http.Client
+    Logger *log.Logger
+
+    queue chan Reading
+}
+
+// NewForwarder creates a forwarder with a queue of queueSize readings
+func NewForwarder(url, token string, queueSize int) *Forwarder {
+    return &Forwarder{
+        URL:    url,
+        Token:  token,
+        Client: &http.Client{Timeout: 10 * time.Second},
+        Logger: log.Default(),
+        queue:  make(chan Reading, queueSize),
+    }
+}
+
+// Send queues a reading without blocking
+func (f *Forwarder) Send(reading Reading) {
+    select {
+    case f.queue <- reading:
+    default:
+        f.Logger.Printf("telemetry: forward queue full, dropping reading from %s", reading.DeviceID)
+    }
+}
+
+// Run posts queued readings, up to 100 per request, until ctx is done
+func (f *Forwarder) Run(ctx context.Context) {
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case reading := <-f.queue:
+            readings := []Reading{reading}
+            for len(readings) < 100 && len(f.queue) > 0 {
+                readings = append(readings, <-f.queue)
+            }
+            if err := f.post(ctx, readings); err != nil {
+                f.Logger.Printf("telemetry: forwarding %d readings: %v", len(readings), err)
+            }
+        }
+    }
+}
+
+func (f *Forwarder) post(ctx context.Context, readings []Reading) error {
+    body, err := json.Marshal(readings)
+    if err != nil {
+        return err
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.URL, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if f.Token != "" {
+        req.Header.Set("Authorization", "Bearer "+f.Token)
+    }
+    resp, err := f.Client.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("%s answered %s", f.URL, resp.Status)
+    }
+    return nil
+}
@@ -0,0 +1,82 @@
+package telemetry
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strings"
+)
+
+const maxRequestBytes = 1 << 20
+
+// Handler accepts POST /readings with a single reading or an array of
+// readings. A non-empty token requires "Authorization: Bearer <token>".
+// Readings without a valid device signature are counted as rejected.
+func Handler(s *Service, token string) http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/readings", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            w.Header().Set("Allow", http.MethodPost)
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        if token != "" {
+            given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+            if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+                http.Error(w, "unauthorized", http.StatusUnauthorized)
+                return
+            }
+        }
+
+        body := json.RawMessage{}
+        if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&body); err != nil {
+            http.Error(w, "invalid JSON", http.StatusBadRequest)
+            return
+        }
+        var readings []Reading
+        if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "[") {
+            if err := json.Unmarshal(body, &readings); err != nil {
+                http.Error(w, "invalid readings", http.StatusBadRequest)
+                return
+            }
+        } else {
+            var reading Reading
+            if err := json.Unmarshal(body, &reading); err != nil {
+                http.Error(w, "invalid reading", http.StatusBadRequest)
+                return
+            }
+            readings = []Reading{reading}
+        }
+        for _, reading := range readings {
+            if err := reading.Validate(); err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+        }
+        accepted, rejected := 0, 0
+        for _, reading := range readings {
+            err := s.Add(r.Context(), reading)
+            switch {
+            case err == nil:
+                accepted++
+            case errors.Is(err, ErrUntrustedReading):
+                rejected++
+            default:
+                http.Error(w, "failed to buffer reading", http.StatusInternalServerError)
+                return
+            }
+        }
+        status := http.StatusAccepted
+        if accepted == 0 {
+            status = http.StatusForbidden
+        }
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(status)
+        json.NewEncoder(w).Encode(map[string]int{"accepted": accepted, "rejected": rejected})
+    })
+    mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusOK)
+    })
+    return mux
+}
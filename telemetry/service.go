@@ -0,0 +1,220 @@
+package telemetry
+
+import (
+    "context"
+    "errors"
+    "log"
+    "sync"
+    "time"
+)
+
+// Anchorer submits a batch's Merkle root to the ledger and returns the
+// transaction ID
+type Anchorer interface {
+    Anchor(ctx context.Context, batch *Batch) (string, error)
+}
+
+// Config sets the batch thresholds: a buffer is cut when it holds
+// MaxReadings or its oldest reading was buffered MaxAge ago
+type Config struct {
+    MaxReadings    int
+    MaxAge         time.Duration
+}
+
+// DefaultConfig batches up to 500 readings or one minute of data
+var DefaultConfig = Config{MaxReadings: 500, MaxAge: time.Minute}
+
+type bufferKey struct {
+    patientID string
+    deviceID  string
+}
+
+type buffer struct {
+    readings []Reading
+    started  time.Time
+}
+
+// Service buffers readings per patient device and anchors them in batches
+type Service struct {
+    config   Config
+    store    Store
+    anchorer Anchorer
+    registry Registry
+    logger   *log.Logger
+
+    mu          sync.Mutex
+    buffers     map[bufferKey]*buffer
+    anchor      sync.Mutex
+    subscribers []func(Reading)
+}
+
+// NewService wires a service to its store, anchorer and device registry
+func NewService(config Config, store Store, anchorer Anchorer, registry Registry, logger *log.Logger) *Service {
+    if config.MaxReadings <= 0 {
+        config.MaxReadings = DefaultConfig.MaxReadings
+    }
+    if config.MaxAge <= 0 {
+        config.MaxAge = DefaultConfig.MaxAge
+    }
+    if logger == nil {
+        logger = log.Default()
+    }
+    return &Service{
+        config:   config,
+        store:    store,
+        anchorer: anchorer,
+        registry: registry,
+        logger:   logger,
+        buffers:  map[bufferKey]*buffer{},
+    }
+}
+
+// Subscribe calls fn with every reading accepted after its signature is
+// verified. It must not block; call it before the service starts.
+func (s *Service) Subscribe(fn func(Reading)) {
+    s.subscribers = append(s.subscribers, fn)
+}
+
+// Add buffers a reading once its device signature checks out against the
+// registry. When its device's buffer reaches MaxReadings the buffer is cut
+// and the batch is saved; anchoring happens on the next tick. If the save
+// fails the readings go back in the buffer and the error is returned.
+func (s *Service) Add(ctx context.Context, reading Reading) error {
+    if err := reading.Validate(); err != nil {
+        return err
+    }
+    device, err := s.registry.Device(ctx, reading.DeviceID)
+    if err != nil {
+        return err
+    }
+    if err := VerifySignature(device, reading); err != nil {
+        return err
+    }
+    for _, fn := range s.subscribers {
+        fn(reading)
+    }
+
+    key := bufferKey{reading.PatientID, reading.DeviceID}
+
+    s.mu.Lock()
+    buf := s.buffers[key]
+    if buf == nil {
+        buf = &buffer{started: time.Now()}
+        s.buffers[key] = buf
+    }
+    buf.readings = append(buf.readings, reading)
+    var full *buffer
+    if len(buf.readings) >= s.config.MaxReadings {
+        full = buf
+        delete(s.buffers, key)
+    }
+    s.mu.Unlock()
+
+    if full != nil {
+        return s.seal(key, full)
+    }
+    return nil
+}
+
+// Cut every buffer older than MaxAge, or every buffer when all is set
+func (s *Service) cut(now time.Time, all bool) map[bufferKey]*buffer {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    due := map[bufferKey]*buffer{}
+    for key, buf := range s.buffers {
+        if all || now.Sub(buf.started) >= s.config.MaxAge {
+            due[key] = buf
+            delete(s.buffers, key)
+        }
+    }
+    return due
+}
+
+// Build and save a batch so it is durable before anchoring. A cut buffer
+// whose batch is not saved is put back ahead of anything buffered since, so
+// no reading is lost and the next cut retries it.
+func (s *Service) seal(key bufferKey, cut *buffer) error {
+    batch, err := NewBatch(cut.readings)
+    if err == nil {
+        err = s.store.Save(batch)
+    }
+    if err != nil {
+        s.restore(key, cut)
+    }
+    return err
+}
+
+// Put a cut buffer back in front of the readings buffered since it was cut
+func (s *Service) restore(key bufferKey, cut *buffer) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if buf := s.buffers[key]; buf != nil {
+        cut.readings = append(cut.readings, buf.readings...)
+    }
+    s.buffers[key] = cut
+}
+
+// Flush seals aged buffers, or all of them when all is set, and anchors
+// every pending batch in the store
+func (s *Service) Flush(ctx context.Context, all bool) error {
+    var errs []error
+    for key, buf := range s.cut(time.Now(), all) {
+        if err := s.seal(key, buf); err != nil {
+            errs = append(errs, err)
+        }
+    }
+    if err := s.anchorPending(ctx); err != nil {
+        errs = append(errs, err)
+    }
+    return errors.Join(errs...)
+}
+
+// Anchor saved batches oldest first. A failed batch stays pending and is
+// retried on the next flush.
+func (s *Service) anchorPending(ctx context.Context) error {
+    s.anchor.Lock()
+    defer s.anchor.Unlock()
+    pending, err := s.store.Pending()
+    if err != nil {
+        return err
+    }
+    for _, batch := range pending {
+        if ctx.Err() != nil {
+            return ctx.Err()
+        }
+        txID, err := s.anchorer.Anchor(ctx, batch)
+        if err != nil {
+            s.logger.Printf("telemetry: anchoring batch %s failed: %v", batch.ID, err)
+            continue
+        }
+        anchoredAt := time.Now().UTC()
+        batch.TxID = txID
+        batch.AnchoredAt = &anchoredAt
+        if err := s.store.Save(batch); err != nil {
+            return err
+        }
+        s.logger.Printf("telemetry: anchored batch %s (%d readings) in tx %s", batch.ID, len(batch.Readings), txID)
+    }
+    return nil
+}
+
+// Run flushes on a timer until ctx is done. Callers should stop accepting
+// readings and then Flush with all set, so nothing buffered is lost.
+func (s *Service) Run(ctx context.Context) {
+    interval := s.config.MaxAge / 4
+    if interval < time.Second {
+        interval = time.Second
+    }
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if err := s.Flush(ctx, false); err != nil {
+                s.logger.Printf("telemetry: flush: %v", err)
+            }
+        }
+    }
+}
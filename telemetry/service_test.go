@@ -0,0 +1,267 @@
+package telemetry
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "io"
+    "log"
+    "sync"
+    "testing"
+    "time"
+)
+
+// memoryStore keeps batches in memory and fails every Save while failing
+// is set
+type memoryStore struct {
+    mu      sync.Mutex
+    batches map[string]*Batch
+    order   []string
+    failing bool
+}
+
+func newMemoryStore() *memoryStore {
+    return &memoryStore{batches: map[string]*Batch{}}
+}
+
+func (s *memoryStore) Save(batch *Batch) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.failing {
+        return errors.New("disk full")
+    }
+    if _, ok := s.batches[batch.ID]; !ok {
+        s.order = append(s.order, batch.ID)
+    }
+    copied := *batch
+    s.batches[batch.ID] = &copied
+    return nil
+}
+
+func (s *memoryStore) Load(id string) (*Batch, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    batch, ok := s.batches[id]
+    if !ok {
+        return nil, errors.New("not found")
+    }
+    return batch, nil
+}
+
+func (s *memoryStore) Pending() ([]*Batch, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    var pending []*Batch
+    for _, id := range s.order {
+        if s.batches[id].TxID == "" {
+            copied := *s.batches[id]
+            pending = append(pending, &copied)
+        }
+    }
+    return pending, nil
+}
+
+func (s *memoryStore) saved() []*Batch {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    batches := make([]*Batch, len(s.order))
+    for i, id := range s.order {
+        batches[i] = s.batches[id]
+    }
+    return batches
+}
+
+// countingAnchorer anchors every batch in tx-<batch ID>
+type countingAnchorer struct {
+    anchored int
+}
+
+func (a *countingAnchorer) Anchor(ctx context.Context, batch *Batch) (string, error) {
+    a.anchored++
+    return "tx-" + batch.ID, nil
+}
+
+// staticRegistry holds one device
+type staticRegistry struct {
+    device *Device
+}
+
+func (r staticRegistry) Device(ctx context.Context, deviceID string) (*Device, error) {
+    if deviceID != r.device.ID {
+        return nil, ErrUntrustedReading
+    }
+    return r.device, nil
+}
+
+// testDevice is a registered wearable that signs its readings
+type testDevice struct {
+    t   *testing.T
+    key *ecdsa.PrivateKey
+    dev *Device
+    at  time.Time
+}
+
+func newTestDevice(t *testing.T) *testDevice {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    publicKey := elliptic.Marshal(elliptic.P256(), key.PublicKey.X, key.PublicKey.Y)
+    return &testDevice{
+        t:   t,
+        key: key,
+        dev: &Device{ID: "watch-1", PatientID: "p1", PublicKey: hex.EncodeToString(publicKey), Status: "active"},
+        at:  time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC),
+    }
+}
+
+// reading returns the device's next signed reading, a second after the last
+func (d *testDevice) reading(heartRate float64) Reading {
+    d.at = d.at.Add(time.Second)
+    r := Reading{PatientID: d.dev.PatientID, DeviceID: d.dev.ID, RecordedAt: d.at, Metrics: map[string]float64{"heart_rate": heartRate}}
+    digest, err := ReadingDigest(r)
+    if err != nil {
+        d.t.Fatal(err)
+    }
+    signature, err := ecdsa.SignASN1(rand.Reader, d.key, digest)
+    if err != nil {
+        d.t.Fatal(err)
+    }
+    r.Signature = hex.EncodeToString(signature)
+    return r
+}
+
+func newTestService(t *testing.T, config Config) (*Service, *memoryStore, *countingAnchorer, *testDevice) {
+    device := newTestDevice(t)
+    store := newMemoryStore()
+    anchorer := &countingAnchorer{}
+    service := NewService(config, store, anchorer, staticRegistry{device.dev}, log.New(io.Discard, "", 0))
+    return service, store, anchorer, device
+}
+
+func (s *Service) buffered() int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    n := 0
+    for _, buf := range s.buffers {
+        n += len(buf.readings)
+    }
+    return n
+}
+
+func TestAddCutsBufferAtMaxReadings(t *testing.T) {
+    service, store, anchorer, device := newTestService(t, Config{MaxReadings: 3, MaxAge: time.Hour})
+    ctx := context.Background()
+
+    for i := 0; i < 2; i++ {
+        if err := service.Add(ctx, device.reading(60)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if len(store.saved()) != 0 || service.buffered() != 2 {
+        t.Fatalf("%d batches saved, %d buffered before the buffer is full", len(store.saved()), service.buffered())
+    }
+    if err := service.Add(ctx, device.reading(61)); err != nil {
+        t.Fatal(err)
+    }
+    saved := store.saved()
+    if len(saved) != 1 || len(saved[0].Readings) != 3 || service.buffered() != 0 {
+        t.Fatalf("%d batches saved, %d buffered, want one batch of 3", len(saved), service.buffered())
+    }
+    if anchorer.anchored != 0 {
+        t.Fatal("Add anchored a batch; anchoring waits for the next flush")
+    }
+}
+
+func TestAddRejectsUntrustedReadings(t *testing.T) {
+    service, _, _, device := newTestService(t, Config{MaxReadings: 3, MaxAge: time.Hour})
+    reading := device.reading(60)
+    reading.Metrics["heart_rate"] = 200
+    if err := service.Add(context.Background(), reading); !errors.Is(err, ErrUntrustedReading) {
+        t.Fatalf("tampered reading: %v, want ErrUntrustedReading", err)
+    }
+    if service.buffered() != 0 {
+        t.Fatal("tampered reading was buffered")
+    }
+}
+
+func TestFlushSealsAgedBuffersAndAnchors(t *testing.T) {
+    service, store, anchorer, device := newTestService(t, Config{MaxReadings: 100, MaxAge: time.Minute})
+    ctx := context.Background()
+    for i := 0; i < 4; i++ {
+        if err := service.Add(ctx, device.reading(60)); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    if err := service.Flush(ctx, false); err != nil {
+        t.Fatal(err)
+    }
+    if len(store.saved()) != 0 || service.buffered() != 4 {
+        t.Fatalf("a fresh buffer was flushed: %d batches saved", len(store.saved()))
+    }
+
+    for _, buf := range service.buffers {
+        buf.started = buf.started.Add(-time.Minute)
+    }
+    if err := service.Flush(ctx, false); err != nil {
+        t.Fatal(err)
+    }
+    saved := store.saved()
+    if len(saved) != 1 || len(saved[0].Readings) != 4 || service.buffered() != 0 {
+        t.Fatalf("%d batches saved, %d buffered, want the aged buffer sealed", len(saved), service.buffered())
+    }
+    if anchorer.anchored != 1 || saved[0].TxID != "tx-"+saved[0].ID || saved[0].AnchoredAt == nil {
+        t.Fatalf("batch %s anchored %d times in %q", saved[0].ID, anchorer.anchored, saved[0].TxID)
+    }
+
+    // An anchored batch is not anchored again
+    if err := service.Flush(ctx, true); err != nil {
+        t.Fatal(err)
+    }
+    if anchorer.anchored != 1 {
+        t.Fatalf("anchored %d times, want 1", anchorer.anchored)
+    }
+}
+
+func TestSaveFailureKeepsReadingsBuffered(t *testing.T) {
+    service, store, _, device := newTestService(t, Config{MaxReadings: 2, MaxAge: time.Hour})
+    ctx := context.Background()
+    store.failing = true
+
+    first, second := device.reading(60), device.reading(61)
+    if err := service.Add(ctx, first); err != nil {
+        t.Fatal(err)
+    }
+    if err := service.Add(ctx, second); err == nil {
+        t.Fatal("Add succeeded while the store is failing")
+    }
+    if service.buffered() != 2 {
+        t.Fatalf("%d readings buffered after a failed save, want 2", service.buffered())
+    }
+    if err := service.Flush(ctx, true); err == nil {
+        t.Fatal("Flush succeeded while the store is failing")
+    }
+    if service.buffered() != 2 {
+        t.Fatalf("%d readings buffered after a failed flush, want 2", service.buffered())
+    }
+
+    // Readings buffered after the failure queue behind the ones put back
+    store.failing = false
+    third := device.reading(62)
+    if err := service.Add(ctx, third); err != nil {
+        t.Fatal(err)
+    }
+    saved := store.saved()
+    if len(saved) != 1 || service.buffered() != 0 {
+        t.Fatalf("%d batches saved, %d buffered once the store recovers", len(saved), service.buffered())
+    }
+    for i, want := range []Reading{first, second, third} {
+        if got := saved[0].Readings[i]; got.Signature != want.Signature {
+            t.Fatalf("reading %d out of order after the retry", i)
+        }
+    }
+}
@@ -0,0 +1,101 @@
+package telemetry
+
+import (
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// Store keeps raw batches. A batch is saved before it is anchored, so
+// readings survive an unreachable ledger and are retried later.
+type Store interface {
+    Save(batch *Batch) error
+    Load(id string) (*Batch, error)
+    Pending() ([]*Batch, error)
+}
+
+// FileStore writes one JSON file per batch into Dir
+type FileStore struct {
+    Dir string
+}
+
+// NewFileStore creates Dir if needed
+func NewFileStore(dir string) (*FileStore, error) {
+    if err := os.MkdirAll(dir, 0o700); err != nil {
+        return nil, err
+    }
+    return &FileStore{Dir: dir}, nil
+}
+
+func (s *FileStore) path(id string) string {
+    return filepath.Join(s.Dir, id+".json")
+}
+
+// Save writes the batch atomically through a temporary file
+func (s *FileStore) Save(batch *Batch) error {
+    data, err := json.Marshal(batch)
+    if err != nil {
+        return err
+    }
+    tmp, err := os.CreateTemp(s.Dir, ".batch-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), s.path(batch.ID))
+}
+
+// Load reads a batch by ID
+func (s *FileStore) Load(id string) (*Batch, error) {
+    if !idPattern.MatchString(id) {
+        return nil, os.ErrNotExist
+    }
+    data, err := os.ReadFile(s.path(id))
+    if err != nil {
+        return nil, err
+    }
+    var batch Batch
+    if err := json.Unmarshal(data, &batch); err != nil {
+        return nil, err
+    }
+    return &batch, nil
+}
+
+// Pending lists batches not yet anchored, oldest first
+func (s *FileStore) Pending() ([]*Batch, error) {
+    entries, err := os.ReadDir(s.Dir)
+    if err != nil {
+        return nil, err
+    }
+    pending := []*Batch{}
+    for _, entry := range entries {
+        name := entry.Name()
+        if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+            continue
+        }
+        batch, err := s.Load(strings.TrimSuffix(name, ".json"))
+        if err != nil {
+            return nil, err
+        }
+        if batch.AnchoredAt == nil {
+            pending = append(pending, batch)
+        }
+    }
+    sort.Slice(pending, func(i, j int) bool {
+        return pending[i].FirstReadingAt.Before(pending[j].FirstReadingAt)
+    })
+    return pending, nil
+}
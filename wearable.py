@@ -26,8 +26,7 @@ class WearableService:
         try:
             response = requests.post(f'{os.getenv("BACKEND_URL", "http://localhost:3000")}/patients/wearable/{user_id}', json=data)
             response.raise_for_status()
-            blockchain_client = BlockchainClient()  # Assume initialized elsewhere
-            blockchain_client.add_wearable_data(user_id, json.dumps(data))
+            self.send_telemetry(user_id, data)
             message = self.get_role_message(role, "wearable data processing", True, name)
             self.logger.info(f"Wearable data processed for {user_id} - {message}")
             return {"message": message, "data": response.json()}
@@ -35,6 +34,31 @@ class WearableService:
             self.logger.error(f"Wearable data error: {e} - {self.get_role_message(role, 'wearable data processing', False, name)}")
             raise Exception(self.get_role_message(role, "wearable data processing", False, name))
 
+    def send_telemetry(self, user_id, data):
+        """
+        Forward a device-signed reading to the telemetry ingestion service,
+        which checks the signature against the device registry, batches
+        readings and anchors each batch's Merkle root on the ledger.
+
+        Args:
+            user_id (int): User ID (patient)
+            data (dict): Wearable data with device_id, recorded_at, metrics
+                and the device's signature, passed through unchanged
+        """
+        reading = {
+            "patient_id": str(user_id),
+            "device_id": data["device_id"],
+            "recorded_at": data["recorded_at"],
+            "metrics": data["metrics"],
+            "signature": data["signature"],
+        }
+        headers = {}
+        token = os.getenv("INGEST_TOKEN")
+        if token:
+            headers["Authorization"] = f"Bearer {token}"
+        response = requests.post(f'{os.getenv("TELEMETRY_URL", "http://localhost:8090")}/readings', json=reading, headers=headers, timeout=10)
+        response.raise_for_status()
+
     def get_role_message(self, role, feature, success=True, name=None):
         """
         Generate role-specific, user-friendly messages.